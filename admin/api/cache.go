@@ -0,0 +1,45 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/fabiolb/fabio/proxy/cache"
+)
+
+// CacheHandler provides the status and purge handler for the response cache.
+type CacheHandler struct {
+	Cache *cache.Cache
+}
+
+type cacheStatus struct {
+	Entries int `json:"entries"`
+	Size    int `json:"size"`
+}
+
+type cachePurge struct {
+	Purged int `json:"purged"`
+}
+
+func (h *CacheHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		var s cacheStatus
+		if h.Cache != nil {
+			s = cacheStatus{h.Cache.Len(), h.Cache.Size()}
+		}
+		writeJSON(w, r, s)
+
+	case "DELETE":
+		// purge the responses for which host and path start with the
+		// prefix, e.g. 'example.com/api/'. An empty prefix purges all
+		// responses.
+		var p cachePurge
+		if h.Cache != nil {
+			p.Purged = h.Cache.Purge(r.URL.Query().Get("prefix"))
+		}
+		writeJSON(w, r, p)
+
+	default:
+		http.Error(w, "not allowed", http.StatusMethodNotAllowed)
+	}
+}
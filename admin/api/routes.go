@@ -17,9 +17,11 @@ type apiRoute struct {
 	Path    string   `json:"path"`
 	Src     string   `json:"src"`
 	Dst     string   `json:"dst"`
+	Match   string   `json:"match,omitempty"`
 	Opts    string   `json:"opts"`
 	Cmd     string   `json:"cmd"`
 	Tags    []string `json:"tags,omitempty"`
+	Health  string   `json:"health,omitempty"`
 	Weight  float64  `json:"weight"`
 	Rate1   float64  `json:"rate1"`
 	Pct99   float64  `json:"pct99"`
@@ -55,9 +57,11 @@ func (h *RoutesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 					Path:    tr.Path,
 					Src:     tr.Host + tr.Path,
 					Dst:     tg.URL.String(),
+					Match:   tr.Match,
 					Opts:    strings.Join(opts, " "),
 					Weight:  tg.Weight,
 					Tags:    tg.Tags,
+					Health:  tg.Health(),
 					Cmd:     "route add",
 					// Rate1:   tg.Timer.Rate1(),
 					// Pct99:   tg.Timer.Percentile(0.99),
@@ -10,6 +10,7 @@ import (
 	"github.com/fabiolb/fabio/admin/ui"
 	"github.com/fabiolb/fabio/config"
 	"github.com/fabiolb/fabio/proxy"
+	"github.com/fabiolb/fabio/proxy/cache"
 )
 
 // Server provides the HTTP server for the admin UI and API.
@@ -21,6 +22,7 @@ type Server struct {
 	Path     string
 	Version  string
 	Commands string
+	Cache    *cache.Cache
 }
 
 // ListenAndServe starts the admin server.
@@ -34,6 +36,7 @@ func (s *Server) handler() http.Handler {
 
 	switch s.Access {
 	case "ro":
+		mux.HandleFunc(p+"/api/cache", forbidden)
 		mux.HandleFunc(p+"/api/paths", forbidden)
 		mux.HandleFunc(p+"/api/manual", forbidden)
 		mux.HandleFunc(p+"/api/manual/", forbidden)
@@ -43,6 +46,7 @@ func (s *Server) handler() http.Handler {
 		// for historical reasons the configured config path starts with a '/'
 		// but Consul treats all KV paths without a leading slash.
 		pathsPrefix := strings.TrimPrefix(s.Cfg.Registry.Consul.KVPath, "/")
+		mux.Handle(p+"/api/cache", &api.CacheHandler{Cache: s.Cache})
 		mux.Handle(p+"/api/paths", &api.ManualPathsHandler{Prefix: pathsPrefix})
 		mux.Handle(p+"/api/manual", &api.ManualHandler{BasePath: p + "/api/manual"})
 		mux.Handle(p+"/api/manual/", &api.ManualHandler{BasePath: p + "/api/manual"})
@@ -48,6 +48,7 @@ func TestAdminServerAccess(t *testing.T) {
 	}
 
 	roTests := []test{
+		{"/api/cache", 403},
 		{"/api/manual", 403},
 		{"/api/paths", 403},
 		{"/api/config", 200},
@@ -62,6 +63,7 @@ func TestAdminServerAccess(t *testing.T) {
 	}
 
 	rwTests := []test{
+		{"/api/cache", 200},
 		{"/api/manual", 200},
 		{"/api/paths", 200},
 		{"/api/config", 200},
@@ -79,6 +81,7 @@ func TestAdminServerAccess(t *testing.T) {
 	testAccess("rw", "", rwTests)
 
 	roTestsWithPath := []test{
+		{"/fabio/api/cache", 403},
 		{"/fabio/api/manual", 403},
 		{"/fabio/api/paths", 403},
 		{"/fabio/api/config", 200},
@@ -93,6 +96,7 @@ func TestAdminServerAccess(t *testing.T) {
 	}
 
 	rwTestsWithPath := []test{
+		{"/fabio/api/cache", 200},
 		{"/fabio/api/manual", 200},
 		{"/fabio/api/paths", 200},
 		{"/fabio/api/config", 200},
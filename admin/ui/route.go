@@ -109,6 +109,7 @@ $(function(){
 		thead += '<th>Dest</th>';
 		thead += '<th>Options</th>';
 		thead += '<th>Weight</th>';
+		thead += '<th>Health</th>';
 		thead += '</tr></thead>';
 
 		let $tbody = $('<tbody />');
@@ -141,6 +142,11 @@ $(function(){
 				$tr.append($('<td />').append($('<a />').attr('href', r.dst).text(r.dst)));
 				$tr.append($('<td />').text(r.opts));
 				$tr.append($('<td />').text((r.weight * 100).toFixed(2) + '%'));
+				if (r.health == 'unhealthy') {
+					$tr.append($('<td />').append($('<span class="red-text" />').text(r.health)));
+				} else {
+					$tr.append($('<td />').text(r.health || ''));
+				}
 
 				$tr.appendTo($tbody);
 			}
@@ -0,0 +1,270 @@
+package cert
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+// acmeTestServer is a minimal ACME server with the endpoints autocert
+// uses for obtaining a certificate. It checks the response to the
+// challenge at the address of the host name and signs the certificates
+// with the certificate of the test server. Signatures are not verified.
+type acmeTestServer struct {
+	*httptest.Server
+
+	// challenge is the challenge type offered to the client.
+	challenge string
+
+	mu     sync.Mutex
+	addrs  map[string]string
+	orders []*acmeTestOrder
+}
+
+type acmeTestOrder struct {
+	host  string
+	token string
+	authz string
+	cert  []byte
+}
+
+func newACMETestServer(t *testing.T, challenge string) *acmeTestServer {
+	s := &acmeTestServer{challenge: challenge, addrs: map[string]string{}}
+	s.Server = httptest.NewTLSServer(http.HandlerFunc(s.serveHTTP))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// setAddr sets the address for validating the challenge of the host.
+func (s *acmeTestServer) setAddr(host, challenge, addr string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addrs[host+" "+challenge] = addr
+}
+
+// issuedHosts returns the host names of the issued certificates.
+func (s *acmeTestServer) issuedHosts() (hosts []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, o := range s.orders {
+		if o.cert != nil {
+			hosts = append(hosts, o.host)
+		}
+	}
+	return hosts
+}
+
+// roots returns the CA certificate for validating the issued
+// certificates.
+func (s *acmeTestServer) roots() *x509.CertPool {
+	p := x509.NewCertPool()
+	p.AddCert(s.Certificate())
+	return p
+}
+
+// directoryCA returns the certificate of the ACME server as PEM.
+func (s *acmeTestServer) directoryCA() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.Certificate().Raw})
+}
+
+func (s *acmeTestServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Replay-Nonce", fmt.Sprintf("nonce-%d", time.Now().UnixNano()))
+
+	switch r.URL.Path {
+	case "/dir":
+		writeJSON(w, http.StatusOK, map[string]any{
+			"newNonce":   s.URL + "/nonce",
+			"newAccount": s.URL + "/account",
+			"newOrder":   s.URL + "/order",
+		})
+		return
+	case "/nonce":
+		return
+	}
+
+	// the payload of the JWS request
+	var jws struct{ Payload string }
+	json.NewDecoder(r.Body).Decode(&jws)
+	payload, _ := base64.RawURLEncoding.DecodeString(jws.Payload)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var id int
+	switch {
+	case r.URL.Path == "/account":
+		w.Header().Set("Location", s.URL+"/account/1")
+		writeJSON(w, http.StatusCreated, map[string]any{"status": "valid"})
+
+	case r.URL.Path == "/order":
+		var req struct{ Identifiers []struct{ Value string } }
+		if json.Unmarshal(payload, &req) != nil || len(req.Identifiers) != 1 {
+			http.Error(w, "invalid order", http.StatusBadRequest)
+			return
+		}
+		token := base64.RawURLEncoding.EncodeToString(fmt.Appendf(nil, "token-%d", len(s.orders)))
+		s.orders = append(s.orders, &acmeTestOrder{host: req.Identifiers[0].Value, token: token, authz: acme.StatusPending})
+		s.writeOrder(w, http.StatusCreated, len(s.orders)-1)
+
+	case scan(r.URL.Path, "/order/%d", &id) && s.order(id) != nil:
+		s.writeOrder(w, http.StatusOK, id)
+
+	case scan(r.URL.Path, "/authz/%d", &id) && s.order(id) != nil:
+		o := s.order(id)
+		writeJSON(w, http.StatusOK, map[string]any{
+			"status":     o.authz,
+			"identifier": map[string]string{"type": "dns", "value": o.host},
+			"challenges": []any{s.challengeJSON(id)},
+		})
+
+	case scan(r.URL.Path, "/challenge/%d", &id) && s.order(id) != nil:
+		o := s.order(id)
+		o.authz = acme.StatusInvalid
+		if s.validate(o) == nil {
+			o.authz = acme.StatusValid
+		}
+		writeJSON(w, http.StatusOK, s.challengeJSON(id))
+
+	case scan(r.URL.Path, "/finalize/%d", &id) && s.order(id) != nil:
+		o := s.order(id)
+		var req struct{ CSR string }
+		json.Unmarshal(payload, &req)
+		csr, _ := base64.RawURLEncoding.DecodeString(req.CSR)
+		cert, err := s.sign(csr)
+		if err != nil || o.authz != acme.StatusValid {
+			http.Error(w, "invalid finalize", http.StatusForbidden)
+			return
+		}
+		o.cert = cert
+		s.writeOrder(w, http.StatusOK, id)
+
+	case scan(r.URL.Path, "/cert/%d", &id) && s.order(id) != nil:
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		w.Write(s.order(id).cert)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *acmeTestServer) order(id int) *acmeTestOrder {
+	if id < 0 || id >= len(s.orders) {
+		return nil
+	}
+	return s.orders[id]
+}
+
+func (s *acmeTestServer) writeOrder(w http.ResponseWriter, code, id int) {
+	o := s.orders[id]
+	status := acme.StatusPending
+	switch {
+	case o.cert != nil:
+		status = acme.StatusValid
+	case o.authz == acme.StatusValid:
+		status = acme.StatusReady
+	}
+	v := map[string]any{
+		"status":         status,
+		"identifiers":    []map[string]string{{"type": "dns", "value": o.host}},
+		"authorizations": []string{fmt.Sprintf("%s/authz/%d", s.URL, id)},
+		"finalize":       fmt.Sprintf("%s/finalize/%d", s.URL, id),
+	}
+	if o.cert != nil {
+		v["certificate"] = fmt.Sprintf("%s/cert/%d", s.URL, id)
+	}
+	w.Header().Set("Location", fmt.Sprintf("%s/order/%d", s.URL, id))
+	writeJSON(w, code, v)
+}
+
+func (s *acmeTestServer) challengeJSON(id int) map[string]any {
+	return map[string]any{
+		"type":   s.challenge,
+		"url":    fmt.Sprintf("%s/challenge/%d", s.URL, id),
+		"token":  s.orders[id].token,
+		"status": s.orders[id].authz,
+	}
+}
+
+// validate checks that the client answers the challenge. The key
+// authorization is not verified.
+func (s *acmeTestServer) validate(o *acmeTestOrder) error {
+	addr := s.addrs[o.host+" "+s.challenge]
+	if s.challenge == "http-01" {
+		req, _ := http.NewRequest("GET", "http://"+addr+"/.well-known/acme-challenge/"+o.token, nil)
+		req.Host = o.host
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK || !strings.HasPrefix(string(b), o.token+".") {
+			return fmt.Errorf("http-01: got %d %q", resp.StatusCode, b)
+		}
+		return nil
+	}
+
+	conn, err := tls.Dial("tcp", addr, &tls.Config{
+		ServerName:         o.host,
+		NextProtos:         []string{acme.ALPNProto},
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	for _, ext := range conn.ConnectionState().PeerCertificates[0].Extensions {
+		if ext.Id.String() == "1.3.6.1.5.5.7.1.31" { // id-pe-acmeIdentifier
+			return nil
+		}
+	}
+	return fmt.Errorf("tls-alpn-01: no acmeIdentifier for %s", o.host)
+}
+
+// sign issues a certificate for the CSR with the certificate of the
+// test server as CA.
+func (s *acmeTestServer) sign(der []byte) ([]byte, error) {
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		DNSNames:     csr.DNSNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+	}
+	crt, err := x509.CreateCertificate(rand.Reader, tmpl, s.Certificate(), csr.PublicKey, s.TLS.Certificates[0].PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	pem.Encode(&b, &pem.Block{Type: "CERTIFICATE", Bytes: crt})
+	return b.Bytes(), nil
+}
+
+func scan(path, format string, id *int) bool {
+	n, err := fmt.Sscanf(path, format, id)
+	return n == 1 && err == nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
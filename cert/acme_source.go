@@ -0,0 +1,361 @@
+package cert
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+	"github.com/hashicorp/consul/api"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// ACMESource implements a certificate source which obtains certificates
+// from an ACME server like Let's Encrypt for the host names of the routing
+// table. A certificate is obtained during the first TLS handshake for a
+// host name and is renewed automatically before it expires.
+//
+// The ACME server validates the host names with the TLS-ALPN-01 challenge
+// on the listeners which use the source and with the HTTP-01 challenge on
+// the HTTP listeners. The account key, the certificates and the challenge
+// responses are stored in a directory or in the consul KV store. With the
+// consul KV store multiple fabio instances share the certificates and only
+// one of them obtains or renews the certificate for a host name at a time.
+type ACMESource struct {
+	// DirectoryURL is the URL of the ACME directory.
+	DirectoryURL string
+
+	// Email is the optional contact address of the ACME account.
+	Email string
+
+	// Storage is a directory or the URL of a consul KV path like
+	// http://localhost:8500/v1/kv/fabio/acme?token=abc123
+	Storage string
+
+	// DirectoryCAPath is the optional path of a PEM file with the CA
+	// certificates for validating the ACME server.
+	DirectoryCAPath string
+
+	ClientCAPath string
+	CAUpgradeCN  string
+
+	once    sync.Once
+	err     error
+	manager *autocert.Manager
+	http    http.Handler
+
+	// lock acquires the lock for obtaining the certificate for a host
+	// name across fabio instances. It is nil for directory storage.
+	lock func(ctx context.Context, host string) (unlock func(), err error)
+
+	mu     sync.Mutex
+	issued map[string]bool
+}
+
+var (
+	acmeMu sync.Mutex
+
+	// acmeSources contains the ACME sources by name. Listeners which
+	// use the same source share the ACME account and the certificates
+	// and the HTTP listeners answer the challenges of all sources.
+	acmeSources = map[string]*ACMESource{}
+)
+
+// newACMESource returns the ACME source for the configuration.
+func newACMESource(cfg config.CertSource) *ACMESource {
+	acmeMu.Lock()
+	defer acmeMu.Unlock()
+	if s, ok := acmeSources[cfg.Name]; ok {
+		return s
+	}
+	s := &ACMESource{
+		DirectoryURL:    cfg.CertPath,
+		Email:           cfg.Email,
+		Storage:         cfg.StoragePath,
+		DirectoryCAPath: cfg.DirectoryCAPath,
+		ClientCAPath:    cfg.ClientCAPath,
+		CAUpgradeCN:     cfg.CAUpgradeCN,
+	}
+	acmeSources[cfg.Name] = s
+	return s
+}
+
+func (s *ACMESource) LoadClientCAs() (*x509.CertPool, error) {
+	return FileSource{ClientAuthFile: s.ClientCAPath, CAUpgradeCN: s.CAUpgradeCN}.LoadClientCAs()
+}
+
+// Certificates returns a closed channel since the certificates are
+// obtained during the TLS handshake.
+func (s *ACMESource) Certificates() chan []tls.Certificate {
+	ch := make(chan []tls.Certificate)
+	close(ch)
+	return ch
+}
+
+// GetCertificate returns the certificate for the server name of the
+// client hello and answers the TLS-ALPN-01 challenges. It implements
+// the tls.Config.GetCertificate hook.
+func (s *ACMESource) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if err := s.init(); err != nil {
+		return nil, err
+	}
+
+	host := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if s.lock == nil || isChallengeHello(hello) || s.hasCert(host) {
+		return s.manager.GetCertificate(hello)
+	}
+
+	// only one instance obtains the certificate. The others find it
+	// in the shared storage once they get the lock. The handshake
+	// fails if the lock is not available within acmeLockTimeout.
+	if err := checkHostPolicy(host); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), acmeLockTimeout)
+	defer cancel()
+	unlock, err := s.lock(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	cert, err := s.manager.GetCertificate(hello)
+	if err == nil {
+		s.mu.Lock()
+		s.issued[host] = true
+		s.mu.Unlock()
+	}
+	return cert, err
+}
+
+func (s *ACMESource) hasCert(host string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.issued[host]
+}
+
+// isChallengeHello returns true if the client hello is from an ACME
+// server which validates a TLS-ALPN-01 challenge.
+func isChallengeHello(hello *tls.ClientHelloInfo) bool {
+	return len(hello.SupportedProtos) == 1 && hello.SupportedProtos[0] == acme.ALPNProto
+}
+
+func (s *ACMESource) init() error {
+	s.once.Do(func() {
+		s.err = s.setup()
+		if s.err != nil {
+			log.Printf("[ERROR] cert: Cannot create ACME source for %s. %s", s.DirectoryURL, s.err)
+		}
+	})
+	return s.err
+}
+
+func (s *ACMESource) setup() error {
+	client := &acme.Client{DirectoryURL: s.DirectoryURL, UserAgent: "fabio"}
+	if s.DirectoryCAPath != "" {
+		pool, err := LoadCAs(s.DirectoryCAPath)
+		if err != nil {
+			return err
+		}
+		client.HTTPClient = &http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{RootCAs: pool},
+			},
+		}
+	}
+
+	if s.Storage == "" {
+		return errors.New("missing storage")
+	}
+	cache, lock, err := newCache(s.Storage)
+	if err != nil {
+		return err
+	}
+	if lock != nil {
+		s.lock = lock
+		cache = &renewCache{Cache: cache, src: s}
+	}
+
+	s.issued = map[string]bool{}
+	s.manager = &autocert.Manager{
+		Prompt: autocert.AcceptTOS,
+		Cache:  cache,
+		HostPolicy: func(_ context.Context, host string) error {
+			return checkHostPolicy(host)
+		},
+		Client: client,
+		Email:  s.Email,
+	}
+
+	// the HTTP handler also enables the HTTP-01 challenge
+	s.http = s.manager.HTTPHandler(http.NotFoundHandler())
+	return nil
+}
+
+// acmeLockTimeout is the maximum time a TLS handshake waits for another
+// fabio instance to obtain a certificate.
+const acmeLockTimeout = 10 * time.Second
+
+// renewCache coordinates the renewals of autocert across fabio instances.
+// autocert renews a certificate in the background by loading it from the
+// cache and obtaining a new one unless another instance has renewed it
+// already. renewCache acquires the lock for the host name when autocert
+// loads a certificate which this instance has obtained before and holds
+// it until the renewal is done. The wait for the lock is bounded by the
+// timeout of the renewal. A failed renewal is retried by autocert.
+type renewCache struct {
+	autocert.Cache
+	src *ACMESource
+}
+
+func (c *renewCache) Get(ctx context.Context, key string) ([]byte, error) {
+	if host, ok := certHost(key); ok && c.src.hasCert(host) {
+		unlock, err := c.src.lock(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		context.AfterFunc(ctx, unlock)
+	}
+	return c.Cache.Get(ctx, key)
+}
+
+// certHost returns the host name of the cache key of a certificate.
+// Keys of the account and of the challenges have a different suffix.
+func certHost(key string) (host string, ok bool) {
+	host = strings.TrimSuffix(key, "+rsa")
+	return host, !strings.Contains(host, "+")
+}
+
+// newCache returns the cache for the storage which is either a directory
+// or the URL of a consul KV path. For the consul KV store it also returns
+// the function which acquires the lock for a host name.
+func newCache(storage string) (cache autocert.Cache, lock func(ctx context.Context, host string) (func(), error), err error) {
+	if !strings.HasPrefix(storage, "http://") && !strings.HasPrefix(storage, "https://") {
+		return autocert.DirCache(storage), nil, nil
+	}
+	cfg, key, err := parseConsulURL(storage)
+	if err != nil {
+		return nil, nil, err
+	}
+	c, err := api.NewClient(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	kv := &consulCache{client: c, prefix: key}
+	return kv, kv.lock, nil
+}
+
+// consulCache implements the autocert.Cache interface with the
+// consul KV store.
+type consulCache struct {
+	client *api.Client
+	prefix string
+}
+
+func (c *consulCache) Get(ctx context.Context, key string) ([]byte, error) {
+	q := (&api.QueryOptions{RequireConsistent: true}).WithContext(ctx)
+	kv, _, err := c.client.KV().Get(path.Join(c.prefix, key), q)
+	if err != nil {
+		return nil, fmt.Errorf("consul: get: %s", err)
+	}
+	if kv == nil {
+		return nil, autocert.ErrCacheMiss
+	}
+	return kv.Value, nil
+}
+
+func (c *consulCache) Put(ctx context.Context, key string, data []byte) error {
+	w := (&api.WriteOptions{}).WithContext(ctx)
+	if _, err := c.client.KV().Put(&api.KVPair{Key: path.Join(c.prefix, key), Value: data}, w); err != nil {
+		return fmt.Errorf("consul: put: %s", err)
+	}
+	return nil
+}
+
+func (c *consulCache) Delete(ctx context.Context, key string) error {
+	w := (&api.WriteOptions{}).WithContext(ctx)
+	if _, err := c.client.KV().Delete(path.Join(c.prefix, key), w); err != nil {
+		return fmt.Errorf("consul: delete: %s", err)
+	}
+	return nil
+}
+
+// lock acquires the consul lock for obtaining the certificate for the
+// host name. It gives up when the context is done.
+func (c *consulCache) lock(ctx context.Context, host string) (unlock func(), err error) {
+	l, err := c.client.LockOpts(&api.LockOptions{
+		Key:         path.Join(c.prefix, "locks", host),
+		SessionName: "fabio-cert",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("consul: lock: %s", err)
+	}
+
+	lost, err := l.Lock(ctx.Done())
+	if err != nil {
+		return nil, fmt.Errorf("consul: lock: %s", err)
+	}
+	if lost == nil {
+		return nil, fmt.Errorf("consul: lock: timeout for %s", host)
+	}
+	return func() { l.Unlock() }, nil
+}
+
+// ACMEChallengeHandler returns a handler which answers the HTTP-01
+// challenges of the ACME sources and passes all other requests to next.
+func ACMEChallengeHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/.well-known/acme-challenge/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		acmeMu.Lock()
+		var srcs []*ACMESource
+		for _, s := range acmeSources {
+			srcs = append(srcs, s)
+		}
+		acmeMu.Unlock()
+
+		for _, s := range srcs {
+			if s.init() != nil {
+				continue
+			}
+			resp := &challengeResponse{header: http.Header{}, code: http.StatusOK}
+			s.http.ServeHTTP(resp, r)
+			if resp.code == http.StatusOK {
+				for k, v := range resp.header {
+					w.Header()[k] = v
+				}
+				w.Write(resp.body.Bytes())
+				return
+			}
+		}
+
+		// the challenge might be for the upstream
+		next.ServeHTTP(w, r)
+	})
+}
+
+// challengeResponse buffers the response of the challenge handler
+// of an ACME source.
+type challengeResponse struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+}
+
+func (r *challengeResponse) Header() http.Header         { return r.header }
+func (r *challengeResponse) Write(b []byte) (int, error) { return r.body.Write(b) }
+func (r *challengeResponse) WriteHeader(code int)        { r.code = code }
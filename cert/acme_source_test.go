@@ -0,0 +1,268 @@
+package cert
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+	"github.com/hashicorp/consul/api"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+func TestACMESource(t *testing.T) {
+	SetHostPolicy(func(host string) bool { return host == "example.com" })
+	defer SetHostPolicy(nil)
+
+	for _, challenge := range []string{"tls-alpn-01", "http-01"} {
+		t.Run(challenge, func(t *testing.T) {
+			ca := newACMETestServer(t, challenge)
+			dir := t.TempDir()
+			caFile := filepath.Join(dir, "acme-ca.pem")
+			writeFile(caFile, ca.directoryCA())
+
+			newSource := func(name string) Source {
+				src, err := NewSource(config.CertSource{
+					Name:            name,
+					Type:            "acme",
+					CertPath:        ca.URL + "/dir",
+					StoragePath:     filepath.Join(dir, "acme"),
+					DirectoryCAPath: caFile,
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				t.Cleanup(func() {
+					acmeMu.Lock()
+					delete(acmeSources, name)
+					acmeMu.Unlock()
+				})
+				return src
+			}
+
+			// https listener which answers the TLS-ALPN-01 challenges
+			httpsAddr := serveTLS(t, newSource(challenge))
+			ca.setAddr("example.com", "tls-alpn-01", httpsAddr)
+
+			// http listener which answers the HTTP-01 challenges
+			httpSrv := httptest.NewServer(ACMEChallengeHandler(http.NotFoundHandler()))
+			defer httpSrv.Close()
+			ca.setAddr("example.com", "http-01", httpSrv.Listener.Addr().String())
+
+			cfg := &tls.Config{RootCAs: ca.roots(), ServerName: "example.com"}
+			if err := handshake(httpsAddr, cfg); err != nil {
+				t.Fatalf("handshake: got %v want nil", err)
+			}
+			if got, want := ca.issuedHosts(), []string{"example.com"}; !reflect.DeepEqual(got, want) {
+				t.Fatalf("got issued %v want %v", got, want)
+			}
+
+			// no certificates for hosts which are not allowed
+			cfg.ServerName = "other.com"
+			if err := handshake(httpsAddr, cfg); err == nil {
+				t.Fatal("handshake for other.com: got nil want error")
+			}
+
+			// a new instance uses the stored certificate
+			addr := serveTLS(t, newSource(challenge+"-restart"))
+			cfg.ServerName = "example.com"
+			if err := handshake(addr, cfg); err != nil {
+				t.Fatalf("handshake after restart: got %v want nil", err)
+			}
+			if got, want := len(ca.issuedHosts()), 1; got != want {
+				t.Fatalf("got %d issued certificates want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestACMEChallengeHandler(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "next")
+	})
+	srv := httptest.NewServer(ACMEChallengeHandler(next))
+	defer srv.Close()
+
+	// unknown challenges and other requests are passed to the upstream
+	for _, path := range []string{"/foo", "/.well-known/acme-challenge/unknown"} {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if got, want := string(body), "next"; got != want {
+			t.Fatalf("%s: got %q want %q", path, got, want)
+		}
+	}
+}
+
+func TestConsulCache(t *testing.T) {
+	kv := newConsulKVServer()
+	defer kv.Close()
+
+	client, err := api.NewClient(&api.Config{Address: strings.TrimPrefix(kv.URL, "http://")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &consulCache{client: client, prefix: "fabio/acme"}
+	ctx := context.Background()
+
+	if _, err := c.Get(ctx, "example.com"); err != autocert.ErrCacheMiss {
+		t.Fatalf("got %v want %v", err, autocert.ErrCacheMiss)
+	}
+	if err := c.Put(ctx, "example.com", []byte("cert")); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := kv.keys(), []string{"fabio/acme/example.com"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got keys %v want %v", got, want)
+	}
+	b, err := c.Get(ctx, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), "cert"; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+	if err := c.Delete(ctx, "example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(ctx, "example.com"); err != autocert.ErrCacheMiss {
+		t.Fatalf("got %v want %v", err, autocert.ErrCacheMiss)
+	}
+}
+
+func TestRenewCache(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		locked []string
+	)
+	held := map[string]bool{}
+	src := &ACMESource{
+		issued: map[string]bool{"example.com": true},
+		lock: func(ctx context.Context, host string) (func(), error) {
+			mu.Lock()
+			defer mu.Unlock()
+			locked = append(locked, host)
+			held[host] = true
+			return func() {
+				mu.Lock()
+				delete(held, host)
+				mu.Unlock()
+			}, nil
+		},
+	}
+	c := &renewCache{Cache: autocert.DirCache(t.TempDir()), src: src}
+
+	// only the certificates which are renewed are locked
+	for _, key := range []string{"acme_account+key", "example.com+token", "other.com"} {
+		c.Get(context.Background(), key)
+	}
+	mu.Lock()
+	if len(locked) != 0 {
+		t.Fatalf("got locked %v want none", locked)
+	}
+	mu.Unlock()
+
+	// the lock is held until the renewal is done
+	ctx, cancel := context.WithCancel(context.Background())
+	c.Get(ctx, "example.com+rsa")
+	mu.Lock()
+	if !held["example.com"] {
+		t.Fatal("lock not held during renewal")
+	}
+	mu.Unlock()
+	cancel()
+	deadline := time.Now().Add(time.Second)
+	for {
+		mu.Lock()
+		ok := !held["example.com"]
+		mu.Unlock()
+		if ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("lock not released after renewal")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// serveTLS serves HTTPS with the certificates of the source and returns
+// the address of the listener.
+func serveTLS(t *testing.T, src Source) string {
+	cfg, err := TLSConfig(src, false, 0, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: http.NotFoundHandler()}
+	go srv.Serve(tls.NewListener(l, cfg))
+	t.Cleanup(func() { srv.Close() })
+	return l.Addr().String()
+}
+
+func handshake(addr string, cfg *tls.Config) error {
+	conn, err := tls.Dial("tcp", addr, cfg)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// consulKVServer implements the key/value endpoints of the consul API.
+type consulKVServer struct {
+	*httptest.Server
+	mu sync.Mutex
+	kv map[string][]byte
+}
+
+func newConsulKVServer() *consulKVServer {
+	s := &consulKVServer{kv: map[string][]byte{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		switch r.Method {
+		case "GET":
+			v, ok := s.kv[key]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			json.NewEncoder(w).Encode([]*api.KVPair{{Key: key, Value: v}})
+		case "PUT":
+			var b bytes.Buffer
+			b.ReadFrom(r.Body)
+			s.kv[key] = b.Bytes()
+			io.WriteString(w, "true")
+		case "DELETE":
+			delete(s.kv, key)
+			io.WriteString(w, "true")
+		}
+	}))
+	return s
+}
+
+func (s *consulKVServer) keys() (keys []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k := range s.kv {
+		keys = append(keys, k)
+	}
+	return keys
+}
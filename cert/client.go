@@ -0,0 +1,58 @@
+package cert
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// ClientTLSConfig creates a tls.Config for upstream connections which
+// presents a client certificate from the given source. The certificates
+// are updated when the source changes the same way as the certificates
+// of the listeners.
+//
+// rootCAs are the CA certificates for validating the upstream
+// certificates. If rootCAs is nil the system roots are used.
+func ClientTLSConfig(src Source, rootCAs *x509.CertPool) *tls.Config {
+	store := NewStore()
+	go func() {
+		for certs := range src.Certificates() {
+			store.SetCertificates(certs)
+		}
+	}()
+
+	return &tls.Config{
+		RootCAs: rootCAs,
+		GetClientCertificate: func(cri *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return getClientCertificate(store.certstore(), cri)
+		},
+	}
+}
+
+// getClientCertificate returns the first certificate which is accepted
+// by the server or the first certificate if none of them is.
+func getClientCertificate(cs certstore, cri *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	if len(cs.Certificates) == 0 {
+		return nil, ErrNoCertsStored
+	}
+	for i := range cs.Certificates {
+		if cri.SupportsCertificate(&cs.Certificates[i]) == nil {
+			return &cs.Certificates[i], nil
+		}
+	}
+	return &cs.Certificates[0], nil
+}
+
+// LoadCAs loads the PEM encoded CA certificates from the file.
+func LoadCAs(path string) (*x509.CertPool, error) {
+	pemBlocks, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pemBlocks) {
+		return nil, fmt.Errorf("cert: no certificates in %s", path)
+	}
+	return pool, nil
+}
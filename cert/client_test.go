@@ -0,0 +1,63 @@
+package cert
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestClientTLSConfig(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.TLS.PeerCertificates[0].DNSNames[0])
+	}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	srv.StartTLS()
+	defer srv.Close()
+
+	// trust the certificate of the test server
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	writeFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}))
+	srvCA, err := LoadCAs(caFile)
+	if err != nil {
+		t.Fatalf("LoadCAs: got %v want nil", err)
+	}
+
+	cfg := ClientTLSConfig(StaticSource{cert: makeCert("client.com", time.Minute)}, srvCA)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+
+	// the certificate is loaded in the background
+	var body []byte
+	ok := waitFor(time.Second, func() bool {
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			return false
+		}
+		defer resp.Body.Close()
+		body, err = io.ReadAll(resp.Body)
+		return err == nil
+	})
+	if !ok {
+		t.Fatal("timeout")
+	}
+	if got, want := string(body), "client.com"; got != want {
+		t.Fatalf("got client cert %q want %q", got, want)
+	}
+}
+
+func TestLoadCAs(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := LoadCAs(filepath.Join(dir, "missing.pem")); err == nil {
+		t.Fatal("got nil want error for missing file")
+	}
+	empty := filepath.Join(dir, "empty.pem")
+	writeFile(empty, []byte("foo"))
+	if _, err := LoadCAs(empty); err == nil {
+		t.Fatal("got nil want error for file without certificates")
+	}
+}
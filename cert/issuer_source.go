@@ -0,0 +1,426 @@
+package cert
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/sync/singleflight"
+)
+
+var (
+	hostPolicyMu sync.RWMutex
+
+	// hostPolicy returns true if certificates can be obtained for the
+	// host name. It is nil until SetHostPolicy is called.
+	hostPolicy func(host string) bool
+)
+
+// SetHostPolicy sets the function which decides whether certificates
+// can be obtained for a host name. Without a host policy no certificates
+// are obtained.
+func SetHostPolicy(f func(host string) bool) {
+	hostPolicyMu.Lock()
+	hostPolicy = f
+	hostPolicyMu.Unlock()
+}
+
+func checkHostPolicy(host string) error {
+	hostPolicyMu.RLock()
+	f := hostPolicy
+	hostPolicyMu.RUnlock()
+	if f == nil || !f(host) {
+		return fmt.Errorf("cert: host %q not allowed", host)
+	}
+	return nil
+}
+
+const (
+	// defaultIssueRefresh is the default time before the expiry of
+	// a certificate when it is re-issued.
+	defaultIssueRefresh = time.Hour
+
+	// defaultMaxIssue is the default number of certificates which
+	// are issued concurrently.
+	defaultMaxIssue = 4
+
+	// issueRetry is the time after which a failed re-issue is retried.
+	issueRetry = time.Minute
+)
+
+// IssuerSource implements a certificate source which issues certificates
+// on-demand with an Issuer during the TLS handshake. Certificates are only
+// issued for the host names allowed by the host policy unless NoHostCheck
+// is set. They are stored in
+// the optional storage to survive restarts and are re-issued before they
+// expire.
+type IssuerSource struct {
+	// Source provides the client authentication certificates.
+	Source
+
+	Issuer Issuer
+
+	// Storage is an optional directory or the URL of a consul KV path like
+	// http://localhost:8500/v1/kv/fabio/certs?token=abc123 for storing the
+	// issued certificates. With the consul KV store only one fabio instance
+	// issues the certificate for a host name at a time.
+	Storage string
+
+	// Re-issue certificates this long before they expire. The default
+	// is one hour.
+	Refresh time.Duration
+
+	// MaxIssue is the maximum number of certificates which are issued
+	// concurrently. The default is 4.
+	MaxIssue int
+
+	// NoHostCheck disables the host policy so that certificates are
+	// issued for any host name.
+	NoHostCheck bool
+
+	once  sync.Once
+	err   error
+	cache autocert.Cache
+	lock  func(ctx context.Context, host string) (unlock func(), err error)
+	sem   chan struct{}
+	sf    singleflight.Group
+
+	mu     sync.Mutex
+	certs  map[string]*tls.Certificate
+	timers map[string]*time.Timer
+}
+
+// issuingSource is a certificate source which can issue certificates.
+type issuingSource interface {
+	Source
+	Issuer
+}
+
+var (
+	issuerMu sync.Mutex
+
+	// issuerSources contains the issuer sources by name. Listeners
+	// which use the same source share the issued certificates.
+	issuerSources = map[string]*IssuerSource{}
+)
+
+// newIssuerSource returns the issuer source for the configuration.
+func newIssuerSource(cfg config.CertSource, src issuingSource) *IssuerSource {
+	issuerMu.Lock()
+	defer issuerMu.Unlock()
+	if s, ok := issuerSources[cfg.Name]; ok {
+		return s
+	}
+	s := &IssuerSource{
+		Source:      src,
+		Issuer:      src,
+		Storage:     cfg.StoragePath,
+		Refresh:     max(cfg.Refresh, time.Hour),
+		MaxIssue:    cfg.MaxIssue,
+		NoHostCheck: cfg.NoHostCheck,
+	}
+	issuerSources[cfg.Name] = s
+	return s
+}
+
+// issuerSourceFor returns the issuer source for src or nil if src cannot
+// issue certificates.
+func issuerSourceFor(src Source) *IssuerSource {
+	switch s := src.(type) {
+	case *IssuerSource:
+		return s
+	case Issuer:
+		return &IssuerSource{Source: src, Issuer: s}
+	}
+	return nil
+}
+
+func (s *IssuerSource) init() error {
+	s.once.Do(func() {
+		s.err = s.setup()
+		if s.err != nil {
+			log.Printf("[ERROR] cert: Cannot create storage %s. %s", s.Storage, s.err)
+		}
+	})
+	return s.err
+}
+
+func (s *IssuerSource) setup() error {
+	if s.Refresh <= 0 {
+		s.Refresh = defaultIssueRefresh
+	}
+	if s.MaxIssue <= 0 {
+		s.MaxIssue = defaultMaxIssue
+	}
+	s.sem = make(chan struct{}, s.MaxIssue)
+	s.certs = map[string]*tls.Certificate{}
+	s.timers = map[string]*time.Timer{}
+	if s.Storage == "" {
+		return nil
+	}
+	cache, lock, err := newCache(s.Storage)
+	if err != nil {
+		return err
+	}
+	s.cache, s.lock = cache, lock
+	return nil
+}
+
+// getCertificate returns the certificate for the server name. It loads
+// the certificate from the storage or issues a new one if the host
+// policy allows it. Otherwise, it returns one of the issued certificates
+// unless strictMatch is set.
+func (s *IssuerSource) getCertificate(serverName string, strictMatch bool) (*tls.Certificate, error) {
+	if err := s.init(); err != nil {
+		return nil, err
+	}
+
+	host := strings.ToLower(strings.TrimSuffix(serverName, "."))
+	if cert := s.cert(host); cert != nil {
+		return cert, nil
+	}
+
+	if err := s.checkHost(host); err != nil {
+		if cert := s.first(); cert != nil && !strictMatch {
+			return cert, nil
+		}
+		return nil, err
+	}
+
+	x, err, _ := s.sf.Do(host, func() (any, error) {
+		return s.load(host)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return x.(*tls.Certificate), nil
+}
+
+// checkHost returns an error if certificates cannot be issued for the
+// host.
+func (s *IssuerSource) checkHost(host string) error {
+	if s.NoHostCheck {
+		return nil
+	}
+	return checkHostPolicy(host)
+}
+
+// cert returns the certificate for the host if it has not expired.
+func (s *IssuerSource) cert(host string) *tls.Certificate {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cert := s.certs[host]
+	if cert == nil || time.Now().After(cert.Leaf.NotAfter) {
+		return nil
+	}
+	return cert
+}
+
+// first returns the issued certificate with the lowest host name.
+func (s *IssuerSource) first() *tls.Certificate {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var hosts []string
+	for host := range s.certs {
+		hosts = append(hosts, host)
+	}
+	if len(hosts) == 0 {
+		return nil
+	}
+	sort.Strings(hosts)
+	return s.certs[hosts[0]]
+}
+
+// load returns the certificate for the host from the storage or issues
+// a new one.
+func (s *IssuerSource) load(host string) (*tls.Certificate, error) {
+	if cert := s.cert(host); cert != nil {
+		return cert, nil
+	}
+
+	// only one instance issues the certificate. The others find
+	// it in the shared storage once they get the lock.
+	if s.lock != nil {
+		unlock, err := s.lockHost(host)
+		if err != nil {
+			return nil, err
+		}
+		defer unlock()
+	}
+
+	cert, err := s.get(host)
+	if err != nil {
+		if cert, err = s.issue(host); err != nil {
+			return nil, err
+		}
+	}
+	s.add(host, cert)
+	return cert, nil
+}
+
+// lockTimeout is the maximum time to wait for another fabio
+// instance to issue a certificate.
+const lockTimeout = 5 * time.Minute
+
+// lockHost acquires the lock for issuing the certificate for the host.
+func (s *IssuerSource) lockHost(host string) (unlock func(), err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), lockTimeout)
+	defer cancel()
+	return s.lock(ctx, host)
+}
+
+// renew re-issues the certificate for the host unless the host policy
+// no longer allows it or the certificate has been replaced in the
+// meantime.
+func (s *IssuerSource) renew(host string, old *tls.Certificate) {
+	s.mu.Lock()
+	current := s.certs[host] == old
+	s.mu.Unlock()
+	if !current {
+		return
+	}
+
+	if err := s.checkHost(host); err != nil {
+		s.remove(host, old)
+		log.Printf("[INFO] cert: Not re-issuing cert for %s. %s", host, err)
+		return
+	}
+
+	_, err, _ := s.sf.Do(host, func() (any, error) {
+		if s.lock != nil {
+			unlock, err := s.lockHost(host)
+			if err != nil {
+				return nil, err
+			}
+			defer unlock()
+		}
+
+		// another instance might have re-issued it already
+		cert, err := s.get(host)
+		if err != nil || !cert.Leaf.NotAfter.After(old.Leaf.NotAfter) {
+			if cert, err = s.issue(host); err != nil {
+				return nil, err
+			}
+		}
+		s.add(host, cert)
+		return cert, nil
+	})
+	if err == nil {
+		return
+	}
+
+	if time.Now().Add(issueRetry).After(old.Leaf.NotAfter) {
+		s.remove(host, old)
+		log.Printf("[ERROR] cert: Failed to re-issue cert for %s: %s", host, err)
+		return
+	}
+	log.Printf("[ERROR] cert: Failed to re-issue cert for %s. Retrying in %s: %s", host, issueRetry, err)
+	s.mu.Lock()
+	if s.certs[host] == old {
+		s.schedule(host, old, issueRetry)
+	}
+	s.mu.Unlock()
+}
+
+// issue issues a new certificate for the host and stores it.
+func (s *IssuerSource) issue(host string) (*tls.Certificate, error) {
+	s.sem <- struct{}{}
+	cert, err := s.Issuer.Issue(host)
+	<-s.sem
+	if err != nil {
+		return nil, err
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return nil, fmt.Errorf("cert: issue: %s", err)
+		}
+	}
+	log.Printf("[INFO] cert: Issued cert for %s valid until %s", host, cert.Leaf.NotAfter.Format(time.RFC3339))
+
+	if s.cache != nil {
+		if err := s.put(host, cert); err != nil {
+			log.Printf("[ERROR] cert: Cannot store cert for %s. %s", host, err)
+		}
+	}
+	return cert, nil
+}
+
+// add makes the certificate available for the TLS handshakes and
+// schedules the re-issue. The certificate is re-issued Refresh before
+// it expires but not before half of its remaining validity period.
+func (s *IssuerSource) add(host string, cert *tls.Certificate) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.certs[host] = cert
+	ttl := time.Until(cert.Leaf.NotAfter)
+	s.schedule(host, cert, max(ttl-s.Refresh, ttl/2))
+}
+
+// schedule replaces the re-issue timer of the host so that there is
+// only one per host. The caller must hold mu.
+func (s *IssuerSource) schedule(host string, cert *tls.Certificate, d time.Duration) {
+	if t := s.timers[host]; t != nil {
+		t.Stop()
+	}
+	s.timers[host] = time.AfterFunc(d, func() { s.renew(host, cert) })
+}
+
+// remove removes the certificate for the host and its re-issue timer
+// unless the certificate has been replaced.
+func (s *IssuerSource) remove(host string, cert *tls.Certificate) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.certs[host] != cert {
+		return
+	}
+	delete(s.certs, host)
+	if t := s.timers[host]; t != nil {
+		t.Stop()
+		delete(s.timers, host)
+	}
+}
+
+// get loads the certificate for the host from the storage. Expired
+// certificates are ignored.
+func (s *IssuerSource) get(host string) (*tls.Certificate, error) {
+	if s.cache == nil {
+		return nil, autocert.ErrCacheMiss
+	}
+	b, err := s.cache.Get(context.Background(), host+".pem")
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.X509KeyPair(b, b)
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(cert.Leaf.NotAfter) {
+		return nil, autocert.ErrCacheMiss
+	}
+	return &cert, nil
+}
+
+// put stores the certificate and the private key for the host in PEM
+// format like the path source expects it.
+func (s *IssuerSource) put(host string, cert *tls.Certificate) error {
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		return err
+	}
+	var b bytes.Buffer
+	pem.Encode(&b, &pem.Block{Type: "PRIVATE KEY", Bytes: key})
+	for _, der := range cert.Certificate {
+		pem.Encode(&b, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	}
+	return s.cache.Put(context.Background(), host+".pem", b.Bytes())
+}
@@ -0,0 +1,276 @@
+package cert
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingIssuer counts the issued certificates and the maximum
+// number of concurrent issues.
+type countingIssuer struct {
+	Issuer
+	delay time.Duration
+
+	mu                sync.Mutex
+	n, active, maxRun int
+}
+
+func (c *countingIssuer) Issue(commonName string) (*tls.Certificate, error) {
+	c.mu.Lock()
+	c.n++
+	c.active++
+	c.maxRun = max(c.maxRun, c.active)
+	c.mu.Unlock()
+
+	time.Sleep(c.delay)
+
+	c.mu.Lock()
+	c.active--
+	c.mu.Unlock()
+	return c.Issuer.Issue(commonName)
+}
+
+func (c *countingIssuer) counts() (n, maxRun int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.n, c.maxRun
+}
+
+func TestLocalCASource(t *testing.T) {
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+
+	src := &LocalCASource{CAFile: caFile, Validity: time.Hour}
+	cert, err := src.Issue("example.com")
+	if err != nil {
+		t.Fatalf("Issue: got %v want nil", err)
+	}
+
+	// the CA is created and the certificate is signed by it
+	b, err := os.ReadFile(caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := makeCertPool(b)
+	if _, err := cert.Leaf.Verify(x509.VerifyOptions{DNSName: "example.com", Roots: roots}); err != nil {
+		t.Fatalf("Verify: got %v want nil", err)
+	}
+	if got, want := cert.Leaf.NotAfter.Sub(cert.Leaf.NotBefore), time.Hour+time.Minute; got != want {
+		t.Fatalf("got validity %s want %s", got, want)
+	}
+
+	// another source uses the existing CA
+	src = &LocalCASource{CAFile: caFile}
+	cert, err = src.Issue("127.0.0.1")
+	if err != nil {
+		t.Fatalf("Issue: got %v want nil", err)
+	}
+	if _, err := cert.Leaf.Verify(x509.VerifyOptions{DNSName: "127.0.0.1", Roots: roots}); err != nil {
+		t.Fatalf("Verify: got %v want nil", err)
+	}
+	if got, want := cert.Leaf.IPAddresses, []net.IP{net.ParseIP("127.0.0.1")}; len(got) != 1 || !got[0].Equal(want[0]) {
+		t.Fatalf("got IP addresses %v want %v", got, want)
+	}
+
+	// a certificate which is not a CA is rejected
+	store := &IssuerSource{Storage: dir}
+	if err := store.setup(); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.put("127.0.0.1", cert); err != nil {
+		t.Fatal(err)
+	}
+	src = &LocalCASource{CAFile: filepath.Join(dir, "127.0.0.1.pem")}
+	if _, err := src.Issue("example.com"); err == nil {
+		t.Fatal("Issue with a non-CA certificate: got nil want error")
+	}
+}
+
+func TestIssuerSource(t *testing.T) {
+	SetHostPolicy(func(host string) bool { return host == "example.com" })
+	defer SetHostPolicy(nil)
+
+	dir := t.TempDir()
+	newSource := func(iss *countingIssuer) *IssuerSource {
+		return &IssuerSource{
+			Source:  &LocalCASource{},
+			Issuer:  iss,
+			Storage: filepath.Join(dir, "certs"),
+		}
+	}
+	ca := &LocalCASource{CAFile: filepath.Join(dir, "ca.pem")}
+	iss := &countingIssuer{Issuer: ca}
+	src := newSource(iss)
+
+	cfg, err := TLSConfig(src, true, 0, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hello := func(serverName string) (*tls.Certificate, error) {
+		return cfg.GetCertificate(&tls.ClientHelloInfo{ServerName: serverName})
+	}
+
+	cert, err := hello("example.com")
+	if err != nil {
+		t.Fatalf("got %v want nil", err)
+	}
+	if got, want := cert.Leaf.DNSNames, []string{"example.com"}; len(got) != 1 || got[0] != want[0] {
+		t.Fatalf("got DNS names %v want %v", got, want)
+	}
+
+	// the certificate is issued only once
+	if _, err := hello("EXAMPLE.com."); err != nil {
+		t.Fatalf("got %v want nil", err)
+	}
+	if n, _ := iss.counts(); n != 1 {
+		t.Fatalf("got %d issued certificates want 1", n)
+	}
+
+	// no certificates for hosts which are not allowed
+	if _, err := hello("other.com"); err == nil {
+		t.Fatal("other.com: got nil want error")
+	}
+
+	// the certificate is stored in the format of the path source
+	if _, err := tls.LoadX509KeyPair(filepath.Join(dir, "certs", "example.com.pem"), filepath.Join(dir, "certs", "example.com.pem")); err != nil {
+		t.Fatalf("got %v want nil", err)
+	}
+
+	// a new instance uses the stored certificate
+	iss2 := &countingIssuer{Issuer: ca}
+	stored, err := newSource(iss2).getCertificate("example.com", true)
+	if err != nil {
+		t.Fatalf("got %v want nil", err)
+	}
+	if n, _ := iss2.counts(); n != 0 {
+		t.Fatalf("got %d issued certificates after restart want 0", n)
+	}
+	if !stored.Leaf.Equal(cert.Leaf) {
+		t.Fatal("got a different certificate after restart")
+	}
+
+	// without strictmatch the issued certificates are the fallback
+	fallback, err := src.getCertificate("other.com", false)
+	if err != nil {
+		t.Fatalf("got %v want nil", err)
+	}
+	if !fallback.Leaf.Equal(cert.Leaf) {
+		t.Fatal("got a different fallback certificate")
+	}
+}
+
+func TestIssuerSourceNoHostCheck(t *testing.T) {
+	SetHostPolicy(func(host string) bool { return false })
+	defer SetHostPolicy(nil)
+
+	ca := &LocalCASource{CAFile: filepath.Join(t.TempDir(), "ca.pem")}
+	if _, err := (&IssuerSource{Source: ca, Issuer: ca}).getCertificate("example.com", true); err == nil {
+		t.Fatal("got nil want error")
+	}
+	if _, err := (&IssuerSource{Source: ca, Issuer: ca, NoHostCheck: true}).getCertificate("example.com", true); err != nil {
+		t.Fatalf("got %v want nil", err)
+	}
+}
+
+func TestIssuerSourceRenew(t *testing.T) {
+	SetHostPolicy(func(host string) bool { return host == "example.com" })
+	defer SetHostPolicy(nil)
+
+	ca := &LocalCASource{CAFile: filepath.Join(t.TempDir(), "ca.pem"), Validity: 2 * time.Second}
+	iss := &countingIssuer{Issuer: ca}
+	src := &IssuerSource{Source: ca, Issuer: iss, Refresh: time.Second}
+
+	cert, err := src.getCertificate("example.com", true)
+	if err != nil {
+		t.Fatalf("got %v want nil", err)
+	}
+
+	// the certificate is re-issued before it expires
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		renewed, err := src.getCertificate("example.com", true)
+		if err != nil {
+			t.Fatalf("got %v want nil", err)
+		}
+		if renewed.Leaf.NotAfter.After(cert.Leaf.NotAfter) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("certificate was not re-issued")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if n, _ := iss.counts(); n < 2 {
+		t.Fatalf("got %d issued certificates want at least 2", n)
+	}
+}
+
+func TestIssuerSourceRenewTimers(t *testing.T) {
+	SetHostPolicy(func(host string) bool { return true })
+	defer SetHostPolicy(nil)
+
+	ca := &LocalCASource{CAFile: filepath.Join(t.TempDir(), "ca.pem")}
+	iss := &countingIssuer{Issuer: ca}
+	src := &IssuerSource{Source: ca, Issuer: iss}
+
+	old, err := src.getCertificate("example.com", true)
+	if err != nil {
+		t.Fatalf("got %v want nil", err)
+	}
+	cert, err := ca.Issue("example.com")
+	if err != nil {
+		t.Fatalf("got %v want nil", err)
+	}
+	for range 3 {
+		src.add("example.com", cert)
+	}
+	if got, want := len(src.timers), 1; got != want {
+		t.Fatalf("got %d timers want %d", got, want)
+	}
+
+	// a replaced certificate is not re-issued
+	src.renew("example.com", old)
+	if n, _ := iss.counts(); n != 1 {
+		t.Fatalf("got %d issued certificates want 1", n)
+	}
+
+	src.remove("example.com", cert)
+	if got, want := len(src.timers), 0; got != want {
+		t.Fatalf("got %d timers want %d", got, want)
+	}
+}
+
+func TestIssuerSourceMaxIssue(t *testing.T) {
+	SetHostPolicy(func(host string) bool { return true })
+	defer SetHostPolicy(nil)
+
+	ca := &LocalCASource{CAFile: filepath.Join(t.TempDir(), "ca.pem")}
+	iss := &countingIssuer{Issuer: ca, delay: 20 * time.Millisecond}
+	src := &IssuerSource{Source: ca, Issuer: iss, MaxIssue: 2}
+
+	var wg sync.WaitGroup
+	for _, host := range []string{"a.com", "b.com", "c.com", "d.com", "e.com", "f.com"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := src.getCertificate(host, true); err != nil {
+				t.Errorf("%s: got %v want nil", host, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	n, maxRun := iss.counts()
+	if n != 6 {
+		t.Fatalf("got %d issued certificates want 6", n)
+	}
+	if maxRun > 2 {
+		t.Fatalf("got %d concurrent issues want at most 2", maxRun)
+	}
+}
@@ -0,0 +1,197 @@
+package cert
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultLocalCAValidity is the default validity period of the
+	// certificates issued by the local CA.
+	defaultLocalCAValidity = 7 * 24 * time.Hour
+
+	// localCAValidity is the validity period of a new local CA.
+	localCAValidity = 10 * 365 * 24 * time.Hour
+)
+
+// LocalCASource implements a certificate source which issues certificates
+// on-demand with a local CA. It is intended for development and test
+// environments where the clients can be configured to trust the CA.
+//
+// The CA certificate and key are created on first use if the CA file does
+// not exist.
+type LocalCASource struct {
+	// CAFile is the path of the PEM file with the CA certificate. It also
+	// contains the CA key unless KeyFile is set.
+	CAFile string
+
+	// KeyFile is the optional path of the PEM file with the CA key.
+	KeyFile string
+
+	ClientCAPath string
+	CAUpgradeCN  string
+
+	// Validity is the validity period of the issued certificates. The
+	// default is seven days.
+	Validity time.Duration
+
+	once sync.Once
+	err  error
+	ca   *x509.Certificate
+	key  crypto.Signer
+}
+
+func (s *LocalCASource) LoadClientCAs() (*x509.CertPool, error) {
+	return FileSource{ClientAuthFile: s.ClientCAPath, CAUpgradeCN: s.CAUpgradeCN}.LoadClientCAs()
+}
+
+// Certificates returns a closed channel since the certificates are
+// issued during the TLS handshake.
+func (s *LocalCASource) Certificates() chan []tls.Certificate {
+	ch := make(chan []tls.Certificate)
+	close(ch)
+	return ch
+}
+
+// Issue issues a certificate for the host name or IP address signed by
+// the local CA.
+func (s *LocalCASource) Issue(commonName string) (*tls.Certificate, error) {
+	s.once.Do(func() {
+		s.err = s.loadCA()
+	})
+	if s.err != nil {
+		return nil, fmt.Errorf("local-ca: %s", s.err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("local-ca: issue: %s", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("local-ca: issue: %s", err)
+	}
+
+	validity := s.Validity
+	if validity <= 0 {
+		validity = defaultLocalCAValidity
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if tmpl.NotAfter.After(s.ca.NotAfter) {
+		tmpl.NotAfter = s.ca.NotAfter
+	}
+	if ip := net.ParseIP(commonName); ip != nil {
+		tmpl.IPAddresses = []net.IP{ip}
+	} else {
+		tmpl.DNSNames = []string{commonName}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, s.ca, &key.PublicKey, s.key)
+	if err != nil {
+		return nil, fmt.Errorf("local-ca: issue: %s", err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("local-ca: issue: %s", err)
+	}
+	return &tls.Certificate{
+		Certificate: [][]byte{der, s.ca.Raw},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
+
+// loadCA loads the CA certificate and key and creates them if the CA
+// file does not exist.
+func (s *LocalCASource) loadCA() error {
+	if _, err := os.Stat(s.CAFile); os.IsNotExist(err) {
+		if err := s.createCA(); err != nil {
+			return err
+		}
+	}
+
+	keyFile := s.KeyFile
+	if keyFile == "" {
+		keyFile = s.CAFile
+	}
+	cert, err := tls.LoadX509KeyPair(s.CAFile, keyFile)
+	if err != nil {
+		return err
+	}
+	if !cert.Leaf.IsCA {
+		return fmt.Errorf("%s is not a CA certificate", s.CAFile)
+	}
+	key, ok := cert.PrivateKey.(crypto.Signer)
+	if !ok {
+		return errors.New("unsupported CA key")
+	}
+	s.ca, s.key = cert.Leaf, key
+	return nil
+}
+
+func (s *LocalCASource) createCA() error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "fabio local CA"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(localCAValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	var crt, k bytes.Buffer
+	pem.Encode(&crt, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	pem.Encode(&k, &pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	perm := os.FileMode(0644)
+	if s.KeyFile == "" {
+		crt.Write(k.Bytes())
+		perm = 0600
+	} else if err := os.WriteFile(s.KeyFile, k.Bytes(), 0600); err != nil {
+		return err
+	}
+	if err := os.WriteFile(s.CAFile, crt.Bytes(), perm); err != nil {
+		return err
+	}
+	log.Printf("[INFO] cert: Created local CA %s. Clients need to trust it.", s.CAFile)
+	return nil
+}
@@ -6,7 +6,7 @@ import (
 	"fmt"
 
 	"github.com/fabiolb/fabio/config"
-	"golang.org/x/sync/singleflight"
+	"golang.org/x/crypto/acme"
 )
 
 // Source provides the interface for dynamic certificate sources.
@@ -72,14 +72,25 @@ func NewSource(cfg config.CertSource) (Source, error) {
 			Refresh:      cfg.Refresh,
 			Client:       NewVaultClient(cfg.VaultFetchToken),
 		}, nil
+
+	case "acme":
+		return newACMESource(cfg), nil
+
 	case "vault-pki":
 		src := NewVaultPKISource()
 		src.CertPath = cfg.CertPath
 		src.ClientCAPath = cfg.ClientCAPath
 		src.CAUpgradeCN = cfg.CAUpgradeCN
-		src.Refresh = cfg.Refresh
 		src.Client = NewVaultClient(cfg.VaultFetchToken)
-		return src, nil
+		return newIssuerSource(cfg, src), nil
+
+	case "local-ca":
+		return newIssuerSource(cfg, &LocalCASource{
+			CAFile:       cfg.CertPath,
+			KeyFile:      cfg.KeyPath,
+			ClientCAPath: cfg.ClientCAPath,
+			CAUpgradeCN:  cfg.CAUpgradeCN,
+		}), nil
 
 	default:
 		return nil, fmt.Errorf("invalid certificate source %q", cfg.Type)
@@ -98,7 +109,7 @@ func TLSConfig(src Source, strictMatch bool, minVersion, maxVersion uint16, ciph
 		return nil, err
 	}
 
-	sf := &singleflight.Group{}
+	issuer := issuerSourceFor(src)
 	store := NewStore()
 	x := &tls.Config{
 		MinVersion:   minVersion,
@@ -119,23 +130,20 @@ func TLSConfig(src Source, strictMatch bool, minVersion, maxVersion uint16, ciph
 				return
 			}
 
-			ca, ok := src.(Issuer)
-			if !ok {
+			if issuer == nil {
 				return
 			}
-
-			serverName := clientHello.ServerName
-			x, err, _ := sf.Do(serverName, func() (any, error) {
-				return ca.Issue(serverName)
-			})
-			if err != nil {
-				return cert, err
-			}
-
-			return x.(*tls.Certificate), nil
+			return issuer.getCertificate(clientHello.ServerName, strictMatch)
 		},
 	}
 
+	// ACME sources obtain the certificates and answer the TLS-ALPN-01
+	// challenges with the client hello.
+	if s, ok := src.(*ACMESource); ok {
+		x.GetCertificate = s.GetCertificate
+		x.NextProtos = append(x.NextProtos, acme.ALPNProto)
+	}
+
 	if clientCAs != nil {
 		x.ClientCAs = clientCAs
 		x.ClientAuth = tls.RequireAndVerifyClientCert
@@ -83,6 +83,12 @@ func TestNewSource(t *testing.T) {
 			Header:       http.Header{"A": []string{"b"}},
 		}
 	}
+	localCA := &LocalCASource{
+		CAFile:       "cert",
+		KeyFile:      "key",
+		ClientCAPath: "clientca",
+		CAUpgradeCN:  "upgcn",
+	}
 	tests := []struct {
 		desc string
 		cfg  config.CertSource
@@ -147,6 +153,15 @@ func TestNewSource(t *testing.T) {
 				Refresh:      3 * time.Second,
 			},
 		},
+		{
+			desc: "local-ca",
+			cfg:  certsource("local-ca"),
+			src: &IssuerSource{
+				Source:  localCA,
+				Issuer:  localCA,
+				Refresh: time.Hour,
+			},
+		},
 	}
 
 	for i, tt := range tests {
@@ -532,6 +547,9 @@ func TestVaultPKISource(t *testing.T) {
 		t.Fatalf("Write role failed: %s", err)
 	}
 
+	SetHostPolicy(func(host string) bool { return host == "localhost" })
+	defer SetHostPolicy(nil)
+
 	for _, tt := range vaultTestCases {
 		t.Run(tt.desc, func(t *testing.T) {
 			src := NewVaultPKISource()
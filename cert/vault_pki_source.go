@@ -8,8 +8,6 @@ import (
 	"fmt"
 	"log"
 	"math/big"
-	"sync"
-	"time"
 )
 
 // VaultPKISource implements a certificate source which issues TLS certificates
@@ -17,29 +15,18 @@ import (
 // loaded from a generic backend (same as in VaultSource). The Vault token
 // should be set through the VAULT_TOKEN environment variable.
 //
-// The TLS certificates are re-issued automatically before they expire.
+// The issued certificates are cached and re-issued before they expire by
+// the IssuerSource.
 type VaultPKISource struct {
 	Client *vaultClient
 
-	certsCh chan []tls.Certificate
-
-	certs        map[string]tls.Certificate // issued certs
 	CertPath     string
 	ClientCAPath string
 	CAUpgradeCN  string
-
-	// Re-issue certificates this long before they expire. Cannot be less then
-	// one hour.
-	Refresh time.Duration
-
-	mu sync.Mutex
 }
 
 func NewVaultPKISource() *VaultPKISource {
-	return &VaultPKISource{
-		certs:   make(map[string]tls.Certificate, 0),
-		certsCh: make(chan []tls.Certificate, 1),
-	}
+	return &VaultPKISource{}
 }
 
 func (s *VaultPKISource) LoadClientCAs() (*x509.CertPool, error) {
@@ -50,8 +37,12 @@ func (s *VaultPKISource) LoadClientCAs() (*x509.CertPool, error) {
 	}).LoadClientCAs()
 }
 
+// Certificates returns a closed channel since the certificates are
+// issued during the TLS handshake.
 func (s *VaultPKISource) Certificates() chan []tls.Certificate {
-	return s.certsCh
+	ch := make(chan []tls.Certificate)
+	close(ch)
+	return ch
 }
 
 func (s *VaultPKISource) Issue(commonName string) (*tls.Certificate, error) {
@@ -103,29 +94,8 @@ func (s *VaultPKISource) Issue(commonName string) (*tls.Certificate, error) {
 		// successfully already, but threw the result away.
 		return nil, fmt.Errorf("vault: issue: %s", err)
 	}
+	cert.Leaf = x509Cert
 
-	refresh := max(s.Refresh, time.Hour)
-
-	expires := x509Cert.NotAfter
-	certTTL := time.Until(expires) - refresh
-	time.AfterFunc(certTTL, func() {
-		_, err := s.Issue(commonName)
-		if err != nil {
-			log.Printf("[ERROR] cert: vault: Failed to re-issue cert for %s: %s", commonName, err)
-			// TODO: Now what? Retry? Do nothing?
-			return
-		}
-	})
-
-	s.mu.Lock()
-	s.certs[commonName] = cert
-	allCerts := make([]tls.Certificate, 0, len(s.certs))
-	for _, c := range s.certs {
-		allCerts = append(allCerts, c)
-	}
-	s.mu.Unlock()
-
-	go func() { s.certsCh <- allCerts }()
 	log.Printf("[INFO] cert: vault: issued cert for %s; serial = %s", commonName, s.formatSerial(x509Cert.SerialNumber))
 
 	return &cert, nil
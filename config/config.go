@@ -31,7 +31,12 @@ type CertSource struct {
 	ClientCAPath    string
 	CAUpgradeCN     string
 	VaultFetchToken string
+	Email           string
+	StoragePath     string
+	DirectoryCAPath string
 	Refresh         time.Duration
+	MaxIssue        int
+	NoHostCheck     bool
 }
 
 type Listen struct {
@@ -48,6 +53,8 @@ type Listen struct {
 	TLSMaxVersion      uint16
 	StrictMatch        bool
 	ProxyProto         bool
+	ProxyTrust         []string
+	MaxHeaderBytes     int
 }
 
 type Source struct {
@@ -74,6 +81,7 @@ type UI struct {
 type Proxy struct {
 	GZIPContentTypes      *regexp.Regexp
 	AuthSchemes           map[string]AuthScheme
+	CertSources           map[string]CertSource
 	Strategy              string
 	Matcher               string
 	LocalIP               string
@@ -95,6 +103,48 @@ type Proxy struct {
 	GRPCMaxRxMsgSize      int
 	GRPCMaxTxMsgSize      int
 	GRPCGShutdownTimeout  time.Duration
+	GRPCHealth            bool
+	MaxBody               int64
+	MaxResponseBody       int64
+	Outlier               Outlier
+	Retry                 Retry
+	Compress              Compress
+	Decompress            Decompress
+	Cache                 Cache
+}
+
+type Cache struct {
+	Size         int
+	MaxEntrySize int
+}
+
+type Compress struct {
+	Encodings   []string
+	MinSize     int
+	GzipLevel   int
+	BrotliLevel int
+	ZstdLevel   int
+}
+
+type Decompress struct {
+	MaxSize int64
+}
+
+type Retry struct {
+	On         string
+	Header     string
+	Timeout    time.Duration
+	Budget     float64
+	MinRetries float64
+}
+
+type Outlier struct {
+	ConsecutiveFailures int
+	FailureRate         float64
+	MinRequests         int
+	Interval            time.Duration
+	Backoff             time.Duration
+	MaxEjectionPercent  int
 }
 
 type STSHeader struct {
@@ -56,6 +56,30 @@ var defaultConfig = &Config{
 		GRPCMaxRxMsgSize:     4 * 1024 * 1024, // 4M
 		GRPCMaxTxMsgSize:     4 * 1024 * 1024, // 4M
 		GRPCGShutdownTimeout: time.Second * 2,
+		Retry: Retry{
+			On:         "connect,502,503,504",
+			Budget:     0.2,
+			MinRetries: 10,
+		},
+		Compress: Compress{
+			Encodings:   []string{"zstd", "br", "gzip"},
+			GzipLevel:   6,
+			BrotliLevel: 4,
+			ZstdLevel:   3,
+		},
+		Decompress: Decompress{
+			MaxSize: 10 << 20, // 10MB
+		},
+		Cache: Cache{
+			Size:         64 << 20, // 64MB
+			MaxEntrySize: 1 << 20,  // 1MB
+		},
+		Outlier: Outlier{
+			MinRequests:        10,
+			Interval:           10 * time.Second,
+			Backoff:            30 * time.Second,
+			MaxEjectionPercent: 50,
+		},
 	},
 	Registry: Registry{
 		Backend: "consul",
@@ -6,6 +6,8 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"math"
+	"net"
 	"net/http"
 	"regexp"
 	"runtime"
@@ -129,6 +131,8 @@ func load(cmdline, environ, envprefix []string, props *properties.Properties) (c
 	var authSchemesValue string
 	var readTimeout, writeTimeout time.Duration
 	var gzipContentTypesValue string
+	var maxBodyValue, maxResponseBodyValue, decompressMaxSizeValue string
+	var cacheSizeValue, cacheMaxEntrySizeValue string
 
 	var obsoleteStr string
 
@@ -156,7 +160,29 @@ func load(cmdline, environ, envprefix []string, props *properties.Properties) (c
 	f.IntVar(&cfg.Proxy.GRPCMaxRxMsgSize, "proxy.grpcmaxrxmsgsize", defaultConfig.Proxy.GRPCMaxRxMsgSize, "max grpc receive message size (in bytes)")
 	f.IntVar(&cfg.Proxy.GRPCMaxTxMsgSize, "proxy.grpcmaxtxmsgsize", defaultConfig.Proxy.GRPCMaxTxMsgSize, "max grpc transmit message size (in bytes)")
 	f.DurationVar(&cfg.Proxy.GRPCGShutdownTimeout, "proxy.grpcshutdowntimeout", defaultConfig.Proxy.GRPCGShutdownTimeout, "amount of time to wait for graceful shutdown of grpc backend")
+	f.BoolVar(&cfg.Proxy.GRPCHealth, "proxy.grpchealth", defaultConfig.Proxy.GRPCHealth, "answer grpc.health.v1 Health/Check requests on grpc listeners")
+	f.IntVar(&cfg.Proxy.Outlier.ConsecutiveFailures, "proxy.outlier.consecutivefailures", defaultConfig.Proxy.Outlier.ConsecutiveFailures, "number of consecutive failures after which a target is ejected. 0 disables the check")
+	f.Float64Var(&cfg.Proxy.Outlier.FailureRate, "proxy.outlier.failurerate", defaultConfig.Proxy.Outlier.FailureRate, "failure rate between 0 and 1 above which a target is ejected. 0 disables the check")
+	f.IntVar(&cfg.Proxy.Outlier.MinRequests, "proxy.outlier.minrequests", defaultConfig.Proxy.Outlier.MinRequests, "minimum number of requests per interval for the failure rate check")
+	f.DurationVar(&cfg.Proxy.Outlier.Interval, "proxy.outlier.interval", defaultConfig.Proxy.Outlier.Interval, "interval for the failure rate check")
+	f.DurationVar(&cfg.Proxy.Outlier.Backoff, "proxy.outlier.backoff", defaultConfig.Proxy.Outlier.Backoff, "time an ejected target receives no traffic")
+	f.IntVar(&cfg.Proxy.Outlier.MaxEjectionPercent, "proxy.outlier.maxejectionpercent", defaultConfig.Proxy.Outlier.MaxEjectionPercent, "maximum percentage of the targets of a route which can be ejected")
+	f.StringVar(&cfg.Proxy.Retry.On, "proxy.retry.on", defaultConfig.Proxy.Retry.On, "conditions for retrying a request: connect and status codes")
+	f.StringVar(&cfg.Proxy.Retry.Header, "proxy.retry.header", defaultConfig.Proxy.Retry.Header, "header which marks a request as retryable")
+	f.DurationVar(&cfg.Proxy.Retry.Timeout, "proxy.retry.timeout", defaultConfig.Proxy.Retry.Timeout, "timeout for a single attempt of a retryable request")
+	f.Float64Var(&cfg.Proxy.Retry.Budget, "proxy.retry.budget", defaultConfig.Proxy.Retry.Budget, "maximum ratio of retries to requests")
+	f.Float64Var(&cfg.Proxy.Retry.MinRetries, "proxy.retry.minretries", defaultConfig.Proxy.Retry.MinRetries, "number of retries per second which are allowed in addition to the budget")
 	f.StringVar(&gzipContentTypesValue, "proxy.gzip.contenttype", defaultValues.GZIPContentTypesValue, "regexp of content types to compress")
+	f.StringVar(&maxBodyValue, "proxy.maxbody", "", "maximum size of request bodies, e.g. 10MB. Unlimited if empty or 0")
+	f.StringVar(&maxResponseBodyValue, "proxy.maxresponsebody", "", "maximum size of response bodies, e.g. 100MB. Unlimited if empty or 0")
+	f.StringSliceVar(&cfg.Proxy.Compress.Encodings, "proxy.compress.encodings", defaultConfig.Proxy.Compress.Encodings, "content encodings for compressed responses in order of preference: zstd, br and gzip")
+	f.IntVar(&cfg.Proxy.Compress.MinSize, "proxy.compress.minsize", defaultConfig.Proxy.Compress.MinSize, "minimum size of compressed responses in bytes")
+	f.IntVar(&cfg.Proxy.Compress.GzipLevel, "proxy.compress.gzip.level", defaultConfig.Proxy.Compress.GzipLevel, "gzip compression level between 1 and 9")
+	f.IntVar(&cfg.Proxy.Compress.BrotliLevel, "proxy.compress.br.level", defaultConfig.Proxy.Compress.BrotliLevel, "brotli compression level between 1 and 11")
+	f.IntVar(&cfg.Proxy.Compress.ZstdLevel, "proxy.compress.zstd.level", defaultConfig.Proxy.Compress.ZstdLevel, "zstd compression level between 1 and 22")
+	f.StringVar(&decompressMaxSizeValue, "proxy.decompress.maxsize", "", "maximum size of decompressed request bodies, e.g. 10MB. Default is 10MB")
+	f.StringVar(&cacheSizeValue, "proxy.cache.size", "", "maximum total size of cached responses, e.g. 64MB. Default is 64MB")
+	f.StringVar(&cacheMaxEntrySizeValue, "proxy.cache.maxentrysize", "", "maximum size of a cached response, e.g. 1MB. Default is 1MB")
 	f.StringVar(&listenerValue, "proxy.addr", defaultValues.ListenerValue, "listener config")
 	f.StringVar(&certSourcesValue, "proxy.cs", defaultValues.CertSourcesValue, "certificate sources")
 	f.DurationVar(&readTimeout, "proxy.readtimeout", defaultValues.ReadTimeout, "read timeout for incoming requests")
@@ -288,6 +314,7 @@ func load(cmdline, environ, envprefix []string, props *properties.Properties) (c
 	}
 
 	cfg.Proxy.AuthSchemes = authSchemes
+	cfg.Proxy.CertSources = certSources
 
 	if uiListenerValue != "" {
 		kvs, err := parseKVSlice(uiListenerValue)
@@ -339,13 +366,106 @@ func load(cmdline, environ, envprefix []string, props *properties.Properties) (c
 		if err != nil {
 			return nil, fmt.Errorf("invalid expression for content types: %s", err)
 		}
+		// keep compressing with gzip only like before unless the
+		// encodings are configured explicitly.
+		if !f.IsSet("proxy.compress.encodings") {
+			cfg.Proxy.Compress.Encodings = []string{"gzip"}
+		}
+	}
+
+	if maxBodyValue != "" {
+		if cfg.Proxy.MaxBody, err = ParseSize(maxBodyValue); err != nil {
+			return nil, fmt.Errorf("invalid proxy.maxbody: %s", err)
+		}
+	}
+
+	if maxResponseBodyValue != "" {
+		if cfg.Proxy.MaxResponseBody, err = ParseSize(maxResponseBodyValue); err != nil {
+			return nil, fmt.Errorf("invalid proxy.maxresponsebody: %s", err)
+		}
+	}
+
+	for _, e := range cfg.Proxy.Compress.Encodings {
+		switch e {
+		case "zstd", "br", "gzip":
+		default:
+			return nil, fmt.Errorf("invalid proxy.compress.encodings: %s", e)
+		}
+	}
+
+	if cfg.Proxy.Compress.MinSize < 0 {
+		return nil, fmt.Errorf("proxy.compress.minsize must not be negative")
+	}
+
+	cfg.Proxy.Decompress.MaxSize = defaultConfig.Proxy.Decompress.MaxSize
+	if decompressMaxSizeValue != "" {
+		if cfg.Proxy.Decompress.MaxSize, err = ParseSize(decompressMaxSizeValue); err != nil {
+			return nil, fmt.Errorf("invalid proxy.decompress.maxsize: %s", err)
+		}
+	}
+	if cfg.Proxy.Decompress.MaxSize <= 0 {
+		return nil, fmt.Errorf("proxy.decompress.maxsize must be greater than 0")
+	}
+
+	if cfg.Proxy.Compress.GzipLevel < 1 || cfg.Proxy.Compress.GzipLevel > 9 {
+		return nil, fmt.Errorf("proxy.compress.gzip.level must be between 1 and 9")
+	}
+
+	if cfg.Proxy.Compress.BrotliLevel < 1 || cfg.Proxy.Compress.BrotliLevel > 11 {
+		return nil, fmt.Errorf("proxy.compress.br.level must be between 1 and 11")
+	}
+
+	if cfg.Proxy.Compress.ZstdLevel < 1 || cfg.Proxy.Compress.ZstdLevel > 22 {
+		return nil, fmt.Errorf("proxy.compress.zstd.level must be between 1 and 22")
+	}
+
+	cfg.Proxy.Cache.Size = defaultConfig.Proxy.Cache.Size
+	if cacheSizeValue != "" {
+		n, err := ParseSize(cacheSizeValue)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy.cache.size: %s", err)
+		}
+		cfg.Proxy.Cache.Size = int(n)
+	}
+
+	cfg.Proxy.Cache.MaxEntrySize = defaultConfig.Proxy.Cache.MaxEntrySize
+	if cacheMaxEntrySizeValue != "" {
+		n, err := ParseSize(cacheMaxEntrySizeValue)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy.cache.maxentrysize: %s", err)
+		}
+		cfg.Proxy.Cache.MaxEntrySize = int(n)
+	}
+
+	if cfg.Proxy.Cache.Size <= 0 {
+		return nil, fmt.Errorf("proxy.cache.size must be greater than 0")
+	}
+
+	if cfg.Proxy.Cache.MaxEntrySize <= 0 || cfg.Proxy.Cache.MaxEntrySize > cfg.Proxy.Cache.Size {
+		return nil, fmt.Errorf("proxy.cache.maxentrysize must be greater than 0 and not larger than proxy.cache.size")
 	}
 
-	if cfg.Proxy.Strategy != "rr" && cfg.Proxy.Strategy != "rnd" {
+	switch cfg.Proxy.Strategy {
+	case "rnd", "rr", "leastconn", "ewma":
+	default:
 		return nil, fmt.Errorf("invalid proxy.strategy: %s", cfg.Proxy.Strategy)
 	}
 
-	if cfg.Proxy.Matcher != "prefix" && cfg.Proxy.Matcher != "glob" && cfg.Proxy.Matcher != "iprefix" {
+	if cfg.Proxy.Retry.Budget < 0 || cfg.Proxy.Retry.MinRetries < 0 {
+		return nil, fmt.Errorf("proxy.retry.budget and proxy.retry.minretries must not be negative")
+	}
+
+	if cfg.Proxy.Outlier.FailureRate < 0 || cfg.Proxy.Outlier.FailureRate > 1 {
+		return nil, fmt.Errorf("proxy.outlier.failurerate must be between 0 and 1")
+	}
+
+	if cfg.Proxy.Outlier.MaxEjectionPercent < 0 || cfg.Proxy.Outlier.MaxEjectionPercent > 100 {
+		return nil, fmt.Errorf("proxy.outlier.maxejectionpercent must be between 0 and 100")
+	}
+
+	switch cfg.Proxy.Matcher {
+	case "prefix", "glob", "iprefix", "regex":
+	default:
 		return nil, fmt.Errorf("invalid proxy.matcher: %s", cfg.Proxy.Matcher)
 	}
 
@@ -430,7 +550,7 @@ func parseListen(cfg map[string]string, cs map[string]CertSource, readTimeout, w
 		case "proto":
 			l.Proto = v
 			switch l.Proto {
-			case "tcp", "tcp+sni", "tcp-dynamic", "http", "https", "grpc", "grpcs", "https+tcp+sni", "prometheus":
+			case "tcp", "tcp+sni", "tcp-dynamic", "http", "https", "http3", "grpc", "grpcs", "https+tcp+sni", "prometheus":
 				// ok
 			default:
 				return Listen{}, fmt.Errorf("unknown protocol %q", v)
@@ -485,6 +605,14 @@ func parseListen(cfg map[string]string, cs map[string]CertSource, readTimeout, w
 			l.TLSCiphers = c
 		case "pxyproto":
 			l.ProxyProto = (v == "true")
+		case "pxytrust":
+			for s := range strings.SplitSeq(v, ",") {
+				s = strings.TrimSpace(s)
+				if _, _, err := net.ParseCIDR(s); err != nil && net.ParseIP(s) == nil {
+					return Listen{}, fmt.Errorf("invalid pxytrust %q", s)
+				}
+				l.ProxyTrust = append(l.ProxyTrust, s)
+			}
 		case "pxytimeout":
 			d, err := time.ParseDuration(v)
 			if err != nil {
@@ -497,6 +625,12 @@ func parseListen(cfg map[string]string, cs map[string]CertSource, readTimeout, w
 				return Listen{}, err
 			}
 			l.Refresh = d
+		case "maxheader":
+			n, err := ParseSize(v)
+			if err != nil || n > math.MaxInt32 {
+				return Listen{}, fmt.Errorf("invalid maxheader %q", v)
+			}
+			l.MaxHeaderBytes = int(n)
 		}
 	}
 
@@ -506,19 +640,31 @@ func parseListen(cfg map[string]string, cs map[string]CertSource, readTimeout, w
 	if l.Addr == "" {
 		return Listen{}, fmt.Errorf("need listening host:port")
 	}
-	if csName != "" && l.Proto != "https" && l.Proto != "tcp" && l.Proto != "tcp-dynamic" && l.Proto != "grpcs" && l.Proto != "prometheus" && l.Proto != "https+tcp+sni" {
-		return Listen{}, fmt.Errorf("cert source requires proto 'https', 'tcp', 'tcp-dynamic', 'https+tcp+sni', 'prometheus', or 'grpcs'")
+	if csName != "" && l.Proto != "https" && l.Proto != "http3" && l.Proto != "tcp" && l.Proto != "tcp-dynamic" && l.Proto != "grpcs" && l.Proto != "prometheus" && l.Proto != "https+tcp+sni" {
+		return Listen{}, fmt.Errorf("cert source requires proto 'https', 'http3', 'tcp', 'tcp-dynamic', 'https+tcp+sni', 'prometheus', or 'grpcs'")
 	}
 	if csName == "" && l.Proto == "https" {
 		return Listen{}, fmt.Errorf("proto 'https' requires cert source")
 	}
+	if csName == "" && l.Proto == "http3" {
+		return Listen{}, fmt.Errorf("proto 'http3' requires cert source")
+	}
+	if len(l.ProxyTrust) > 0 && !l.ProxyProto {
+		return Listen{}, fmt.Errorf("pxytrust requires pxyproto")
+	}
+	if l.Proto == "http3" && l.ProxyProto {
+		return Listen{}, fmt.Errorf("proto 'http3' does not support pxyproto")
+	}
+	if l.Proto == "http3" && l.TLSMaxVersion != 0 && l.TLSMaxVersion < tls.VersionTLS13 {
+		return Listen{}, fmt.Errorf("proto 'http3' requires TLS 1.3")
+	}
 	if csName == "" && l.Proto == "grpcs" {
 		return Listen{}, fmt.Errorf("proto 'grpcs' requires cert source")
 	}
-	if cs[csName].Type == "vault-pki" && !l.StrictMatch {
+	if t := cs[csName].Type; (t == "vault-pki" || t == "local-ca") && !l.StrictMatch {
 		// Without StrictMatch the first issued certificate is used for all
 		// subsequent requests, even if the common name doesn't match.
-		log.Printf("[INFO] vault-pki requires strictmatch; enabling strictmatch for listener %s", l.Addr)
+		log.Printf("[INFO] %s requires strictmatch; enabling strictmatch for listener %s", t, l.Addr)
 		l.StrictMatch = true
 	}
 	if l.ProxyProto && l.ProxyHeaderTimeout == 0 {
@@ -614,6 +760,24 @@ func parseCertSource(cfg map[string]string) (c CertSource, err error) {
 			c.Refresh = d
 		case "vaultfetchtoken":
 			c.VaultFetchToken = v
+		case "email":
+			c.Email = v
+		case "storage":
+			c.StoragePath = v
+		case "directoryca":
+			c.DirectoryCAPath = v
+		case "maxissue":
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return CertSource{}, fmt.Errorf("invalid maxissue %q", v)
+			}
+			c.MaxIssue = n
+		case "hostcheck":
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return CertSource{}, fmt.Errorf("invalid hostcheck %q", v)
+			}
+			c.NoHostCheck = !b
 		case "hdr":
 			p := strings.SplitN(v, ": ", 2)
 			if len(p) != 2 {
@@ -636,7 +800,15 @@ func parseCertSource(cfg map[string]string) (c CertSource, err error) {
 		return CertSource{}, fmt.Errorf("missing 'type' in %s", cfg)
 	case "file", "consul":
 		c.Refresh = 0
-	case "path", "http", "vault", "vault-pki":
+	case "acme":
+		if c.StoragePath == "" {
+			return CertSource{}, fmt.Errorf("missing 'storage' in %s", cfg)
+		}
+		if c.NoHostCheck {
+			return CertSource{}, fmt.Errorf("'hostcheck=false' is not supported in %s", cfg)
+		}
+		c.Refresh = 0
+	case "path", "http", "vault", "vault-pki", "local-ca":
 		// no-op
 	default:
 		return CertSource{}, fmt.Errorf("unknown cert source type %s", c.Type)
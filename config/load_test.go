@@ -155,6 +155,7 @@ func TestLoad(t *testing.T) {
 			cfg: func(cfg *Config) *Config {
 				cfg.Listen = []Listen{{Addr: ":5555", Proto: "https"}}
 				cfg.Listen[0].CertSource = CertSource{Name: "name", Type: "file", CertPath: "value"}
+				cfg.Proxy.CertSources = map[string]CertSource{"name": cfg.Listen[0].CertSource}
 				return cfg
 			},
 		},
@@ -164,6 +165,7 @@ func TestLoad(t *testing.T) {
 			cfg: func(cfg *Config) *Config {
 				cfg.Listen = []Listen{{Addr: ":5555", Proto: "https"}}
 				cfg.Listen[0].CertSource = CertSource{Name: "name", Type: "path", CertPath: "value", Refresh: 3 * time.Second}
+				cfg.Proxy.CertSources = map[string]CertSource{"name": cfg.Listen[0].CertSource}
 				return cfg
 			},
 		},
@@ -173,6 +175,7 @@ func TestLoad(t *testing.T) {
 			cfg: func(cfg *Config) *Config {
 				cfg.Listen = []Listen{{Addr: ":5555", Proto: "https"}}
 				cfg.Listen[0].CertSource = CertSource{Name: "name", Type: "http", CertPath: "value", Refresh: 3 * time.Second}
+				cfg.Proxy.CertSources = map[string]CertSource{"name": cfg.Listen[0].CertSource}
 				return cfg
 			},
 		},
@@ -182,6 +185,7 @@ func TestLoad(t *testing.T) {
 			cfg: func(cfg *Config) *Config {
 				cfg.Listen = []Listen{{Addr: ":5555", Proto: "https"}}
 				cfg.Listen[0].CertSource = CertSource{Name: "name", Type: "consul", CertPath: "value"}
+				cfg.Proxy.CertSources = map[string]CertSource{"name": cfg.Listen[0].CertSource}
 				return cfg
 			},
 		},
@@ -191,6 +195,7 @@ func TestLoad(t *testing.T) {
 			cfg: func(cfg *Config) *Config {
 				cfg.Listen = []Listen{{Addr: ":5555", Proto: "https"}}
 				cfg.Listen[0].CertSource = CertSource{Name: "name", Type: "vault", CertPath: "value", Refresh: 3 * time.Second}
+				cfg.Proxy.CertSources = map[string]CertSource{"name": cfg.Listen[0].CertSource}
 				return cfg
 			},
 		},
@@ -204,6 +209,7 @@ func TestLoad(t *testing.T) {
 				cfg.Listen = []Listen{{Addr: ":5555", Proto: "https"}}
 				cfg.Listen[0].CertSource = CertSource{Name: "name", Type: "vault-pki", CertPath: "pki/issue/value", Refresh: 3 * time.Second}
 				cfg.Listen[0].StrictMatch = true // implicit
+				cfg.Proxy.CertSources = map[string]CertSource{"name": cfg.Listen[0].CertSource}
 				return cfg
 			},
 		},
@@ -217,6 +223,48 @@ func TestLoad(t *testing.T) {
 				cfg.Listen = []Listen{{Addr: ":5555", Proto: "https"}}
 				cfg.Listen[0].CertSource = CertSource{Name: "name", Type: "vault-pki", CertPath: "pki/issue/value", Refresh: 3 * time.Second}
 				cfg.Listen[0].StrictMatch = true // implicit
+				cfg.Proxy.CertSources = map[string]CertSource{"name": cfg.Listen[0].CertSource}
+				return cfg
+			},
+		},
+		{
+			desc: "-proxy.addr with local-ca cert source",
+			args: []string{
+				"-proxy.addr", ":5555;cs=name",
+				"-proxy.cs", "cs=name;type=local-ca;cert=ca.pem;storage=/var/lib/fabio/certs;maxissue=2;refresh=24h",
+			},
+			cfg: func(cfg *Config) *Config {
+				cfg.Listen = []Listen{{Addr: ":5555", Proto: "https"}}
+				cfg.Listen[0].CertSource = CertSource{Name: "name", Type: "local-ca", CertPath: "ca.pem", StoragePath: "/var/lib/fabio/certs", MaxIssue: 2, Refresh: 24 * time.Hour}
+				cfg.Listen[0].StrictMatch = true // implicit
+				cfg.Proxy.CertSources = map[string]CertSource{"name": cfg.Listen[0].CertSource}
+				return cfg
+			},
+		},
+		{
+			desc: "-proxy.addr with vault-pki cert source without host check",
+			args: []string{
+				"-proxy.addr", ":5555;cs=name",
+				"-proxy.cs", "cs=name;type=vault-pki;cert=pki/issue/example-dot-com;hostcheck=false",
+			},
+			cfg: func(cfg *Config) *Config {
+				cfg.Listen = []Listen{{Addr: ":5555", Proto: "https"}}
+				cfg.Listen[0].CertSource = CertSource{Name: "name", Type: "vault-pki", CertPath: "pki/issue/example-dot-com", NoHostCheck: true, Refresh: 3 * time.Second}
+				cfg.Listen[0].StrictMatch = true // implicit
+				cfg.Proxy.CertSources = map[string]CertSource{"name": cfg.Listen[0].CertSource}
+				return cfg
+			},
+		},
+		{
+			desc: "-proxy.addr with acme cert source",
+			args: []string{
+				"-proxy.addr", ":5555;cs=name",
+				"-proxy.cs", "cs=name;type=acme;cert=https://acme.example.com/dir;storage=/var/lib/fabio/acme;email=ops@example.com;directoryca=ca.pem;refresh=1h",
+			},
+			cfg: func(cfg *Config) *Config {
+				cfg.Listen = []Listen{{Addr: ":5555", Proto: "https"}}
+				cfg.Listen[0].CertSource = CertSource{Name: "name", Type: "acme", CertPath: "https://acme.example.com/dir", StoragePath: "/var/lib/fabio/acme", Email: "ops@example.com", DirectoryCAPath: "ca.pem"}
+				cfg.Proxy.CertSources = map[string]CertSource{"name": cfg.Listen[0].CertSource}
 				return cfg
 			},
 		},
@@ -240,6 +288,7 @@ func TestLoad(t *testing.T) {
 						},
 					},
 				}
+				cfg.Proxy.CertSources = map[string]CertSource{"name": cfg.Listen[0].CertSource}
 				return cfg
 			},
 		},
@@ -263,6 +312,7 @@ func TestLoad(t *testing.T) {
 						},
 					},
 				}
+				cfg.Proxy.CertSources = map[string]CertSource{"name": cfg.Listen[0].CertSource}
 				return cfg
 			},
 		},
@@ -303,6 +353,27 @@ func TestLoad(t *testing.T) {
 						},
 					},
 				}
+				cfg.Proxy.CertSources = map[string]CertSource{"name": cfg.Listen[0].CertSource}
+				return cfg
+			},
+		},
+		{
+			desc: "-proxy.addr with proto 'http3'",
+			args: []string{"-proxy.addr", ":443;cs=name;proto=http3", "-proxy.cs", "cs=name;type=path;cert=foo"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Listen = []Listen{
+					{
+						Addr:  ":443",
+						Proto: "http3",
+						CertSource: CertSource{
+							Name:     "name",
+							Type:     "path",
+							CertPath: "foo",
+							Refresh:  3 * time.Second,
+						},
+					},
+				}
+				cfg.Proxy.CertSources = map[string]CertSource{"name": cfg.Listen[0].CertSource}
 				return cfg
 			},
 		},
@@ -340,6 +411,7 @@ func TestLoad(t *testing.T) {
 					Type:     "consul",
 					CertPath: "http://localhost:8500/v1/kv/ssl?token=token",
 				}
+				cfg.Proxy.CertSources = map[string]CertSource{"consul-cs": cfg.Listen[0].CertSource}
 				return cfg
 			},
 		},
@@ -364,6 +436,40 @@ func TestLoad(t *testing.T) {
 				return cfg
 			},
 		},
+		{
+			args: []string{"-proxy.strategy", "leastconn"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.Strategy = "leastconn"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.strategy", "ewma"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.Strategy = "ewma"
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.retry.on", "connect,503", "-proxy.retry.header", "X-Retry", "-proxy.retry.timeout", "1s", "-proxy.retry.budget", "0.5", "-proxy.retry.minretries", "5"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.Retry.On = "connect,503"
+				cfg.Proxy.Retry.Header = "X-Retry"
+				cfg.Proxy.Retry.Timeout = time.Second
+				cfg.Proxy.Retry.Budget = 0.5
+				cfg.Proxy.Retry.MinRetries = 5
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.outlier.consecutivefailures", "5", "-proxy.outlier.failurerate", "0.5", "-proxy.outlier.backoff", "1m"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.Outlier.ConsecutiveFailures = 5
+				cfg.Proxy.Outlier.FailureRate = 0.5
+				cfg.Proxy.Outlier.Backoff = time.Minute
+				return cfg
+			},
+		},
 		{
 			args: []string{"-proxy.matcher", "prefix"},
 			cfg: func(cfg *Config) *Config {
@@ -385,6 +491,13 @@ func TestLoad(t *testing.T) {
 				return cfg
 			},
 		},
+		{
+			args: []string{"-proxy.matcher", "regex"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.Matcher = "regex"
+				return cfg
+			},
+		},
 		{
 			args: []string{"-proxy.noroutestatus", "555"},
 			cfg: func(cfg *Config) *Config {
@@ -508,6 +621,7 @@ func TestLoad(t *testing.T) {
 			args: []string{"-proxy.gzip.contenttype", `^text/.*$`},
 			cfg: func(cfg *Config) *Config {
 				cfg.Proxy.GZIPContentTypes = regexp.MustCompile(`^text/.*$`)
+				cfg.Proxy.Compress.Encodings = []string{"gzip"}
 				return cfg
 			},
 		},
@@ -515,6 +629,70 @@ func TestLoad(t *testing.T) {
 			args: []string{"-proxy.gzip.contenttype", "^(text/.*|application/(javascript|json|font-woff|xml)|.*\\+(json|xml))(;.*)?$"},
 			cfg: func(cfg *Config) *Config {
 				cfg.Proxy.GZIPContentTypes = regexp.MustCompile(`^(text/.*|application/(javascript|json|font-woff|xml)|.*\+(json|xml))(;.*)?$`)
+				cfg.Proxy.Compress.Encodings = []string{"gzip"}
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.gzip.contenttype", `^text/.*$`, "-proxy.compress.encodings", "zstd,gzip"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.GZIPContentTypes = regexp.MustCompile(`^text/.*$`)
+				cfg.Proxy.Compress.Encodings = []string{"zstd", "gzip"}
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.compress.encodings", "br,gzip", "-proxy.compress.minsize", "1024", "-proxy.compress.gzip.level", "9", "-proxy.compress.br.level", "5", "-proxy.compress.zstd.level", "7"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.Compress.Encodings = []string{"br", "gzip"}
+				cfg.Proxy.Compress.MinSize = 1024
+				cfg.Proxy.Compress.GzipLevel = 9
+				cfg.Proxy.Compress.BrotliLevel = 5
+				cfg.Proxy.Compress.ZstdLevel = 7
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.decompress.maxsize", "1KB"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.Decompress.MaxSize = 1024
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.cache.size", "1MB", "-proxy.cache.maxentrysize", "65536"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.Cache.Size = 1048576
+				cfg.Proxy.Cache.MaxEntrySize = 65536
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.maxbody", "10MB", "-proxy.maxresponsebody", "1GB"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.MaxBody = 10 << 20
+				cfg.Proxy.MaxResponseBody = 1 << 30
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.addr", ":5555;maxheader=64KB"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Listen = []Listen{{Addr: ":5555", Proto: "http", MaxHeaderBytes: 64 << 10}}
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.addr", ":5555;proto=tcp;pxyproto=true;pxytrust=\"10.0.0.0/8, 192.168.1.1\""},
+			cfg: func(cfg *Config) *Config {
+				cfg.Listen = []Listen{{Addr: ":5555", Proto: "tcp", ProxyProto: true, ProxyTrust: []string{"10.0.0.0/8", "192.168.1.1"}, ProxyHeaderTimeout: 250 * time.Millisecond}}
+				return cfg
+			},
+		},
+		{
+			args: []string{"-proxy.grpchealth=true"},
+			cfg: func(cfg *Config) *Config {
+				cfg.Proxy.GRPCHealth = true
 				return cfg
 			},
 		},
@@ -933,6 +1111,7 @@ func TestLoad(t *testing.T) {
 				cfg.UI.Listen.CertSource.CertPath = "value"
 				cfg.Registry.Consul.CheckScheme = "https"
 				cfg.Registry.Consul.ServiceAddr = ":9998"
+				cfg.Proxy.CertSources = map[string]CertSource{"ui": cfg.UI.Listen.CertSource}
 				return cfg
 			},
 		},
@@ -1066,6 +1245,126 @@ func TestLoad(t *testing.T) {
 		},
 
 		// errors
+		{
+			desc: "-proxy.compress.encodings invalid",
+			args: []string{"-proxy.compress.encodings", "gzip,deflate"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid proxy.compress.encodings: deflate"),
+		},
+		{
+			desc: "-proxy.compress.minsize negative",
+			args: []string{"-proxy.compress.minsize", "-1"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proxy.compress.minsize must not be negative"),
+		},
+		{
+			desc: "-proxy.decompress.maxsize zero",
+			args: []string{"-proxy.decompress.maxsize", "0"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proxy.decompress.maxsize must be greater than 0"),
+		},
+		{
+			desc: "-proxy.decompress.maxsize invalid",
+			args: []string{"-proxy.decompress.maxsize", "10XB"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New(`invalid proxy.decompress.maxsize: invalid size "10XB"`),
+		},
+		{
+			desc: "-proxy.maxbody invalid",
+			args: []string{"-proxy.maxbody", "10XB"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New(`invalid proxy.maxbody: invalid size "10XB"`),
+		},
+		{
+			desc: "-proxy.addr with invalid maxheader",
+			args: []string{"-proxy.addr", ":5555;maxheader=big"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New(`invalid maxheader "big"`),
+		},
+		{
+			desc: "-proxy.addr with invalid pxytrust",
+			args: []string{"-proxy.addr", ":5555;pxyproto=true;pxytrust=10.0.0.0/33"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New(`invalid pxytrust "10.0.0.0/33"`),
+		},
+		{
+			desc: "-proxy.addr with pxytrust without pxyproto",
+			args: []string{"-proxy.addr", ":5555;pxytrust=10.0.0.0/8"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("pxytrust requires pxyproto"),
+		},
+		{
+			desc: "-proxy.cache.size zero",
+			args: []string{"-proxy.cache.size", "0"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proxy.cache.size must be greater than 0"),
+		},
+		{
+			desc: "-proxy.cache.size invalid",
+			args: []string{"-proxy.cache.size", "64XB"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New(`invalid proxy.cache.size: invalid size "64XB"`),
+		},
+		{
+			desc: "-proxy.cache.maxentrysize larger than proxy.cache.size",
+			args: []string{"-proxy.cache.size", "1024", "-proxy.cache.maxentrysize", "2048"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proxy.cache.maxentrysize must be greater than 0 and not larger than proxy.cache.size"),
+		},
+		{
+			desc: "-proxy.compress.gzip.level out of range",
+			args: []string{"-proxy.compress.gzip.level", "10"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proxy.compress.gzip.level must be between 1 and 9"),
+		},
+		{
+			desc: "-proxy.compress.br.level out of range",
+			args: []string{"-proxy.compress.br.level", "0"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proxy.compress.br.level must be between 1 and 11"),
+		},
+		{
+			desc: "-proxy.compress.zstd.level out of range",
+			args: []string{"-proxy.compress.zstd.level", "23"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proxy.compress.zstd.level must be between 1 and 22"),
+		},
+		{
+			desc: "-proxy.retry.budget negative",
+			args: []string{"-proxy.retry.budget", "-1"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proxy.retry.budget and proxy.retry.minretries must not be negative"),
+		},
+		{
+			desc: "-proxy.outlier.failurerate out of range",
+			args: []string{"-proxy.outlier.failurerate", "2"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proxy.outlier.failurerate must be between 0 and 1"),
+		},
+		{
+			desc: "-proxy.cs with invalid maxissue",
+			args: []string{"-proxy.cs", "cs=name;type=local-ca;cert=ca.pem;maxissue=x"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid maxissue \"x\""),
+		},
+		{
+			desc: "-proxy.cs with invalid hostcheck",
+			args: []string{"-proxy.cs", "cs=name;type=local-ca;cert=ca.pem;hostcheck=x"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("invalid hostcheck \"x\""),
+		},
+		{
+			desc: "-proxy.cs with acme cert source without host check",
+			args: []string{"-proxy.cs", "cs=name;type=acme;cert=https://acme.example.com/dir;storage=/tmp;hostcheck=false"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("'hostcheck=false' is not supported in map[cert:https://acme.example.com/dir cs:name hostcheck:false storage:/tmp type:acme]"),
+		},
+		{
+			desc: "-proxy.cs with acme cert source requires storage",
+			args: []string{"-proxy.cs", "cs=name;type=acme;cert=https://acme.example.com/dir"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("missing 'storage' in map[cert:https://acme.example.com/dir cs:name type:acme]"),
+		},
 		{
 			desc: "-proxy.addr with unknown cert source 'foo'",
 			args: []string{"-proxy.addr", ":5555;cs=foo"},
@@ -1090,17 +1389,35 @@ func TestLoad(t *testing.T) {
 			cfg:  func(cfg *Config) *Config { return nil },
 			err:  errors.New("proto 'grpcs' requires cert source"),
 		},
+		{
+			desc: "-proxy.addr with proto 'http3' requires cert source",
+			args: []string{"-proxy.addr", ":5555;proto=http3"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proto 'http3' requires cert source"),
+		},
+		{
+			desc: "-proxy.addr with proto 'http3' and pxyproto",
+			args: []string{"-proxy.addr", ":5555;cs=name;proto=http3;pxyproto=true", "-proxy.cs", "cs=name;type=path;cert=value"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proto 'http3' does not support pxyproto"),
+		},
+		{
+			desc: "-proxy.addr with proto 'http3' and tlsmax below TLS 1.3",
+			args: []string{"-proxy.addr", ":5555;cs=name;proto=http3;tlsmax=tls12", "-proxy.cs", "cs=name;type=path;cert=value"},
+			cfg:  func(cfg *Config) *Config { return nil },
+			err:  errors.New("proto 'http3' requires TLS 1.3"),
+		},
 		{
 			desc: "-proxy.addr with cert source and proto 'http' requires proto 'https', 'tcp', or 'grpcs'",
 			args: []string{"-proxy.addr", ":5555;cs=name;proto=http", "-proxy.cs", "cs=name;type=path;cert=value"},
 			cfg:  func(cfg *Config) *Config { return nil },
-			err:  errors.New("cert source requires proto 'https', 'tcp', 'tcp-dynamic', 'https+tcp+sni', 'prometheus', or 'grpcs'"),
+			err:  errors.New("cert source requires proto 'https', 'http3', 'tcp', 'tcp-dynamic', 'https+tcp+sni', 'prometheus', or 'grpcs'"),
 		},
 		{
 			desc: "-proxy.addr with cert source and proto 'tcp+sni' requires proto 'https', 'tcp' or 'grpcs'",
 			args: []string{"-proxy.addr", ":5555;cs=name;proto=tcp+sni", "-proxy.cs", "cs=name;type=path;cert=value"},
 			cfg:  func(cfg *Config) *Config { return nil },
-			err:  errors.New("cert source requires proto 'https', 'tcp', 'tcp-dynamic', 'https+tcp+sni', 'prometheus', or 'grpcs'"),
+			err:  errors.New("cert source requires proto 'https', 'http3', 'tcp', 'tcp-dynamic', 'https+tcp+sni', 'prometheus', or 'grpcs'"),
 		},
 		{
 			desc: "-proxy.noroutestatus too small",
@@ -0,0 +1,38 @@
+package config
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		n    int64
+		fail bool
+	}{
+		{"0", 0, false},
+		{"512", 512, false},
+		{"512B", 512, false},
+		{"64KB", 64 << 10, false},
+		{"64k", 64 << 10, false},
+		{"10MB", 10 << 20, false},
+		{"10 MiB", 10 << 20, false},
+		{"1gb", 1 << 30, false},
+		{"", 0, true},
+		{"MB", 0, true},
+		{"-1MB", 0, true},
+		{"1.5MB", 0, true},
+		{"10TB", 0, true},
+		{"9999999999GB", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			n, err := ParseSize(tt.in)
+			if got, want := err != nil, tt.fail; got != want {
+				t.Fatalf("got error %v want error %v", err, want)
+			}
+			if got, want := n, tt.n; got != want {
+				t.Fatalf("got %d want %d", got, want)
+			}
+		})
+	}
+}
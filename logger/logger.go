@@ -85,6 +85,11 @@ type Event struct {
 	// UpstreamService is the name of the upstream service as
 	// defined in the route.
 	UpstreamService string
+
+	// WSCloseCode is the status code of the close frame of a
+	// websocket session. It should only be set for websocket
+	// sessions.
+	WSCloseCode int
 }
 
 // Logger logs an event.
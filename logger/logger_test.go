@@ -83,6 +83,7 @@ func TestLog(t *testing.T) {
 		UpstreamAddr:    uurl.Host,
 		UpstreamService: "svc-a",
 		UpstreamURL:     uurl,
+		WSCloseCode:     1000,
 	}
 
 	tests := []struct {
@@ -123,6 +124,7 @@ func TestLog(t *testing.T) {
 		{"$upstream_request_uri", "/foo?q=x\n"},
 		{"$upstream_request_url", "http://7.8.9.0:5678/foo?q=x\n"},
 		{"$upstream_service", "svc-a\n"},
+		{"$ws_close_code", "1000\n"},
 	}
 
 	for _, tt := range tests {
@@ -259,6 +259,12 @@ var fields = map[string]field{
 	"$upstream_service": func(b *bytes.Buffer, e *Event) {
 		b.WriteString(e.UpstreamService)
 	},
+	"$ws_close_code": func(b *bytes.Buffer, e *Event) {
+		if e.WSCloseCode == 0 {
+			return
+		}
+		atoi(b, int64(e.WSCloseCode), 0)
+	},
 }
 
 var shortMonthNames = []string{
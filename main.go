@@ -32,6 +32,7 @@ import (
 	"github.com/fabiolb/fabio/metrics"
 	"github.com/fabiolb/fabio/noroute"
 	"github.com/fabiolb/fabio/proxy"
+	"github.com/fabiolb/fabio/proxy/cache"
 	"github.com/fabiolb/fabio/proxy/tcp"
 	"github.com/fabiolb/fabio/registry"
 	"github.com/fabiolb/fabio/registry/consul"
@@ -72,6 +73,15 @@ func main() {
 	}
 
 	transport.SetConfig(cfg)
+	route.SetOutlierConfig(cfg.Proxy.Outlier)
+	route.SetCertSources(cfg.Proxy.CertSources)
+	hostGlobCache := route.NewGlobCache(cfg.GlobCacheSize)
+	cert.SetHostPolicy(func(host string) bool {
+		return route.GetTable().HasHost(host, hostGlobCache, cfg.GlobMatchingDisabled)
+	})
+	if err := route.SetRetryConfig(cfg.Proxy.Retry); err != nil {
+		exit.Fatal("[FATAL] proxy.retry.on: ", err)
+	}
 
 	log.Printf("[INFO] Setting log level to %s", logOutput.Level())
 	if !logOutput.SetLevel(cfg.Log.Level) {
@@ -141,7 +151,11 @@ func main() {
 	initRuntime(cfg)
 	initBackend(cfg)
 
-	startAdmin(cfg)
+	// the response cache is shared by the http listeners and
+	// can be purged through the admin api.
+	responseCache := cache.New(cfg.Proxy.Cache.Size, cfg.Proxy.Cache.MaxEntrySize)
+
+	startAdmin(cfg, responseCache)
 
 	go watchNoRouteHTML()
 
@@ -151,7 +165,7 @@ func main() {
 	<-first
 
 	// create proxies after metrics since they use the metrics registry.
-	startServers(cfg, metrics)
+	startServers(cfg, metrics, responseCache)
 
 	// warn again so that it is visible in the terminal
 	WarnIfRunAsRoot(cfg.Insecure)
@@ -184,7 +198,7 @@ func newGrpcProxy(cfg *config.Config, tlscfg *tls.Config, statsHandler *proxy.Gr
 	}
 }
 
-func newHTTPProxy(cfg *config.Config, statsHandler *proxy.HttpStatsHandler) *proxy.HTTPProxy {
+func newHTTPProxy(cfg *config.Config, statsHandler *proxy.HttpStatsHandler, responseCache *cache.Cache) *proxy.HTTPProxy {
 	var w io.Writer
 
 	//Init Glob Cache
@@ -246,6 +260,7 @@ func newHTTPProxy(cfg *config.Config, statsHandler *proxy.HttpStatsHandler) *pro
 		Logger:      l,
 		AuthSchemes: authSchemes,
 		Stats:       *statsHandler,
+		Cache:       responseCache,
 	}
 }
 
@@ -277,25 +292,27 @@ func lookupHostFn(cfg *config.Config, notFound gkm.Counter) func(string) *route.
 }
 
 // Returns a matcher function compatible with tcpproxy Matcher from github.com/inetaf/tcpproxy
-func lookupHostMatcher(cfg *config.Config) func(context.Context, string) bool {
+// which matches the hosts whose target satisfies match.
+func lookupHostMatcher(cfg *config.Config, match func(*route.Target) bool) func(context.Context, string) bool {
 	pick := route.Picker[cfg.Proxy.Strategy]
 	return func(ctx context.Context, host string) bool {
 		t := route.GetTable().LookupHost(host, pick)
 		if t == nil {
 			return false
 		}
+		return match(t)
+	}
+}
 
-		// Make sure this is supposed to be a tcp proxy.
-		// opts proto= overrides scheme if present.
-		var (
-			ok    bool
-			proto string
-		)
-		if proto, ok = t.Opts["proto"]; !ok && t.URL != nil {
-			proto = t.URL.Scheme
+// hasACMESource returns true if one of the certificate sources is an
+// ACME source whose HTTP-01 challenges the HTTP listeners answer.
+func hasACMESource(cfg *config.Config) bool {
+	for _, cs := range cfg.Proxy.CertSources {
+		if cs.Type == "acme" {
+			return true
 		}
-		return proto == "tcp"
 	}
+	return false
 }
 
 func makeTLSConfig(l config.Listen) (*tls.Config, error) {
@@ -313,7 +330,7 @@ func makeTLSConfig(l config.Listen) (*tls.Config, error) {
 	return tlscfg, nil
 }
 
-func startAdmin(cfg *config.Config) {
+func startAdmin(cfg *config.Config, responseCache *cache.Cache) {
 	log.Printf("[INFO] Admin server access mode %q", cfg.UI.Access)
 	log.Printf("[INFO] Admin server listening on %q", cfg.UI.Listen.Addr)
 	go func() {
@@ -330,6 +347,7 @@ func startAdmin(cfg *config.Config) {
 			Version:  version,
 			Commands: route.Commands,
 			Cfg:      cfg,
+			Cache:    responseCache,
 		}
 		if err := srv.ListenAndServe(l, tlscfg); err != nil {
 			exit.Fatal("[FATAL] ui: ", err)
@@ -337,7 +355,7 @@ func startAdmin(cfg *config.Config) {
 	}()
 }
 
-func startServers(cfg *config.Config, stats metrics.Provider) {
+func startServers(cfg *config.Config, stats metrics.Provider, responseCache *cache.Cache) {
 	notFound := stats.NewCounter("notfound")
 
 	var (
@@ -354,9 +372,9 @@ func startServers(cfg *config.Config, stats metrics.Provider) {
 	grpcCounters := func() {
 		grpStatsHandler = &proxy.GrpcStatsHandler{
 			Connect: stats.NewCounter("grpc.conn"),
-			Request: stats.NewHistogram("grpc.requests"),
+			Request: stats.NewHistogram("grpc.requests", "method"),
 			NoRoute: stats.NewCounter("grpc.noroute"),
-			Status:  stats.NewHistogram("grep.status", "code"),
+			Status:  stats.NewHistogram("grpc.status", "code", "method"),
 		}
 	}
 
@@ -369,11 +387,16 @@ func startServers(cfg *config.Config, stats metrics.Provider) {
 			WSConn:          stats.NewGauge("ws.conn"),
 			StatusTimer:     stats.NewHistogram("http.status", "code", "service"),
 			RedirectCounter: stats.NewCounter("http.redirect.count", "code"),
+			Mirror:          stats.NewCounter("http.mirror", "service", "result"),
+			Cache:           stats.NewCounter("http.cache", "service", "result"),
 		}
 	}
 
 	var httpOnce sync.Once
 
+	// advertise the http3 listeners on the https listeners
+	altSvc := proxy.AltSvc(cfg.Listen)
+
 	tcpCounters := func() {
 		tcpConn = stats.NewCounter("tcp.conn")
 		tcpConnFail = stats.NewCounter("tcp.connfail")
@@ -403,10 +426,24 @@ func startServers(cfg *config.Config, stats metrics.Provider) {
 		case "http", "https":
 			httpOnce.Do(httpCounters)
 			go func() {
-				h := newHTTPProxy(cfg, httpStatsHandler)
+				h := newHTTPProxy(cfg, httpStatsHandler, responseCache)
+				h.AltSvc = altSvc
 				// reset the ws.conn gauge
 				h.Stats.WSConn.Set(0)
-				if err := proxy.ListenAndServeHTTP(l, h, tlscfg); err != nil {
+				var hh http.Handler = h
+				if tlscfg == nil && hasACMESource(cfg) {
+					hh = cert.ACMEChallengeHandler(h)
+				}
+				if err := proxy.ListenAndServeHTTP(l, hh, tlscfg); err != nil {
+					exit.Fatal("[FATAL] ", err)
+				}
+			}()
+		case "http3":
+			httpOnce.Do(httpCounters)
+			go func() {
+				h := newHTTPProxy(cfg, httpStatsHandler, responseCache)
+				h.AltSvc = altSvc
+				if err := proxy.ListenAndServeHTTP3(l, h, tlscfg); err != nil {
 					exit.Fatal("[FATAL] ", err)
 				}
 			}()
@@ -503,14 +540,23 @@ func startServers(cfg *config.Config, stats metrics.Provider) {
 			tcpSniOnce.Do(tcpSniCounters)
 			httpOnce.Do(httpCounters)
 			go func() {
-				hp := newHTTPProxy(cfg, httpStatsHandler)
+				hp := newHTTPProxy(cfg, httpStatsHandler, responseCache)
+				hp.AltSvc = altSvc
 				tp := &tcp.SNIProxy{
 					DialTimeout: cfg.Proxy.DialTimeout,
 					Lookup:      lookupHostFn(cfg, notFound),
 					Conn:        tcpSniConn,
 					ConnFail:    tcpSniConnFail,
 					Noroute:     tcpSniNoRoute}
-				if err := proxy.ListenAndServeHTTPSTCPSNI(l, hp, tp, tlscfg, lookupHostMatcher(cfg)); err != nil {
+				tlsp := &tcp.TLSProxy{
+					DialTimeout: cfg.Proxy.DialTimeout,
+					Lookup:      lookupHostFn(cfg, notFound),
+					Conn:        tcpSniConn,
+					ConnFail:    tcpSniConnFail,
+					Noroute:     tcpSniNoRoute}
+				passthrough := lookupHostMatcher(cfg, (*route.Target).TLSPassthrough)
+				terminate := lookupHostMatcher(cfg, (*route.Target).TLSTerminateTCP)
+				if err := proxy.ListenAndServeHTTPSTCPSNI(l, hp, tp, tlsp, tlscfg, passthrough, terminate); err != nil {
 					exit.Fatal("[FATAL] ", err)
 				}
 			}()
@@ -172,9 +172,9 @@ func TargetNameWith(name string, values []string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	// Handle .tx and .rx
-	if i := strings.LastIndex(name, "."); i != -1 {
-		n += name[i:]
+	// Handle .tx, .rx and the other suffixes of the route metrics
+	if i := strings.LastIndex(name, RoutePrefix+"."); i != -1 {
+		n += name[i+len(RoutePrefix):]
 	}
 	return n, nil
 }
@@ -51,3 +51,25 @@ func TestTargetName(t *testing.T) {
 		}
 	}
 }
+
+func TestTargetNameWith(t *testing.T) {
+	values := []string{"service", "s", "host", "h", "path", "p", "target", "http://foo.com/bar"}
+	tests := []struct {
+		name, want string
+	}{
+		{"route", "s.h.p.foo_com"},
+		{"route.rx", "s.h.p.foo_com.rx"},
+		{"fabio.route.tx", "s.h.p.foo_com.tx"},
+		{"route.ws.rx.frames", "s.h.p.foo_com.ws.rx.frames"},
+	}
+
+	for _, tt := range tests {
+		got, err := TargetNameWith(tt.name, values)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %q want %q", tt.name, got, tt.want)
+		}
+	}
+}
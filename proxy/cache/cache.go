@@ -0,0 +1,210 @@
+// Package cache provides an in-memory HTTP cache for GET requests which
+// honors the Cache-Control, Expires and Vary response headers and
+// revalidates stale responses with ETag and Last-Modified.
+//
+// The cache is bounded by the total size of the cached responses and
+// evicts the least recently used responses first.
+package cache
+
+import (
+	"container/list"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// now returns the current time. It is a variable to allow testing.
+var now = time.Now
+
+// Cache stores HTTP responses in memory.
+type Cache struct {
+	// maxSize is the maximum total size of the cached responses.
+	maxSize int
+
+	// maxEntrySize is the maximum size of a single response.
+	maxEntrySize int
+
+	mu   sync.Mutex
+	size int
+
+	// lru contains the entries in order of their last use. The
+	// most recently used entry is at the front.
+	lru *list.List
+
+	// entries contains the list elements of the entries by their
+	// primary key. A primary key has one entry for every variant
+	// of the response selected by the Vary header.
+	entries map[string][]*list.Element
+}
+
+// entry is a cached response.
+type entry struct {
+	// key is the primary key of the entry which consists of the
+	// host, path and query of the request.
+	key string
+
+	// vary contains the names of the request headers of the Vary
+	// response header and varyValues their values in the request.
+	vary       []string
+	varyValues []string
+
+	status int
+	header http.Header
+	body   []byte
+
+	// stored is the time the response was stored or revalidated.
+	stored time.Time
+
+	// expires is the time after which the response is stale.
+	expires time.Time
+
+	size int
+}
+
+// New returns a cache which holds up to maxSize bytes of responses.
+// Responses larger than maxEntrySize bytes are not cached.
+func New(maxSize, maxEntrySize int) *Cache {
+	return &Cache{
+		maxSize:      maxSize,
+		maxEntrySize: maxEntrySize,
+		lru:          list.New(),
+		entries:      map[string][]*list.Element{},
+	}
+}
+
+// Len returns the number of cached responses.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// Size returns the total size of the cached responses in bytes.
+func (c *Cache) Size() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+// Purge removes the responses for which the host and path of the request
+// start with prefix, e.g. 'example.com/api/'. The host name is matched
+// in lower case and without the port. It returns the number of removed
+// responses.
+func (c *Cache) Purge(prefix string) int {
+	if host, path, ok := strings.Cut(prefix, "/"); ok {
+		prefix = keyHost(host) + "/" + path
+	} else {
+		prefix = strings.ToLower(prefix)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for key, elems := range c.entries {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		// remove modifies the slice of the elements
+		for _, el := range append([]*list.Element(nil), elems...) {
+			c.remove(el)
+			n++
+		}
+	}
+	return n
+}
+
+// get returns the entry for the request or nil.
+func (c *Cache) get(key string, r *http.Request) *entry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, el := range c.entries[key] {
+		e := el.Value.(*entry)
+		if e.matches(r) {
+			c.lru.MoveToFront(el)
+			return e
+		}
+	}
+	return nil
+}
+
+// put stores the entry and replaces an existing entry for the same
+// variant. Least recently used entries are evicted until the cache is
+// within its size limit.
+func (c *Cache) put(e *entry) {
+	if e.size > c.maxEntrySize || e.size > c.maxSize {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, el := range c.entries[e.key] {
+		if el.Value.(*entry).sameVariant(e) {
+			c.remove(el)
+			break
+		}
+	}
+	c.entries[e.key] = append(c.entries[e.key], c.lru.PushFront(e))
+	c.size += e.size
+	for c.size > c.maxSize {
+		c.remove(c.lru.Back())
+	}
+}
+
+// delete removes the entry from the cache if it is still cached.
+func (c *Cache) delete(e *entry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, el := range c.entries[e.key] {
+		if el.Value == e {
+			c.remove(el)
+			return
+		}
+	}
+}
+
+// remove removes the list element. The caller must hold the lock.
+func (c *Cache) remove(el *list.Element) {
+	e := c.lru.Remove(el).(*entry)
+	c.size -= e.size
+	elems := c.entries[e.key]
+	for i := range elems {
+		if elems[i] == el {
+			elems = append(elems[:i], elems[i+1:]...)
+			break
+		}
+	}
+	if len(elems) == 0 {
+		delete(c.entries, e.key)
+	} else {
+		c.entries[e.key] = elems
+	}
+}
+
+// matches returns true if the request headers listed in the Vary header
+// of the entry have the same values as the request of the entry.
+func (e *entry) matches(r *http.Request) bool {
+	for i, name := range e.vary {
+		if strings.Join(r.Header.Values(name), ",") != e.varyValues[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// sameVariant returns true if both entries are for the same variant.
+func (e *entry) sameVariant(o *entry) bool {
+	if len(e.vary) != len(o.vary) {
+		return false
+	}
+	for i := range e.vary {
+		if e.vary[i] != o.vary[i] || e.varyValues[i] != o.varyValues[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// fresh returns true if the entry has not expired.
+func (e *entry) fresh(t time.Time) bool {
+	return t.Before(e.expires)
+}
@@ -0,0 +1,343 @@
+package cache
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// upstream is a test handler which counts the requests and responds
+// with the configured headers, status and body.
+type upstream struct {
+	requests int
+	header   http.Header
+	status   int
+	body     string
+
+	// notModified makes the upstream answer conditional requests
+	// with a matching ETag with '304 Not Modified'.
+	notModified bool
+}
+
+func (u *upstream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u.requests++
+	for k, v := range u.header {
+		w.Header()[k] = v
+	}
+	if u.notModified && r.Header.Get("If-None-Match") != "" && r.Header.Get("If-None-Match") == u.header.Get("Etag") {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	status := u.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+	io.WriteString(w, u.body)
+}
+
+// do sends the request through the handler and returns the status
+// code, the body and the Age header of the response.
+func do(h http.Handler, r *http.Request) (int, string, string) {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	return rec.Code, rec.Body.String(), rec.Header().Get("Age")
+}
+
+// withTime sets the current time of the cache for the test.
+func withTime(t *testing.T) *time.Time {
+	cur := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	now = func() time.Time { return cur }
+	t.Cleanup(func() { now = time.Now })
+	return &cur
+}
+
+func TestCacheFreshness(t *testing.T) {
+	tests := []struct {
+		desc   string
+		header http.Header
+		ttl    time.Duration
+		status int
+		after  time.Duration
+		cached bool
+	}{
+		{"max-age fresh", http.Header{"Cache-Control": {"max-age=60"}}, 0, 200, 59 * time.Second, true},
+		{"max-age stale", http.Header{"Cache-Control": {"max-age=60"}}, 0, 200, 60 * time.Second, false},
+		{"s-maxage overrides max-age", http.Header{"Cache-Control": {"max-age=10, s-maxage=60"}}, 0, 200, 30 * time.Second, true},
+		{"max-age overrides ttl", http.Header{"Cache-Control": {"max-age=10"}}, time.Minute, 200, 30 * time.Second, false},
+		{"expires", http.Header{"Date": {"Wed, 01 Jan 2025 00:00:00 GMT"}, "Expires": {"Wed, 01 Jan 2025 00:01:00 GMT"}}, 0, 200, 30 * time.Second, true},
+		{"invalid expires", http.Header{"Expires": {"0"}}, time.Minute, 200, 0, false},
+		{"ttl", nil, time.Minute, 200, 30 * time.Second, true},
+		{"no lifetime", nil, 0, 200, 0, false},
+		{"no-store", http.Header{"Cache-Control": {"no-store, max-age=60"}}, 0, 200, 0, false},
+		{"private", http.Header{"Cache-Control": {"private, max-age=60"}}, 0, 200, 0, false},
+		{"no-cache", http.Header{"Cache-Control": {"no-cache"}}, time.Minute, 200, 0, false},
+		{"set-cookie", http.Header{"Set-Cookie": {"a=b"}}, time.Minute, 200, 0, false},
+		{"vary *", http.Header{"Vary": {"*"}}, time.Minute, 200, 0, false},
+		{"not found", nil, time.Minute, 404, 0, true},
+		{"server error", nil, time.Minute, 500, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			cur := withTime(t)
+			u := &upstream{header: tt.header, status: tt.status, body: "hello"}
+			h := New(1<<20, 1<<10).Handler(u, "example.com", tt.ttl, nil)
+
+			do(h, httptest.NewRequest("GET", "http://example.com/foo", nil))
+			*cur = cur.Add(tt.after)
+			_, body, _ := do(h, httptest.NewRequest("GET", "http://example.com/foo", nil))
+			if body != "hello" {
+				t.Fatalf("got body %q want %q", body, "hello")
+			}
+			if got, want := u.requests == 1, tt.cached; got != want {
+				t.Fatalf("got cached %v want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	u := &upstream{header: http.Header{"Cache-Control": {"max-age=60"}}, body: "hello"}
+	c := New(1<<20, 1<<10)
+	h1 := c.Handler(u, "a.com", 0, nil)
+	h2 := c.Handler(u, "b.com", 0, nil)
+
+	for _, tt := range []struct {
+		h        http.Handler
+		method   string
+		url      string
+		header   http.Header
+		requests int
+	}{
+		{h1, "GET", "http://a.com/foo?x=1", nil, 1},
+		{h1, "GET", "http://a.com/foo?x=1", nil, 1},
+		{h1, "GET", "http://a.com/foo?x=2", nil, 2},
+		{h1, "GET", "http://a.com/bar?x=1", nil, 3},
+		{h2, "GET", "http://b.com/foo?x=1", nil, 4},
+		{h1, "POST", "http://a.com/foo?x=1", nil, 5},
+		{h1, "GET", "http://a.com/foo?x=1", http.Header{"Authorization": {"Basic Zm9vOmJhcg=="}}, 6},
+		{h1, "GET", "http://a.com/foo?x=1", http.Header{"Range": {"bytes=0-1"}}, 7},
+		{h1, "GET", "http://a.com/foo?x=1", http.Header{"Cache-Control": {"no-cache"}}, 8},
+		{h1, "GET", "http://a.com/foo?x=1", http.Header{"Cache-Control": {"max-age=0"}}, 9},
+		{h1, "GET", "http://a.com/foo?x=1", nil, 9},
+	} {
+		r := httptest.NewRequest(tt.method, tt.url, nil)
+		r.Header = tt.header
+		if r.Header == nil {
+			r.Header = http.Header{}
+		}
+		do(tt.h, r)
+		if got, want := u.requests, tt.requests; got != want {
+			t.Fatalf("%s %s %v: got %d upstream requests want %d", tt.method, tt.url, tt.header, got, want)
+		}
+	}
+}
+
+func TestCacheCookie(t *testing.T) {
+	tests := []struct {
+		desc   string
+		header http.Header
+		cached bool
+	}{
+		{"private by default", http.Header{"Cache-Control": {"max-age=60"}}, false},
+		{"ttl", nil, false},
+		{"public", http.Header{"Cache-Control": {"public, max-age=60"}}, true},
+		{"s-maxage", http.Header{"Cache-Control": {"s-maxage=60"}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			u := &upstream{header: tt.header, body: "hello"}
+			h := New(1<<20, 1<<10).Handler(u, "example.com", time.Minute, nil)
+
+			for _, cookie := range []string{"session=alice", "session=bob"} {
+				r := httptest.NewRequest("GET", "http://example.com/foo", nil)
+				r.Header.Set("Cookie", cookie)
+				do(h, r)
+			}
+			if got, want := u.requests == 1, tt.cached; got != want {
+				t.Fatalf("got cached %v want %v", got, want)
+			}
+
+			// requests without cookies do not get the responses
+			// stored for requests with cookies either
+			do(h, httptest.NewRequest("GET", "http://example.com/foo", nil))
+			if got, want := u.requests == 1, tt.cached; got != want {
+				t.Fatalf("without cookie: got cached %v want %v", got, want)
+			}
+		})
+	}
+
+	// responses cached for requests without cookies are not served
+	// to requests with cookies unless they are shared
+	u := &upstream{header: http.Header{"Cache-Control": {"max-age=60"}}, body: "hello"}
+	h := New(1<<20, 1<<10).Handler(u, "example.com", 0, nil)
+	do(h, httptest.NewRequest("GET", "http://example.com/foo", nil))
+	r := httptest.NewRequest("GET", "http://example.com/foo", nil)
+	r.Header.Set("Cookie", "session=alice")
+	do(h, r)
+	if got, want := u.requests, 2; got != want {
+		t.Fatalf("got %d upstream requests want %d", got, want)
+	}
+}
+
+func TestCacheVary(t *testing.T) {
+	u := &upstream{header: http.Header{"Cache-Control": {"max-age=60"}, "Vary": {"Accept-Language"}}, body: "hello"}
+	c := New(1<<20, 1<<10)
+	h := c.Handler(u, "example.com", 0, nil)
+
+	for i, tt := range []struct {
+		lang     string
+		requests int
+	}{
+		{"en", 1},
+		{"de", 2},
+		{"en", 2},
+		{"de", 2},
+		{"", 3},
+	} {
+		r := httptest.NewRequest("GET", "http://example.com/", nil)
+		if tt.lang != "" {
+			r.Header.Set("Accept-Language", tt.lang)
+		}
+		do(h, r)
+		if got, want := u.requests, tt.requests; got != want {
+			t.Fatalf("%d: got %d upstream requests want %d", i, got, want)
+		}
+	}
+	if got, want := c.Len(), 3; got != want {
+		t.Fatalf("got %d entries want %d", got, want)
+	}
+}
+
+func TestCacheRevalidate(t *testing.T) {
+	cur := withTime(t)
+	u := &upstream{header: http.Header{"Cache-Control": {"max-age=10"}, "Etag": {`"v1"`}}, body: "hello", notModified: true}
+	var results []string
+	h := New(1<<20, 1<<10).Handler(u, "example.com", 0, func(s string) { results = append(results, s) })
+
+	get := func(etag string) (int, string, string) {
+		r := httptest.NewRequest("GET", "http://example.com/", nil)
+		if etag != "" {
+			r.Header.Set("If-None-Match", etag)
+		}
+		return do(h, r)
+	}
+
+	if code, body, _ := get(""); code != 200 || body != "hello" {
+		t.Fatalf("got %d %q want 200 %q", code, body, "hello")
+	}
+
+	// fresh response
+	*cur = cur.Add(5 * time.Second)
+	if code, body, age := get(""); code != 200 || body != "hello" || age != "5" {
+		t.Fatalf("got %d %q age %q want 200 %q age 5", code, body, age, "hello")
+	}
+
+	// conditional request for a fresh response
+	if code, body, _ := get(`"v1"`); code != 304 || body != "" {
+		t.Fatalf("got %d %q want 304", code, body)
+	}
+
+	// stale response which has not been modified
+	*cur = cur.Add(10 * time.Second)
+	if code, body, age := get(""); code != 200 || body != "hello" || age != "0" {
+		t.Fatalf("got %d %q age %q want 200 %q age 0", code, body, age, "hello")
+	}
+	if got, want := u.requests, 2; got != want {
+		t.Fatalf("got %d upstream requests want %d", got, want)
+	}
+
+	// stale response which has been modified
+	*cur = cur.Add(10 * time.Second)
+	u.header.Set("Etag", `"v2"`)
+	u.body = "world"
+	if code, body, _ := get(`"v1"`); code != 200 || body != "world" {
+		t.Fatalf("got %d %q want 200 %q", code, body, "world")
+	}
+	if code, body, _ := get(""); code != 200 || body != "world" {
+		t.Fatalf("got %d %q want 200 %q", code, body, "world")
+	}
+
+	want := []string{"miss", "hit", "hit", "revalidated", "miss", "hit"}
+	if fmt.Sprint(results) != fmt.Sprint(want) {
+		t.Fatalf("got results %v want %v", results, want)
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	u := &upstream{header: http.Header{"Cache-Control": {"max-age=60"}}, body: strings.Repeat("x", 100)}
+	size := 100 + headerSize(http.Header{"Cache-Control": {"max-age=60"}})
+	c := New(3*size, 2*size)
+	h := c.Handler(u, "example.com", 0, nil)
+
+	get := func(path string) {
+		do(h, httptest.NewRequest("GET", "http://example.com"+path, nil))
+	}
+	get("/a")
+	get("/b")
+	get("/c")
+	get("/a") // a is now the most recently used entry
+	get("/d") // evicts b
+	if got, want := u.requests, 4; got != want {
+		t.Fatalf("got %d upstream requests want %d", got, want)
+	}
+	if got, want := c.Size(), 3*size; got != want {
+		t.Fatalf("got size %d want %d", got, want)
+	}
+
+	get("/a")
+	get("/c")
+	get("/d")
+	if got, want := u.requests, 4; got != want {
+		t.Fatalf("got %d upstream requests want %d", got, want)
+	}
+	get("/b")
+	if got, want := u.requests, 5; got != want {
+		t.Fatalf("got %d upstream requests want %d", got, want)
+	}
+
+	// responses larger than the maximum entry size are not cached
+	u.body = strings.Repeat("x", 2*size)
+	get("/large")
+	get("/large")
+	if got, want := u.requests, 7; got != want {
+		t.Fatalf("got %d upstream requests want %d", got, want)
+	}
+}
+
+func TestCachePurge(t *testing.T) {
+	u := &upstream{header: http.Header{"Cache-Control": {"max-age=60"}}, body: "hello"}
+	c := New(1<<20, 1<<10)
+	for _, url := range []string{"http://a.com/api/1", "http://a.com/api/2", "http://a.com/static/1", "http://b.com/api/1"} {
+		r := httptest.NewRequest("GET", url, nil)
+		do(c.Handler(u, r.Host, 0, nil), r)
+	}
+
+	if got, want := c.Purge("a.com/api/"), 2; got != want {
+		t.Fatalf("got %d purged want %d", got, want)
+	}
+	if got, want := c.Len(), 2; got != want {
+		t.Fatalf("got %d entries want %d", got, want)
+	}
+	if got, want := c.Purge(""), 2; got != want {
+		t.Fatalf("got %d purged want %d", got, want)
+	}
+	if got, want := c.Size(), 0; got != want {
+		t.Fatalf("got size %d want %d", got, want)
+	}
+	// the host is stored in lower case and without the port
+	r := httptest.NewRequest("GET", "https://Example.com:443/x", nil)
+	do(c.Handler(u, r.Host, 0, nil), r)
+	if got, want := c.Purge("example.com/"), 1; got != want {
+		t.Fatalf("got %d purged want %d", got, want)
+	}
+	if got, want := c.Len(), 0; got != want {
+		t.Fatalf("got %d entries want %d", got, want)
+	}
+}
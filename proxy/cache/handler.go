@@ -0,0 +1,342 @@
+package cache
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Handler returns a handler which serves GET requests from the cache and
+// stores the responses of h. host is the Host header of the client
+// request which is part of the cache key without the port. ttl is the freshness lifetime
+// of responses without Cache-Control or Expires header. If result is not
+// nil it is called with 'hit', 'miss' or 'revalidated' for every
+// cacheable request.
+//
+// Responses to requests with a Cookie header might be personalized and
+// are only cached if they are marked as shared with the 'public' or
+// 's-maxage' directive.
+func (c *Cache) Handler(h http.Handler, host string, ttl time.Duration, result func(string)) http.Handler {
+	count := func(s string) {
+		if result != nil {
+			result(s)
+		}
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !cacheableRequest(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		key := keyHost(host) + r.URL.Path + "?" + r.URL.RawQuery
+		reqCC := parseCacheControl(r.Header.Values("Cache-Control"))
+		_, noCache := reqCC["no-cache"]
+		if v, ok := reqCC["max-age"]; ok && v == "0" {
+			noCache = true
+		}
+
+		cookie := r.Header.Get("Cookie") != ""
+		e := c.get(key, r)
+		if e != nil && cookie && !shared(e.header) {
+			e = nil
+		}
+		if e != nil && e.fresh(now()) && !noCache {
+			count("hit")
+			e.serve(w, r)
+			return
+		}
+
+		// revalidate a cached response with its validators
+		out := r
+		if e != nil && (e.header.Get("Etag") != "" || e.header.Get("Last-Modified") != "") {
+			out = r.Clone(r.Context())
+			out.Header.Del("If-None-Match")
+			out.Header.Del("If-Modified-Since")
+			if etag := e.header.Get("Etag"); etag != "" {
+				out.Header.Set("If-None-Match", etag)
+			}
+			if lm := e.header.Get("Last-Modified"); lm != "" {
+				out.Header.Set("If-Modified-Since", lm)
+			}
+		}
+
+		rw := &responseWriter{ResponseWriter: w, revalidate: out != r, max: c.maxEntrySize}
+		h.ServeHTTP(rw, out)
+		t := now()
+
+		if rw.notModified {
+			count("revalidated")
+			header := e.header.Clone()
+			for _, name := range []string{"Cache-Control", "Date", "Etag", "Expires", "Last-Modified"} {
+				if v, ok := rw.header[name]; ok {
+					header[name] = v
+				}
+			}
+			ne := &entry{key: e.key, vary: e.vary, varyValues: e.varyValues, status: e.status, header: header, body: e.body, stored: t, size: e.size}
+			if d, ok := lifetime(header, ttl, t); ok && (!cookie || shared(header)) {
+				ne.expires = t.Add(d)
+				c.put(ne)
+			} else {
+				c.delete(e)
+			}
+
+			// replace the headers of the 304 response
+			for k := range w.Header() {
+				delete(w.Header(), k)
+			}
+			ne.serve(w, r)
+			return
+		}
+
+		count("miss")
+		if _, noStore := reqCC["no-store"]; noStore || rw.tooLarge || !cacheableStatus(rw.code) {
+			if e != nil {
+				c.delete(e)
+			}
+			return
+		}
+		d, ok := lifetime(rw.header, ttl, t)
+		if !ok || cookie && !shared(rw.header) {
+			if e != nil {
+				c.delete(e)
+			}
+			return
+		}
+		ne := &entry{
+			key:     key,
+			status:  rw.code,
+			header:  rw.header,
+			body:    rw.buf.Bytes(),
+			stored:  t,
+			expires: t.Add(d),
+		}
+		for _, v := range rw.header.Values("Vary") {
+			for name := range strings.SplitSeq(v, ",") {
+				if name = http.CanonicalHeaderKey(strings.TrimSpace(name)); name != "" {
+					ne.vary = append(ne.vary, name)
+					ne.varyValues = append(ne.varyValues, strings.Join(r.Header.Values(name), ","))
+				}
+			}
+		}
+		ne.size = len(ne.body) + headerSize(ne.header)
+		c.put(ne)
+	})
+}
+
+// serve writes the cached response. Conditional requests with a
+// matching ETag get a '304 Not Modified' response.
+func (e *entry) serve(w http.ResponseWriter, r *http.Request) {
+	h := w.Header()
+	for k, v := range e.header {
+		h[k] = v
+	}
+	h.Set("Age", strconv.Itoa(int(now().Sub(e.stored).Seconds())))
+
+	if etag := e.header.Get("Etag"); etag != "" && e.status == http.StatusOK && matchETag(r.Header.Get("If-None-Match"), etag) {
+		h.Del("Content-Length")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	h.Set("Content-Length", strconv.Itoa(len(e.body)))
+	w.WriteHeader(e.status)
+	if r.Method != http.MethodHead {
+		w.Write(e.body)
+	}
+}
+
+// responseWriter records the response for the cache while it is sent
+// to the client.
+type responseWriter struct {
+	http.ResponseWriter
+
+	// revalidate is set if the request has been made conditional by
+	// the cache. A '304 Not Modified' response is then not sent to
+	// the client.
+	revalidate  bool
+	notModified bool
+
+	// header is a copy of the response header.
+	header http.Header
+	code   int
+
+	// buf contains the response body unless it is larger than max.
+	buf      bytes.Buffer
+	max      int
+	tooLarge bool
+}
+
+func (rw *responseWriter) WriteHeader(code int) {
+	if rw.code != 0 {
+		return
+	}
+	rw.code = code
+	rw.header = rw.Header().Clone()
+	if rw.revalidate && code == http.StatusNotModified {
+		rw.notModified = true
+		return
+	}
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	if rw.code == 0 {
+		rw.WriteHeader(http.StatusOK)
+	}
+	if rw.notModified {
+		return len(b), nil
+	}
+	if !rw.tooLarge {
+		if rw.buf.Len()+len(b) > rw.max {
+			rw.tooLarge = true
+			rw.buf = bytes.Buffer{}
+		} else {
+			rw.buf.Write(b)
+		}
+	}
+	return rw.ResponseWriter.Write(b)
+}
+
+func (rw *responseWriter) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// cacheableRequest returns true if the response to the request can be
+// served from or stored in the cache.
+func cacheableRequest(r *http.Request) bool {
+	return r.Method == http.MethodGet &&
+		r.Header.Get("Authorization") == "" &&
+		r.Header.Get("Range") == "" &&
+		r.Header.Get("Upgrade") == ""
+}
+
+// cacheableStatus returns true if responses with the status code can be
+// stored.
+func cacheableStatus(code int) bool {
+	switch code {
+	case http.StatusOK, http.StatusNonAuthoritativeInfo, http.StatusNoContent,
+		http.StatusMultipleChoices, http.StatusMovedPermanently,
+		http.StatusNotFound, http.StatusGone:
+		return true
+	}
+	return false
+}
+
+// lifetime returns the freshness lifetime of the response and whether it
+// can be stored. The lifetime is determined by the s-maxage and max-age
+// directives of the Cache-Control header, the Expires header or ttl in
+// that order. Responses without lifetime are only stored if they can be
+// revalidated.
+func lifetime(h http.Header, ttl time.Duration, t time.Time) (time.Duration, bool) {
+	cc := parseCacheControl(h.Values("Cache-Control"))
+	if _, ok := cc["no-store"]; ok {
+		return 0, false
+	}
+	if _, ok := cc["private"]; ok {
+		return 0, false
+	}
+	if h.Get("Set-Cookie") != "" || strings.TrimSpace(h.Get("Vary")) == "*" {
+		return 0, false
+	}
+
+	var d time.Duration
+	_, noCache := cc["no-cache"]
+	switch {
+	case noCache:
+	case cc["s-maxage"] != "":
+		d = seconds(cc["s-maxage"])
+	case cc["max-age"] != "":
+		d = seconds(cc["max-age"])
+	case h.Get("Expires") != "":
+		expires, err := http.ParseTime(h.Get("Expires"))
+		if err != nil {
+			break
+		}
+		date, err := http.ParseTime(h.Get("Date"))
+		if err != nil {
+			date = t
+		}
+		d = max(expires.Sub(date), 0)
+	default:
+		d = ttl
+	}
+
+	if d <= 0 && h.Get("Etag") == "" && h.Get("Last-Modified") == "" {
+		return 0, false
+	}
+	return d, true
+}
+
+// shared returns true if the Cache-Control header of the response
+// allows serving it to other clients than the one which sent a cookie.
+func shared(h http.Header) bool {
+	cc := parseCacheControl(h.Values("Cache-Control"))
+	_, public := cc["public"]
+	return public || cc["s-maxage"] != ""
+}
+
+// keyHost returns the host name for the cache key in lower case and
+// without the port.
+func keyHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
+
+// parseCacheControl returns the directives of the Cache-Control header
+// values with their lower case names.
+func parseCacheControl(values []string) map[string]string {
+	cc := map[string]string{}
+	for _, v := range values {
+		for d := range strings.SplitSeq(v, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(d), "=")
+			if name == "" {
+				continue
+			}
+			cc[strings.ToLower(name)] = strings.Trim(value, `"`)
+		}
+	}
+	return cc
+}
+
+func seconds(s string) time.Duration {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return time.Duration(n) * time.Second
+}
+
+// matchETag returns true if the If-None-Match header value contains the
+// ETag. Weak validators match.
+func matchETag(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	if strings.TrimSpace(ifNoneMatch) == "*" {
+		return true
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for v := range strings.SplitSeq(ifNoneMatch, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(v), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// headerSize returns the approximate size of the header in bytes.
+func headerSize(h http.Header) int {
+	n := 0
+	for k, vs := range h {
+		for _, v := range vs {
+			n += len(k) + len(v) + 4
+		}
+	}
+	return n
+}
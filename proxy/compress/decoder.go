@@ -0,0 +1,49 @@
+package compress
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Supported returns true if the content encoding can be decompressed.
+func Supported(encoding string) bool {
+	switch encoding {
+	case Gzip, Brotli, Zstd:
+		return true
+	}
+	return false
+}
+
+// NewReader returns a reader which decompresses the data from r with
+// the content encoding.
+func NewReader(encoding string, r io.Reader) (io.ReadCloser, error) {
+	switch encoding {
+	case Gzip:
+		return gzip.NewReader(r)
+	case Brotli:
+		return io.NopCloser(brotli.NewReader(r)), nil
+	case Zstd:
+		d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	default:
+		return nil, fmt.Errorf("unsupported encoding %q", encoding)
+	}
+}
+
+// Accepts returns true if the Accept-Encoding header values accept the
+// content encoding.
+func Accepts(values []string, encoding string) bool {
+	q := parseAcceptEncoding(values)
+	v, ok := q[encoding]
+	if !ok {
+		v = q["*"]
+	}
+	return v > 0
+}
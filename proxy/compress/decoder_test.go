@@ -0,0 +1,58 @@
+package compress
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestNewReader(t *testing.T) {
+	for _, encoding := range []string{Gzip, Brotli, Zstd} {
+		t.Run(encoding, func(t *testing.T) {
+			var buf bytes.Buffer
+			e := newEncoder(encoding, defaultLevel[encoding])
+			e.Reset(&buf)
+			e.Write([]byte("Hello World"))
+			e.Close()
+
+			r, err := NewReader(encoding, &buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := "Hello World"; string(got) != want {
+				t.Fatalf("got %q want %q", got, want)
+			}
+		})
+	}
+
+	if _, err := NewReader("deflate", &bytes.Buffer{}); err == nil {
+		t.Fatal("got no error for unsupported encoding")
+	}
+}
+
+func TestAccepts(t *testing.T) {
+	tests := []struct {
+		accept   []string
+		encoding string
+		want     bool
+	}{
+		{nil, Gzip, false},
+		{[]string{"gzip"}, Gzip, true},
+		{[]string{"br", "gzip;q=0.5"}, Gzip, true},
+		{[]string{"gzip;q=0"}, Gzip, false},
+		{[]string{"identity"}, Gzip, false},
+		{[]string{"*"}, Zstd, true},
+		{[]string{"*, zstd;q=0"}, Zstd, false},
+	}
+
+	for _, tt := range tests {
+		if got := Accepts(tt.accept, tt.encoding); got != tt.want {
+			t.Errorf("Accepts(%q, %q) got %v want %v", tt.accept, tt.encoding, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package compress
+
+import (
+	"compress/gzip"
+	"io"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Supported content encodings.
+const (
+	Gzip   = "gzip"
+	Brotli = "br"
+	Zstd   = "zstd"
+)
+
+// defaultLevel is the compression level of an encoding if none
+// is configured.
+var defaultLevel = map[string]int{
+	Gzip:   gzip.DefaultCompression,
+	Brotli: 4,
+	Zstd:   3,
+}
+
+// encoder compresses a response body.
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+type poolKey struct {
+	encoding string
+	level    int
+}
+
+// pools contains a pool of encoders for every encoding and level.
+var pools sync.Map
+
+func pool(encoding string, level int) *sync.Pool {
+	key := poolKey{encoding, level}
+	if p, ok := pools.Load(key); ok {
+		return p.(*sync.Pool)
+	}
+	p, _ := pools.LoadOrStore(key, &sync.Pool{New: func() any { return newEncoder(encoding, level) }})
+	return p.(*sync.Pool)
+}
+
+func getEncoder(encoding string, level int) encoder {
+	return pool(encoding, level).Get().(encoder)
+}
+
+func putEncoder(encoding string, level int, e encoder) {
+	// release the reference to the response writer
+	e.Reset(io.Discard)
+	pool(encoding, level).Put(e)
+}
+
+func newEncoder(encoding string, level int) encoder {
+	switch encoding {
+	case Brotli:
+		return brotli.NewWriterLevel(nil, level)
+	case Zstd:
+		// the encoder compresses a single response and
+		// does not need concurrent goroutines
+		e, _ := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)), zstd.WithEncoderConcurrency(1))
+		return e
+	default:
+		e, err := gzip.NewWriterLevel(nil, level)
+		if err != nil {
+			e = gzip.NewWriter(nil)
+		}
+		return e
+	}
+}
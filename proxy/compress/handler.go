@@ -0,0 +1,270 @@
+// Copyright (c) 2016 Sebastian Mancke and eBay, both MIT licensed
+
+// Package compress provides an HTTP handler which compresses responses
+// with gzip, brotli or zstd if the client supports this, the response
+// is compressable and not already compressed.
+//
+// Based on https://github.com/smancke/handler/gzip
+package compress
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	headerVary            = "Vary"
+	headerAccept          = "Accept"
+	headerAcceptEncoding  = "Accept-Encoding"
+	headerContentEncoding = "Content-Encoding"
+	headerContentType     = "Content-Type"
+	headerContentLength   = "Content-Length"
+)
+
+var blacklistedAcceptContentTypes = []string{"text/event-stream"}
+
+// DefaultContentTypes matches the content types which are compressed
+// when no other expression is configured.
+var DefaultContentTypes = regexp.MustCompile(`^(text/.*|application/(javascript|json|font-woff|xml)|.*\+(json|xml))(;.*)?$`)
+
+// Options configures the compression of responses.
+type Options struct {
+	// Encodings is the list of content encodings in order of preference
+	// for clients which accept several encodings with the same quality.
+	Encodings []string
+
+	// ContentTypes matches the content types of compressable responses.
+	ContentTypes *regexp.Regexp
+
+	// MinSize is the minimum size of a compressed response body.
+	// Smaller responses are sent uncompressed.
+	MinSize int
+
+	// Levels contains the compression level per encoding. Encodings
+	// without a level use the default level of the encoder.
+	Levels map[string]int
+}
+
+// NewHandler wraps an existing handler to transparently compress the
+// response body with the preferred encoding of the client (via the
+// Accept-Encoding header) if the response Content-Type matches the
+// content types expression.
+func NewHandler(h http.Handler, opts Options) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add(headerVary, headerAcceptEncoding)
+
+		if encoding := negotiate(r, opts.Encodings); encoding != "" {
+			cw := NewResponseWriter(w, encoding, opts)
+			defer cw.Close()
+			h.ServeHTTP(cw, r)
+		} else {
+			h.ServeHTTP(w, r)
+		}
+	})
+}
+
+// ResponseWriter compresses the response body with the given encoding.
+// Close must be called after the response has been written.
+type ResponseWriter struct {
+	encoding string
+	opts     Options
+
+	// writer is the writer for the response body. It is nil until
+	// the decision whether to compress the response has been made.
+	writer  io.Writer
+	encoder encoder
+
+	// code is the status code while the response is buffered.
+	code int
+
+	// buf contains the start of the response body until it has
+	// reached the minimum size.
+	buf []byte
+
+	http.ResponseWriter
+}
+
+func NewResponseWriter(w http.ResponseWriter, encoding string, opts Options) *ResponseWriter {
+	return &ResponseWriter{ResponseWriter: w, encoding: encoding, opts: opts}
+}
+
+func (cw *ResponseWriter) WriteHeader(code int) {
+	if cw.writer != nil || cw.code != 0 {
+		return
+	}
+	if !cw.compressable(code) {
+		cw.writer = cw.ResponseWriter
+		cw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	// buffer the response until it has reached the minimum size
+	// unless the size is known
+	if cw.opts.MinSize > 0 && cw.Header().Get(headerContentLength) == "" {
+		cw.code = code
+		return
+	}
+	cw.compress(code)
+}
+
+func (cw *ResponseWriter) Write(b []byte) (int, error) {
+	if cw.writer == nil && cw.code == 0 {
+		if _, ok := cw.Header()[headerContentType]; !ok {
+			// Set content-type if not present. Otherwise golang would make application/gzip out of that.
+			cw.Header().Set(headerContentType, http.DetectContentType(b))
+		}
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.writer == nil {
+		cw.buf = append(cw.buf, b...)
+		if len(cw.buf) >= cw.opts.MinSize {
+			if err := cw.compress(cw.code); err != nil {
+				return 0, err
+			}
+		}
+		return len(b), nil
+	}
+	return cw.writer.Write(b)
+}
+
+// Flush sends the buffered data to the client.
+func (cw *ResponseWriter) Flush() {
+	if cw.writer == nil && cw.code != 0 {
+		cw.compress(cw.code)
+	}
+	if cw.encoder != nil {
+		cw.encoder.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close finishes the response. Buffered responses which are smaller
+// than the minimum size are sent uncompressed.
+func (cw *ResponseWriter) Close() {
+	if cw.writer == nil && cw.code != 0 {
+		cw.Header().Set(headerContentLength, strconv.Itoa(len(cw.buf)))
+		cw.ResponseWriter.WriteHeader(cw.code)
+		cw.ResponseWriter.Write(cw.buf)
+		cw.writer = cw.ResponseWriter
+	}
+	if cw.encoder != nil {
+		cw.encoder.Close()
+		putEncoder(cw.encoding, cw.level(), cw.encoder)
+		cw.encoder = nil
+	}
+}
+
+func (cw *ResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hj, ok := cw.ResponseWriter.(http.Hijacker); ok {
+		return hj.Hijack()
+	}
+	return nil, nil, errors.New("not a Hijacker")
+}
+
+// compress sends the header and the buffered body and compresses the
+// remaining response.
+func (cw *ResponseWriter) compress(code int) error {
+	cw.Header().Del(headerContentLength)
+	cw.Header().Set(headerContentEncoding, cw.encoding)
+	cw.encoder = getEncoder(cw.encoding, cw.level())
+	cw.encoder.Reset(cw.ResponseWriter)
+	cw.writer = cw.encoder
+	cw.code = 0
+	cw.ResponseWriter.WriteHeader(code)
+
+	buf := cw.buf
+	cw.buf = nil
+	if len(buf) > 0 {
+		_, err := cw.writer.Write(buf)
+		return err
+	}
+	return nil
+}
+
+func (cw *ResponseWriter) level() int {
+	if l, ok := cw.opts.Levels[cw.encoding]; ok {
+		return l
+	}
+	return defaultLevel[cw.encoding]
+}
+
+func (cw *ResponseWriter) compressable(code int) bool {
+	// responses without a body
+	if code < 200 || code == http.StatusNoContent || code == http.StatusNotModified {
+		return false
+	}
+	// don't compress if it is already encoded
+	if cw.Header().Get(headerContentEncoding) != "" {
+		return false
+	}
+	if n, err := strconv.Atoi(cw.Header().Get(headerContentLength)); err == nil && n < cw.opts.MinSize {
+		return false
+	}
+	contentTypes := cw.opts.ContentTypes
+	if contentTypes == nil {
+		contentTypes = DefaultContentTypes
+	}
+	return contentTypes.MatchString(cw.Header().Get(headerContentType))
+}
+
+// negotiate returns the encoding with the highest quality in the
+// Accept-Encoding header of the request. Encodings with the same quality
+// are chosen in the order of the encodings list. It returns an empty
+// string if the response should not be compressed.
+func negotiate(r *http.Request, encodings []string) string {
+	accept := r.Header.Get(headerAccept)
+	for _, contentType := range blacklistedAcceptContentTypes {
+		if strings.Contains(accept, contentType) {
+			return ""
+		}
+	}
+
+	q := parseAcceptEncoding(r.Header.Values(headerAcceptEncoding))
+	best, bestQ := "", 0.0
+	for _, e := range encodings {
+		v, ok := q[e]
+		if !ok {
+			v = q["*"]
+		}
+		if v > bestQ {
+			best, bestQ = e, v
+		}
+	}
+	return best
+}
+
+// parseAcceptEncoding returns the quality values of the content codings
+// of the Accept-Encoding header. Codings without a quality value have
+// a quality of 1.
+func parseAcceptEncoding(values []string) map[string]float64 {
+	q := map[string]float64{}
+	for _, v := range values {
+		for part := range strings.SplitSeq(v, ",") {
+			coding, params, _ := strings.Cut(part, ";")
+			coding = strings.ToLower(strings.TrimSpace(coding))
+			if coding == "" {
+				continue
+			}
+			qv := 1.0
+			for p := range strings.SplitSeq(params, ";") {
+				name, value, _ := strings.Cut(strings.TrimSpace(p), "=")
+				if strings.EqualFold(name, "q") {
+					f, err := strconv.ParseFloat(value, 64)
+					if err != nil || f < 0 || f > 1 {
+						f = 0
+					}
+					qv = f
+				}
+			}
+			q[coding] = qv
+		}
+	}
+	return q
+}
@@ -0,0 +1,263 @@
+// Copyright (c) 2016 Sebastian Mancke and eBay, both MIT licensed
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/fabiolb/fabio/assert"
+)
+
+var contentTypes = regexp.MustCompile(`^(text/.*|application/(javascript|json|font-woff|xml)|.*\+(json|xml))(;.*)?$`)
+
+var gzipOptions = Options{Encodings: []string{Gzip}, ContentTypes: contentTypes}
+
+func Test_Handler_CompressableType(t *testing.T) {
+	server := httptest.NewServer(NewHandler(test_text_handler(), gzipOptions))
+
+	assertEqual := assert.Equal(t)
+
+	r, err := http.NewRequest("GET", server.URL, nil)
+	assertEqual(err, nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+
+	resp, err := http.DefaultClient.Do(r)
+	assertEqual(err, nil)
+
+	assertEqual(resp.Header.Get("Content-Type"), "text/plain; charset=utf-8")
+	assertEqual(resp.Header.Get("Content-Encoding"), "gzip")
+
+	gzBytes, err := io.ReadAll(resp.Body)
+	assertEqual(err, nil)
+	assertEqual(resp.Header.Get("Content-Length"), strconv.Itoa(len(gzBytes)))
+
+	reader, err := gzip.NewReader(bytes.NewBuffer(gzBytes))
+	assertEqual(err, nil)
+	defer reader.Close()
+
+	bytes, err := io.ReadAll(reader)
+	assertEqual(err, nil)
+
+	assertEqual(string(bytes), "Hello World")
+}
+
+func Test_Handler_NotCompressingTwice(t *testing.T) {
+	server := httptest.NewServer(NewHandler(test_already_compressed_handler(), gzipOptions))
+
+	assertEqual := assert.Equal(t)
+
+	r, err := http.NewRequest("GET", server.URL, nil)
+	assertEqual(err, nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+
+	resp, err := http.DefaultClient.Do(r)
+	assertEqual(err, nil)
+
+	assertEqual(resp.Header.Get("Content-Encoding"), "gzip")
+
+	reader, err := gzip.NewReader(resp.Body)
+	assertEqual(err, nil)
+	defer reader.Close()
+
+	bytes, err := io.ReadAll(reader)
+	assertEqual(err, nil)
+
+	assertEqual(string(bytes), "Hello World")
+}
+
+func Test_Handler_CompressableType_NoAccept(t *testing.T) {
+	server := httptest.NewServer(NewHandler(test_text_handler(), gzipOptions))
+
+	assertEqual := assert.Equal(t)
+
+	r, err := http.NewRequest("GET", server.URL, nil)
+	assertEqual(err, nil)
+	r.Header.Set("Accept-Encoding", "none")
+
+	resp, err := http.DefaultClient.Do(r)
+	assertEqual(err, nil)
+
+	assertEqual(resp.Header.Get("Content-Encoding"), "")
+
+	bytes, err := io.ReadAll(resp.Body)
+	assertEqual(err, nil)
+
+	assertEqual(string(bytes), "Hello World")
+}
+
+func Test_Handler_NonCompressableType(t *testing.T) {
+	server := httptest.NewServer(NewHandler(test_binary_handler(), gzipOptions))
+
+	assertEqual := assert.Equal(t)
+
+	r, err := http.NewRequest("GET", server.URL, nil)
+	assertEqual(err, nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+
+	resp, err := http.DefaultClient.Do(r)
+	assertEqual(err, nil)
+
+	assertEqual(resp.Header.Get("Content-Encoding"), "")
+
+	bytes, err := io.ReadAll(resp.Body)
+	assertEqual(err, nil)
+
+	assertEqual(bytes, []byte{42})
+}
+
+func test_text_handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b := []byte("Hello World")
+		w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+		w.Write(b)
+	})
+}
+
+func test_binary_handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpg")
+		w.Write([]byte{42})
+	})
+}
+
+func test_already_compressed_handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		gzWriter := gzip.NewWriter(w)
+		gzWriter.Write([]byte("Hello World"))
+		gzWriter.Close()
+	})
+}
+
+func Test_Handler_Encodings(t *testing.T) {
+	opts := Options{Encodings: []string{Zstd, Brotli, Gzip}, ContentTypes: contentTypes}
+	server := httptest.NewServer(NewHandler(test_text_handler(), opts))
+	defer server.Close()
+
+	decoders := map[string]func(io.Reader) (io.Reader, error){
+		Gzip:   func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		Brotli: func(r io.Reader) (io.Reader, error) { return brotli.NewReader(r), nil },
+		Zstd:   func(r io.Reader) (io.Reader, error) { return zstd.NewReader(r) },
+	}
+
+	for _, encoding := range []string{Gzip, Brotli, Zstd} {
+		t.Run(encoding, func(t *testing.T) {
+			assertEqual := assert.Equal(t)
+
+			r, err := http.NewRequest("GET", server.URL, nil)
+			assertEqual(err, nil)
+			r.Header.Set("Accept-Encoding", encoding)
+
+			resp, err := http.DefaultTransport.RoundTrip(r)
+			assertEqual(err, nil)
+			defer resp.Body.Close()
+			assertEqual(resp.Header.Get("Content-Encoding"), encoding)
+
+			reader, err := decoders[encoding](resp.Body)
+			assertEqual(err, nil)
+			b, err := io.ReadAll(reader)
+			assertEqual(err, nil)
+			assertEqual(string(b), "Hello World")
+		})
+	}
+}
+
+func Test_Handler_MinSize(t *testing.T) {
+	tests := []struct {
+		desc     string
+		size     int
+		length   bool
+		encoding string
+	}{
+		{"small response", 10, false, ""},
+		{"large response", 100, false, Gzip},
+		{"small response with length", 10, true, ""},
+		{"large response with length", 100, true, Gzip},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			assertEqual := assert.Equal(t)
+
+			body := strings.Repeat("a", tt.size)
+			h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/plain")
+				if tt.length {
+					w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+				}
+				// write the body in small chunks
+				for i := 0; i < len(body); i += 7 {
+					w.Write([]byte(body[i:min(i+7, len(body))]))
+				}
+			})
+			opts := Options{Encodings: []string{Gzip}, ContentTypes: contentTypes, MinSize: 50}
+
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("Accept-Encoding", "gzip")
+			w := httptest.NewRecorder()
+			NewHandler(h, opts).ServeHTTP(w, r)
+
+			assertEqual(w.Header().Get("Content-Encoding"), tt.encoding)
+			var got []byte
+			if tt.encoding == Gzip {
+				reader, err := gzip.NewReader(w.Body)
+				assertEqual(err, nil)
+				got, err = io.ReadAll(reader)
+				assertEqual(err, nil)
+			} else {
+				got = w.Body.Bytes()
+				assertEqual(w.Header().Get("Content-Length"), strconv.Itoa(len(body)))
+			}
+			assertEqual(string(got), body)
+		})
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	encodings := []string{Zstd, Brotli, Gzip}
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", ""},
+		{"identity", ""},
+		{"gzip", Gzip},
+		{"gzip, deflate, br", Brotli},
+		{"gzip, deflate, br, zstd", Zstd},
+		{"gzip;q=1.0, br;q=0.8", Gzip},
+		{"GZIP;Q=0.5, br;q=0.4", Gzip},
+		{"br;q=0, gzip;q=0.1", Gzip},
+		{"*", Zstd},
+		{"*;q=0.5, br", Brotli},
+		{"zstd;q=0, *", Brotli},
+		{"gzip;q=0", ""},
+		{"gzip;q=invalid", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("Accept-Encoding", tt.accept)
+			if got := negotiate(r, encodings); got != tt.want {
+				t.Fatalf("got %q want %q", got, tt.want)
+			}
+		})
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	r.Header.Set("Accept", "text/event-stream")
+	if got := negotiate(r, encodings); got != "" {
+		t.Fatalf("got %q for event stream want none", got)
+	}
+}
@@ -95,6 +95,11 @@ func makeGRPCTargetKey(t *route.Target) string {
 func (g GrpcProxyInterceptor) Stream(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
 	ctx := stream.Context()
 
+	if g.Config.Proxy.GRPCHealth && info.FullMethod == grpcHealthCheckMethod {
+		setRPCMethod(ctx, info.FullMethod)
+		return g.healthCheck(stream)
+	}
+
 	target, err := g.lookup(ctx, info.FullMethod)
 
 	if err != nil {
@@ -108,6 +113,10 @@ func (g GrpcProxyInterceptor) Stream(srv any, stream grpc.ServerStream, info *gr
 		return status.Error(codes.NotFound, "no route found")
 	}
 
+	// only routed methods are used as metric labels so that
+	// clients cannot create an unbounded number of them
+	setRPCMethod(ctx, info.FullMethod)
+
 	ctx = context.WithValue(ctx, targetKey{}, target)
 
 	proxyStream := proxyStream{
@@ -117,11 +126,14 @@ func (g GrpcProxyInterceptor) Stream(srv any, stream grpc.ServerStream, info *gr
 
 	start := time.Now()
 
+	target.Begin()
 	err = handler(srv, proxyStream)
 
 	end := time.Now()
 	dur := end.Sub(start)
 
+	target.End(dur)
+	target.ReportResult(status.Code(err) != codes.Unavailable)
 	target.Timer.Observe(dur.Seconds())
 
 	return err
@@ -157,6 +169,7 @@ func (g GrpcProxyInterceptor) lookup(ctx context.Context, fullMethodName string)
 	//todo: better a configuration flag is required to disable/enable this function, and make it disabled by default configuration
 
 	req := &http.Request{
+		Method: http.MethodPost,
 		Host:   dstHostSpecifiedByGRPCClient,
 		URL:    reqUrl,
 		Header: headers,
@@ -191,12 +204,27 @@ type GrpcStatsHandler struct {
 type connCtxKey struct{}
 type rpcCtxKey struct{}
 
+// rpcInfo contains the labels of an RPC for the metrics.
+type rpcInfo struct {
+	// method is the full method name of the RPC or empty if
+	// the RPC was not routed.
+	method string
+}
+
+// setRPCMethod sets the method label of the RPC. The End stats are
+// handled after the stream handler has returned.
+func setRPCMethod(ctx context.Context, method string) {
+	if info, ok := ctx.Value(rpcCtxKey{}).(*rpcInfo); ok {
+		info.method = method
+	}
+}
+
 func (h *GrpcStatsHandler) TagConn(ctx context.Context, info *stats.ConnTagInfo) context.Context {
 	return context.WithValue(ctx, connCtxKey{}, info)
 }
 
 func (h *GrpcStatsHandler) TagRPC(ctx context.Context, info *stats.RPCTagInfo) context.Context {
-	return context.WithValue(ctx, rpcCtxKey{}, info)
+	return context.WithValue(ctx, rpcCtxKey{}, &rpcInfo{})
 }
 
 func (h *GrpcStatsHandler) HandleRPC(ctx context.Context, rpc stats.RPCStats) {
@@ -209,11 +237,16 @@ func (h *GrpcStatsHandler) HandleRPC(ctx context.Context, rpc stats.RPCStats) {
 
 	dur := rpcStats.EndTime.Sub(rpcStats.BeginTime)
 
-	h.Request.Observe(dur.Seconds())
+	method := "unknown"
+	if info, ok := ctx.Value(rpcCtxKey{}).(*rpcInfo); ok && info.method != "" {
+		method = info.method
+	}
+
+	h.Request.With("method", method).Observe(dur.Seconds())
 
 	s, _ := status.FromError(rpcStats.Error)
 
-	h.Status.With("code", s.Code().String()).Observe(dur.Seconds())
+	h.Status.With("code", s.Code().String(), "method", method).Observe(dur.Seconds())
 }
 
 // HandleConn processes the Conn stats.
@@ -264,16 +297,20 @@ func (p *grpcConnectionPool) newConnection(target *route.Target) (*grpc.ClientCo
 		grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(p.cfg.Proxy.GRPCMaxRxMsgSize)),
 	}
 
-	if target.URL.Scheme == "grpcs" && p.tlscfg != nil {
-		opts = append(opts, grpc.WithTransportCredentials(
-			credentials.NewTLS(&tls.Config{
-				ClientCAs:          p.tlscfg.ClientCAs,
-				InsecureSkipVerify: target.TLSSkipVerify,
-				// as per the http/2 spec, the host header isn't required, so if your
-				// target service doesn't have IP SANs in it's certificate
-				// then you will need to override the servername
-				ServerName: target.Opts["grpcservername"],
-			})))
+	if target.URL.Scheme == "grpcs" && (p.tlscfg != nil || target.UpstreamTLS != nil) {
+		tlscfg := &tls.Config{}
+		if target.UpstreamTLS != nil {
+			tlscfg = target.UpstreamTLS.Clone()
+		}
+		if p.tlscfg != nil {
+			tlscfg.ClientCAs = p.tlscfg.ClientCAs
+		}
+		tlscfg.InsecureSkipVerify = target.TLSSkipVerify
+		// as per the http/2 spec, the host header isn't required, so if your
+		// target service doesn't have IP SANs in it's certificate
+		// then you will need to override the servername
+		tlscfg.ServerName = target.Opts["grpcservername"]
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(tlscfg)))
 	} else {
 		opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
 	}
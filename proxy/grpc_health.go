@@ -0,0 +1,36 @@
+package proxy
+
+import (
+	"log"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// grpcHealthCheckMethod is the full method name of the gRPC health check.
+const grpcHealthCheckMethod = "/grpc.health.v1.Health/Check"
+
+// healthCheck answers a grpc.health.v1 health check request. A service
+// is serving if there is a route for the path '/<service>/'. The empty
+// service name refers to fabio itself which is always serving.
+func (g GrpcProxyInterceptor) healthCheck(stream grpc.ServerStream) error {
+	var req healthpb.HealthCheckRequest
+	if err := stream.RecvMsg(&req); err != nil {
+		return err
+	}
+
+	resp := &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING}
+	if req.Service != "" {
+		target, err := g.lookup(stream.Context(), "/"+req.Service+"/")
+		if err != nil {
+			log.Println("[ERROR] grpc: error looking up route", err)
+			return status.Error(codes.Internal, "internal error")
+		}
+		if target == nil {
+			resp.Status = healthpb.HealthCheckResponse_NOT_SERVING
+		}
+	}
+	return stream.SendMsg(resp)
+}
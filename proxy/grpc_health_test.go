@@ -0,0 +1,136 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/route"
+
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/discard"
+	grpc_proxy "github.com/mwitkow/grpc-proxy/proxy"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+func TestGRPCHealthCheck(t *testing.T) {
+	tbl, err := route.NewTable(bytes.NewBufferString(`route add svc /my.service grpc://127.0.0.1:1 opts "proto=grpc"`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	route.SetTable(tbl)
+	defer route.SetTable(make(route.Table))
+
+	cfg := &config.Config{Proxy: config.Proxy{Strategy: "rr", Matcher: "prefix", GRPCHealth: true}}
+	statuses := &labelHistogram{}
+	statsHandler := &GrpcStatsHandler{
+		Connect: discard.NewCounter(),
+		Request: discard.NewHistogram(),
+		NoRoute: discard.NewCounter(),
+		Status:  statuses,
+	}
+	interceptor := GrpcProxyInterceptor{Config: cfg, StatsHandler: statsHandler, GlobCache: globCache}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer(
+		grpc.UnknownServiceHandler(grpc_proxy.TransparentHandler(GetGRPCDirector(nil, cfg))),
+		grpc.StreamInterceptor(interceptor.Stream),
+		grpc.StatsHandler(statsHandler),
+	)
+	go srv.Serve(ln)
+	defer srv.Stop()
+
+	conn, err := grpc.NewClient(ln.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := healthpb.NewHealthClient(conn)
+
+	tests := []struct {
+		desc    string
+		service string
+		want    healthpb.HealthCheckResponse_ServingStatus
+	}{
+		{"fabio", "", healthpb.HealthCheckResponse_SERVING},
+		{"routed service", "my.service", healthpb.HealthCheckResponse_SERVING},
+		{"unknown service", "other.service", healthpb.HealthCheckResponse_NOT_SERVING},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			resp, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: tt.service})
+			if err != nil {
+				t.Fatalf("got %v want nil", err)
+			}
+			if got, want := resp.Status, tt.want; got != want {
+				t.Fatalf("got %v want %v", got, want)
+			}
+		})
+	}
+
+	err = conn.Invoke(context.Background(), "/other.service/Method", &healthpb.HealthCheckRequest{}, &healthpb.HealthCheckResponse{})
+	if got, want := status.Code(err), codes.NotFound; got != want {
+		t.Fatalf("got %v want %v", got, want)
+	}
+
+	want := [][]string{
+		{"code", "OK", "method", grpcHealthCheckMethod},
+		{"code", "OK", "method", grpcHealthCheckMethod},
+		{"code", "OK", "method", grpcHealthCheckMethod},
+		{"code", "NotFound", "method", "unknown"},
+	}
+	// the stats of an RPC are handled after the response was sent
+	deadline := time.Now().Add(time.Second)
+	for len(statuses.get()) < len(want) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := statuses.get(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got labels %v want %v", got, want)
+	}
+}
+
+// labelHistogram records the label values of all observations.
+type labelHistogram struct {
+	mu  sync.Mutex
+	obs [][]string
+	lvs []string
+	// parent is the histogram which records the observations
+	parent *labelHistogram
+}
+
+func (h *labelHistogram) With(labelValues ...string) metrics.Histogram {
+	root := h
+	if h.parent != nil {
+		root = h.parent
+	}
+	return &labelHistogram{lvs: append(append([]string{}, h.lvs...), labelValues...), parent: root}
+}
+
+func (h *labelHistogram) Observe(float64) {
+	root := h
+	if h.parent != nil {
+		root = h.parent
+	}
+	root.mu.Lock()
+	root.obs = append(root.obs, h.lvs)
+	root.mu.Unlock()
+}
+
+func (h *labelHistogram) get() [][]string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([][]string{}, h.obs...)
+}
@@ -0,0 +1,295 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/fabiolb/fabio/route"
+	"github.com/fabiolb/fabio/transport"
+)
+
+// gRPC-Web content types. The text variant encodes the body with base64.
+const (
+	grpcWebContentType     = "application/grpc-web"
+	grpcWebTextContentType = "application/grpc-web-text"
+)
+
+// grpcWebTrailerFlag marks the frame which contains the trailers in the
+// body of a gRPC-Web response.
+const grpcWebTrailerFlag = 0x80
+
+// isGRPCWeb returns true if the request is a gRPC-Web request.
+func isGRPCWeb(r *http.Request) bool {
+	return r.Method == http.MethodPost && strings.HasPrefix(r.Header.Get("Content-Type"), grpcWebContentType)
+}
+
+// isGRPC returns true if the url is the url of a gRPC target.
+func isGRPC(u *url.URL) bool {
+	return u.Scheme == "grpc" || u.Scheme == "grpcs"
+}
+
+// grpcWebHandler translates gRPC-Web requests to native gRPC requests
+// over HTTP/2 and the responses back to gRPC-Web. The trailers of the
+// gRPC response are sent as the last frame of the response body since
+// browsers cannot read HTTP trailers.
+type grpcWebHandler struct {
+	// target is the url of the gRPC target with the 'grpc' or
+	// 'grpcs' scheme.
+	target *url.URL
+
+	// tr is the HTTP/2 transport for the gRPC target.
+	tr http.RoundTripper
+}
+
+func newGRPCWebHandler(target *url.URL, tr http.RoundTripper) *grpcWebHandler {
+	return &grpcWebHandler{target: target, tr: tr}
+}
+
+func (h *grpcWebHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	contentType := r.Header.Get("Content-Type")
+	text := strings.HasPrefix(contentType, grpcWebTextContentType)
+
+	body := r.Body
+	if text {
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			grpcWebError(w, contentType, grpcUnavailable, "cannot read request")
+			return
+		}
+		if b, err = decodeBase64Chunks(b); err != nil {
+			grpcWebError(w, contentType, grpcInternal, "invalid base64 request body")
+			return
+		}
+		body = io.NopCloser(bytes.NewReader(b))
+	}
+
+	u := *h.target
+	u.Scheme = "http"
+	if h.target.Scheme == "grpcs" {
+		u.Scheme = "https"
+	}
+
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, u.String(), body)
+	if err != nil {
+		grpcWebError(w, contentType, grpcInternal, "invalid request")
+		return
+	}
+	for k, v := range r.Header {
+		if hopHeaders[k] || k == "Content-Length" || k == "X-Grpc-Web" {
+			continue
+		}
+		req.Header[k] = v
+	}
+	req.Host = r.Host
+	req.Header.Set("Content-Type", grpcContentType(contentType))
+	req.Header.Set("Te", "trailers")
+
+	resp, err := h.tr.RoundTrip(req)
+	if err != nil {
+		log.Printf("[ERROR] grpc-web: %s", err)
+		grpcWebError(w, contentType, grpcUnavailable, "upstream unavailable")
+		return
+	}
+	defer resp.Body.Close()
+
+	for k, v := range resp.Header {
+		if hopHeaders[k] || k == "Content-Length" || k == "Trailer" {
+			continue
+		}
+		w.Header()[k] = v
+	}
+	w.Header().Set("Content-Type", grpcWebResponseContentType(contentType, resp.Header.Get("Content-Type")))
+	w.WriteHeader(resp.StatusCode)
+
+	out := io.Writer(w)
+	if text {
+		out = &base64ChunkWriter{w: w}
+	}
+	if err := copyFlush(out, w, resp.Body); err != nil {
+		log.Printf("[ERROR] grpc-web: %s", err)
+		return
+	}
+
+	// trailers-only responses have the status in the headers
+	if len(resp.Trailer) == 0 {
+		return
+	}
+	out.Write(grpcWebTrailerFrame(resp.Trailer))
+	if fl, ok := w.(http.Flusher); ok {
+		fl.Flush()
+	}
+}
+
+// hopHeaders are the hop-by-hop headers which are not forwarded.
+var hopHeaders = map[string]bool{
+	"Connection":          true,
+	"Keep-Alive":          true,
+	"Proxy-Connection":    true,
+	"Transfer-Encoding":   true,
+	"Upgrade":             true,
+	"Te":                  true,
+	"Proxy-Authenticate":  true,
+	"Proxy-Authorization": true,
+}
+
+// gRPC status codes for errors of the proxy.
+const (
+	grpcInternal    = 13
+	grpcUnavailable = 14
+)
+
+// grpcWebError sends a trailers-only gRPC-Web response with the status.
+func grpcWebError(w http.ResponseWriter, contentType string, code int, msg string) {
+	w.Header().Set("Content-Type", grpcWebResponseContentType(contentType, ""))
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	w.Header().Set("Grpc-Message", msg)
+	w.WriteHeader(http.StatusOK)
+}
+
+// grpcContentType returns the gRPC content type for the gRPC-Web content
+// type, e.g. 'application/grpc+proto' for 'application/grpc-web-text+proto'.
+func grpcContentType(contentType string) string {
+	subtype := strings.TrimPrefix(strings.TrimPrefix(contentType, grpcWebTextContentType), grpcWebContentType)
+	return "application/grpc" + subtype
+}
+
+// grpcWebResponseContentType returns the content type of the gRPC-Web
+// response for the request content type and the content type of the
+// gRPC response.
+func grpcWebResponseContentType(reqContentType, respContentType string) string {
+	prefix := grpcWebContentType
+	if strings.HasPrefix(reqContentType, grpcWebTextContentType) {
+		prefix = grpcWebTextContentType
+	}
+	return prefix + strings.TrimPrefix(respContentType, "application/grpc")
+}
+
+// grpcWebTrailerFrame returns the trailers as a gRPC-Web body frame.
+// The trailer names are sent in lower case.
+func grpcWebTrailerFrame(trailer http.Header) []byte {
+	var buf bytes.Buffer
+	for k, vs := range trailer {
+		for _, v := range vs {
+			buf.WriteString(strings.ToLower(k) + ": " + v + "\r\n")
+		}
+	}
+	frame := make([]byte, 5, 5+buf.Len())
+	frame[0] = grpcWebTrailerFlag
+	binary.BigEndian.PutUint32(frame[1:], uint32(buf.Len()))
+	return append(frame, buf.Bytes()...)
+}
+
+// copyFlush copies the response body and flushes every chunk so that
+// messages of streaming responses are not delayed.
+func copyFlush(dst io.Writer, w http.ResponseWriter, src io.Reader) error {
+	fl, _ := w.(http.Flusher)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			if _, werr := dst.Write(buf[:n]); werr != nil {
+				return werr
+			}
+			if fl != nil {
+				fl.Flush()
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// base64ChunkWriter encodes every write as a separate padded base64
+// chunk as expected by gRPC-Web text clients.
+type base64ChunkWriter struct {
+	w io.Writer
+}
+
+func (b *base64ChunkWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(b.w, base64.StdEncoding.EncodeToString(p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// decodeBase64Chunks decodes a sequence of padded base64 chunks.
+func decodeBase64Chunks(b []byte) ([]byte, error) {
+	s := strings.Join(strings.Fields(string(b)), "")
+	var out []byte
+	for s != "" {
+		// a chunk ends after its padding
+		end := len(s)
+		if i := strings.IndexByte(s, '='); i >= 0 {
+			end = i
+			for end < len(s) && s[end] == '=' {
+				end++
+			}
+		}
+		p, err := base64.StdEncoding.DecodeString(s[:end])
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, p...)
+		s = s[end:]
+	}
+	return out, nil
+}
+
+var (
+	grpcWebH2COnce      sync.Once
+	grpcWebH2CTransport *http.Transport
+
+	// grpcWebTLSTransports contains the HTTP/2 transports for 'grpcs'
+	// targets by their TLS settings.
+	grpcWebTLSTransports sync.Map
+)
+
+// grpcWebTLSKey contains the TLS settings of a 'grpcs' transport.
+type grpcWebTLSKey struct {
+	serverName    string
+	tlsSkipVerify bool
+	upstreamTLS   *tls.Config
+}
+
+// grpcWebTransport returns the HTTP/2 transport for the gRPC target.
+// Plaintext 'grpc' targets use HTTP/2 with prior knowledge.
+func grpcWebTransport(t *route.Target) http.RoundTripper {
+	if t.URL.Scheme != "grpcs" {
+		grpcWebH2COnce.Do(func() {
+			grpcWebH2CTransport = transport.NewH2CTransport()
+		})
+		return grpcWebH2CTransport
+	}
+
+	// the server name can be overridden for targets without IP SANs
+	serverName := t.Opts["grpcservername"]
+	key := grpcWebTLSKey{serverName: serverName, tlsSkipVerify: t.TLSSkipVerify, upstreamTLS: t.UpstreamTLS}
+	if tr, ok := grpcWebTLSTransports.Load(key); ok {
+		return tr.(*http.Transport)
+	}
+	tlscfg := &tls.Config{}
+	if t.UpstreamTLS != nil {
+		tlscfg = t.UpstreamTLS.Clone()
+	}
+	tlscfg.ServerName = serverName
+	tlscfg.InsecureSkipVerify = t.TLSSkipVerify
+	tr := transport.NewTransport(tlscfg)
+	tr.Protocols = new(http.Protocols)
+	tr.Protocols.SetHTTP2(true)
+	actual, _ := grpcWebTLSTransports.LoadOrStore(key, tr)
+	return actual.(*http.Transport)
+}
@@ -0,0 +1,147 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fabiolb/fabio/route"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestProxyGRPCWeb(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	hs := health.NewServer()
+	hs.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(srv, hs)
+	go srv.Serve(ln)
+	defer srv.Stop()
+
+	proxy := httptest.NewServer(&HTTPProxy{
+		Transport: http.DefaultTransport,
+		Lookup: func(r *http.Request) *route.Target {
+			tbl, _ := route.NewTable(bytes.NewBufferString("route add grpc / grpc://" + ln.Addr().String() + ` opts "proto=grpc"`))
+			return tbl.Lookup(r, route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+		},
+	})
+	defer proxy.Close()
+
+	msg, err := proto.Marshal(&healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reqBody := grpcFrame(0, msg)
+
+	tests := []struct {
+		desc        string
+		contentType string
+		path        string
+		status      string
+		serving     bool
+	}{
+		{"binary", "application/grpc-web+proto", "/grpc.health.v1.Health/Check", "0", true},
+		{"text", "application/grpc-web-text", "/grpc.health.v1.Health/Check", "0", true},
+		{"unimplemented", "application/grpc-web", "/grpc.health.v1.Health/Unknown", "12", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			body := reqBody
+			text := strings.HasPrefix(tt.contentType, "application/grpc-web-text")
+			if text {
+				body = []byte(base64.StdEncoding.EncodeToString(body))
+			}
+			req, _ := http.NewRequest("POST", proxy.URL+tt.path, bytes.NewReader(body))
+			req.Header.Set("Content-Type", tt.contentType)
+			req.Header.Set("X-Grpc-Web", "1")
+			resp, respBody := mustDo(req)
+
+			if got, want := resp.StatusCode, 200; got != want {
+				t.Fatalf("got status %d want %d", got, want)
+			}
+			if got, want := resp.Header.Get("Content-Type"), strings.TrimSuffix(tt.contentType, "+proto"); !strings.HasPrefix(got, want) {
+				t.Fatalf("got content type %q want %q", got, want)
+			}
+			if text {
+				if respBody, err = decodeBase64Chunks(respBody); err != nil {
+					t.Fatalf("got %v want nil", err)
+				}
+			}
+
+			var serving bool
+			status := resp.Header.Get("Grpc-Status")
+			for len(respBody) > 0 {
+				flag, data, rest := readGRPCFrame(t, respBody)
+				respBody = rest
+				if flag == grpcWebTrailerFlag {
+					for line := range strings.SplitSeq(strings.TrimSpace(string(data)), "\r\n") {
+						if v, ok := strings.CutPrefix(line, "grpc-status: "); ok {
+							status = v
+						}
+					}
+					continue
+				}
+				var m healthpb.HealthCheckResponse
+				if err := proto.Unmarshal(data, &m); err != nil {
+					t.Fatalf("got %v want nil", err)
+				}
+				serving = m.Status == healthpb.HealthCheckResponse_SERVING
+			}
+			if got, want := status, tt.status; got != want {
+				t.Fatalf("got grpc-status %q want %q", got, want)
+			}
+			if got, want := serving, tt.serving; got != want {
+				t.Fatalf("got serving %v want %v", got, want)
+			}
+			// the status of responses with messages is in the trailer frame
+			if tt.serving && resp.Header.Get("Grpc-Status") != "" {
+				t.Fatal("got grpc-status header want trailer frame")
+			}
+		})
+	}
+}
+
+func TestDecodeBase64Chunks(t *testing.T) {
+	in := base64.StdEncoding.EncodeToString([]byte("a")) + base64.StdEncoding.EncodeToString([]byte("bc")) + base64.StdEncoding.EncodeToString([]byte("def"))
+	out, err := decodeBase64Chunks([]byte(in))
+	if err != nil {
+		t.Fatalf("got %v want nil", err)
+	}
+	if got, want := string(out), "abcdef"; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+	if _, err := decodeBase64Chunks([]byte("not base64!")); err == nil {
+		t.Fatal("got nil want error")
+	}
+}
+
+func grpcFrame(flag byte, data []byte) []byte {
+	frame := make([]byte, 5, 5+len(data))
+	frame[0] = flag
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(data)))
+	return append(frame, data...)
+}
+
+func readGRPCFrame(t *testing.T, b []byte) (flag byte, data, rest []byte) {
+	t.Helper()
+	if len(b) < 5 {
+		t.Fatalf("short frame %q", b)
+	}
+	n := int(binary.BigEndian.Uint32(b[1:5]))
+	if len(b) < 5+n {
+		t.Fatalf("short frame %q", b)
+	}
+	return b[0], b[5 : 5+n], b[5+n:]
+}
@@ -0,0 +1,57 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/fabiolb/fabio/config"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// altSvcMaxAge is the number of seconds clients remember
+// the HTTP/3 endpoint advertised in the Alt-Svc header.
+const altSvcMaxAge = 86400
+
+// AltSvc returns the value of the Alt-Svc header which advertises the
+// HTTP/3 listeners to clients of the HTTPS listeners. It returns an
+// empty string if there are no HTTP/3 listeners.
+func AltSvc(listen []config.Listen) string {
+	var svcs []string
+	for _, l := range listen {
+		if l.Proto != "http3" {
+			continue
+		}
+		_, port, err := net.SplitHostPort(l.Addr)
+		if err != nil {
+			continue
+		}
+		svcs = append(svcs, fmt.Sprintf(`h3=":%s"; ma=%d`, port, altSvcMaxAge))
+	}
+	return strings.Join(svcs, ", ")
+}
+
+// http3Server serves HTTP/3 requests on a UDP connection.
+type http3Server struct {
+	srv  *http3.Server
+	conn net.PacketConn
+}
+
+// Serve serves requests on the UDP connection of the server. The
+// listener is ignored since HTTP/3 does not use a stream listener.
+func (s *http3Server) Serve(net.Listener) error {
+	return s.srv.Serve(s.conn)
+}
+
+func (s *http3Server) Close() error {
+	err := s.srv.Close()
+	s.conn.Close()
+	return err
+}
+
+func (s *http3Server) Shutdown(ctx context.Context) error {
+	err := s.srv.Shutdown(ctx)
+	s.conn.Close()
+	return err
+}
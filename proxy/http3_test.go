@@ -0,0 +1,129 @@
+package proxy
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/route"
+	"github.com/quic-go/quic-go/http3"
+)
+
+func TestAltSvc(t *testing.T) {
+	tests := []struct {
+		desc   string
+		listen []config.Listen
+		want   string
+	}{
+		{"no listeners", nil, ""},
+		{"no http3 listeners", []config.Listen{{Addr: ":443", Proto: "https"}}, ""},
+		{"http3 listener", []config.Listen{{Addr: ":443", Proto: "https"}, {Addr: ":443", Proto: "http3"}}, `h3=":443"; ma=86400`},
+		{"multiple http3 listeners", []config.Listen{{Addr: ":443", Proto: "http3"}, {Addr: "1.2.3.4:8443", Proto: "http3"}}, `h3=":443"; ma=86400, h3=":8443"; ma=86400`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			if got, want := AltSvc(tt.listen), tt.want; got != want {
+				t.Fatalf("got %q want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestProxyAltSvcHeader(t *testing.T) {
+	server := httptest.NewServer(okHandler)
+	defer server.Close()
+
+	h := &HTTPProxy{
+		Transport: http.DefaultTransport,
+		AltSvc:    `h3=":443"; ma=86400`,
+		Lookup: func(r *http.Request) *route.Target {
+			tbl, _ := route.NewTable(bytes.NewBufferString("route add mock / " + server.URL))
+			return tbl.Lookup(r, route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+		},
+	}
+
+	// the header is only sent on TLS connections
+	proxy := httptest.NewServer(h)
+	defer proxy.Close()
+	resp, _ := mustGet(proxy.URL)
+	if got, want := resp.Header.Get("Alt-Svc"), ""; got != want {
+		t.Fatalf("got Alt-Svc %q want %q", got, want)
+	}
+
+	tlsProxy := httptest.NewUnstartedServer(h)
+	tlsProxy.TLS = tlsServerConfig()
+	tlsProxy.StartTLS()
+	defer tlsProxy.Close()
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsClientConfig()}}
+	resp, err := client.Get(tlsProxy.URL)
+	if err != nil {
+		t.Fatalf("got %v want nil", err)
+	}
+	resp.Body.Close()
+	if got, want := resp.Header.Get("Alt-Svc"), `h3=":443"; ma=86400`; got != want {
+		t.Fatalf("got Alt-Svc %q want %q", got, want)
+	}
+}
+
+func TestListenAndServeHTTP3(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("X-Forwarded-Proto")))
+	}))
+	defer server.Close()
+
+	// find a free udp port
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := conn.LocalAddr().String()
+	conn.Close()
+
+	h := &HTTPProxy{
+		Transport: http.DefaultTransport,
+		Lookup: func(r *http.Request) *route.Target {
+			tbl, _ := route.NewTable(bytes.NewBufferString("route add mock / " + server.URL))
+			return tbl.Lookup(r, route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+		},
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- ListenAndServeHTTP3(config.Listen{Addr: addr, Proto: "http3"}, h, tlsServerConfig())
+	}()
+
+	tr := &http3.Transport{TLSClientConfig: tlsClientConfig()}
+	defer tr.Close()
+	client := &http.Client{Transport: tr, Timeout: 5 * time.Second}
+
+	// give the server some time to start up
+	var resp *http.Response
+	for range 50 {
+		if resp, err = client.Get("https://" + addr + "/"); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("got %v want nil", err)
+	}
+	var body bytes.Buffer
+	body.ReadFrom(resp.Body)
+	resp.Body.Close()
+
+	if got, want := resp.Proto, "HTTP/3.0"; got != want {
+		t.Fatalf("got proto %q want %q", got, want)
+	}
+	if got, want := body.String(), "https"; got != want {
+		t.Fatalf("got X-Forwarded-Proto %q want %q", got, want)
+	}
+
+	Close()
+	if err := <-done; err != nil {
+		t.Fatalf("got %v want nil", err)
+	}
+}
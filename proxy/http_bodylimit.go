@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/fabiolb/fabio/route"
+)
+
+// errResponseTooLarge is returned when the response body of the
+// upstream is larger than the maximum response size.
+var errResponseTooLarge = errors.New("response body too large")
+
+// bodyLimit returns the maximum body size for the limit of the route
+// and the global limit. It returns zero if there is no limit.
+func bodyLimit(routeLimit, globalLimit int64) int64 {
+	switch {
+	case routeLimit > 0:
+		return routeLimit
+	case routeLimit < 0:
+		return 0
+	default:
+		return globalLimit
+	}
+}
+
+// limitRequestBody rejects requests with a Content-Length larger than
+// the maximum request body size of the target. The bodies of chunked
+// requests fail once more than the maximum size has been read. It
+// returns the status code for the client if the request is rejected.
+func (p *HTTPProxy) limitRequestBody(w http.ResponseWriter, r *http.Request, t *route.Target) (int, string) {
+	max := bodyLimit(t.MaxBody, p.Config.MaxBody)
+	if max <= 0 || r.Body == nil || r.Body == http.NoBody {
+		return 0, ""
+	}
+	if r.ContentLength > max {
+		return http.StatusRequestEntityTooLarge, "request body too large"
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, max)
+	return 0, ""
+}
+
+// responseLimitTransport fails responses with a body which is larger
+// than max bytes. Responses with a larger Content-Length are rejected
+// before they are sent to the client. Other responses are cut off
+// once the limit is exceeded.
+type responseLimitTransport struct {
+	http.RoundTripper
+	max int64
+}
+
+func (t *responseLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.RoundTripper.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.ContentLength > t.max {
+		resp.Body.Close()
+		return nil, errResponseTooLarge
+	}
+	resp.Body = &maxBytesBody{ReadCloser: resp.Body, n: t.max}
+	return resp, nil
+}
+
+// maxBytesBody returns errResponseTooLarge after n bytes.
+type maxBytesBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *maxBytesBody) Read(p []byte) (int, error) {
+	if int64(len(p)) > b.n+1 {
+		p = p[:b.n+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if int64(n) > b.n {
+		n = int(b.n)
+		b.n = 0
+		return n, errResponseTooLarge
+	}
+	b.n -= int64(n)
+	return n, err
+}
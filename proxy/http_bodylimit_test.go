@@ -0,0 +1,128 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/route"
+)
+
+func TestProxyMaxBody(t *testing.T) {
+	var received string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// truncated bodies of aborted requests fail to read
+		body, err := io.ReadAll(r.Body)
+		if err == nil {
+			received = string(body)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		desc    string
+		opts    string
+		body    string
+		chunked bool
+		status  int
+		want    string
+	}{
+		{"within global limit", "", "0123456789", false, 200, "0123456789"},
+		{"content length too large", "", "0123456789x", false, 413, ""},
+		{"chunked body too large", "", "0123456789x", true, 413, ""},
+		{"chunked body within limit", "", "0123", true, 200, "0123"},
+		{"route limit", "maxbody=4", "01234", false, 413, ""},
+		{"route limit disabled", "maxbody=0", "0123456789x", true, 200, "0123456789x"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			received = ""
+			proxy := httptest.NewServer(&HTTPProxy{
+				Config:    config.Proxy{MaxBody: 10},
+				Transport: http.DefaultTransport,
+				Lookup: func(r *http.Request) *route.Target {
+					tbl, _ := route.NewTable(bytes.NewBufferString("route add mock / " + server.URL + ` opts "` + tt.opts + `"`))
+					return tbl.Lookup(r, route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+				},
+			})
+			defer proxy.Close()
+
+			var body io.Reader = strings.NewReader(tt.body)
+			if tt.chunked {
+				// hide the length so that the body is sent chunked
+				body = io.MultiReader(body)
+			}
+			req, _ := http.NewRequest("POST", proxy.URL, body)
+			resp, _ := mustDo(req)
+			if got, want := resp.StatusCode, tt.status; got != want {
+				t.Fatalf("got status %d want %d", got, want)
+			}
+			if got, want := received, tt.want; got != want {
+				t.Fatalf("got body %q want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestProxyMaxResponseBody(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := strings.Repeat("x", 100)
+		if r.URL.Path == "/chunked" {
+			w.Write([]byte(body[:50]))
+			w.(http.Flusher).Flush()
+			w.Write([]byte(body[50:]))
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	proxy := httptest.NewServer(&HTTPProxy{
+		Config:    config.Proxy{MaxResponseBody: 80},
+		Transport: http.DefaultTransport,
+		Lookup: func(r *http.Request) *route.Target {
+			routes := "route add mock / " + server.URL + "\n"
+			routes += "route add mock /unlimited " + server.URL + ` opts "maxresponsebody=0"`
+			tbl, _ := route.NewTable(bytes.NewBufferString(routes))
+			return tbl.Lookup(r, route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+		},
+	})
+	defer proxy.Close()
+
+	t.Run("content length too large", func(t *testing.T) {
+		resp, _ := mustGet(proxy.URL + "/")
+		if got, want := resp.StatusCode, http.StatusBadGateway; got != want {
+			t.Fatalf("got status %d want %d", got, want)
+		}
+	})
+
+	t.Run("chunked body too large", func(t *testing.T) {
+		resp, err := http.Get(proxy.URL + "/chunked")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err == nil {
+			t.Fatalf("got body %q want error", body)
+		}
+		if len(body) > 80 {
+			t.Fatalf("got %d bytes want at most 80", len(body))
+		}
+	})
+
+	t.Run("route limit disabled", func(t *testing.T) {
+		resp, body := mustGet(proxy.URL + "/unlimited")
+		if got, want := resp.StatusCode, http.StatusOK; got != want {
+			t.Fatalf("got status %d want %d", got, want)
+		}
+		if got, want := len(body), 100; got != want {
+			t.Fatalf("got %d bytes want %d", got, want)
+		}
+	})
+}
@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/fabiolb/fabio/proxy/compress"
+	"github.com/fabiolb/fabio/route"
+)
+
+// decompressRequest replaces a compressed request body with the
+// decompressed body so that upstreams which do not support compressed
+// requests can handle it. The decompressed body is buffered and must not
+// be larger than proxy.decompress.maxsize to guard against zip bombs.
+// It returns the status code for the client if the body cannot be
+// decompressed.
+func (p *HTTPProxy) decompressRequest(r *http.Request) (int, string) {
+	encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+	if !compress.Supported(encoding) {
+		return 0, ""
+	}
+
+	max := p.Config.Decompress.MaxSize
+	zr, err := compress.NewReader(encoding, r.Body)
+	if err != nil {
+		return http.StatusBadRequest, "invalid request body"
+	}
+	defer zr.Close()
+	body, err := io.ReadAll(io.LimitReader(zr, max+1))
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return http.StatusRequestEntityTooLarge, "request body too large"
+	}
+	if err != nil {
+		return http.StatusBadRequest, "invalid request body"
+	}
+	if int64(len(body)) > max {
+		return http.StatusRequestEntityTooLarge, "request body too large"
+	}
+
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	r.Header.Del("Content-Encoding")
+	r.Header.Del("Content-Length")
+	return 0, ""
+}
+
+// decompressTransport requests compressed responses from the upstream
+// and decompresses the responses with an encoding the client does not
+// accept.
+type decompressTransport struct {
+	http.RoundTripper
+
+	// encodings is the value of the Accept-Encoding header for the
+	// upstream request.
+	encodings string
+
+	// accept are the Accept-Encoding header values of the client.
+	accept []string
+}
+
+// newDecompressTransport returns a transport which requests the content
+// encodings of the target from the upstream.
+func newDecompressTransport(t *route.Target, r *http.Request, tr http.RoundTripper) *decompressTransport {
+	return &decompressTransport{
+		RoundTripper: tr,
+		encodings:    strings.Join(t.UpstreamEncodings, ", "),
+		accept:       r.Header.Values("Accept-Encoding"),
+	}
+}
+
+func (t *decompressTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// partial responses cannot be decompressed
+	if req.Header.Get("Range") != "" {
+		return t.RoundTripper.RoundTrip(req)
+	}
+
+	req = req.Clone(req.Context())
+	req.Header.Set("Accept-Encoding", t.encodings)
+	resp, err := t.RoundTripper.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	if encoding == "" || !compress.Supported(encoding) || compress.Accepts(t.accept, encoding) {
+		return resp, nil
+	}
+
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+
+	// responses without a body
+	if req.Method == http.MethodHead || resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified {
+		return resp, nil
+	}
+
+	zr, err := compress.NewReader(encoding, resp.Body)
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	resp.Body = &decompressBody{ReadCloser: zr, body: resp.Body}
+	return resp, nil
+}
+
+// decompressBody closes the decompressing reader and the response body.
+type decompressBody struct {
+	io.ReadCloser
+	body io.ReadCloser
+}
+
+func (b *decompressBody) Close() error {
+	b.ReadCloser.Close()
+	return b.body.Close()
+}
@@ -0,0 +1,128 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/route"
+)
+
+func TestProxyDecompressRequest(t *testing.T) {
+	type received struct {
+		body, encoding string
+		length         int64
+	}
+	var got received
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got = received{string(body), r.Header.Get("Content-Encoding"), r.ContentLength}
+	}))
+	defer server.Close()
+
+	body := strings.Repeat("hello ", 100)
+	tests := []struct {
+		desc     string
+		opts     string
+		encoding string
+		body     []byte
+		status   int
+		want     received
+	}{
+		{"plain body", "decompress.req=true", "", []byte("hello"), 200, received{"hello", "", 5}},
+		{"gzip body", "decompress.req=true", "gzip", gzipCompress([]byte(body)), 200, received{body, "", int64(len(body))}},
+		{"gzip body without option", "", "gzip", gzipCompress([]byte(body)), 200, received{string(gzipCompress([]byte(body))), "gzip", int64(len(gzipCompress([]byte(body))))}},
+		{"unsupported encoding", "decompress.req=true", "deflate", []byte("xxx"), 200, received{"xxx", "deflate", 3}},
+		{"invalid body", "decompress.req=true", "gzip", []byte("not gzip"), 400, received{}},
+		{"body too large", "decompress.req=true", "gzip", gzipCompress([]byte(body + "!")), 413, received{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			got = received{}
+			proxy := httptest.NewServer(&HTTPProxy{
+				Config:    config.Proxy{Decompress: config.Decompress{MaxSize: int64(len(body))}},
+				Transport: http.DefaultTransport,
+				Lookup: func(r *http.Request) *route.Target {
+					tbl, _ := route.NewTable(bytes.NewBufferString("route add mock / " + server.URL + ` opts "` + tt.opts + `"`))
+					return tbl.Lookup(r, route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+				},
+			})
+			defer proxy.Close()
+
+			req, _ := http.NewRequest("POST", proxy.URL, bytes.NewReader(tt.body))
+			if tt.encoding != "" {
+				req.Header.Set("Content-Encoding", tt.encoding)
+			}
+			resp, _ := mustDo(req)
+			if resp.StatusCode != tt.status {
+				t.Fatalf("got status %d want %d", resp.StatusCode, tt.status)
+			}
+			if got != tt.want {
+				t.Fatalf("got %+v want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProxyUpstreamCompression(t *testing.T) {
+	body := strings.Repeat("hello ", 100)
+	var upstreamAccept string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamAccept = r.Header.Get("Accept-Encoding")
+		w.Header().Set("Content-Type", "text/plain")
+		if strings.Contains(upstreamAccept, "gzip") {
+			b := gzipCompress([]byte(body))
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+			w.Write(b)
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		desc            string
+		opts            string
+		acceptEncoding  string
+		upstreamAccept  string
+		contentEncoding string
+	}{
+		{"client accepts gzip", "compress.upstream=gzip", "gzip, br", "gzip", "gzip"},
+		{"client accepts identity", "compress.upstream=gzip", "identity", "gzip", ""},
+		{"client rejects gzip", "compress.upstream=br,gzip", "*, gzip;q=0", "br, gzip", ""},
+		{"without option", "", "identity", "identity", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			proxy := httptest.NewServer(&HTTPProxy{
+				Transport: http.DefaultTransport,
+				Lookup: func(r *http.Request) *route.Target {
+					tbl, _ := route.NewTable(bytes.NewBufferString("route add mock / " + server.URL + ` opts "` + tt.opts + `"`))
+					return tbl.Lookup(r, route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+				},
+			})
+			defer proxy.Close()
+
+			req, _ := http.NewRequest("GET", proxy.URL, nil)
+			req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			resp, got := mustDo(req)
+			if upstreamAccept != tt.upstreamAccept {
+				t.Fatalf("got upstream Accept-Encoding %q want %q", upstreamAccept, tt.upstreamAccept)
+			}
+			if enc := resp.Header.Get("Content-Encoding"); enc != tt.contentEncoding {
+				t.Fatalf("got Content-Encoding %q want %q", enc, tt.contentEncoding)
+			}
+			if tt.contentEncoding == "" && string(got) != body {
+				t.Fatalf("got body %q want %q", got, body)
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package proxy
 
 import (
 	"context"
+	"errors"
 	"io"
 	"log"
 	"net"
@@ -41,9 +42,22 @@ func httpProxyErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
 	// If a "context canceled" error is returned by the http.Request handler this means the client closed the connection before getting a response
 	// So we are changing the StatusCode on these situations to the non-standard 499 (Client Closed Request)
 
+	// tell the client which upstream timeout fired
+	var timeoutErr *upstreamTimeoutError
+	if errors.As(err, &timeoutErr) {
+		log.Print("[ERROR] ", err)
+		http.Error(w, timeoutErr.msg, http.StatusGatewayTimeout)
+		return
+	}
+
 	statusCode := http.StatusInternalServerError
 
-	if e, ok := err.(net.Error); ok {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		statusCode = http.StatusRequestEntityTooLarge
+	} else if errors.Is(err, errResponseTooLarge) {
+		statusCode = http.StatusBadGateway
+	} else if e, ok := err.(net.Error); ok {
 		if e.Timeout() {
 			statusCode = http.StatusGatewayTimeout
 		} else {
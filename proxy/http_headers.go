@@ -6,9 +6,11 @@ import (
 	"net"
 	"net/http"
 	"net/textproto"
+	"os"
 	"strings"
 
 	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/route"
 )
 
 // addResponseHeaders adds/updates headers in the response
@@ -161,6 +163,61 @@ func addHeaders(r *http.Request, hdrs map[string]bool, cfg config.Proxy, stripPa
 	return nil
 }
 
+// applyHeaderRules modifies the headers according to the rules of a
+// target. The header values can contain the variables $client_ip,
+// $request_id, $host, $path and $scheme which are replaced by vars.
+// Other variables are not replaced.
+func applyHeaderRules(h http.Header, rules *route.HeaderRules, vars func(string) (string, bool)) {
+	expand := func(s string) string {
+		if !strings.Contains(s, "$") {
+			return s
+		}
+		return os.Expand(s, func(name string) string {
+			if v, ok := vars(name); ok {
+				return v
+			}
+			return "$" + name
+		})
+	}
+
+	for _, name := range rules.Del {
+		h.Del(name)
+	}
+	for _, hv := range rules.Set {
+		h.Set(hv.Name, expand(hv.Value))
+	}
+	for _, hv := range rules.Add {
+		h.Add(hv.Name, expand(hv.Value))
+	}
+}
+
+// headerVars returns the values of the variables for the header rules.
+// host is the Host header of the client request.
+func (p *HTTPProxy) headerVars(r *http.Request, host string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		switch name {
+		case "client_ip":
+			ip, _, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil {
+				return r.RemoteAddr, true
+			}
+			return ip, true
+		case "request_id":
+			if p.Config.RequestID == "" {
+				return "", true
+			}
+			return r.Header.Get(p.Config.RequestID), true
+		case "host":
+			return host, true
+		case "path":
+			return r.URL.Path, true
+		case "scheme":
+			return scheme(r), true
+		}
+		return "", false
+	}
+}
+
 var tlsver = map[uint16]string{
 	tls.VersionTLS10: "tls10",
 	tls.VersionTLS11: "tls11",
@@ -5,9 +5,11 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"testing"
 
 	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/route"
 	"github.com/pascaldekloe/goe/verify"
 )
 
@@ -469,6 +471,70 @@ func TestAddResponseHeaders(t *testing.T) {
 	}
 }
 
+func TestApplyHeaderRules(t *testing.T) {
+	r := &http.Request{
+		RemoteAddr: "1.2.3.4:5555",
+		Host:       "example.com",
+		URL:        &url.URL{Path: "/foo"},
+		Header:     http.Header{"X-Request-Id": []string{"abc"}},
+	}
+	p := &HTTPProxy{Config: config.Proxy{RequestID: "X-Request-Id"}}
+
+	tests := []struct {
+		desc  string
+		rules *route.HeaderRules
+		hdrs  http.Header
+		want  http.Header
+	}{
+		{"del",
+			&route.HeaderRules{Del: []string{"Server"}},
+			http.Header{"Server": []string{"nginx"}, "X-Foo": []string{"bar"}},
+			http.Header{"X-Foo": []string{"bar"}},
+		},
+		{"set",
+			&route.HeaderRules{Set: []route.HeaderValue{{Name: "X-Tenant", Value: "acme"}}},
+			http.Header{"X-Tenant": []string{"a", "b"}},
+			http.Header{"X-Tenant": []string{"acme"}},
+		},
+		{"add",
+			&route.HeaderRules{Add: []route.HeaderValue{{Name: "X-Tenant", Value: "acme"}}},
+			http.Header{"X-Tenant": []string{"a"}},
+			http.Header{"X-Tenant": []string{"a", "acme"}},
+		},
+		{"del before set before add",
+			&route.HeaderRules{
+				Del: []string{"X-Foo"},
+				Set: []route.HeaderValue{{Name: "X-Foo", Value: "a"}},
+				Add: []route.HeaderValue{{Name: "X-Foo", Value: "b"}},
+			},
+			http.Header{"X-Foo": []string{"x"}},
+			http.Header{"X-Foo": []string{"a", "b"}},
+		},
+		{"variables",
+			&route.HeaderRules{Set: []route.HeaderValue{
+				{Name: "X-Client", Value: "$client_ip"},
+				{Name: "X-Id", Value: "id=${request_id}"},
+				{Name: "X-Origin", Value: "$scheme://$host$path"},
+				{Name: "X-Unknown", Value: "$foo"},
+			}},
+			http.Header{},
+			http.Header{
+				"X-Client":  []string{"1.2.3.4"},
+				"X-Id":      []string{"id=abc"},
+				"X-Origin":  []string{"http://example.com/foo"},
+				"X-Unknown": []string{"$foo"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			applyHeaderRules(tt.hdrs, tt.rules, p.headerVars(r, r.Host))
+			verify.Values(t, "", tt.hdrs, tt.want)
+		})
+	}
+}
+
 func TestLocalPort(t *testing.T) {
 	tests := []struct {
 		r    *http.Request
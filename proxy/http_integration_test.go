@@ -22,6 +22,7 @@ import (
 	"github.com/fabiolb/fabio/config"
 	"github.com/fabiolb/fabio/logger"
 	"github.com/fabiolb/fabio/noroute"
+	"github.com/fabiolb/fabio/proxy/cache"
 	"github.com/fabiolb/fabio/proxy/internal"
 	"github.com/fabiolb/fabio/route"
 	"github.com/pascaldekloe/goe/verify"
@@ -127,6 +128,44 @@ func TestProxyRequestIDHeader(t *testing.T) {
 	}
 }
 
+func TestProxyHeaderRules(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "upstream")
+		w.Header().Set("X-Tenant", r.Header.Get("X-Tenant"))
+		w.Header().Set("X-Client", r.Header.Get("X-Client"))
+		w.Header().Set("X-Secret", r.Header.Get("X-Secret"))
+		w.Write([]byte("OK"))
+	}))
+	defer server.Close()
+
+	proxy := httptest.NewServer(&HTTPProxy{
+		Transport: http.DefaultTransport,
+		Lookup: func(r *http.Request) *route.Target {
+			tbl, _ := route.NewTable(bytes.NewBufferString("route add mock / " + server.URL + ` opts "hdr.req.set=X-Tenant:acme,X-Client:$client_ip hdr.req.del=X-Secret hdr.resp.del=Server hdr.resp.add=X-Proxy:fabio"`))
+			return tbl.Lookup(r, route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+		},
+	})
+	defer proxy.Close()
+
+	req, _ := http.NewRequest("GET", proxy.URL, nil)
+	req.Header.Set("X-Tenant", "other")
+	req.Header.Set("X-Secret", "secret")
+	resp, _ := mustDo(req)
+
+	want := map[string]string{
+		"Server":   "",
+		"X-Tenant": "acme",
+		"X-Client": "127.0.0.1",
+		"X-Secret": "",
+		"X-Proxy":  "fabio",
+	}
+	for name, v := range want {
+		if got := resp.Header.Get(name); got != v {
+			t.Errorf("got %s %q want %q", name, got, v)
+		}
+	}
+}
+
 func TestProxySTSHeader(t *testing.T) {
 	server := httptest.NewServer(okHandler)
 	defer server.Close()
@@ -192,6 +231,131 @@ func TestProxyChecksHeaderForAccessRules(t *testing.T) {
 	}
 }
 
+func TestProxyRateLimit(t *testing.T) {
+	server := httptest.NewServer(okHandler)
+	defer server.Close()
+
+	proxy := httptest.NewServer(&HTTPProxy{
+		Transport: http.DefaultTransport,
+		Lookup: func(r *http.Request) *route.Target {
+			tbl, _ := route.NewTable(bytes.NewBufferString("route add mock /ratelimit " + server.URL + ` opts "ratelimit=1/m burst=2 ratelimit.key=ip"`))
+			return tbl.Lookup(r, route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+		},
+	})
+	defer proxy.Close()
+
+	for i := range 2 {
+		resp, _ := mustGet(proxy.URL + "/ratelimit")
+		if got, want := resp.StatusCode, http.StatusOK; got != want {
+			t.Fatalf("request %d: got status %d want %d", i, got, want)
+		}
+	}
+
+	resp, _ := mustGet(proxy.URL + "/ratelimit")
+	if got, want := resp.StatusCode, http.StatusTooManyRequests; got != want {
+		t.Fatalf("got status %d want %d", got, want)
+	}
+	if got, want := resp.Header.Get("Retry-After"), "60"; got != want {
+		t.Fatalf("got Retry-After %q want %q", got, want)
+	}
+}
+
+func TestProxyCache(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write(plainContent)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		desc           string
+		opts           string
+		acceptEncoding string
+		requests       int
+	}{
+		{"cache disabled", "", "", 2},
+		{"cache enabled", "cache=true cache_ttl=1m", "", 1},
+		{"cache enabled without ttl", "cache=true", "", 2},
+		{"cache with compression", "cache=true cache_ttl=1m compress=gzip", "gzip", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			requests = 0
+			proxy := httptest.NewServer(&HTTPProxy{
+				Transport: http.DefaultTransport,
+				Cache:     cache.New(1<<20, 1<<10),
+				Lookup: func(r *http.Request) *route.Target {
+					tbl, _ := route.NewTable(bytes.NewBufferString("route add mock / " + server.URL + ` opts "` + tt.opts + `"`))
+					return tbl.Lookup(r, route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+				},
+			})
+			defer proxy.Close()
+
+			for i := range 2 {
+				req, _ := http.NewRequest("GET", proxy.URL+"/foo", nil)
+				if tt.acceptEncoding != "" {
+					req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+				}
+				resp, body := mustDo(req)
+				if resp.Header.Get("Content-Encoding") == "gzip" {
+					zr, err := gzip.NewReader(bytes.NewReader(body))
+					if err != nil {
+						t.Fatalf("request %d: got %v want nil", i, err)
+					}
+					body, _ = io.ReadAll(zr)
+				}
+				if !bytes.Equal(body, plainContent) {
+					t.Fatalf("request %d: got body %q want %q", i, body, plainContent)
+				}
+			}
+			if got, want := requests, tt.requests; got != want {
+				t.Fatalf("got %d upstream requests want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestProxyH2CUpstream(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	}))
+	server.Config.Protocols = new(http.Protocols)
+	server.Config.Protocols.SetHTTP1(true)
+	server.Config.Protocols.SetUnencryptedHTTP2(true)
+	server.Start()
+	defer server.Close()
+
+	tests := []struct {
+		desc  string
+		opts  string
+		proto string
+	}{
+		{"http/1.1", "", "HTTP/1.1"},
+		{"h2c", "proto=h2c", "HTTP/2.0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			proxy := httptest.NewServer(&HTTPProxy{
+				Transport: http.DefaultTransport,
+				Lookup: func(r *http.Request) *route.Target {
+					tbl, _ := route.NewTable(bytes.NewBufferString("route add mock / " + server.URL + ` opts "` + tt.opts + `"`))
+					return tbl.Lookup(r, route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+				},
+			})
+			defer proxy.Close()
+
+			_, body := mustGet(proxy.URL)
+			if got, want := string(body), tt.proto; got != want {
+				t.Fatalf("got upstream proto %q want %q", got, want)
+			}
+		})
+	}
+}
+
 func TestProxyNoRouteHTML(t *testing.T) {
 	want := "<html>503</html>"
 	noroute.SetHTML(want)
@@ -252,6 +416,35 @@ func TestProxyStripsPath(t *testing.T) {
 	}
 }
 
+func TestProxyRewritesPath(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.RequestURI {
+		case "/api/v2/users?x=1":
+			w.Write([]byte("OK"))
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+
+	proxy := httptest.NewServer(&HTTPProxy{
+		ProtectHeaders: testProtectHeaders,
+		Transport:      http.DefaultTransport,
+		Lookup: func(r *http.Request) *route.Target {
+			tbl, _ := route.NewTable(bytes.NewBufferString("route add mock /api/v1/(.*) " + server.URL + ` opts "rewrite=/api/v2/$1"`))
+			return tbl.Lookup(r, route.Picker["rr"], route.Matcher["regex"], globCache, globEnabled)
+		},
+	})
+	defer proxy.Close()
+
+	resp, body := mustGet(proxy.URL + "/api/v1/users?x=1")
+	if got, want := resp.StatusCode, http.StatusOK; got != want {
+		t.Fatalf("got status %d want %d", got, want)
+	}
+	if got, want := string(body), "OK"; got != want {
+		t.Fatalf("got body %q want %q", got, want)
+	}
+}
+
 func TestProxyPrependsPath(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		switch r.RequestURI {
@@ -539,6 +732,7 @@ func testProxyLogOutput(t *testing.T, bodySize int, cfg config.Proxy) {
 		"upstream_request_uri:/foo?x=y",
 		"upstream_request_url:" + upstreamURL.String() + "/foo?x=y",
 		"upstream_service:svc-a",
+		"ws_close_code:",
 	}
 
 	data := b.String()
@@ -731,8 +925,54 @@ func TestProxyGzipHandler(t *testing.T) {
 	}
 }
 
+func TestProxyCompressRoute(t *testing.T) {
+	server := httptest.NewServer(plainHandler("text/plain"))
+	defer server.Close()
+
+	tests := []struct {
+		desc            string
+		opts            string
+		gzipContentType bool
+		acceptEncoding  string
+		contentEncoding string
+	}{
+		{"no option", "", false, "gzip, br, zstd", ""},
+		{"no option with content types", "", true, "gzip, br, zstd", "zstd"},
+		{"on", "compress=on", false, "gzip, br", "br"},
+		{"off", "compress=off", true, "gzip, br, zstd", ""},
+		{"encodings", "compress=gzip,br", false, "gzip, br, zstd", "gzip"},
+		{"encodings with q-values", "compress=gzip,br", false, "gzip;q=0.5, br", "br"},
+		{"not accepted", "compress=gzip", false, "br", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			cfg := config.Proxy{Compress: config.Compress{Encodings: []string{"zstd", "br", "gzip"}}}
+			if tt.gzipContentType {
+				cfg.GZIPContentTypes = regexp.MustCompile("^text/plain(;.*)?$")
+			}
+			proxy := httptest.NewServer(&HTTPProxy{
+				Config:    cfg,
+				Transport: http.DefaultTransport,
+				Lookup: func(r *http.Request) *route.Target {
+					tbl, _ := route.NewTable(bytes.NewBufferString("route add mock / " + server.URL + ` opts "` + tt.opts + `"`))
+					return tbl.Lookup(r, route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+				},
+			})
+			defer proxy.Close()
+
+			req, _ := http.NewRequest("GET", proxy.URL, nil)
+			req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			resp, _ := mustDo(req)
+			if got, want := resp.Header.Get("Content-Encoding"), tt.contentEncoding; got != want {
+				t.Errorf("got content-encoding %q want %q", got, want)
+			}
+		})
+	}
+}
+
 var plainContent = []byte("Hello World")
-var gzipContent = compress(plainContent)
+var gzipContent = gzipCompress(plainContent)
 
 func plainHandler(contentType string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -813,8 +1053,8 @@ func mustGet(urlstr string) (*http.Response, []byte) {
 	return mustDo(req)
 }
 
-// compress returns the gzip compressed content of b.
-func compress(b []byte) []byte {
+// gzipCompress returns the gzip compressed content of b.
+func gzipCompress(b []byte) []byte {
 	var buf bytes.Buffer
 	w := gzip.NewWriter(&buf)
 	if _, err := w.Write(b); err != nil {
@@ -0,0 +1,175 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/fabiolb/fabio/route"
+)
+
+// maxMirrorBody is the maximum size of a request body which is buffered
+// for mirroring. Requests with larger bodies are not mirrored.
+const maxMirrorBody = 1 << 20
+
+// maxMirrorRequests is the maximum number of concurrent mirror requests.
+// Requests are not mirrored while the limit is reached.
+const maxMirrorRequests = 100
+
+// mirrorTimeout is the timeout for a mirror request.
+const mirrorTimeout = 30 * time.Second
+
+// mirrorSem limits the number of concurrent mirror requests.
+var mirrorSem = make(chan struct{}, maxMirrorRequests)
+
+// mirror sends a copy of the request to a target of the mirror service
+// of the target in the background and discards the response. host is
+// the original Host header of the request.
+//
+// The request body is copied while the primary request reads it and
+// the copy is sent once the body has been read completely. The returned
+// function must be called after the primary request has completed to
+// drop the mirror request if the body was not read completely.
+func (p *HTTPProxy) mirror(t *route.Target, r *http.Request, host string) (done func()) {
+	done = func() {}
+	if t.MirrorPct < 100 && rand.Float64()*100 >= t.MirrorPct {
+		return
+	}
+
+	lookup := p.MirrorTarget
+	if lookup == nil {
+		lookup = route.MirrorTarget
+	}
+	mt := lookup(t.Mirror)
+	if mt == nil {
+		p.countMirror(t.Mirror, "failure")
+		return
+	}
+
+	select {
+	case mirrorSem <- struct{}{}:
+	default:
+		p.countMirror(t.Mirror, "dropped")
+		return
+	}
+
+	// the request must not be used after the handler has returned
+	// so the copy is made before the request is proxied.
+	ctx, cancel := context.WithTimeout(context.Background(), mirrorTimeout)
+	req := r.Clone(ctx)
+	req.RequestURI = ""
+	req.URL = upstreamURL(mt, r.URL)
+	req.Host = upstreamHost(mt, req.URL, host)
+	req.Body = http.NoBody
+	tr := p.transport(mt)
+
+	send := func(body []byte, ok bool) {
+		if !ok {
+			<-mirrorSem
+			cancel()
+			p.countMirror(t.Mirror, "dropped")
+			return
+		}
+		if len(body) > 0 {
+			req.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		go p.sendMirror(mt, tr, req, cancel)
+	}
+
+	if r.Body == nil || r.Body == http.NoBody {
+		send(nil, true)
+		return
+	}
+	b := &mirrorBody{ReadCloser: r.Body, size: r.ContentLength, send: send}
+	r.Body = b
+	return func() { b.finish(false) }
+}
+
+// sendMirror sends the mirror request and releases the mirror slot.
+func (p *HTTPProxy) sendMirror(mt *route.Target, tr http.RoundTripper, req *http.Request, cancel context.CancelFunc) {
+	defer func() { <-mirrorSem }()
+	defer cancel()
+
+	mt.Begin()
+	start := time.Now()
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		mt.End(0)
+		log.Printf("[WARN] mirror: request to %s failed. %s", req.URL, err)
+		p.countMirror(mt.Service, "failure")
+		return
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	mt.End(time.Since(start))
+
+	if resp.StatusCode >= 500 {
+		p.countMirror(mt.Service, "failure")
+		return
+	}
+	p.countMirror(mt.Service, "success")
+}
+
+// mirrorBody copies the request body into a buffer of up to
+// maxMirrorBody bytes while the primary request reads it. It hands the
+// copy to send once the body has been read completely or drops the
+// mirror request when the body is larger or is closed early.
+type mirrorBody struct {
+	io.ReadCloser
+	size int64
+	send func(body []byte, ok bool)
+
+	mu   sync.Mutex
+	buf  bytes.Buffer
+	over bool
+	done bool
+}
+
+func (b *mirrorBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.mu.Lock()
+	if !b.done && !b.over {
+		if b.buf.Len()+n > maxMirrorBody {
+			b.over = true
+			b.buf = bytes.Buffer{}
+		} else {
+			b.buf.Write(p[:n])
+		}
+	}
+	b.mu.Unlock()
+	if err == io.EOF {
+		b.finish(true)
+	}
+	return n, err
+}
+
+func (b *mirrorBody) Close() error {
+	b.finish(false)
+	return b.ReadCloser.Close()
+}
+
+// finish hands the copy of the body to send once.
+func (b *mirrorBody) finish(eof bool) {
+	b.mu.Lock()
+	if b.done {
+		b.mu.Unlock()
+		return
+	}
+	b.done = true
+	complete := eof || b.size > 0 && int64(b.buf.Len()) == b.size
+	body, ok := b.buf.Bytes(), complete && !b.over
+	b.mu.Unlock()
+	b.send(body, ok)
+}
+
+// countMirror updates the mirror metric for the service.
+func (p *HTTPProxy) countMirror(service, result string) {
+	if p.Stats.Mirror != nil {
+		p.Stats.Mirror.With("service", service, "result", result).Add(1)
+	}
+}
@@ -0,0 +1,195 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	gkm "github.com/go-kit/kit/metrics"
+
+	"github.com/fabiolb/fabio/route"
+)
+
+// resultCounter sends the result label of every update to a channel.
+type resultCounter struct {
+	labels  []string
+	results chan string
+}
+
+func (c *resultCounter) With(labels ...string) gkm.Counter {
+	return &resultCounter{labels: labels, results: c.results}
+}
+
+func (c *resultCounter) Add(float64) {
+	for i := 0; i+1 < len(c.labels); i += 2 {
+		if c.labels[i] == "result" {
+			c.results <- c.labels[i+1]
+		}
+	}
+}
+
+func TestProxyMirror(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write([]byte("OK" + string(body)))
+	}))
+	defer server.Close()
+
+	type mirrored struct {
+		method, uri, body string
+	}
+	requests := make(chan mirrored, 10)
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- mirrored{r.Method, r.RequestURI, string(body)}
+		time.Sleep(500 * time.Millisecond)
+		w.Write([]byte("mirror"))
+	}))
+	defer mirror.Close()
+
+	counter := &resultCounter{results: make(chan string, 10)}
+	proxy := httptest.NewServer(&HTTPProxy{
+		Transport: http.DefaultTransport,
+		Stats:     HttpStatsHandler{Mirror: counter},
+		Lookup: func(r *http.Request) *route.Target {
+			tbl, _ := route.NewTable(bytes.NewBufferString("route add svc / " + server.URL + ` opts "mirror=svc-v2"`))
+			return tbl.Lookup(r, route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+		},
+		MirrorTarget: func(service string) *route.Target {
+			if service != "svc-v2" {
+				return nil
+			}
+			return &route.Target{Service: service, URL: mustParse(mirror.URL)}
+		},
+	})
+	defer proxy.Close()
+
+	req, err := http.NewRequest("POST", proxy.URL+"/foo?x=1", strings.NewReader("body"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	resp, body := mustDo(req)
+	if got, want := resp.StatusCode, http.StatusOK; got != want {
+		t.Fatalf("got status %d want %d", got, want)
+	}
+	if got, want := string(body), "OKbody"; got != want {
+		t.Fatalf("got body %q want %q", got, want)
+	}
+	if d := time.Since(start); d >= 500*time.Millisecond {
+		t.Fatalf("response was delayed by the mirror for %s", d)
+	}
+
+	select {
+	case got := <-requests:
+		want := mirrored{"POST", "/foo?x=1", "body"}
+		if got != want {
+			t.Fatalf("got mirrored request %v want %v", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("request was not mirrored")
+	}
+
+	select {
+	case got := <-counter.results:
+		if want := "success"; got != want {
+			t.Fatalf("got result %q want %q", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("mirror result was not counted")
+	}
+}
+
+func TestProxyMirrorPct(t *testing.T) {
+	var mirrored int
+	proxy := &HTTPProxy{
+		MirrorTarget: func(service string) *route.Target {
+			mirrored++
+			return nil
+		},
+	}
+	r := httptest.NewRequest("GET", "/", nil)
+	for range 10 {
+		proxy.mirror(&route.Target{Mirror: "svc-v2", MirrorPct: 0}, r, "")
+	}
+	if mirrored != 0 {
+		t.Fatalf("got %d mirrored requests want 0", mirrored)
+	}
+	for range 10 {
+		proxy.mirror(&route.Target{Mirror: "svc-v2", MirrorPct: 100}, r, "")
+	}
+	if mirrored != 10 {
+		t.Fatalf("got %d mirrored requests want 10", mirrored)
+	}
+}
+
+func TestProxyMirrorStreamsBody(t *testing.T) {
+	started := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	}))
+	defer server.Close()
+
+	bodies := make(chan string, 1)
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies <- string(body)
+	}))
+	defer mirror.Close()
+
+	proxy := httptest.NewServer(&HTTPProxy{
+		Transport: http.DefaultTransport,
+		Lookup: func(r *http.Request) *route.Target {
+			tbl, _ := route.NewTable(bytes.NewBufferString("route add svc / " + server.URL + ` opts "mirror=svc-v2"`))
+			return tbl.Lookup(r, route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+		},
+		MirrorTarget: func(service string) *route.Target {
+			return &route.Target{Service: service, URL: mustParse(mirror.URL)}
+		},
+	})
+	defer proxy.Close()
+
+	// the primary request is proxied before the client has sent the
+	// whole body.
+	pr, pw := io.Pipe()
+	go func() {
+		pw.Write([]byte("hello"))
+		select {
+		case <-started:
+		case <-time.After(5 * time.Second):
+		}
+		pw.Write([]byte(" world"))
+		pw.Close()
+	}()
+
+	req, err := http.NewRequest("POST", proxy.URL+"/", pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	resp, body := mustDo(req)
+	if d := time.Since(start); d >= 5*time.Second {
+		t.Fatalf("primary request was delayed by the mirror for %s", d)
+	}
+	if got, want := resp.StatusCode, http.StatusOK; got != want {
+		t.Fatalf("got status %d want %d", got, want)
+	}
+	if got, want := string(body), "hello world"; got != want {
+		t.Fatalf("got body %q want %q", got, want)
+	}
+
+	select {
+	case got := <-bodies:
+		if want := "hello world"; got != want {
+			t.Fatalf("got mirrored body %q want %q", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("request was not mirrored")
+	}
+}
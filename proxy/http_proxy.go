@@ -2,10 +2,12 @@ package proxy
 
 import (
 	"bufio"
+	"context"
 	"crypto/tls"
 	"errors"
 	gkm "github.com/go-kit/kit/metrics"
 	"io"
+	"math"
 	"net"
 	"net/http"
 	"net/url"
@@ -18,8 +20,10 @@ import (
 	"github.com/fabiolb/fabio/config"
 	"github.com/fabiolb/fabio/logger"
 	"github.com/fabiolb/fabio/noroute"
-	"github.com/fabiolb/fabio/proxy/gzip"
+	"github.com/fabiolb/fabio/proxy/cache"
+	"github.com/fabiolb/fabio/proxy/compress"
 	"github.com/fabiolb/fabio/route"
+	"github.com/fabiolb/fabio/transport"
 	"github.com/fabiolb/fabio/uuid"
 )
 
@@ -39,6 +43,12 @@ type HttpStatsHandler struct {
 
 	// RedirectCounter - counts redirects
 	RedirectCounter gkm.Counter
+
+	// Mirror counts the mirrored requests per service and result.
+	Mirror gkm.Counter
+
+	// Cache counts the cacheable requests per service and result.
+	Cache gkm.Counter
 }
 
 // HTTPProxy is a dynamic reverse proxy for HTTP and HTTPS protocols.
@@ -79,6 +89,24 @@ type HTTPProxy struct {
 
 	// ProtectHeaders is a map of headers to protect against client side manipulation
 	ProtectHeaders map[string]bool
+
+	// NextTarget returns the target for retrying a request which has
+	// failed with the given targets. If NextTarget is nil,
+	// route.NextTarget is used.
+	NextTarget func(tried []*route.Target) *route.Target
+
+	// MirrorTarget returns the target of the mirror service for
+	// mirrored requests. If MirrorTarget is nil, route.MirrorTarget
+	// is used.
+	MirrorTarget func(service string) *route.Target
+
+	// Cache stores the responses of routes with the 'cache' option.
+	// If Cache is nil, responses are not cached.
+	Cache *cache.Cache
+
+	// AltSvc is the value of the Alt-Svc header which advertises the
+	// HTTP/3 listeners in responses to TLS requests.
+	AltSvc string
 }
 
 func (p *HTTPProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -94,6 +122,10 @@ func (p *HTTPProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		r.Header.Set(p.Config.RequestID, id())
 	}
 
+	if p.AltSvc != "" && r.TLS != nil {
+		w.Header().Set("Alt-Svc", p.AltSvc)
+	}
+
 	// Normalize Paths before routing.
 	r.URL.Path = normalizePath(r.URL.Path)
 
@@ -122,6 +154,12 @@ func (p *HTTPProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if limited, retry := t.RateLimitedHTTP(r); limited {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retry.Seconds()))))
+		http.Error(w, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+
 	// build the request url since r.URL will get modified
 	// by the reverse proxy and contains only the RequestURI anyway
 	requestURL := &url.URL{
@@ -140,72 +178,93 @@ func (p *HTTPProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// build the real target url that is passed to the proxy
-	targetURL := &url.URL{
-		Scheme: t.URL.Scheme,
-		Host:   t.URL.Host,
-		Path:   r.URL.Path,
+	targetURL := upstreamURL(t, r.URL)
+
+	// keep the original host for retries with another target
+	host := r.Host
+	r.Host = upstreamHost(t, targetURL, host)
+
+	if err := addHeaders(r, p.ProtectHeaders, p.Config, t.StripPath); err != nil {
+		http.Error(w, "cannot parse "+r.RemoteAddr, http.StatusInternalServerError)
+		return
 	}
-	if t.URL.RawQuery == "" || r.URL.RawQuery == "" {
-		targetURL.RawQuery = t.URL.RawQuery + r.URL.RawQuery
-	} else {
-		targetURL.RawQuery = t.URL.RawQuery + "&" + r.URL.RawQuery
+
+	addResponseHeaders(w, r, p.Config)
+
+	if t.ReqHeaders != nil {
+		applyHeaderRules(r.Header, t.ReqHeaders, p.headerVars(r, host))
 	}
 
-	if t.Host == "dst" {
-		r.Host = targetURL.Host
-	} else if t.Host != "" {
-		r.Host = t.Host
+	if status, msg := p.limitRequestBody(w, r, t); status != 0 {
+		http.Error(w, msg, status)
+		return
 	}
 
-	// TODO(fs): The HasPrefix check seems redundant since the lookup function should
-	// TODO(fs): have found the target based on the prefix but there may be other
-	// TODO(fs): matchers which may have different rules. I'll keep this for
-	// TODO(fs): a defensive approach.
-	if t.StripPath != "" && strings.HasPrefix(r.URL.Path, t.StripPath) {
-		targetURL.Path = targetURL.Path[len(t.StripPath):]
-		// ensure absolute path after stripping to maintain compliance with
-		// section 5.3 of RFC7230 (https://tools.ietf.org/html/rfc7230#section-5.3)
-		if !strings.HasPrefix(targetURL.Path, "/") {
-			targetURL.Path = "/" + targetURL.Path
+	if t.DecompressRequest {
+		if status, msg := p.decompressRequest(r); status != 0 {
+			http.Error(w, msg, status)
+			return
 		}
 	}
 
-	if t.PrependPath != "" {
-		targetURL.Path = t.PrependPath + targetURL.Path
-		// ensure absolute path after stripping to maintain compliance with
-		// section 5.3 of RFC7230 (https://tools.ietf.org/html/rfc7230#section-5.3)
-		if !strings.HasPrefix(targetURL.Path, "/") {
-			targetURL.Path = "/" + targetURL.Path
-		}
+	upgrade, accept := r.Header.Get("Upgrade"), r.Header.Get("Accept")
+
+	// send a copy of the request to the mirror service
+	if t.Mirror != "" && upgrade == "" && accept != "text/event-stream" {
+		done := p.mirror(t, r, host)
+		defer done()
 	}
 
-	if err := addHeaders(r, p.ProtectHeaders, p.Config, t.StripPath); err != nil {
-		http.Error(w, "cannot parse "+r.RemoteAddr, http.StatusInternalServerError)
-		return
+	// limit the upstream request to the timeout of the route
+	if upgrade == "" {
+		var cancel context.CancelFunc
+		r, cancel = withRouteTimeout(r, t)
+		defer cancel()
 	}
 
-	addResponseHeaders(w, r, p.Config)
+	// send the address of the client with the PROXY protocol
+	pxyHeader := p.proxyHeader(r, t)
+	if pxyHeader != nil {
+		r = r.WithContext(transport.WithProxyHeader(r.Context(), pxyHeader))
+	}
 
-	upgrade, accept := r.Header.Get("Upgrade"), r.Header.Get("Accept")
+	tr := p.transport(t)
 
-	tr := p.Transport
-	if t.Transport != nil {
-		tr = t.Transport
-	} else if t.TLSSkipVerify {
-		tr = p.InsecureTransport
+	// retry idempotent requests with another target
+	var rt *retryTransport
+	if t.Retry != nil && upgrade == "" && p.retryable(r) {
+		if rt = p.newRetryTransport(t, r, host, tr); rt != nil {
+			tr = rt
+		}
+	}
+
+	// request compressed responses from the upstream
+	if len(t.UpstreamEncodings) > 0 && upgrade == "" {
+		tr = newDecompressTransport(t, r, tr)
+	}
+
+	if max := bodyLimit(t.MaxResponseBody, p.Config.MaxResponseBody); max > 0 {
+		tr = &responseLimitTransport{RoundTripper: tr, max: max}
 	}
 
 	var h http.Handler
+	var ws *wsSession
 	switch {
+	case isGRPCWeb(r) && isGRPC(targetURL):
+		// translate gRPC-Web requests for gRPC targets
+		h = newGRPCWebHandler(targetURL, grpcWebTransport(t))
+
 	case upgrade == "websocket" || upgrade == "Websocket":
 		r.URL = targetURL
+		ws = &wsSession{}
+		var tlsConfig *tls.Config
 		if targetURL.Scheme == "https" || targetURL.Scheme == "wss" {
-			h = newWSHandler(targetURL.Host, func(network, address string) (net.Conn, error) {
-				return tls.Dial(network, address, tr.(*http.Transport).TLSClientConfig)
-			}, p.Stats.WSConn)
-		} else {
-			h = newWSHandler(targetURL.Host, net.Dial, p.Stats.WSConn)
+			tlsConfig = &tls.Config{}
+			if tr, ok := p.upstreamTransport(t).(*http.Transport); ok && tr.TLSClientConfig != nil {
+				tlsConfig = tr.TLSClientConfig
+			}
 		}
+		h = newWSHandler(targetURL.Host, wsDialer(t.DialTimeout, tlsConfig, pxyHeader), p.Stats.WSConn, t, ws)
 
 	case accept == "text/event-stream":
 		// use the flush interval for SSE (server-sent events)
@@ -216,8 +275,23 @@ func (p *HTTPProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		h = newHTTPProxy(targetURL, tr, p.Config.GlobalFlushInterval)
 	}
 
-	if p.Config.GZIPContentTypes != nil {
-		h = gzip.NewGzipHandler(h, p.Config.GZIPContentTypes)
+	// serve cached responses. The cache is wrapped by the compression
+	// so that cached responses are compressed for every client.
+	if t.Cache && p.Cache != nil && upgrade == "" && accept != "text/event-stream" {
+		h = p.Cache.Handler(h, host, t.CacheTTL, func(result string) {
+			if p.Stats.Cache != nil {
+				p.Stats.Cache.With("service", t.Service, "result", result).Add(1)
+			}
+		})
+	}
+
+	if encodings := p.compressEncodings(t); len(encodings) > 0 {
+		h = compress.NewHandler(h, compress.Options{
+			Encodings:    encodings,
+			ContentTypes: p.Config.GZIPContentTypes,
+			MinSize:      p.Config.Compress.MinSize,
+			Levels:       p.compressLevels(),
+		})
 	}
 
 	timeNow := p.Time
@@ -225,18 +299,54 @@ func (p *HTTPProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		timeNow = time.Now
 	}
 
+	var latency time.Duration
+	t.Begin()
+	defer func() { t.End(latency) }()
+
 	start := timeNow()
 	rw := &responseWriter{w: w}
+	if t.RespHeaders != nil {
+		vars := p.headerVars(r, host)
+		rw.header = func(h http.Header) { applyHeaderRules(h, t.RespHeaders, vars) }
+	}
 	h.ServeHTTP(rw, r)
 	end := timeNow()
 	dur := end.Sub(start)
 
+	// long-lived websocket and SSE connections
+	// do not count towards the latency of the target
+	if upgrade == "" && accept != "text/event-stream" {
+		latency = dur
+	}
+
+	// the upstream which served the request after retries. The
+	// latency of retried targets is tracked by the retry transport.
+	upstream := t
+	if rt != nil && rt.target != t {
+		upstream = rt.target
+		targetURL = rt.url
+		latency = 0
+	}
+
+	// upstream errors and 5xx responses count towards the outlier detection
+	upstream.ReportResult(rw.code < 500)
+
 	if p.Stats.Requests != nil {
 		p.Stats.Requests.With("service", t.Service).Observe(dur.Seconds())
 	}
 	if t.Timer != nil {
 		t.Timer.Observe(dur.Seconds())
 	}
+
+	// the connection of websocket sessions is hijacked and
+	// the session is logged with the size of the data which
+	// was sent to the client.
+	if ws != nil && ws.upgraded {
+		rw.code = http.StatusSwitchingProtocols
+		rw.size = int(ws.tx.bytes)
+		observeWSSession(t, ws, dur)
+	}
+
 	if rw.code <= 0 {
 		return
 	}
@@ -257,12 +367,132 @@ func (p *HTTPProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			},
 			RequestURL:      requestURL,
 			UpstreamAddr:    targetURL.Host,
-			UpstreamService: t.Service,
+			UpstreamService: upstream.Service,
 			UpstreamURL:     targetURL,
+			WSCloseCode:     wsCloseCode(ws),
 		})
 	}
 }
 
+// upstreamURL returns the url of the request for the target.
+func upstreamURL(t *route.Target, reqURL *url.URL) *url.URL {
+	targetURL := &url.URL{
+		Scheme: t.URL.Scheme,
+		Host:   t.URL.Host,
+		Path:   t.RewritePath(reqURL.Path),
+	}
+	if t.URL.RawQuery == "" || reqURL.RawQuery == "" {
+		targetURL.RawQuery = t.URL.RawQuery + reqURL.RawQuery
+	} else {
+		targetURL.RawQuery = t.URL.RawQuery + "&" + reqURL.RawQuery
+	}
+
+	// TODO(fs): The HasPrefix check seems redundant since the lookup function should
+	// TODO(fs): have found the target based on the prefix but there may be other
+	// TODO(fs): matchers which may have different rules. I'll keep this for
+	// TODO(fs): a defensive approach.
+	if t.StripPath != "" && strings.HasPrefix(targetURL.Path, t.StripPath) {
+		targetURL.Path = targetURL.Path[len(t.StripPath):]
+		// ensure absolute path after stripping to maintain compliance with
+		// section 5.3 of RFC7230 (https://tools.ietf.org/html/rfc7230#section-5.3)
+		if !strings.HasPrefix(targetURL.Path, "/") {
+			targetURL.Path = "/" + targetURL.Path
+		}
+	}
+
+	if t.PrependPath != "" {
+		targetURL.Path = t.PrependPath + targetURL.Path
+		// ensure absolute path after stripping to maintain compliance with
+		// section 5.3 of RFC7230 (https://tools.ietf.org/html/rfc7230#section-5.3)
+		if !strings.HasPrefix(targetURL.Path, "/") {
+			targetURL.Path = "/" + targetURL.Path
+		}
+	}
+	return targetURL
+}
+
+// upstreamHost returns the value of the Host header for the target.
+func upstreamHost(t *route.Target, targetURL *url.URL, host string) string {
+	switch {
+	case t.Host == "dst":
+		return targetURL.Host
+	case t.Host != "":
+		return t.Host
+	default:
+		return host
+	}
+}
+
+// transport returns the transport for the target.
+func (p *HTTPProxy) transport(t *route.Target) http.RoundTripper {
+	tr := p.upstreamTransport(t)
+	// the v1 header is the same for all requests of a client connection
+	// so that its upstream connections can be reused.
+	if t.ProxyProto && !t.ProxyProtoV2 && t.Transport != nil {
+		tr = transport.ProxyHeaderRoundTripper(t.Transport)
+	}
+	return &timeoutTransport{RoundTripper: tr, target: t}
+}
+
+// upstreamTransport returns the connection pool for the target.
+// Like the reverse proxy it falls back to http.DefaultTransport if the
+// proxy has no transport.
+func (p *HTTPProxy) upstreamTransport(t *route.Target) http.RoundTripper {
+	var tr http.RoundTripper
+	switch {
+	case t.Transport != nil:
+		return t.Transport
+	case t.TLSSkipVerify:
+		tr = p.InsecureTransport
+	default:
+		tr = p.Transport
+	}
+	if tr == nil {
+		return http.DefaultTransport
+	}
+	return tr
+}
+
+// compressEncodings returns the content encodings for compressing the
+// responses of the target or nil if they are not compressed. Responses
+// are compressed if proxy.gzip.contenttype is set unless the route
+// overrides it with the 'compress' option.
+func (p *HTTPProxy) compressEncodings(t *route.Target) []string {
+	encodings := p.Config.Compress.Encodings
+	if len(encodings) == 0 {
+		encodings = []string{compress.Gzip}
+	}
+	switch t.Compress {
+	case "":
+		if p.Config.GZIPContentTypes == nil {
+			return nil
+		}
+		return encodings
+	case "on":
+		return encodings
+	case "off":
+		return nil
+	default:
+		return strings.Split(t.Compress, ",")
+	}
+}
+
+// compressLevels returns the configured compression levels. Encodings
+// without a level use the default level of the encoder.
+func (p *HTTPProxy) compressLevels() map[string]int {
+	levels := map[string]int{}
+	for e, l := range map[string]int{
+		compress.Gzip:   p.Config.Compress.GzipLevel,
+		compress.Brotli: p.Config.Compress.BrotliLevel,
+		compress.Zstd:   p.Config.Compress.ZstdLevel,
+	} {
+		if l > 0 {
+			levels[e] = l
+		}
+	}
+	return levels
+}
+
 // normalizePath ,normalizes a URL path by handling percent-encoding and path traversal.
 func normalizePath(urlPath string) string {
 	normalizedPath := urlPath
@@ -291,6 +521,9 @@ type responseWriter struct {
 	w    http.ResponseWriter
 	code int
 	size int
+
+	// header modifies the response headers before they are written.
+	header func(http.Header)
 }
 
 func (rw *responseWriter) Header() http.Header {
@@ -298,16 +531,27 @@ func (rw *responseWriter) Header() http.Header {
 }
 
 func (rw *responseWriter) Write(b []byte) (int, error) {
+	rw.writeHeader()
 	n, err := rw.w.Write(b)
 	rw.size += n
 	return n, err
 }
 
 func (rw *responseWriter) WriteHeader(statusCode int) {
+	rw.writeHeader()
 	rw.w.WriteHeader(statusCode)
 	rw.code = statusCode
 }
 
+// writeHeader calls the header function once before the
+// response headers are written.
+func (rw *responseWriter) writeHeader() {
+	if rw.header != nil {
+		rw.header(rw.w.Header())
+		rw.header = nil
+	}
+}
+
 func (rw *responseWriter) Flush() {
 	if fl, ok := rw.w.(http.Flusher); ok {
 		fl.Flush()
@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"time"
+
+	"github.com/fabiolb/fabio/proxy/tcp"
+	"github.com/fabiolb/fabio/route"
+	"github.com/fabiolb/fabio/uuid"
+)
+
+// proxyHeader returns the PROXY protocol header with the address of the
+// client for targets with the 'pxyproto' option or nil. The v2 header
+// contains the TLS details of the client connection and the request id
+// as unique id.
+func (p *HTTPProxy) proxyHeader(r *http.Request, t *route.Target) []byte {
+	if !t.ProxyProto {
+		return nil
+	}
+
+	var src, dst net.Addr
+	if ap, err := netip.ParseAddrPort(r.RemoteAddr); err == nil {
+		src = net.TCPAddrFromAddrPort(ap)
+	}
+	dst, _ = r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+
+	version, v := byte(1), tcp.ProxyTLVs{}
+	if t.ProxyProtoV2 {
+		version = 2
+		if r.TLS != nil {
+			v = tcp.TLSProxyTLVs(r.TLS)
+		}
+		if p.Config.RequestID != "" {
+			v.UniqueID = r.Header.Get(p.Config.RequestID)
+		}
+		if v.UniqueID == "" {
+			v.UniqueID = uuid.NewUUID()
+		}
+	}
+
+	hdr, err := tcp.ProxyHeader(version, src, dst, v)
+	if err != nil {
+		log.Printf("[WARN] Cannot create PROXY protocol header for %s. %s", r.URL, err)
+		return nil
+	}
+	return hdr
+}
+
+// wsDialer returns the function which connects to the upstream of
+// websocket sessions. The connection uses TLS if tlsConfig is not nil
+// and hdr is written before the TLS handshake if it is not nil.
+func wsDialer(timeout time.Duration, tlsConfig *tls.Config, hdr []byte) dialFunc {
+	dialer := &net.Dialer{Timeout: timeout}
+	return func(network, address string) (net.Conn, error) {
+		c, err := dialer.Dial(network, address)
+		if err != nil {
+			return nil, err
+		}
+		if hdr != nil {
+			if _, err := c.Write(hdr); err != nil {
+				c.Close()
+				return nil, err
+			}
+		}
+		if tlsConfig == nil {
+			return c, nil
+		}
+
+		cfg := tlsConfig.Clone()
+		if cfg.ServerName == "" {
+			cfg.ServerName, _, _ = net.SplitHostPort(address)
+		}
+		tc := tls.Client(c, cfg)
+		if err := tc.Handshake(); err != nil {
+			c.Close()
+			return nil, err
+		}
+		return tc, nil
+	}
+}
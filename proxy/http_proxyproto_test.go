@@ -0,0 +1,161 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/route"
+
+	proxyproto "github.com/pires/go-proxyproto"
+)
+
+type proxyConnKey struct{}
+
+func TestProxyProtoHTTP(t *testing.T) {
+	// the upstream responds with the client address and
+	// the unique id of the PROXY protocol header
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var id string
+		if c, ok := r.Context().Value(proxyConnKey{}).(*proxyproto.Conn); ok && c.ProxyHeader() != nil {
+			tlvs, _ := c.ProxyHeader().TLVs()
+			for _, tlv := range tlvs {
+				if tlv.Type == proxyproto.PP2_TYPE_UNIQUE_ID {
+					id = string(tlv.Value)
+				}
+			}
+		}
+		w.Write([]byte(r.RemoteAddr + " " + id))
+	}))
+	server.Listener = &proxyproto.Listener{Listener: server.Listener}
+	server.Config.ConnContext = func(ctx context.Context, c net.Conn) context.Context {
+		return context.WithValue(ctx, proxyConnKey{}, c)
+	}
+	var conns atomic.Int32
+	server.Config.ConnState = func(_ net.Conn, s http.ConnState) {
+		if s == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	// v1 headers describe the client connection and the upstream
+	// connections are reused. v2 headers contain the request id.
+	tests := []struct {
+		opts  string
+		id    string
+		conns int32
+	}{
+		{"pxyproto=true", "", 1},
+		{"pxyproto=v2", "req-1", 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.opts, func(t *testing.T) {
+			conns.Store(0)
+			proxy := httptest.NewServer(&HTTPProxy{
+				Config: config.Proxy{RequestID: "X-Request-Id"},
+				UUID:   func() string { return "req-1" },
+				Lookup: func(r *http.Request) *route.Target {
+					tbl, _ := route.NewTable(bytes.NewBufferString("route add mock / " + server.URL + ` opts "` + tt.opts + `"`))
+					return tbl.Lookup(r, route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+				},
+			})
+			defer proxy.Close()
+
+			// record the address of the client connection
+			var client string
+			c := &http.Client{Transport: &http.Transport{
+				DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+					conn, err := net.Dial(network, addr)
+					if err == nil {
+						client = conn.LocalAddr().String()
+					}
+					return conn, err
+				},
+			}}
+
+			for range 2 {
+				resp, err := c.Get(proxy.URL)
+				if err != nil {
+					t.Fatal(err)
+				}
+				body, _ := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				if got, want := string(body), client+" "+tt.id; got != want {
+					t.Fatalf("got %q want %q", got, want)
+				}
+			}
+			if got, want := conns.Load(), tt.conns; got != want {
+				t.Fatalf("got %d upstream connections want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestProxyProtoTrust(t *testing.T) {
+	tests := []struct {
+		desc   string
+		trust  []string
+		header string
+		want   string
+	}{
+		{"no allowlist", nil, "PROXY TCP4 1.2.3.4 5.6.7.8 12345 80\r\n", "1.2.3.4:12345 foo"},
+		{"trusted source", []string{"127.0.0.0/8"}, "PROXY TCP4 1.2.3.4 5.6.7.8 12345 80\r\n", "1.2.3.4:12345 foo"},
+		{"trusted source without header", []string{"127.0.0.1"}, "", "127.0.0.1 foo"},
+		{"untrusted source", []string{"10.0.0.0/8"}, "PROXY TCP4 1.2.3.4 5.6.7.8 12345 80\r\n", "error"},
+		{"untrusted source without header", []string{"10.0.0.0/8"}, "", "127.0.0.1 foo"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			l := config.Listen{Addr: "127.0.0.1:57779", ProxyProto: true, ProxyTrust: tt.trust, ProxyHeaderTimeout: time.Second}
+			ln, err := ListenTCP(l, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ln.Close()
+
+			result := make(chan string, 1)
+			go func() {
+				c, err := ln.Accept()
+				if err != nil {
+					result <- "error"
+					return
+				}
+				defer c.Close()
+				line, _, err := bufio.NewReader(c).ReadLine()
+				if err != nil {
+					result <- "error"
+					return
+				}
+				addr := c.RemoteAddr().String()
+				if tt.header == "" {
+					addr, _, _ = net.SplitHostPort(addr)
+				}
+				result <- addr + " " + string(line)
+			}()
+
+			c, err := net.Dial("tcp", l.Addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			c.Write([]byte(tt.header + "foo\n"))
+
+			if got := <-result; !strings.HasPrefix(got, tt.want) {
+				t.Fatalf("got %q want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,280 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/route"
+)
+
+// maxRetryBody is the maximum size of a request body which is buffered
+// for retries. Requests with larger bodies are not retried.
+const maxRetryBody = 1 << 20
+
+// retryable returns true if the request can be retried with another
+// target. These are requests with an idempotent method or requests
+// which have the configured retry header.
+func (p *HTTPProxy) retryable(r *http.Request) bool {
+	switch r.Method {
+	case "GET", "HEAD", "OPTIONS":
+		return true
+	}
+	return p.Config.Retry.Header != "" && r.Header.Get(p.Config.Retry.Header) != ""
+}
+
+// newRetryTransport returns a transport which retries the request with
+// other targets of the route. It returns nil if the request body is too
+// large to be buffered.
+func (p *HTTPProxy) newRetryTransport(t *route.Target, r *http.Request, host string, tr http.RoundTripper) *retryTransport {
+	body, ok := bufferBody(r, maxRetryBody)
+	if !ok {
+		return nil
+	}
+
+	next := p.NextTarget
+	if next == nil {
+		next = route.NextTarget
+	}
+
+	budget.request()
+
+	return &retryTransport{
+		proxy:  p,
+		policy: t.Retry,
+		reqURL: &url.URL{Path: r.URL.Path, RawQuery: r.URL.RawQuery},
+		host:   host,
+		body:   body,
+		next:   next,
+		target: t,
+		tried:  []*route.Target{t},
+		tr:     tr,
+	}
+}
+
+// retryTransport sends the request to the target and retries it with
+// other targets of the route if the attempt fails according to the
+// retry policy and the retry budget is not exhausted.
+type retryTransport struct {
+	proxy  *HTTPProxy
+	policy *route.RetryPolicy
+	next   func(tried []*route.Target) *route.Target
+
+	// reqURL, host and body are from the original request.
+	reqURL *url.URL
+	host   string
+	body   []byte
+
+	// target, url and tr are the target of the current attempt,
+	// its url and its transport.
+	target *route.Target
+	url    *url.URL
+	tr     http.RoundTripper
+
+	// tried contains the targets of all attempts.
+	tried []*route.Target
+}
+
+func (rt *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt.url = req.URL
+	for attempt := 0; ; attempt++ {
+		start := time.Now()
+		if attempt > 0 {
+			rt.target.Begin()
+		}
+		resp, err := rt.try(req)
+		retry := rt.retry(resp, err)
+
+		// only targets of retried attempts are tracked here. The
+		// first target is tracked by the proxy.
+		if attempt > 0 {
+			switch {
+			case err != nil:
+				rt.target.End(0)
+			case retry:
+				rt.target.End(time.Since(start))
+			default:
+				t, d := rt.target, time.Since(start)
+				resp.Body = doneBody{ReadCloser: resp.Body, done: sync.OnceFunc(func() { t.End(d) })}
+			}
+		}
+
+		if !retry || attempt >= rt.policy.Attempts {
+			return resp, err
+		}
+
+		next := rt.next(rt.tried)
+		if next == nil || !budget.withdraw(rt.proxy.Config.Retry) {
+			return resp, err
+		}
+
+		if err != nil {
+			log.Printf("[WARN] retry: retrying %s %s with %s after %s", req.Method, rt.url, next.URL, err)
+		} else {
+			log.Printf("[WARN] retry: retrying %s %s with %s after status %d", req.Method, rt.url, next.URL, resp.StatusCode)
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+			resp.Body.Close()
+		}
+		rt.target.ReportResult(false)
+
+		// send the next attempt to the new target
+		rt.target = next
+		rt.tried = append(rt.tried, next)
+		rt.tr = rt.proxy.transport(next)
+		rt.url = upstreamURL(next, rt.reqURL)
+		req = req.Clone(req.Context())
+		req.URL = rt.url
+		req.Host = upstreamHost(next, rt.url, rt.host)
+	}
+}
+
+// try sends a single attempt of the request with the per-try timeout.
+func (rt *retryTransport) try(req *http.Request) (*http.Response, error) {
+	if rt.body != nil {
+		req.Body = io.NopCloser(bytes.NewReader(rt.body))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(rt.body)), nil
+		}
+	}
+	if rt.policy.Timeout <= 0 {
+		return rt.tr.RoundTrip(req)
+	}
+
+	// the timeout only applies until the response headers have been
+	// received. The context is canceled when the body is closed.
+	ctx, cancel := context.WithCancel(req.Context())
+	var timedOut atomic.Bool
+	timer := time.AfterFunc(rt.policy.Timeout, func() {
+		timedOut.Store(true)
+		cancel()
+	})
+	resp, err := rt.tr.RoundTrip(req.WithContext(ctx))
+	timer.Stop()
+	if err != nil {
+		cancel()
+		if timedOut.Load() {
+			return nil, errRetryTimeout
+		}
+		return nil, err
+	}
+	resp.Body = doneBody{ReadCloser: resp.Body, done: cancel}
+	return resp, nil
+}
+
+// retry returns true if the result of an attempt should be retried.
+func (rt *retryTransport) retry(resp *http.Response, err error) bool {
+	switch {
+	case err == errRetryTimeout:
+		return true
+	case err != nil:
+		var opErr *net.OpError
+		return rt.policy.Connect && errors.As(err, &opErr) && opErr.Op == "dial"
+	default:
+		return rt.policy.Retryable(resp.StatusCode)
+	}
+}
+
+// errRetryTimeout is returned when an attempt exceeds the per-try timeout.
+// It is a timeout error so that the proxy responds with 504.
+var errRetryTimeout error = retryTimeoutError{}
+
+type retryTimeoutError struct{}
+
+func (retryTimeoutError) Error() string   { return "retry: per-try timeout exceeded" }
+func (retryTimeoutError) Timeout() bool   { return true }
+func (retryTimeoutError) Temporary() bool { return true }
+
+// doneBody calls done when the response body is closed.
+type doneBody struct {
+	io.ReadCloser
+	done func()
+}
+
+func (b doneBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.done()
+	return err
+}
+
+// bufferBody reads the request body into memory so that it can be sent
+// more than once and replaces the body of the request with a reader for
+// the buffer. It returns false and leaves the body readable if the body
+// is larger than max bytes.
+func bufferBody(r *http.Request, max int) ([]byte, bool) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, true
+	}
+	buf, err := io.ReadAll(io.LimitReader(r.Body, int64(max)+1))
+	if err != nil || len(buf) > max {
+		r.Body = readCloser{io.MultiReader(bytes.NewReader(buf), r.Body), r.Body}
+		return nil, false
+	}
+	r.Body.Close()
+	if len(buf) == 0 {
+		r.Body = http.NoBody
+		return nil, true
+	}
+	r.Body = io.NopCloser(bytes.NewReader(buf))
+	return buf, true
+}
+
+// readCloser combines a reader with the closer of another reader.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// budget limits the number of retries of all routes.
+var budget = &retryBudget{}
+
+// retryBudgetWindow is the period over which requests and retries
+// are counted for the retry budget.
+const retryBudgetWindow = 10 * time.Second
+
+// retryBudget limits the retries to a ratio of the retryable requests
+// plus a minimum number of retries per second within a time window.
+// This prevents retries from overloading the targets when a large
+// number of requests fail.
+type retryBudget struct {
+	mu       sync.Mutex
+	window   time.Time
+	requests float64
+	retries  float64
+}
+
+// reset starts a new window if the current one has expired.
+func (b *retryBudget) reset() {
+	if t := time.Now(); t.Sub(b.window) > retryBudgetWindow {
+		b.window, b.requests, b.retries = t, 0, 0
+	}
+}
+
+// request records a retryable request.
+func (b *retryBudget) request() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.reset()
+	b.requests++
+}
+
+// withdraw returns true if a retry is within the budget.
+func (b *retryBudget) withdraw(cfg config.Retry) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.reset()
+	if b.retries+1 > cfg.Budget*b.requests+cfg.MinRetries*retryBudgetWindow.Seconds() {
+		log.Print("[WARN] retry: retry budget exhausted")
+		return false
+	}
+	b.retries++
+	return true
+}
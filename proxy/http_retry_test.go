@@ -0,0 +1,188 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/route"
+)
+
+func TestProxyRetry(t *testing.T) {
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write([]byte("OK" + string(body)))
+	}))
+	defer ok.Close()
+
+	unavailable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer unavailable.Close()
+
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer slow.Close()
+
+	// a closed listener for connection failures
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := "http://" + l.Addr().String()
+	l.Close()
+
+	policy := &route.RetryPolicy{Attempts: 1, Connect: true, Status: []int{502, 503, 504}}
+
+	tests := []struct {
+		desc   string
+		method string
+		header string
+		body   string
+		first  string
+		policy *route.RetryPolicy
+		retry  config.Retry
+		status int
+		resp   string
+	}{
+		{
+			desc:   "retry on status",
+			method: "GET",
+			first:  unavailable.URL,
+			policy: policy,
+			retry:  config.Retry{MinRetries: 10},
+			status: 200,
+			resp:   "OK",
+		},
+		{
+			desc:   "retry on connect",
+			method: "GET",
+			first:  closed,
+			policy: policy,
+			retry:  config.Retry{MinRetries: 10},
+			status: 200,
+			resp:   "OK",
+		},
+		{
+			desc:   "retry on timeout",
+			method: "GET",
+			first:  slow.URL,
+			policy: &route.RetryPolicy{Attempts: 1, Timeout: 50 * time.Millisecond},
+			retry:  config.Retry{MinRetries: 10},
+			status: 200,
+			resp:   "OK",
+		},
+		{
+			desc:   "timeout without retry",
+			method: "GET",
+			first:  slow.URL,
+			policy: &route.RetryPolicy{Attempts: 1, Timeout: 50 * time.Millisecond},
+			status: 504,
+		},
+		{
+			desc:   "no retry without policy",
+			method: "GET",
+			first:  unavailable.URL,
+			retry:  config.Retry{MinRetries: 10},
+			status: 503,
+		},
+		{
+			desc:   "no retry for status not in policy",
+			method: "GET",
+			first:  unavailable.URL,
+			policy: &route.RetryPolicy{Attempts: 1, Status: []int{502}},
+			retry:  config.Retry{MinRetries: 10},
+			status: 503,
+		},
+		{
+			desc:   "no retry for POST",
+			method: "POST",
+			body:   "x",
+			first:  unavailable.URL,
+			policy: policy,
+			retry:  config.Retry{MinRetries: 10},
+			status: 503,
+		},
+		{
+			desc:   "retry POST with header",
+			method: "POST",
+			header: "X-Retry",
+			body:   "x",
+			first:  unavailable.URL,
+			policy: policy,
+			retry:  config.Retry{Header: "X-Retry", MinRetries: 10},
+			status: 200,
+			resp:   "OKx",
+		},
+		{
+			desc:   "no retry without budget",
+			method: "GET",
+			first:  unavailable.URL,
+			policy: policy,
+			status: 503,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			budget = &retryBudget{}
+			proxy := httptest.NewServer(&HTTPProxy{
+				Config:    config.Proxy{Retry: tt.retry},
+				Transport: http.DefaultTransport,
+				Lookup: func(r *http.Request) *route.Target {
+					return &route.Target{Service: "first", URL: mustParse(tt.first), Retry: tt.policy}
+				},
+				NextTarget: func(tried []*route.Target) *route.Target {
+					if len(tried) > 1 {
+						return nil
+					}
+					return &route.Target{Service: "next", URL: mustParse(ok.URL), Retry: tt.policy}
+				},
+			})
+			defer proxy.Close()
+
+			req, err := http.NewRequest(tt.method, proxy.URL+"/foo", strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.header != "" {
+				req.Header.Set(tt.header, "true")
+			}
+			resp, body := mustDo(req)
+			if got, want := resp.StatusCode, tt.status; got != want {
+				t.Fatalf("got status %d want %d", got, want)
+			}
+			if tt.resp != "" {
+				if got, want := string(body), tt.resp; got != want {
+					t.Fatalf("got body %q want %q", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	b := &retryBudget{}
+	cfg := config.Retry{Budget: 0.5, MinRetries: 0.1}
+	for range 10 {
+		b.request()
+	}
+	// 10 requests * 0.5 + 0.1/s * 10s = 6 retries
+	for i := range 6 {
+		if !b.withdraw(cfg) {
+			t.Fatalf("retry %d denied", i)
+		}
+	}
+	if b.withdraw(cfg) {
+		t.Fatal("retry allowed after budget is exhausted")
+	}
+}
@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/fabiolb/fabio/route"
+)
+
+// errRouteTimeout is the cause of the request context
+// when the request exceeds the 'timeout' of the route.
+var errRouteTimeout = errors.New("route timeout exceeded")
+
+// withRouteTimeout limits the request to the 'timeout' of the route.
+// The returned cancel function must be called when the request is done.
+func withRouteTimeout(r *http.Request, t *route.Target) (*http.Request, context.CancelFunc) {
+	if t.Timeout <= 0 {
+		return r, func() {}
+	}
+	ctx, cancel := context.WithTimeoutCause(r.Context(), t.Timeout, errRouteTimeout)
+	return r.WithContext(ctx), cancel
+}
+
+// upstreamTimeoutError is returned when the route timeout or the upstream
+// connect timeout fires. It is a timeout error so that the proxy responds
+// with 504 and wraps the original error for the retry transport.
+type upstreamTimeoutError struct {
+	msg string
+	err error
+}
+
+func (e *upstreamTimeoutError) Error() string   { return e.msg + ": " + e.err.Error() }
+func (e *upstreamTimeoutError) Unwrap() error   { return e.err }
+func (e *upstreamTimeoutError) Timeout() bool   { return true }
+func (e *upstreamTimeoutError) Temporary() bool { return true }
+
+// timeoutTransport detects the requests to the target which exceeded the
+// route timeout or the connect timeout and counts them.
+type timeoutTransport struct {
+	http.RoundTripper
+	target *route.Target
+}
+
+func (tt *timeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := tt.RoundTripper.RoundTrip(req)
+	if err != nil {
+		var opErr *net.OpError
+		switch {
+		case context.Cause(req.Context()) == errRouteTimeout:
+			if tt.target.TimeoutCounter != nil {
+				tt.target.TimeoutCounter.Add(1)
+			}
+			return nil, &upstreamTimeoutError{msg: "upstream request timeout", err: err}
+		case errors.As(err, &opErr) && opErr.Op == "dial" && opErr.Timeout():
+			if tt.target.DialTimeoutCounter != nil {
+				tt.target.DialTimeoutCounter.Add(1)
+			}
+			return nil, &upstreamTimeoutError{msg: "upstream connect timeout", err: err}
+		}
+		return nil, err
+	}
+
+	// the route timeout can also fire while the body is forwarded
+	if _, ok := req.Context().Deadline(); ok && resp.StatusCode != http.StatusSwitchingProtocols {
+		resp.Body = &timeoutBody{ReadCloser: resp.Body, ctx: req.Context(), target: tt.target}
+	}
+	return resp, nil
+}
+
+// timeoutBody counts the response bodies which could not be read
+// because the route timeout fired.
+type timeoutBody struct {
+	io.ReadCloser
+	ctx    context.Context
+	target *route.Target
+	once   sync.Once
+}
+
+func (b *timeoutBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF && context.Cause(b.ctx) == errRouteTimeout && b.target.TimeoutCounter != nil {
+		b.once.Do(func() { b.target.TimeoutCounter.Add(1) })
+	}
+	return n, err
+}
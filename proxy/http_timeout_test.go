@@ -0,0 +1,131 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/route"
+
+	gkm "github.com/go-kit/kit/metrics"
+)
+
+// timeoutCounter counts the number of updates.
+type timeoutCounter struct {
+	n atomic.Int64
+}
+
+func (c *timeoutCounter) With(...string) gkm.Counter { return c }
+func (c *timeoutCounter) Add(float64)                { c.n.Add(1) }
+
+// timeoutProxy returns a proxy for a single route to server with the
+// given options. The timeout counters are set on the target.
+func timeoutProxy(server, opts string, tr http.RoundTripper, timeouts, dialTimeouts *timeoutCounter) *httptest.Server {
+	return httptest.NewServer(&HTTPProxy{
+		Transport: tr,
+		Lookup: func(r *http.Request) *route.Target {
+			tbl, _ := route.NewTable(bytes.NewBufferString("route add mock / " + server + ` opts "` + opts + `"`))
+			t := tbl.Lookup(r, route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+			t.TimeoutCounter, t.DialTimeoutCounter = timeouts, dialTimeouts
+			return t
+		},
+	})
+}
+
+func TestProxyRouteTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d, _ := time.ParseDuration(r.URL.Query().Get("sleep"))
+		if r.URL.Path == "/body" {
+			w.Write([]byte("partial"))
+			w.(http.Flusher).Flush()
+		}
+		select {
+		case <-time.After(d):
+			w.Write([]byte("OK"))
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		desc   string
+		opts   string
+		path   string
+		status int
+		body   string
+		count  int64
+	}{
+		{"no timeout", "", "/?sleep=100ms", 200, "OK", 0},
+		{"within timeout", "timeout=1s", "/?sleep=10ms", 200, "OK", 0},
+		{"timeout exceeded", "timeout=50ms", "/?sleep=2s", 504, "upstream request timeout\n", 1},
+		{"timeout exceeded during body", "timeout=50ms", "/body?sleep=2s", 200, "partial", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			var timeouts, dialTimeouts timeoutCounter
+			proxy := timeoutProxy(server.URL, tt.opts, http.DefaultTransport, &timeouts, &dialTimeouts)
+			defer proxy.Close()
+
+			resp, err := http.Get(proxy.URL + tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the body of aborted responses is truncated
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			if got, want := resp.StatusCode, tt.status; got != want {
+				t.Fatalf("got status %d want %d", got, want)
+			}
+			if got, want := string(body), tt.body; got != want {
+				t.Fatalf("got body %q want %q", got, want)
+			}
+			if got, want := timeouts.n.Load(), tt.count; got != want {
+				t.Fatalf("got %d timeouts want %d", got, want)
+			}
+			if got := dialTimeouts.n.Load(); got != 0 {
+				t.Fatalf("got %d dial timeouts want 0", got)
+			}
+		})
+	}
+}
+
+// timeoutNetError is a dial error which timed out.
+type timeoutNetError struct{}
+
+func (timeoutNetError) Error() string   { return "i/o timeout" }
+func (timeoutNetError) Timeout() bool   { return true }
+func (timeoutNetError) Temporary() bool { return true }
+
+func TestProxyDialTimeout(t *testing.T) {
+	tr := &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return nil, &net.OpError{Op: "dial", Net: network, Err: timeoutNetError{}}
+		},
+	}
+
+	var timeouts, dialTimeouts timeoutCounter
+	proxy := timeoutProxy("http://127.0.0.1:1", "", tr, &timeouts, &dialTimeouts)
+	defer proxy.Close()
+
+	resp, body := mustGet(proxy.URL)
+	if got, want := resp.StatusCode, http.StatusGatewayTimeout; got != want {
+		t.Fatalf("got status %d want %d", got, want)
+	}
+	if got, want := string(body), "upstream connect timeout\n"; got != want {
+		t.Fatalf("got body %q want %q", got, want)
+	}
+	if got, want := dialTimeouts.n.Load(), int64(1); got != want {
+		t.Fatalf("got %d dial timeouts want %d", got, want)
+	}
+	if got := timeouts.n.Load(); got != 0 {
+		t.Fatalf("got %d timeouts want 0", got)
+	}
+}
@@ -60,25 +60,8 @@ route add tcproute example2.com/ tcp://%s opts "proto=tcp"`
 			return table.LookupHost(h, route.Picker["rr"])
 		},
 	}
-	m := func(_ context.Context, h string) bool {
-		// TODO - matcher needs to move out of main
-		// so we can test it more easily.  Probably
-		// the other functions too.
-		t := table.LookupHost(h, route.Picker["rr"])
-		if t == nil {
-			return false
-		}
-		// Make sure this is supposed to be a tcp proxy.
-		// opts proto= overrides scheme if present.
-		var (
-			ok    bool
-			proto string
-		)
-		if proto, ok = t.Opts["proto"]; !ok && t.URL != nil {
-			proto = t.URL.Scheme
-		}
-		return proto == "tcp"
-	}
+	passthrough := hostMatcher(table, (*route.Target).TLSPassthrough)
+	terminate := hostMatcher(table, (*route.Target).TLSTerminateTCP)
 
 	// get an unused port for use for the proxy.  the rest of the tests just
 	// pick a high-numbered port, but this should be safer, if ugly.  could
@@ -95,7 +78,7 @@ route add tcproute example2.com/ tcp://%s opts "proto=tcp"`
 
 	l := config.Listen{Addr: proxyAddr}
 	go func() {
-		err := ListenAndServeHTTPSTCPSNI(l, hp, tp, tlsCfg1, m)
+		err := ListenAndServeHTTPSTCPSNI(l, hp, tp, &tcp.TLSProxy{}, tlsCfg1, passthrough, terminate)
 		if err != nil {
 			t.Logf("error shutting down: %s", err)
 		}
@@ -168,6 +151,57 @@ route add tcproute example2.com/ tcp://%s opts "proto=tcp"`
 
 }
 
+// TestProxyTLSTerminateTCP tests terminating TLS for a TCP route on an
+// https+tcp+sni listener and forwarding the plaintext data to the
+// upstream server.
+func TestProxyTLSTerminateTCP(t *testing.T) {
+	srv := tcptest.NewServer(echoHandler)
+	defer srv.Close()
+
+	tpl := `route add tcproute example.com/ tcp://%s opts "proto=tcp tls=terminate"`
+	table, _ := route.NewTable(bytes.NewBufferString(fmt.Sprintf(tpl, srv.Addr)))
+	lookup := func(h string) *route.Target {
+		return table.LookupHost(h, route.Picker["rr"])
+	}
+
+	// get an unused port for the proxy like TestProxyTCPAndHTTPS
+	tmp := httptest.NewServer(okHandler)
+	proxyAddr := tmp.Listener.Addr().String()
+	tmp.Close()
+
+	go func() {
+		l := config.Listen{Addr: proxyAddr}
+		hp := &HTTPProxy{}
+		tp := &tcp.SNIProxy{Lookup: lookup}
+		tlsp := &tcp.TLSProxy{Lookup: lookup}
+		passthrough := hostMatcher(table, (*route.Target).TLSPassthrough)
+		terminate := hostMatcher(table, (*route.Target).TLSTerminateTCP)
+		if err := ListenAndServeHTTPSTCPSNI(l, hp, tp, tlsp, tlsServerConfig(), passthrough, terminate); err != nil {
+			t.Log("ListenAndServeHTTPSTCPSNI: ", err)
+		}
+	}()
+	defer Close()
+
+	cfg := tlsClientConfig()
+	cfg.ServerName = "example.com"
+	out, err := tcptest.NewTLSRetryDialer(cfg).Dial("tcp", proxyAddr)
+	if err != nil {
+		t.Fatalf("tls.Dial: %#v", err)
+	}
+	defer out.Close()
+
+	testRoundtrip(t, out)
+}
+
+// hostMatcher returns a tcpproxy matcher for the hosts of the table
+// whose target satisfies match.
+func hostMatcher(table route.Table, match func(*route.Target) bool) func(context.Context, string) bool {
+	return func(_ context.Context, h string) bool {
+		t := table.LookupHost(h, route.Picker["rr"])
+		return t != nil && match(t)
+	}
+}
+
 func foundDNSName(crt *x509.Certificate, dnsName string) bool {
 	found := slices.Contains(crt.DNSNames, dnsName)
 	return found
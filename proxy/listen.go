@@ -27,10 +27,7 @@ func ListenTCP(l config.Listen, cfg *tls.Config) (net.Listener, error) {
 
 	// enable PROXY protocol support
 	if l.ProxyProto {
-		ln = &proxyproto.Listener{
-			Listener:          ln,
-			ReadHeaderTimeout: l.ProxyHeaderTimeout,
-		}
+		ln = proxyProtoListener(ln, l)
 	}
 
 	// enable TLS
@@ -41,6 +38,35 @@ func ListenTCP(l config.Listen, cfg *tls.Config) (net.Listener, error) {
 	return &tcpListener{ln, addr, cfg}, nil
 }
 
+// proxyProtoListener accepts connections with a PROXY protocol v1 or
+// v2 header. If l.ProxyTrust is not empty only connections from these
+// addresses may send a header and other connections with a header are
+// rejected.
+func proxyProtoListener(ln net.Listener, l config.Listen) net.Listener {
+	pln := &proxyproto.Listener{
+		Listener:          ln,
+		ReadHeaderTimeout: l.ProxyHeaderTimeout,
+	}
+	if len(l.ProxyTrust) > 0 {
+		pln.ConnPolicy = proxyproto.ConnMustStrictWhiteListPolicy(l.ProxyTrust)
+	}
+	return pln
+}
+
+// ListenUDP returns the UDP connection for an HTTP/3 listener.
+func ListenUDP(l config.Listen) (net.PacketConn, error) {
+	addr, err := net.ResolveUDPAddr("udp", l.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("listen: Fail to resolve udp addr. %s", l.Addr)
+	}
+
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("listen: Fail to listen. %s", err)
+	}
+	return conn, nil
+}
+
 type tcpListener struct {
 	l         net.Listener
 	addr      net.Addr
@@ -2,6 +2,8 @@ package proxy
 
 import (
 	"bytes"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -11,6 +13,7 @@ import (
 
 	"github.com/fabiolb/fabio/config"
 	"github.com/fabiolb/fabio/route"
+	"github.com/fabiolb/fabio/transport"
 )
 
 func TestGracefulShutdown(t *testing.T) {
@@ -79,3 +82,58 @@ func TestGracefulShutdown(t *testing.T) {
 	// note that the actual listeners have not returned yet
 	wg.Wait()
 }
+
+func TestListenAndServeHTTPH2C(t *testing.T) {
+	// find a free tcp port
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	})
+	done := make(chan error, 1)
+	go func() {
+		done <- ListenAndServeHTTP(config.Listen{Addr: addr}, h, nil)
+	}()
+
+	tests := []struct {
+		desc  string
+		tr    *http.Transport
+		proto string
+	}{
+		{"http/1.1", &http.Transport{}, "HTTP/1.1"},
+		{"h2c", transport.NewH2CTransport(), "HTTP/2.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			defer tt.tr.CloseIdleConnections()
+			client := &http.Client{Transport: tt.tr, Timeout: 5 * time.Second}
+
+			// give the server some time to start up
+			var resp *http.Response
+			for range 50 {
+				if resp, err = client.Get("http://" + addr + "/"); err == nil {
+					break
+				}
+				time.Sleep(20 * time.Millisecond)
+			}
+			if err != nil {
+				t.Fatalf("got %v want nil", err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if got, want := string(body), tt.proto; got != want {
+				t.Fatalf("got proto %q want %q", got, want)
+			}
+		})
+	}
+
+	Close()
+	if err := <-done; err != nil {
+		t.Fatalf("got %v want nil", err)
+	}
+}
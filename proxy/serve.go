@@ -17,8 +17,9 @@ import (
 	"github.com/fabiolb/fabio/proxy/tcp"
 
 	"github.com/inetaf/tcpproxy"
-	"github.com/pires/go-proxyproto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
 )
 
 type Server interface {
@@ -84,16 +85,57 @@ func ListenAndServeHTTP(l config.Listen, h http.Handler, cfg *tls.Config) error
 	}
 
 	srv := &http.Server{
-		Addr:         l.Addr,
-		Handler:      h,
-		ReadTimeout:  l.ReadTimeout,
-		WriteTimeout: l.WriteTimeout,
-		IdleTimeout:  l.IdleTimeout,
-		TLSConfig:    cfg,
+		Addr:           l.Addr,
+		Handler:        h,
+		ReadTimeout:    l.ReadTimeout,
+		WriteTimeout:   l.WriteTimeout,
+		IdleTimeout:    l.IdleTimeout,
+		MaxHeaderBytes: l.MaxHeaderBytes,
+		TLSConfig:      cfg,
+	}
+
+	// accept HTTP/2 with prior knowledge (h2c) on plaintext listeners
+	if cfg == nil {
+		srv.Protocols = new(http.Protocols)
+		srv.Protocols.SetHTTP1(true)
+		srv.Protocols.SetUnencryptedHTTP2(true)
 	}
 	return serve(ln, srv)
 }
 
+// ListenAndServeHTTP3 serves HTTP/3 requests over QUIC on the UDP
+// address of the listener. cfg must not be nil.
+func ListenAndServeHTTP3(l config.Listen, h http.Handler, cfg *tls.Config) error {
+	conn, err := ListenUDP(l)
+	if err != nil {
+		return err
+	}
+
+	srv := &http3Server{
+		conn: conn,
+		srv: &http3.Server{
+			Addr:           l.Addr,
+			Handler:        h,
+			IdleTimeout:    l.IdleTimeout,
+			MaxHeaderBytes: l.MaxHeaderBytes,
+			TLSConfig:      cfg,
+			// 0-RTT requests can be replayed and are disabled
+			QUICConfig: &quic.Config{},
+		},
+	}
+
+	// the servers are keyed by address and a TCP listener
+	// may use the same address as the UDP listener.
+	mu.Lock()
+	servers["udp/"+conn.LocalAddr().String()] = srv
+	mu.Unlock()
+	err = srv.Serve(nil)
+	if errors.Is(err, http.ErrServerClosed) || errors.Is(err, net.ErrClosed) {
+		err = nil
+	}
+	return err
+}
+
 func ListenAndServePrometheus(l config.Listen, pcfg config.Prometheus, cfg *tls.Config) error {
 	ln, err := ListenTCP(l, cfg)
 	if err != nil {
@@ -109,21 +151,27 @@ func ListenAndServePrometheus(l config.Listen, pcfg config.Prometheus, cfg *tls.
 	mux.Handle(pcfg.Path, promhttp.Handler())
 
 	srv := &http.Server{
-		Addr:         l.Addr,
-		Handler:      mux,
-		ReadTimeout:  l.ReadTimeout,
-		WriteTimeout: l.WriteTimeout,
-		IdleTimeout:  l.IdleTimeout,
-		TLSConfig:    cfg,
+		Addr:           l.Addr,
+		Handler:        mux,
+		ReadTimeout:    l.ReadTimeout,
+		WriteTimeout:   l.WriteTimeout,
+		IdleTimeout:    l.IdleTimeout,
+		MaxHeaderBytes: l.MaxHeaderBytes,
+		TLSConfig:      cfg,
 	}
 	return serve(ln, srv)
 }
 
-func ListenAndServeHTTPSTCPSNI(l config.Listen, h http.Handler, p tcp.Handler, cfg *tls.Config, m tcpproxy.Matcher) error {
+// ListenAndServeHTTPSTCPSNI serves TCP, TLS terminated TCP and HTTPS
+// connections on the same listener. Connections whose server name matches
+// passthrough are forwarded to p without terminating TLS. Connections whose
+// server name matches terminate are terminated with cfg and the plaintext
+// data is forwarded to tp. All other connections are served as HTTPS by h.
+func ListenAndServeHTTPSTCPSNI(l config.Listen, h http.Handler, p, tp tcp.Handler, cfg *tls.Config, passthrough, terminate tcpproxy.Matcher) error {
 	// we only want proxy proto enabled on tcp proxies
 	pxyProto := l.ProxyProto
 	l.ProxyProto = false
-	tp := &tcpproxy.Proxy{
+	ip := &tcpproxy.Proxy{
 		ListenFunc: func(net, laddr string) (net.Listener, error) {
 			// cfg is nil here so it's not terminating TLS (yet)
 			return ListenTCP(l, nil)
@@ -132,26 +180,27 @@ func ListenAndServeHTTPSTCPSNI(l config.Listen, h http.Handler, p tcp.Handler, c
 
 	// This inspects SNI for matches.  If this succeeds then we Proxy tcp.
 	tcpSNIListener := &tcpproxy.TargetListener{Address: l.Addr}
-	tp.AddSNIMatchRoute(l.Addr, m, tcpSNIListener)
+	ip.AddSNIMatchRoute(l.Addr, passthrough, tcpSNIListener)
+
+	// This terminates TLS for the TCP routes which match the SNI.
+	tlsTCPListener := &tcpproxy.TargetListener{Address: l.Addr}
+	ip.AddSNIMatchRoute(l.Addr, terminate, tlsTCPListener)
 
 	// Fallthrough to https
 	httpsListener := &tcpproxy.TargetListener{Address: l.Addr}
-	tp.AddRoute(l.Addr, httpsListener)
+	ip.AddRoute(l.Addr, httpsListener)
 
 	// Start the listener
-	err := tp.Start()
+	err := ip.Start()
 	if err != nil {
 		return err
 	}
 
-	tps := &InetAfTCPProxyServer{Proxy: tp}
+	tps := &InetAfTCPProxyServer{Proxy: ip}
 	var tln net.Listener = tcpSNIListener
 	// enable proxy protocol on the tcp side if configured to do so
 	if pxyProto {
-		tln = &proxyproto.Listener{
-			Listener:          tln,
-			ReadHeaderTimeout: l.ProxyHeaderTimeout,
-		}
+		tln = proxyProtoListener(tln, l)
 	}
 	tps.ServeLater(tln, &tcp.Server{
 		Addr:         l.Addr,
@@ -160,14 +209,26 @@ func ListenAndServeHTTPSTCPSNI(l config.Listen, h http.Handler, p tcp.Handler, c
 		WriteTimeout: l.WriteTimeout,
 	})
 
-	// wrap TargetListener in a tls terminating version for HTTPS
-	tps.ServeLater(tls.NewListener(httpsListener, cfg), &http.Server{
+	var tlsln net.Listener = tlsTCPListener
+	if pxyProto {
+		tlsln = proxyProtoListener(tlsln, l)
+	}
+	tps.ServeLater(tls.NewListener(tlsln, cfg), &tcp.Server{
 		Addr:         l.Addr,
-		Handler:      h,
+		Handler:      tp,
 		ReadTimeout:  l.ReadTimeout,
 		WriteTimeout: l.WriteTimeout,
-		IdleTimeout:  l.IdleTimeout,
-		TLSConfig:    cfg,
+	})
+
+	// wrap TargetListener in a tls terminating version for HTTPS
+	tps.ServeLater(tls.NewListener(httpsListener, cfg), &http.Server{
+		Addr:           l.Addr,
+		Handler:        h,
+		ReadTimeout:    l.ReadTimeout,
+		WriteTimeout:   l.WriteTimeout,
+		IdleTimeout:    l.IdleTimeout,
+		MaxHeaderBytes: l.MaxHeaderBytes,
+		TLSConfig:      cfg,
 	})
 
 	// tcpproxy creates its own listener from the configuration above so we can
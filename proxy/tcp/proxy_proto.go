@@ -1,7 +1,14 @@
 package tcp
 
 import (
+	"crypto/tls"
 	"net"
+
+	"github.com/fabiolb/fabio/route"
+	"github.com/fabiolb/fabio/uuid"
+
+	proxyproto "github.com/pires/go-proxyproto"
+	"github.com/pires/go-proxyproto/tlvparse"
 )
 
 // WriteProxyHeader extracts remote and local IP address and port
@@ -22,3 +29,112 @@ func WriteProxyHeader(out, in net.Conn) error {
 	_, err := out.Write([]byte(header))
 	return err
 }
+
+// ProxyTLVs contains the details of the client connection which are
+// sent as TLVs with PROXY protocol v2 headers. Empty values are omitted.
+type ProxyTLVs struct {
+	// Authority is the server name which the client sent with SNI.
+	Authority string
+
+	// ALPN is the application protocol negotiated with the client.
+	ALPN string
+
+	// TLS is true if the client connected with TLS. TLSVersion is the
+	// version of the connection. ClientCert is true if the client sent
+	// a certificate and ClientVerified is true if it was verified.
+	// ClientCN is the common name of the client certificate.
+	TLS            bool
+	TLSVersion     string
+	ClientCert     bool
+	ClientVerified bool
+	ClientCN       string
+
+	// UniqueID identifies the connection.
+	UniqueID string
+}
+
+// TLSProxyTLVs returns the TLVs of a TLS connection.
+func TLSProxyTLVs(cs *tls.ConnectionState) ProxyTLVs {
+	v := ProxyTLVs{
+		Authority:      cs.ServerName,
+		ALPN:           cs.NegotiatedProtocol,
+		TLS:            true,
+		TLSVersion:     tls.VersionName(cs.Version),
+		ClientCert:     len(cs.PeerCertificates) > 0,
+		ClientVerified: len(cs.VerifiedChains) > 0,
+	}
+	if v.ClientCert {
+		v.ClientCN = cs.PeerCertificates[0].Subject.CommonName
+	}
+	return v
+}
+
+// ProxyHeader returns the PROXY protocol header of the given version
+// for a connection from src to dst. The TLVs are only sent with v2.
+func ProxyHeader(version byte, src, dst net.Addr, v ProxyTLVs) ([]byte, error) {
+	h := proxyproto.HeaderProxyFromAddrs(version, src, dst)
+	if version == 2 {
+		if err := h.SetTLVs(v.tlvs()); err != nil {
+			return nil, err
+		}
+	}
+	return h.Format()
+}
+
+// appendTLV appends a TLV with the value s if s is not empty.
+func appendTLV(tlvs []proxyproto.TLV, typ proxyproto.PP2Type, s string) []proxyproto.TLV {
+	if s == "" {
+		return tlvs
+	}
+	return append(tlvs, proxyproto.TLV{Type: typ, Value: []byte(s)})
+}
+
+func (v ProxyTLVs) tlvs() []proxyproto.TLV {
+	var tlvs []proxyproto.TLV
+	tlvs = appendTLV(tlvs, proxyproto.PP2_TYPE_ALPN, v.ALPN)
+	tlvs = appendTLV(tlvs, proxyproto.PP2_TYPE_AUTHORITY, v.Authority)
+	tlvs = appendTLV(tlvs, proxyproto.PP2_TYPE_UNIQUE_ID, v.UniqueID)
+
+	if v.TLS {
+		ssl := tlvparse.PP2SSL{Client: tlvparse.PP2_BITFIELD_CLIENT_SSL, Verify: 1}
+		if v.ClientCert {
+			ssl.Client |= tlvparse.PP2_BITFIELD_CLIENT_CERT_CONN
+		}
+		if v.ClientVerified {
+			ssl.Verify = 0
+		}
+		ssl.TLV = appendTLV(ssl.TLV, proxyproto.PP2_SUBTYPE_SSL_VERSION, v.TLSVersion)
+		ssl.TLV = appendTLV(ssl.TLV, proxyproto.PP2_SUBTYPE_SSL_CN, v.ClientCN)
+		if tlv, err := ssl.Marshal(); err == nil {
+			tlvs = append(tlvs, tlv)
+		}
+	}
+	return tlvs
+}
+
+// writeProxyHeader writes the PROXY protocol header of the target to
+// the upstream connection. serverName is the server name of the client
+// hello for tcp+sni connections.
+func writeProxyHeader(out, in net.Conn, t *route.Target, serverName string) error {
+	if !t.ProxyProtoV2 {
+		return WriteProxyHeader(out, in)
+	}
+
+	// the TLS details are known after the handshake
+	cs, err := handshake(in)
+	if err != nil {
+		return err
+	}
+	v := ProxyTLVs{Authority: serverName}
+	if cs != nil {
+		v = TLSProxyTLVs(cs)
+	}
+	v.UniqueID = uuid.NewUUID()
+
+	hdr, err := ProxyHeader(2, in.RemoteAddr(), in.LocalAddr(), v)
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(hdr)
+	return err
+}
@@ -0,0 +1,105 @@
+package tcp
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+
+	proxyproto "github.com/pires/go-proxyproto"
+	"github.com/pires/go-proxyproto/tlvparse"
+)
+
+func TestProxyHeader(t *testing.T) {
+	src := &net.TCPAddr{IP: net.ParseIP("1.2.3.4"), Port: 12345}
+	dst := &net.TCPAddr{IP: net.ParseIP("5.6.7.8"), Port: 443}
+	v := ProxyTLVs{
+		Authority:      "example.com",
+		ALPN:           "h2",
+		TLS:            true,
+		TLSVersion:     "TLS 1.3",
+		ClientCert:     true,
+		ClientVerified: true,
+		ClientCN:       "client",
+		UniqueID:       "abc",
+	}
+
+	t.Run("v1", func(t *testing.T) {
+		hdr, err := ProxyHeader(1, src, dst, v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := string(hdr), "PROXY TCP4 1.2.3.4 5.6.7.8 12345 443\r\n"; got != want {
+			t.Fatalf("got %q want %q", got, want)
+		}
+	})
+
+	t.Run("v2", func(t *testing.T) {
+		hdr, err := ProxyHeader(2, src, dst, v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h, err := proxyproto.Read(bufio.NewReader(bytes.NewReader(hdr)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := h.SourceAddr.String(), src.String(); got != want {
+			t.Fatalf("got source %s want %s", got, want)
+		}
+		if got, want := h.DestinationAddr.String(), dst.String(); got != want {
+			t.Fatalf("got destination %s want %s", got, want)
+		}
+
+		tlvs, err := h.TLVs()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := map[proxyproto.PP2Type]string{}
+		for _, tlv := range tlvs {
+			if tlv.Type != proxyproto.PP2_TYPE_SSL {
+				got[tlv.Type] = string(tlv.Value)
+			}
+		}
+		want := map[proxyproto.PP2Type]string{
+			proxyproto.PP2_TYPE_ALPN:      "h2",
+			proxyproto.PP2_TYPE_AUTHORITY: "example.com",
+			proxyproto.PP2_TYPE_UNIQUE_ID: "abc",
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("got TLVs %v want %v", got, want)
+		}
+
+		ssl, ok := tlvparse.FindSSL(tlvs)
+		if !ok {
+			t.Fatal("got no SSL TLV")
+		}
+		if !ssl.ClientSSL() || !ssl.ClientCertConn() || !ssl.Verified() {
+			t.Fatalf("got SSL flags %x verify %d", ssl.Client, ssl.Verify)
+		}
+		if got, _ := ssl.SSLVersion(); got != "TLS 1.3" {
+			t.Fatalf("got TLS version %q want %q", got, "TLS 1.3")
+		}
+		if got, _ := ssl.ClientCN(); got != "client" {
+			t.Fatalf("got client CN %q want %q", got, "client")
+		}
+	})
+
+	t.Run("v2 without TLS", func(t *testing.T) {
+		hdr, err := ProxyHeader(2, src, dst, ProxyTLVs{UniqueID: "abc"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		h, err := proxyproto.Read(bufio.NewReader(bytes.NewReader(hdr)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tlvs, _ := h.TLVs()
+		if _, ok := tlvparse.FindSSL(tlvs); ok {
+			t.Fatal("got SSL TLV for plain connection")
+		}
+		if got, want := len(tlvs), 1; got != want {
+			t.Fatalf("got %d TLVs want %d", got, want)
+		}
+	})
+}
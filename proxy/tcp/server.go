@@ -156,6 +156,11 @@ func (c *conn) Close() error {
 	return c.c.Close()
 }
 
+// NetConn returns the underlying connection.
+func (c *conn) NetConn() net.Conn {
+	return c.c
+}
+
 func (c *conn) LocalAddr() net.Addr {
 	return c.c.LocalAddr()
 }
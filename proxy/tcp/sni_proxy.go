@@ -104,7 +104,15 @@ func (p *SNIProxy) ServeTCP(in net.Conn) error {
 		return nil
 	}
 
+	if t.RateLimitedTCP(in) {
+		return nil
+	}
+
+	t.Begin()
+	defer t.End(0)
+
 	out, err := net.DialTimeout("tcp", addr, p.DialTimeout)
+	t.ReportResult(err == nil)
 	if err != nil {
 		log.Print("[WARN] tcp+sni: cannot connect to upstream ", addr)
 		if p.ConnFail != nil {
@@ -116,7 +124,7 @@ func (p *SNIProxy) ServeTCP(in net.Conn) error {
 
 	// enable PROXY protocol support on outbound connection
 	if t.ProxyProto {
-		err := WriteProxyHeader(out, in)
+		err := writeProxyHeader(out, in, t, host)
 		if err != nil {
 			log.Print("[WARN] tcp+sni: write proxy protocol header failed. ", err)
 			if p.ConnFail != nil {
@@ -54,7 +54,15 @@ func (p *DynamicProxy) ServeTCP(in net.Conn) error {
 		return nil
 	}
 
+	if t.RateLimitedTCP(in) {
+		return nil
+	}
+
+	t.Begin()
+	defer t.End(0)
+
 	out, err := net.DialTimeout("tcp", addr, p.DialTimeout)
+	t.ReportResult(err == nil)
 	if err != nil {
 		log.Print("[WARN] tcp: cannot connect to upstream ", addr)
 		if p.ConnFail != nil {
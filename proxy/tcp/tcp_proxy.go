@@ -68,7 +68,15 @@ func (p *Proxy) ServeTCP(in net.Conn) error {
 		return nil
 	}
 
+	if t.RateLimitedTCP(in) {
+		return nil
+	}
+
+	t.Begin()
+	defer t.End(0)
+
 	out, err := net.DialTimeout("tcp", addr, p.DialTimeout)
+	t.ReportResult(err == nil)
 	if err != nil {
 		log.Print("[WARN] tcp: cannot connect to upstream ", addr)
 		if p.ConnFail != nil {
@@ -80,7 +88,7 @@ func (p *Proxy) ServeTCP(in net.Conn) error {
 
 	// enable PROXY protocol support on outbound connection
 	if t.ProxyProto {
-		err := WriteProxyHeader(out, in)
+		err := writeProxyHeader(out, in, t, "")
 		if err != nil {
 			log.Print("[WARN] tcp: write proxy protocol header failed. ", err)
 			if p.ConnFail != nil {
@@ -0,0 +1,147 @@
+package tcp
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"time"
+
+	"github.com/fabiolb/fabio/route"
+
+	gkm "github.com/go-kit/kit/metrics"
+)
+
+// TLSProxy forwards the plaintext data of TLS connections which are
+// terminated by the listener to the target of the route for the server
+// name of the connection.
+type TLSProxy struct {
+	// Conn counts the number of connections.
+	Conn gkm.Counter
+
+	// ConnFail counts the failed upstream connection attempts.
+	ConnFail gkm.Counter
+
+	// Noroute counts the failed Lookup() calls.
+	Noroute gkm.Counter
+
+	// Lookup returns a target host for the given server name.
+	// The proxy will panic if this value is nil.
+	Lookup func(host string) *route.Target
+
+	// DialTimeout sets the timeout for establishing the outbound
+	// connection.
+	DialTimeout time.Duration
+}
+
+func (p *TLSProxy) ServeTCP(in net.Conn) error {
+	defer in.Close()
+
+	if p.Conn != nil {
+		p.Conn.Add(1)
+	}
+
+	cs, err := handshake(in)
+	if err == nil && cs == nil {
+		err = errors.New("not a TLS connection")
+	}
+	if err != nil {
+		log.Printf("[DEBUG] tcp+tls: TLS handshake failed (%s)", err)
+		if p.ConnFail != nil {
+			p.ConnFail.Add(1)
+		}
+		return err
+	}
+
+	host := cs.ServerName
+	t := p.Lookup(host)
+	if t == nil {
+		if p.Noroute != nil {
+			p.Noroute.Add(1)
+		}
+		return nil
+	}
+	addr := t.URL.Host
+
+	if t.AccessDeniedTCP(in) {
+		return nil
+	}
+
+	if t.RateLimitedTCP(in) {
+		return nil
+	}
+
+	t.Begin()
+	defer t.End(0)
+
+	out, err := net.DialTimeout("tcp", addr, p.DialTimeout)
+	t.ReportResult(err == nil)
+	if err != nil {
+		log.Print("[WARN] tcp+tls: cannot connect to upstream ", addr)
+		if p.ConnFail != nil {
+			p.ConnFail.Add(1)
+		}
+		return err
+	}
+	defer out.Close()
+
+	// enable PROXY protocol support on outbound connection
+	if t.ProxyProto {
+		err := writeProxyHeader(out, in, t, host)
+		if err != nil {
+			log.Print("[WARN] tcp+tls: write proxy protocol header failed. ", err)
+			if p.ConnFail != nil {
+				p.ConnFail.Add(1)
+			}
+			return err
+		}
+	}
+
+	errc := make(chan error, 2)
+	cp := func(dst io.Writer, src io.Reader, c gkm.Counter) {
+		errc <- copyBuffer(dst, src, c)
+	}
+
+	go cp(in, out, t.RxCounter)
+	go cp(out, in, t.TxCounter)
+	err = <-errc
+	if err != nil && err != io.EOF {
+		log.Print("[WARN]: tcp+tls:  ", err)
+		return err
+	}
+	return nil
+}
+
+// handshake completes the TLS handshake of a connection which was
+// accepted by a TLS listener and returns the connection state. It
+// returns nil if in is not a TLS connection.
+func handshake(in net.Conn) (*tls.ConnectionState, error) {
+	c, ok := tlsConn(in)
+	if !ok {
+		return nil, nil
+	}
+	if cc, ok := in.(*conn); ok && cc.ReadTimeout > 0 {
+		c.SetReadDeadline(time.Now().Add(cc.ReadTimeout))
+	}
+	if err := c.Handshake(); err != nil {
+		return nil, err
+	}
+	cs := c.ConnectionState()
+	return &cs, nil
+}
+
+// tlsConn returns the TLS connection of the client if the listener
+// terminates TLS.
+func tlsConn(c net.Conn) (*tls.Conn, bool) {
+	for {
+		switch cc := c.(type) {
+		case *tls.Conn:
+			return cc, true
+		case interface{ NetConn() net.Conn }:
+			c = cc.NetConn()
+		default:
+			return nil, false
+		}
+	}
+}
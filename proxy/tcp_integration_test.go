@@ -5,6 +5,7 @@ import (
 	"bytes"
 	"crypto/tls"
 	"crypto/x509"
+	"fmt"
 	"net"
 	"net/url"
 	"os"
@@ -18,6 +19,8 @@ import (
 	"github.com/fabiolb/fabio/proxy/tcp"
 	"github.com/fabiolb/fabio/proxy/tcp/tcptest"
 	"github.com/fabiolb/fabio/route"
+
+	proxyproto "github.com/pires/go-proxyproto"
 )
 
 var echoHandler tcp.HandlerFunc = func(c net.Conn) error {
@@ -98,6 +101,49 @@ func TestTCPProxy(t *testing.T) {
 	testRoundtrip(t, out)
 }
 
+// TestTCPProxyRateLimit tests that the TCP proxy refuses connections
+// which exceed the rate limit of the route.
+func TestTCPProxyRateLimit(t *testing.T) {
+	srv := tcptest.NewServer(echoHandler)
+	defer srv.Close()
+
+	// start proxy
+	proxyAddr := "127.0.0.1:57778"
+	go func() {
+		h := &tcp.Proxy{
+			Lookup: func(h string) *route.Target {
+				tbl, _ := route.NewTable(bytes.NewBufferString("route add srv :57778 tcp://" + srv.Addr + ` opts "ratelimit=1/m"`))
+				return tbl.LookupHost(h, route.Picker["rr"])
+			},
+		}
+		l := config.Listen{Addr: proxyAddr}
+		if err := ListenAndServeTCP(l, h, nil); err != nil {
+			t.Log("ListenAndServeTCP: ", err)
+		}
+	}()
+	defer Close()
+
+	// the first connection is proxied
+	out, err := tcptest.NewRetryDialer().Dial("tcp", proxyAddr)
+	if err != nil {
+		t.Fatalf("net.Dial: %#v", err)
+	}
+	defer out.Close()
+	testRoundtrip(t, out)
+
+	// the second connection is closed by the proxy
+	out2, err := net.Dial("tcp", proxyAddr)
+	if err != nil {
+		t.Fatalf("net.Dial: %#v", err)
+	}
+	defer out2.Close()
+	out2.SetDeadline(time.Now().Add(5 * time.Second))
+	out2.Write([]byte("foo\n"))
+	if _, _, err := bufio.NewReader(out2).ReadLine(); err == nil {
+		t.Fatal("connection exceeding the rate limit was not closed")
+	}
+}
+
 // TestTCPProxyWithTLS tests proxying an encrypted TCP connection
 // to an unencrypted upstream TCP server. The proxy terminates the
 // TLS connection.
@@ -390,6 +436,94 @@ func TestTCPSNIProxyWithProxyProto(t *testing.T) {
 	testProxyProto(t, out)
 }
 
+// proxyV2Handler responds with the received line, the client address
+// and the authority and unique id TLVs of the PROXY protocol v2 header.
+var proxyV2Handler tcp.HandlerFunc = func(c net.Conn) error {
+	defer c.Close()
+	line, _, err := bufio.NewReader(c).ReadLine()
+	if err != nil {
+		return err
+	}
+
+	// find the PROXY protocol connection below the TLS connection
+	nc := c
+	for {
+		u, ok := nc.(interface{ NetConn() net.Conn })
+		if !ok {
+			break
+		}
+		nc = u.NetConn()
+	}
+	var authority string
+	var id bool
+	if pc, ok := nc.(*proxyproto.Conn); ok && pc.ProxyHeader() != nil {
+		tlvs, _ := pc.ProxyHeader().TLVs()
+		for _, tlv := range tlvs {
+			switch tlv.Type {
+			case proxyproto.PP2_TYPE_AUTHORITY:
+				authority = string(tlv.Value)
+			case proxyproto.PP2_TYPE_UNIQUE_ID:
+				id = len(tlv.Value) > 0
+			}
+		}
+	}
+
+	str := fmt.Sprintf(" %s authority=%s id=%v", c.RemoteAddr(), authority, id)
+	_, err = c.Write(append(line, []byte(str)...))
+	return err
+}
+
+// TestTCPSNIProxyWithProxyProtoV2 tests that the tcp+sni proxy sends a
+// PROXY protocol v2 header with the server name and a unique id.
+func TestTCPSNIProxyWithProxyProtoV2(t *testing.T) {
+	srv := tcptest.NewTLSServerWithProxyProto(proxyV2Handler)
+	defer srv.Close()
+
+	// start tcp proxy
+	proxyAddr := "127.0.0.1:57778"
+	go func() {
+		h := &tcp.SNIProxy{
+			Lookup: func(string) *route.Target {
+				return &route.Target{URL: &url.URL{Host: srv.Addr}, ProxyProto: true, ProxyProtoV2: true}
+			},
+		}
+		l := config.Listen{Addr: proxyAddr, ProxyProto: true}
+		if err := ListenAndServeTCP(l, h, nil); err != nil {
+			t.Log("ListenAndServeTCP: ", err)
+		}
+	}()
+	defer Close()
+
+	rootCAs := x509.NewCertPool()
+	if ok := rootCAs.AppendCertsFromPEM(internal.LocalhostCert); !ok {
+		t.Fatal("could not parse cert")
+	}
+	cfg := &tls.Config{
+		RootCAs:    rootCAs,
+		ServerName: "example.com",
+	}
+
+	// connect to proxy
+	dialer := tcptest.NewTLSRetryDialer(cfg)
+	dialer.ProxyProto = true
+	out, err := dialer.Dial("tcp", proxyAddr)
+	if err != nil {
+		t.Fatalf("tls.Dial: %#v", err)
+	}
+	defer out.Close()
+
+	if _, err := out.Write([]byte("foo\n")); err != nil {
+		t.Fatal("out.Write: ", err)
+	}
+	line, _, err := bufio.NewReader(out).ReadLine()
+	if err != nil {
+		t.Fatal("readLine: ", err)
+	}
+	if got, want := string(line), "foo 1.2.3.4:12345 authority=example.com id=true"; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+}
+
 func testProxyProto(t *testing.T, c net.Conn) {
 	// send data to server
 	_, err := c.Write([]byte("foo\n"))
@@ -2,14 +2,19 @@ package proxy
 
 import (
 	"bytes"
-	gkm "github.com/go-kit/kit/metrics"
+	"encoding/binary"
 	"io"
 	"log"
 	"net"
 	"net/http"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/fabiolb/fabio/route"
+
+	gkm "github.com/go-kit/kit/metrics"
 )
 
 // conns keeps track of the number of open ws connections
@@ -17,12 +22,37 @@ var conns int64
 
 type dialFunc func(network, address string) (net.Conn, error)
 
+// wsCloseAbnormal is the close code of sessions which
+// ended without a close frame.
+const wsCloseAbnormal = 1006
+
+// wsSession contains the result of a websocket session.
+type wsSession struct {
+	// upgraded is true if the handshake was successful.
+	upgraded bool
+
+	// closeCode is the status code of the first close frame
+	// or 1006 if the session ended without a close frame.
+	closeCode int
+
+	// rx and tx are the bytes and frames which were received
+	// from and sent to the client after the handshake.
+	rx, tx wsFrameCounter
+}
+
 // newWSHandler returns an HTTP handler which forwards data between
 // an incoming and outgoing websocket connection. It checks whether
 // the handshake was completed successfully before forwarding data
-// between the client and server.
-func newWSHandler(host string, dial dialFunc, conn gkm.Gauge) http.Handler {
+// between the client and server. The result of the session is
+// recorded in s. t is the target of the upstream connection.
+func newWSHandler(host string, dial dialFunc, conn gkm.Gauge, t *route.Target, s *wsSession) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if t.WS != nil && !t.WS.AllowOrigin(r.Header.Get("Origin")) {
+			log.Printf("[INFO] WS origin %q not allowed for %s", r.Header.Get("Origin"), r.URL)
+			http.Error(w, "origin not allowed", http.StatusForbidden)
+			return
+		}
+
 		if conn != nil {
 			conn.Set(float64(atomic.AddInt64(&conns, 1)))
 			defer func() {
@@ -93,17 +123,229 @@ func newWSHandler(host string, dial dialFunc, conn gkm.Gauge) http.Handler {
 
 		out.SetReadDeadline(time.Time{})
 
+		s.upgraded = true
+		var closeCode atomic.Int64
+		s.rx.closeCode, s.tx.closeCode = &closeCode, &closeCode
+
+		// the server may have sent frames together with the handshake
+		if i := bytes.Index(b, []byte("\r\n\r\n")); i >= 0 {
+			s.tx.count(b[i+4:])
+		}
+
+		// closing both connections stops the copy goroutines
+		var closeOnce sync.Once
+		closeConns := func(reason string) {
+			closeOnce.Do(func() {
+				if reason != "" {
+					log.Printf("[INFO] WS %s for %s", reason, r.URL)
+				}
+				in.Close()
+				out.Close()
+			})
+		}
+
+		var lastActive atomic.Int64
+		lastActive.Store(time.Now().UnixNano())
+		s.rx.active = &lastActive
+		s.tx.active = &lastActive
+
+		if p := t.WS; p != nil && p.IdleTimeout > 0 {
+			var idle *time.Timer
+			idle = time.AfterFunc(p.IdleTimeout, func() {
+				// re-arm the timer if there was traffic in the meantime
+				if d := time.Since(time.Unix(0, lastActive.Load())); d < p.IdleTimeout {
+					idle.Reset(p.IdleTimeout - d)
+					return
+				}
+				closeConns("idle timeout")
+			})
+			defer idle.Stop()
+		}
+		if p := t.WS; p != nil && p.MaxLifetime > 0 {
+			maxLifetime := time.AfterFunc(p.MaxLifetime, func() { closeConns("max lifetime reached") })
+			defer maxLifetime.Stop()
+		}
+
 		errc := make(chan error, 2)
-		cp := func(dst io.Writer, src io.Reader) {
-			_, err := io.Copy(dst, src)
+		cp := func(dst io.Writer, src io.Reader, c *wsFrameCounter) {
+			_, err := io.Copy(&wsFrameWriter{w: dst, c: c}, src)
 			errc <- err
 		}
 
-		go cp(out, in)
-		go cp(in, out)
+		go cp(out, in, &s.rx)
+		go cp(in, out, &s.tx)
 		err = <-errc
-		if err != nil && err != io.EOF {
+		if err != nil && err != io.EOF && closeCode.Load() == 0 {
 			log.Printf("[INFO] WS error for %s. %s", r.URL, err)
 		}
+		closeConns("")
+		<-errc
+
+		s.closeCode = int(closeCode.Load())
+		if s.closeCode == 0 {
+			s.closeCode = wsCloseAbnormal
+		}
 	})
 }
+
+// observeWSSession updates the websocket metrics of the target.
+func observeWSSession(t *route.Target, s *wsSession, dur time.Duration) {
+	if t.WSRxCounter != nil {
+		t.WSRxCounter.Add(float64(s.rx.bytes))
+	}
+	if t.WSTxCounter != nil {
+		t.WSTxCounter.Add(float64(s.tx.bytes))
+	}
+	if t.WSRxFrames != nil {
+		t.WSRxFrames.Add(float64(s.rx.frames))
+	}
+	if t.WSTxFrames != nil {
+		t.WSTxFrames.Add(float64(s.tx.frames))
+	}
+	if t.WSDuration != nil {
+		t.WSDuration.Observe(dur.Seconds())
+	}
+}
+
+// wsCloseCode returns the close code of the websocket session
+// or 0 if s is nil or the handshake failed.
+func wsCloseCode(s *wsSession) int {
+	if s == nil || !s.upgraded {
+		return 0
+	}
+	return s.closeCode
+}
+
+// wsFrameWriter counts the websocket frames which are written to w.
+type wsFrameWriter struct {
+	w io.Writer
+	c *wsFrameCounter
+}
+
+func (f *wsFrameWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	f.c.count(p[:n])
+	return n, err
+}
+
+// wsFrameCounter parses a stream of websocket frames to count the
+// bytes and frames. It records the status code of the first close
+// frame of the session in closeCode.
+type wsFrameCounter struct {
+	bytes  int64
+	frames int64
+
+	// closeCode is shared by both directions of the session.
+	closeCode *atomic.Int64
+
+	// active is set to the time of the last data.
+	active *atomic.Int64
+
+	// hdr contains the partial header of the next frame.
+	hdr []byte
+
+	// remaining is the number of payload bytes of the current frame
+	// which have not been read and pos is the number of read bytes.
+	remaining, pos uint64
+
+	// closing is true if the current frame is a close frame.
+	closing bool
+	masked  bool
+	mask    [4]byte
+	code    []byte
+}
+
+func (c *wsFrameCounter) count(p []byte) {
+	if len(p) == 0 {
+		return
+	}
+	c.bytes += int64(len(p))
+	if c.active != nil {
+		c.active.Store(time.Now().UnixNano())
+	}
+
+	for len(p) > 0 {
+		if c.remaining > 0 {
+			n := min(uint64(len(p)), c.remaining)
+			if c.closing {
+				for i := uint64(0); i < n && len(c.code) < 2; i++ {
+					b := p[i]
+					if c.masked {
+						b ^= c.mask[(c.pos+i)%4]
+					}
+					c.code = append(c.code, b)
+				}
+			}
+			c.pos += n
+			c.remaining -= n
+			p = p[n:]
+			if c.remaining == 0 {
+				c.endFrame()
+			}
+			continue
+		}
+
+		c.hdr = append(c.hdr, p[0])
+		p = p[1:]
+		if !c.parseHeader() {
+			continue
+		}
+		if c.remaining == 0 {
+			c.endFrame()
+		}
+	}
+}
+
+// parseHeader returns true if hdr contains a complete frame header
+// and sets the state for the payload of the frame.
+func (c *wsFrameCounter) parseHeader() bool {
+	if len(c.hdr) < 2 {
+		return false
+	}
+	size, ext := uint64(c.hdr[1]&0x7f), 0
+	switch size {
+	case 126:
+		ext = 2
+	case 127:
+		ext = 8
+	}
+	masked := c.hdr[1]&0x80 != 0
+	need := 2 + ext
+	if masked {
+		need += 4
+	}
+	if len(c.hdr) < need {
+		return false
+	}
+
+	switch ext {
+	case 2:
+		size = uint64(binary.BigEndian.Uint16(c.hdr[2:4]))
+	case 8:
+		size = binary.BigEndian.Uint64(c.hdr[2:10])
+	}
+	c.masked = masked
+	if masked {
+		copy(c.mask[:], c.hdr[2+ext:need])
+	}
+	c.closing = c.hdr[0]&0x0f == 0x8
+	c.remaining, c.pos = size, 0
+	c.code = c.code[:0]
+	c.hdr = c.hdr[:0]
+	c.frames++
+	return true
+}
+
+// endFrame records the status code of a close frame. Close
+// frames without status code are recorded as 1005.
+func (c *wsFrameCounter) endFrame() {
+	if !c.closing || c.closeCode == nil {
+		return
+	}
+	code := int64(1005)
+	if len(c.code) == 2 {
+		code = int64(binary.BigEndian.Uint16(c.code))
+	}
+	c.closeCode.CompareAndSwap(0, code)
+	c.closing = false
+}
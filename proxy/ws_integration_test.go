@@ -7,9 +7,13 @@ import (
 	"net/http/httptest"
 	"regexp"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/fabiolb/fabio/config"
+	"github.com/fabiolb/fabio/logger"
 	"github.com/fabiolb/fabio/route"
 
 	"golang.org/x/net/websocket"
@@ -93,6 +97,131 @@ func TestProxyWSUpstream(t *testing.T) {
 	t.Run("ws-ws via http proxy with strip & prepend", func(t *testing.T) { testWSEcho(t, "ws://"+httpProxyURL+"/old/world", nil) })
 }
 
+func TestProxyWSSession(t *testing.T) {
+	wsServer := httptest.NewServer(websocket.Handler(wsEchoHandler))
+	defer wsServer.Close()
+
+	var logs lockedBuffer
+	l, err := logger.New(&logs, "$response_status $ws_close_code")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	routes := "route add ws /ws " + wsServer.URL + ` opts "ws.origins=http://localhost ws.idletimeout=200ms"`
+	proxy := httptest.NewServer(&HTTPProxy{
+		Transport: http.DefaultTransport,
+		Logger:    l,
+		Lookup: func(r *http.Request) *route.Target {
+			tbl, _ := route.NewTable(bytes.NewBufferString(routes))
+			return tbl.Lookup(r, route.Picker["rr"], route.Matcher["prefix"], globCache, globEnabled)
+		},
+	})
+	defer proxy.Close()
+	url := "ws://" + proxy.URL[len("http://"):] + "/ws"
+
+	waitLog := func(t *testing.T, want string) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for logs.String() != want && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if got := logs.String(); got != want {
+			t.Fatalf("got log %q want %q", got, want)
+		}
+		logs.Reset()
+	}
+
+	t.Run("origin not allowed", func(t *testing.T) {
+		if _, err := websocket.Dial(url, "", "http://example.com"); err == nil {
+			t.Fatal("got nil want error")
+		}
+		waitLog(t, "403 \n")
+	})
+
+	t.Run("close", func(t *testing.T) {
+		ws, err := websocket.Dial(url, "", "http://localhost")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ws.Write([]byte("foo")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ws.Read(make([]byte, 10)); err != nil {
+			t.Fatal(err)
+		}
+		ws.Close()
+		waitLog(t, "101 1000\n")
+	})
+
+	t.Run("idle timeout", func(t *testing.T) {
+		ws, err := websocket.Dial(url, "", "http://localhost")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ws.Close()
+		start := time.Now()
+		if _, err := ws.Read(make([]byte, 10)); err == nil {
+			t.Fatal("got nil want error")
+		}
+		if d := time.Since(start); d < 200*time.Millisecond {
+			t.Fatalf("got session closed after %s want 200ms", d)
+		}
+		waitLog(t, "101 1006\n")
+	})
+}
+
+func TestWSFrameCounter(t *testing.T) {
+	var closeCode atomic.Int64
+	c := &wsFrameCounter{closeCode: &closeCode}
+
+	// unmasked text frame with a 126 byte payload
+	text := append([]byte{0x81, 126, 0, 126}, bytes.Repeat([]byte("a"), 126)...)
+	// masked close frame with status 1001
+	mask := []byte{1, 2, 3, 4}
+	closeFrame := append([]byte{0x88, 0x82}, mask...)
+	closeFrame = append(closeFrame, 0x03^mask[0], 0xe9^mask[1])
+
+	stream := append(text, closeFrame...)
+	// feed the stream in small chunks to split headers and payloads
+	for i := 0; i < len(stream); i += 3 {
+		c.count(stream[i:min(i+3, len(stream))])
+	}
+
+	if got, want := c.frames, int64(2); got != want {
+		t.Fatalf("got %d frames want %d", got, want)
+	}
+	if got, want := c.bytes, int64(len(stream)); got != want {
+		t.Fatalf("got %d bytes want %d", got, want)
+	}
+	if got, want := closeCode.Load(), int64(1001); got != want {
+		t.Fatalf("got close code %d want %d", got, want)
+	}
+}
+
+// lockedBuffer is a bytes.Buffer which is safe for concurrent use.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func (b *lockedBuffer) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf.Reset()
+}
+
 func testWSEcho(t *testing.T, url string, hdr http.Header) {
 	cfg, err := websocket.NewConfig(url, "http://localhost/")
 	if err != nil {
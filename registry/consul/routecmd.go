@@ -57,7 +57,7 @@ func (r routecmd) build() []string {
 			dst := "http://" + addr + "/"
 
 			var weight string
-			var ropts []string
+			var match, ropts []string
 			for o := range strings.FieldsSeq(opts) {
 				switch {
 				case o == "proto=tcp":
@@ -75,6 +75,9 @@ func (r routecmd) build() []string {
 				case strings.HasPrefix(o, "weight="):
 					weight = o[len("weight="):]
 
+				case strings.HasPrefix(o, "match="):
+					match = append(match, o[len("match="):])
+
 				case strings.HasPrefix(o, "redirect="):
 					redir := strings.Split(o[len("redirect="):], ",")
 					if len(redir) == 2 {
@@ -93,6 +96,9 @@ func (r routecmd) build() []string {
 			if weight != "" {
 				cfg += " weight " + weight
 			}
+			if len(match) > 0 {
+				cfg += " match " + strconv.Quote(strings.Join(match, " "))
+			}
 			if len(svctags) > 0 {
 				cfg += " tags " + strconv.Quote(strings.Join(svctags, ","))
 			}
@@ -90,6 +90,21 @@ func TestRouteCmd(t *testing.T) {
 				`route add svc-1 :1234 tcp://1.1.1.1:2222`,
 			},
 		},
+		{
+			name: "http with match conditions",
+			r: routecmd{
+				prefix: "p-",
+				svc: &api.CatalogService{
+					ServiceName:    "svc-1",
+					ServiceAddress: "1.1.1.1",
+					ServicePort:    2222,
+					ServiceTags:    []string{`p-foo/bar match=header:X-Canary=true match=method:GET strip=/foo`},
+				},
+			},
+			cfg: []string{
+				`route add svc-1 foo/bar http://1.1.1.1:2222/ match "header:X-Canary=true method:GET" opts "strip=/foo"`,
+			},
+		},
 	}
 
 	for _, c := range cases {
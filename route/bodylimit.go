@@ -0,0 +1,40 @@
+package route
+
+import (
+	"fmt"
+
+	"github.com/fabiolb/fabio/config"
+)
+
+// parseBodyLimits returns the maximum size of the request and response
+// bodies of the route. It returns zero if an option is not set and the
+// global limit applies. It is configured with the following options:
+//
+//	maxbody=10MB           maximum size of request bodies
+//	maxresponsebody=100MB  maximum size of response bodies
+//
+// A value of 0 disables the global limit for the route and is
+// returned as a negative limit.
+func parseBodyLimits(opts map[string]string) (maxBody, maxResponseBody int64, err error) {
+	parse := func(name string) (int64, error) {
+		v := opts[name]
+		if v == "" {
+			return 0, nil
+		}
+		n, err := config.ParseSize(v)
+		if err != nil {
+			return 0, fmt.Errorf("route: invalid %s %q", name, v)
+		}
+		if n == 0 {
+			return -1, nil
+		}
+		return n, nil
+	}
+	if maxBody, err = parse("maxbody"); err != nil {
+		return 0, 0, err
+	}
+	if maxResponseBody, err = parse("maxresponsebody"); err != nil {
+		return 0, 0, err
+	}
+	return maxBody, maxResponseBody, nil
+}
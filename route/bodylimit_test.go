@@ -0,0 +1,31 @@
+package route
+
+import "testing"
+
+func TestParseBodyLimits(t *testing.T) {
+	tests := []struct {
+		desc                     string
+		opts                     map[string]string
+		maxBody, maxResponseBody int64
+		err                      bool
+	}{
+		{"no options", map[string]string{}, 0, 0, false},
+		{"maxbody", map[string]string{"maxbody": "10MB"}, 10 << 20, 0, false},
+		{"maxresponsebody", map[string]string{"maxresponsebody": "1GB"}, 0, 1 << 30, false},
+		{"disabled", map[string]string{"maxbody": "0", "maxresponsebody": "0"}, -1, -1, false},
+		{"invalid maxbody", map[string]string{"maxbody": "10XB"}, 0, 0, true},
+		{"invalid maxresponsebody", map[string]string{"maxresponsebody": "-1"}, 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			maxBody, maxResponseBody, err := parseBodyLimits(tt.opts)
+			if got, want := err != nil, tt.err; got != want {
+				t.Fatalf("got error %v want error %v", err, want)
+			}
+			if maxBody != tt.maxBody || maxResponseBody != tt.maxResponseBody {
+				t.Fatalf("got %d, %d want %d, %d", maxBody, maxResponseBody, tt.maxBody, tt.maxResponseBody)
+			}
+		})
+	}
+}
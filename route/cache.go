@@ -0,0 +1,33 @@
+package route
+
+import (
+	"fmt"
+	"time"
+)
+
+// parseCache returns whether responses of the route are cached and the
+// freshness lifetime of responses without Cache-Control or Expires
+// header. It is configured with the following options:
+//
+//	cache=true       cache the responses of GET requests
+//	cache_ttl=30s    lifetime of responses without explicit lifetime
+func parseCache(opts map[string]string) (bool, time.Duration, error) {
+	var enabled bool
+	switch v := opts["cache"]; v {
+	case "", "false":
+	case "true":
+		enabled = true
+	default:
+		return false, 0, fmt.Errorf("route: invalid cache %q", v)
+	}
+
+	var ttl time.Duration
+	if v := opts["cache_ttl"]; v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return false, 0, fmt.Errorf("route: invalid cache_ttl %q", v)
+		}
+		ttl = d
+	}
+	return enabled, ttl, nil
+}
@@ -0,0 +1,36 @@
+package route
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCache(t *testing.T) {
+	tests := []struct {
+		desc    string
+		opts    map[string]string
+		enabled bool
+		ttl     time.Duration
+		err     bool
+	}{
+		{"no options", map[string]string{}, false, 0, false},
+		{"enabled", map[string]string{"cache": "true"}, true, 0, false},
+		{"disabled", map[string]string{"cache": "false"}, false, 0, false},
+		{"ttl", map[string]string{"cache": "true", "cache_ttl": "30s"}, true, 30 * time.Second, false},
+		{"invalid cache", map[string]string{"cache": "yes"}, false, 0, true},
+		{"invalid ttl", map[string]string{"cache": "true", "cache_ttl": "30"}, false, 0, true},
+		{"negative ttl", map[string]string{"cache": "true", "cache_ttl": "-1s"}, false, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			enabled, ttl, err := parseCache(tt.opts)
+			if got, want := err != nil, tt.err; got != want {
+				t.Fatalf("got error %v want error %v", err, want)
+			}
+			if enabled != tt.enabled || ttl != tt.ttl {
+				t.Fatalf("got %v, %s want %v, %s", enabled, ttl, tt.enabled, tt.ttl)
+			}
+		})
+	}
+}
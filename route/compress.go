@@ -0,0 +1,41 @@
+package route
+
+import (
+	"fmt"
+	"strings"
+)
+
+// parseCompress returns the value of the 'compress' option which enables
+// or disables the compression of responses for the route:
+//
+//	compress=on         compress responses with the proxy.compress.encodings
+//	compress=off        do not compress responses
+//	compress=br,gzip    compress responses with brotli or gzip
+//
+// An empty value uses the proxy.gzip.contenttype setting.
+func parseCompress(opts map[string]string) (string, error) {
+	v := opts["compress"]
+	switch v {
+	case "", "on", "off":
+		return v, nil
+	}
+	encodings, err := parseEncodings("compress", v)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(encodings, ","), nil
+}
+
+// parseEncodings parses a comma separated list of content encodings.
+func parseEncodings(opt, v string) ([]string, error) {
+	var encodings []string
+	for e := range strings.SplitSeq(v, ",") {
+		switch e = strings.TrimSpace(e); e {
+		case "zstd", "br", "gzip":
+			encodings = append(encodings, e)
+		default:
+			return nil, fmt.Errorf("route: invalid %s encoding %q", opt, e)
+		}
+	}
+	return encodings, nil
+}
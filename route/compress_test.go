@@ -0,0 +1,30 @@
+package route
+
+import "testing"
+
+func TestParseCompress(t *testing.T) {
+	tests := []struct {
+		in, out string
+		err     bool
+	}{
+		{"", "", false},
+		{"on", "on", false},
+		{"off", "off", false},
+		{"br, gzip", "br,gzip", false},
+		{"zstd", "zstd", false},
+		{"deflate", "", true},
+		{"true", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			out, err := parseCompress(map[string]string{"compress": tt.in})
+			if got, want := err != nil, tt.err; got != want {
+				t.Fatalf("got error %v want error %v", err, want)
+			}
+			if got, want := out, tt.out; got != want {
+				t.Fatalf("got %q want %q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package route
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/fabiolb/fabio/transport"
+)
+
+var (
+	h2cTransportOnce sync.Once
+	h2cTransportPool *http.Transport
+)
+
+// h2cTransport returns the shared transport for targets with the
+// 'proto=h2c' option. Sharing the transport keeps the multiplexed
+// connections open across routing table updates.
+func h2cTransport() *http.Transport {
+	h2cTransportOnce.Do(func() {
+		h2cTransportPool = transport.NewH2CTransport()
+	})
+	return h2cTransportPool
+}
@@ -0,0 +1,32 @@
+package route
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestH2CTransport(t *testing.T) {
+	tbl, err := NewTable(bytes.NewBufferString(`
+		route add a a.com/ http://1.2.3.4/ opts "proto=h2c"
+		route add b b.com/ http://1.2.3.5/ opts "proto=h2c"
+		route add c c.com/ https://1.2.3.6/ opts "proto=h2c"
+		route add d d.com/ http://1.2.3.7/`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := func(host string) *Target { return tbl[host][0].Targets[0] }
+
+	tr := target("a.com").Transport
+	if tr == nil || tr.Protocols == nil || !tr.Protocols.UnencryptedHTTP2() {
+		t.Fatal("got no h2c transport for proto=h2c")
+	}
+	if target("b.com").Transport != tr {
+		t.Fatal("got different h2c transports want shared transport")
+	}
+	if target("c.com").Transport != nil {
+		t.Fatal("got h2c transport for https upstream")
+	}
+	if target("d.com").Transport != nil {
+		t.Fatal("got h2c transport without proto=h2c")
+	}
+}
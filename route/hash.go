@@ -0,0 +1,163 @@
+package route
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"net"
+	"net/http"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// hashPointsPerTarget is the number of points a target with an average
+// weight gets on the hash ring. More points distribute the keys more
+// evenly at the cost of a larger ring.
+const hashPointsPerTarget = 100
+
+// hashSource describes which part of the request is used as the key
+// for consistent hashing. It is configured with the 'hash' option:
+//
+//	hash=ip              client ip address
+//	hash=header:<name>   value of request header <name>
+//	hash=cookie:<name>   value of cookie <name>
+type hashSource struct {
+	kind string
+	name string
+}
+
+func parseHashSource(s string) (*hashSource, error) {
+	kind, name, _ := strings.Cut(s, ":")
+	switch kind {
+	case "ip":
+		if name != "" {
+			return nil, fmt.Errorf("route: invalid hash source %q", s)
+		}
+		return &hashSource{kind: kind}, nil
+	case "header":
+		if name == "" {
+			return nil, fmt.Errorf("route: hash source %q requires a header name", s)
+		}
+		return &hashSource{kind: kind, name: textproto.CanonicalMIMEHeaderKey(name)}, nil
+	case "cookie":
+		if name == "" {
+			return nil, fmt.Errorf("route: hash source %q requires a cookie name", s)
+		}
+		return &hashSource{kind: kind, name: name}, nil
+	default:
+		return nil, fmt.Errorf("route: invalid hash source %q", s)
+	}
+}
+
+// key returns the hash key for the request and whether it was found.
+func (s *hashSource) key(req *http.Request) (string, bool) {
+	if req == nil {
+		return "", false
+	}
+	switch s.kind {
+	case "ip":
+		host, _, err := net.SplitHostPort(req.RemoteAddr)
+		if err != nil {
+			host = req.RemoteAddr
+		}
+		return host, host != ""
+	case "header":
+		v := req.Header.Get(s.name)
+		return v, v != ""
+	case "cookie":
+		c, err := req.Cookie(s.name)
+		if err != nil || c.Value == "" {
+			return "", false
+		}
+		return c.Value, true
+	}
+	return "", false
+}
+
+// hashRing distributes the targets of a route on a consistent hash ring.
+// Every target gets a number of points proportional to its weight so that
+// adding or removing a target only moves the keys between the neighbors of
+// its points.
+type hashRing struct {
+	points  []uint64
+	targets []*Target
+}
+
+// newHashRing returns the hash ring for the healthy targets of the route.
+// The number of points of a target does not depend on the health of the
+// other targets so that only the keys of failing targets move.
+func newHashRing(r *Route) *hashRing {
+	ring := &hashRing{}
+	for _, t := range r.Targets {
+		if t.Weight <= 0 || !r.isUp(t) {
+			continue
+		}
+		n := int(math.Round(t.Weight * float64(len(r.Targets)) * hashPointsPerTarget))
+		if n == 0 {
+			n = 1
+		}
+		id := t.Service + "\x00" + t.URL.String()
+		for i := range n {
+			ring.points = append(ring.points, hashKey(id+"\x00"+strconv.Itoa(i)))
+			ring.targets = append(ring.targets, t)
+		}
+	}
+	sort.Sort(ring)
+	return ring
+}
+
+func (r *hashRing) Len() int           { return len(r.points) }
+func (r *hashRing) Less(i, j int) bool { return r.points[i] < r.points[j] }
+func (r *hashRing) Swap(i, j int) {
+	r.points[i], r.points[j] = r.points[j], r.points[i]
+	r.targets[i], r.targets[j] = r.targets[j], r.targets[i]
+}
+
+// get returns the target owning the first point
+// on the ring at or after the hash of the key.
+func (r *hashRing) get(key string) *Target {
+	if len(r.points) == 0 {
+		return nil
+	}
+	h := hashKey(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.targets[i]
+}
+
+// hashKey returns the 64-bit FNV-1a hash of s with a
+// final avalanche step to spread similar keys on the ring.
+func hashKey(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// hashPick returns the target for the request on the hash ring of the
+// route or nil if the route does not use consistent hashing or the
+// request does not have a hash key.
+func (r *Route) hashPick(req *http.Request) *Target {
+	if r.hash == nil {
+		return nil
+	}
+	key, ok := r.hash.key(req)
+	if !ok {
+		return nil
+	}
+	ring := r.ring.Load()
+	if ring == nil {
+		ring = newHashRing(r)
+		r.ring.Store(ring)
+	}
+	return ring.get(key)
+}
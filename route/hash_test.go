@@ -0,0 +1,167 @@
+package route
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestParseHashSource(t *testing.T) {
+	tests := []struct {
+		in  string
+		out *hashSource
+		err bool
+	}{
+		{"ip", &hashSource{kind: "ip"}, false},
+		{"header:x-user-id", &hashSource{kind: "header", name: "X-User-Id"}, false},
+		{"cookie:SESSION", &hashSource{kind: "cookie", name: "SESSION"}, false},
+		{"", nil, true},
+		{"ip:foo", nil, true},
+		{"header", nil, true},
+		{"cookie:", nil, true},
+		{"path", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseHashSource(tt.in)
+			if gotErr := err != nil; gotErr != tt.err {
+				t.Fatalf("got error %v want error %v", err, tt.err)
+			}
+			if !reflect.DeepEqual(got, tt.out) {
+				t.Fatalf("got %#v want %#v", got, tt.out)
+			}
+		})
+	}
+}
+
+func TestHashSourceKey(t *testing.T) {
+	req := &http.Request{
+		RemoteAddr: "1.2.3.4:5678",
+		Header: http.Header{
+			"X-User": []string{"alice"},
+			"Cookie": []string{"SESSION=abc; other=def"},
+		},
+	}
+
+	tests := []struct {
+		src string
+		key string
+		ok  bool
+	}{
+		{"ip", "1.2.3.4", true},
+		{"header:x-user", "alice", true},
+		{"header:x-missing", "", false},
+		{"cookie:SESSION", "abc", true},
+		{"cookie:missing", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			s, err := parseHashSource(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			key, ok := s.key(req)
+			if key != tt.key || ok != tt.ok {
+				t.Fatalf("got %q, %v want %q, %v", key, ok, tt.key, tt.ok)
+			}
+		})
+	}
+}
+
+func hashTestRoute(n int) *Route {
+	r := &Route{Host: "www.bar.com", Path: "/hash", hash: &hashSource{kind: "header", name: "X-Key"}}
+	for i := range n {
+		r.addTarget("svc", mustParse(fmt.Sprintf("http://10.0.0.%d:8080/", i)), 0, nil, nil)
+	}
+	return r
+}
+
+func hashTestRequest(key string) *http.Request {
+	return &http.Request{Header: http.Header{"X-Key": []string{key}}}
+}
+
+func TestHashPickStable(t *testing.T) {
+	r := hashTestRoute(5)
+
+	seen := map[*Target]int{}
+	for i := range 1000 {
+		req := hashTestRequest(fmt.Sprintf("key-%d", i))
+		first := r.hashPick(req)
+		if got := r.hashPick(req); got != first {
+			t.Fatalf("key-%d: got %v want %v", i, got.URL, first.URL)
+		}
+		seen[first]++
+	}
+
+	// every target should get a reasonable share of the keys
+	for _, tg := range r.Targets {
+		if n := seen[tg]; n < 100 || n > 300 {
+			t.Errorf("target %s got %d of 1000 keys", tg.URL, n)
+		}
+	}
+}
+
+func TestHashPickMinimalDisruption(t *testing.T) {
+	r := hashTestRoute(5)
+
+	before := map[string]*url.URL{}
+	for i := range 1000 {
+		key := fmt.Sprintf("key-%d", i)
+		before[key] = r.hashPick(hashTestRequest(key)).URL
+	}
+
+	removed := r.Targets[2].URL.String()
+	r.filter(func(t *Target) bool { return t.URL.String() == removed })
+
+	moved := 0
+	for key, u := range before {
+		got := r.hashPick(hashTestRequest(key)).URL
+		if got.String() == removed {
+			t.Fatalf("%s: picked removed target", key)
+		}
+		if u.String() != removed && got.String() != u.String() {
+			moved++
+		}
+	}
+	if moved > 0 {
+		t.Fatalf("%d keys of remaining targets moved", moved)
+	}
+}
+
+func TestTableLookupHash(t *testing.T) {
+	tbl, err := NewTable(bytes.NewBufferString(`
+route add svc / http://foo.com/ opts "hash=cookie:SESSION"
+route add svc / http://bar.com/
+route add svc / http://baz.com/
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := &http.Request{
+		Host:   "www.foo.com",
+		URL:    mustParse("/"),
+		Header: http.Header{"Cookie": []string{"SESSION=12345"}},
+	}
+	first := tbl.Lookup(req, rrPicker, prefixMatcher, globCache, globEnabled)
+	for i := range 10 {
+		if got := tbl.Lookup(req, rrPicker, prefixMatcher, globCache, globEnabled); got != first {
+			t.Fatalf("%d: got %v want %v", i, got.URL, first.URL)
+		}
+	}
+
+	// requests without the cookie use the picker
+	req.Header.Del("Cookie")
+	seen := map[*Target]bool{}
+	for range 3 {
+		seen[tbl.Lookup(req, rrPicker, prefixMatcher, globCache, globEnabled)] = true
+	}
+	if got, want := len(seen), 3; got != want {
+		t.Fatalf("got %d targets want %d", got, want)
+	}
+}
@@ -0,0 +1,69 @@
+package route
+
+import (
+	"fmt"
+	"net/textproto"
+	"strings"
+)
+
+// HeaderValue is a header with a value which can contain variables.
+type HeaderValue struct {
+	Name  string
+	Value string
+}
+
+// HeaderRules describes how the proxy modifies the request or response
+// headers of a target. Headers are removed first, then set and then added.
+// The rules are configured with the following options where <dir> is
+// 'req' for the request and 'resp' for the response headers:
+//
+//	hdr.<dir>.add=Name:value,...  add a value to the header
+//	hdr.<dir>.set=Name:value,...  replace the values of the header
+//	hdr.<dir>.del=Name,...        remove the header
+type HeaderRules struct {
+	Add []HeaderValue
+	Set []HeaderValue
+	Del []string
+}
+
+// parseHeaderRules returns the header rules for the request or response
+// from the route options or nil if there are none. dir is either 'req'
+// or 'resp'.
+func parseHeaderRules(opts map[string]string, dir string) (*HeaderRules, error) {
+	prefix := "hdr." + dir + "."
+	add, set, del := opts[prefix+"add"], opts[prefix+"set"], opts[prefix+"del"]
+	if add == "" && set == "" && del == "" {
+		return nil, nil
+	}
+
+	var err error
+	h := &HeaderRules{}
+	if h.Add, err = parseHeaderValues(prefix+"add", add); err != nil {
+		return nil, err
+	}
+	if h.Set, err = parseHeaderValues(prefix+"set", set); err != nil {
+		return nil, err
+	}
+	for name := range strings.SplitSeq(del, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			h.Del = append(h.Del, textproto.CanonicalMIMEHeaderKey(name))
+		}
+	}
+	return h, nil
+}
+
+// parseHeaderValues parses a comma separated list of 'Name:value' pairs.
+func parseHeaderValues(opt, s string) ([]HeaderValue, error) {
+	var hdrs []HeaderValue
+	for f := range strings.SplitSeq(s, ",") {
+		if f = strings.TrimSpace(f); f == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(f, ":")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("route: invalid header %q in %s", f, opt)
+		}
+		hdrs = append(hdrs, HeaderValue{Name: textproto.CanonicalMIMEHeaderKey(name), Value: value})
+	}
+	return hdrs, nil
+}
@@ -0,0 +1,50 @@
+package route
+
+import (
+	"testing"
+
+	"github.com/pascaldekloe/goe/verify"
+)
+
+func TestParseHeaderRules(t *testing.T) {
+	tests := []struct {
+		desc  string
+		opts  map[string]string
+		dir   string
+		rules *HeaderRules
+		err   bool
+	}{
+		{"no rules", map[string]string{"strip": "/foo"}, "req", nil, false},
+		{"other direction", map[string]string{"hdr.resp.del": "Server"}, "req", nil, false},
+		{
+			"request rules",
+			map[string]string{"hdr.req.add": "x-foo:a,X-Foo:b", "hdr.req.set": "X-Tenant:acme,X-Empty:", "hdr.req.del": "x-secret, Cookie"},
+			"req",
+			&HeaderRules{
+				Add: []HeaderValue{{Name: "X-Foo", Value: "a"}, {Name: "X-Foo", Value: "b"}},
+				Set: []HeaderValue{{Name: "X-Tenant", Value: "acme"}, {Name: "X-Empty", Value: ""}},
+				Del: []string{"X-Secret", "Cookie"},
+			},
+			false,
+		},
+		{
+			"value with colon",
+			map[string]string{"hdr.resp.set": "X-Url:http://foo/$path"},
+			"resp",
+			&HeaderRules{Set: []HeaderValue{{Name: "X-Url", Value: "http://foo/$path"}}},
+			false,
+		},
+		{"missing value", map[string]string{"hdr.req.set": "X-Tenant"}, "req", nil, true},
+		{"missing name", map[string]string{"hdr.req.add": ":acme"}, "req", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			rules, err := parseHeaderRules(tt.opts, tt.dir)
+			if got, want := err != nil, tt.err; got != want {
+				t.Fatalf("got error %v want error %v", err, want)
+			}
+			verify.Values(t, "", rules, tt.rules)
+		})
+	}
+}
@@ -0,0 +1,335 @@
+package route
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fabiolb/fabio/transport"
+)
+
+// Defaults for the active health check options.
+const (
+	defaultCheckInterval = 10 * time.Second
+	defaultCheckTimeout  = 2 * time.Second
+)
+
+// healthCheck configures the active health check of a target.
+// It is set with the following options:
+//
+//	check=/path        path of the health check endpoint
+//	check.interval=10s time between two checks
+//	check.timeout=2s   timeout of a single check
+//	check.status=200   expected status code. Default is any 2xx code.
+type healthCheck struct {
+	Path     string
+	Interval time.Duration
+	Timeout  time.Duration
+	Status   int
+}
+
+// parseHealthCheck returns the health check configuration from the
+// route options or nil if the route has no health check.
+func parseHealthCheck(opts map[string]string) (*healthCheck, error) {
+	if opts["check"] == "" {
+		return nil, nil
+	}
+
+	c := &healthCheck{
+		Path:     opts["check"],
+		Interval: defaultCheckInterval,
+		Timeout:  defaultCheckTimeout,
+	}
+
+	var err error
+	if v := opts["check.interval"]; v != "" {
+		if c.Interval, err = time.ParseDuration(v); err != nil || c.Interval <= 0 {
+			return nil, fmt.Errorf("route: invalid check.interval %q", v)
+		}
+	}
+	if v := opts["check.timeout"]; v != "" {
+		if c.Timeout, err = time.ParseDuration(v); err != nil || c.Timeout <= 0 {
+			return nil, fmt.Errorf("route: invalid check.timeout %q", v)
+		}
+	}
+	if v := opts["check.status"]; v != "" {
+		if c.Status, err = strconv.Atoi(v); err != nil || c.Status < 100 || c.Status > 999 {
+			return nil, fmt.Errorf("route: invalid check.status %q", v)
+		}
+	}
+	if c.Timeout > c.Interval {
+		c.Timeout = c.Interval
+	}
+	return c, nil
+}
+
+// ok returns true if the status code is a passing status code.
+func (c *healthCheck) ok(status int) bool {
+	if c.Status > 0 {
+		return status == c.Status
+	}
+	return status >= 200 && status <= 299
+}
+
+// tableMu serializes updates of the routing table
+// by SetTable and the health checks.
+var tableMu sync.Mutex
+
+// reweighTable replaces the routing table with a copy where the targets
+// are distributed according to their current health. Routes and targets
+// are copied since the active table can be read concurrently.
+func reweighTable() {
+	tableMu.Lock()
+	defer tableMu.Unlock()
+
+	old := GetTable()
+	t := make(Table, len(old))
+	for host, routes := range old {
+		clone := make(Routes, len(routes))
+		for i, r := range routes {
+			clone[i] = r.clone()
+		}
+		t[host] = clone
+	}
+	table.Store(t)
+}
+
+// reweighRoute replaces the routing table with a copy where only the
+// targets of the route k are redistributed. The other routes are shared
+// with the active table.
+func reweighRoute(k routeKey) {
+	tableMu.Lock()
+	defer tableMu.Unlock()
+
+	old := GetTable()
+	routes := old[k.host]
+	for i, r := range routes {
+		if r.key() != k {
+			continue
+		}
+		t := maps.Clone(old)
+		t[k.host] = slices.Clone(routes)
+		t[k.host][i] = r.clone()
+		table.Store(t)
+		return
+	}
+}
+
+// clone returns a copy of the route with copies of its targets.
+func (r *Route) clone() *Route {
+	c := &Route{
+		Glob:   r.Glob,
+		Regexp: r.Regexp,
+		Host:   r.Host,
+		Path:   r.Path,
+		Match:  r.Match,
+		conds:  r.conds,
+		total:  r.total,
+		pick:   r.pick,
+		hash:   r.hash,
+	}
+	for _, t := range r.Targets {
+		tc := *t
+		c.Targets = append(c.Targets, &tc)
+	}
+	c.weighTargets()
+	return c
+}
+
+// checks manages the active health checks.
+var checks = &healthChecker{probes: map[string]*probe{}}
+
+// healthChecker runs the active health checks for
+// all targets in the routing table which have one.
+type healthChecker struct {
+	mu     sync.Mutex
+	probes map[string]*probe
+}
+
+// probe checks the health of a single target until it is stopped.
+type probe struct {
+	check healthCheck
+	url   string
+	host  string
+	stats *targetStats
+	tr    http.RoundTripper
+
+	// mu guards the health of the target against
+	// updates from a probe which is being stopped.
+	mu   sync.Mutex
+	stop chan struct{}
+}
+
+// active returns true if there are running health checks.
+func (hc *healthChecker) active() bool {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	return len(hc.probes) > 0
+}
+
+// sync starts the health checks for the targets in the table
+// and stops the health checks for targets which are gone.
+func (hc *healthChecker) sync(t Table) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	want := map[string]*probe{}
+	for _, routes := range t {
+		for _, r := range routes {
+			for _, tg := range r.Targets {
+				if tg.check == nil || tg.stats == nil {
+					continue
+				}
+				want[r.statsKey(tg.Service, tg.URL.String())] = newProbe(tg)
+			}
+		}
+	}
+
+	for key, p := range hc.probes {
+		if np, ok := want[key]; ok && np.check == p.check && np.url == p.url && np.host == p.host {
+			continue
+		}
+		p.close()
+		delete(hc.probes, key)
+	}
+
+	for key, p := range want {
+		if _, ok := hc.probes[key]; ok {
+			continue
+		}
+		hc.probes[key] = p
+		go p.run()
+	}
+}
+
+func newProbe(t *Target) *probe {
+	u := &url.URL{Scheme: t.URL.Scheme, Host: t.URL.Host}
+	if t.Opts["proto"] == "https" {
+		u.Scheme = "https"
+	}
+	if u.Scheme != "https" {
+		u.Scheme = "http"
+	}
+	u.Path, u.RawQuery, _ = strings.Cut(t.check.Path, "?")
+
+	var host string
+	if t.Host != "dst" {
+		host = t.Host
+	}
+
+	tr := t.Transport
+	if tr == nil {
+		tr = checkTransport(t.TLSSkipVerify)
+	}
+
+	return &probe{
+		check: *t.check,
+		url:   u.String(),
+		host:  host,
+		stats: t.stats,
+		tr:    tr,
+		stop:  make(chan struct{}),
+	}
+}
+
+var (
+	checkTransportOnce     sync.Once
+	checkSecureTransport   *http.Transport
+	checkInsecureTransport *http.Transport
+)
+
+// checkTransport returns the shared transport for the health checks.
+func checkTransport(insecure bool) *http.Transport {
+	checkTransportOnce.Do(func() {
+		checkSecureTransport = transport.NewTransport(nil)
+		checkInsecureTransport = transport.NewTransport(&tls.Config{InsecureSkipVerify: true})
+	})
+	if insecure {
+		return checkInsecureTransport
+	}
+	return checkSecureTransport
+}
+
+func (p *probe) run() {
+	ticker := time.NewTicker(p.check.Interval)
+	defer ticker.Stop()
+
+	for {
+		p.update(p.do())
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// do performs a single health check and returns an error if it failed.
+func (p *probe) do() error {
+	client := &http.Client{
+		Transport: p.tr,
+		Timeout:   p.check.Timeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	req, err := http.NewRequest("GET", p.url, nil)
+	if err != nil {
+		return err
+	}
+	if p.host != "" {
+		req.Host = p.host
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if !p.check.ok(resp.StatusCode) {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// close stops the probe and resets the health of the target.
+func (p *probe) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	close(p.stop)
+	p.stats.unhealthy.Store(false)
+}
+
+// update records the result of a health check and updates
+// the routing table if the health of the target has changed.
+func (p *probe) update(err error) {
+	p.mu.Lock()
+	select {
+	case <-p.stop:
+		p.mu.Unlock()
+		return
+	default:
+	}
+	unhealthy := err != nil
+	changed := p.stats.unhealthy.Swap(unhealthy) != unhealthy
+	p.mu.Unlock()
+
+	if !changed {
+		return
+	}
+	if unhealthy {
+		log.Printf("[WARN] health: %s is unhealthy. %s", p.url, err)
+	} else {
+		log.Printf("[INFO] health: %s is healthy", p.url)
+	}
+	reweighTable()
+}
@@ -0,0 +1,138 @@
+package route
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseHealthCheck(t *testing.T) {
+	tests := []struct {
+		desc string
+		opts map[string]string
+		out  *healthCheck
+		err  bool
+	}{
+		{"no check", map[string]string{"strip": "/foo"}, nil, false},
+		{
+			"defaults",
+			map[string]string{"check": "/health"},
+			&healthCheck{Path: "/health", Interval: defaultCheckInterval, Timeout: defaultCheckTimeout},
+			false,
+		},
+		{
+			"all options",
+			map[string]string{"check": "/health?full=1", "check.interval": "5s", "check.timeout": "1s", "check.status": "204"},
+			&healthCheck{Path: "/health?full=1", Interval: 5 * time.Second, Timeout: time.Second, Status: 204},
+			false,
+		},
+		{
+			"timeout capped by interval",
+			map[string]string{"check": "/health", "check.interval": "1s", "check.timeout": "5s"},
+			&healthCheck{Path: "/health", Interval: time.Second, Timeout: time.Second},
+			false,
+		},
+		{"invalid interval", map[string]string{"check": "/health", "check.interval": "x"}, nil, true},
+		{"negative timeout", map[string]string{"check": "/health", "check.timeout": "-1s"}, nil, true},
+		{"invalid status", map[string]string{"check": "/health", "check.status": "20"}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			got, err := parseHealthCheck(tt.opts)
+			if gotErr := err != nil; gotErr != tt.err {
+				t.Fatalf("got error %v want error %v", err, tt.err)
+			}
+			if !reflect.DeepEqual(got, tt.out) {
+				t.Fatalf("got %#v want %#v", got, tt.out)
+			}
+		})
+	}
+}
+
+func TestHealthCheckWeighTargets(t *testing.T) {
+	var fooDown atomic.Bool
+	foo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" || fooDown.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer foo.Close()
+	bar := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer bar.Close()
+
+	tbl, err := NewTable(bytes.NewBufferString(`
+route add svc /health-check ` + foo.URL + ` opts "check=/health check.interval=10ms"
+route add svc /health-check ` + bar.URL + ` opts "check=/health check.interval=10ms"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetTable(tbl)
+	defer SetTable(make(Table))
+
+	// targets returns the distinct targets which receive traffic
+	// and the health of the foo target.
+	targets := func() (n int, health string) {
+		r := GetTable()[""].find("/health-check", "")
+		m := map[string]bool{}
+		for _, t := range r.wTargets {
+			m[t.URL.String()] = true
+		}
+		for _, t := range r.Targets {
+			if t.URL.String() == foo.URL {
+				health = t.Health()
+			}
+		}
+		return len(m), health
+	}
+
+	waitFor := func(desc string, n int, health string) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			if gotN, gotHealth := targets(); gotN == n && gotHealth == health {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		gotN, gotHealth := targets()
+		t.Fatalf("%s: got %d targets and %q want %d targets and %q", desc, gotN, gotHealth, n, health)
+	}
+
+	waitFor("all healthy", 2, "healthy")
+
+	fooDown.Store(true)
+	waitFor("foo unhealthy", 1, "unhealthy")
+
+	fooDown.Store(false)
+	waitFor("foo recovered", 2, "healthy")
+}
+
+func TestHealthCheckAllDown(t *testing.T) {
+	r := &Route{Host: "www.bar.com", Path: "/all-down"}
+	r.addTarget("svc", fooDotCom, 0, nil, map[string]string{"check": "/health"})
+	r.addTarget("svc", barDotCom, 0, nil, map[string]string{"check": "/health"})
+	defer func() {
+		for _, t := range r.Targets {
+			t.stats.unhealthy.Store(false)
+		}
+	}()
+
+	r.Targets[0].stats.unhealthy.Store(true)
+	r.weighTargets()
+	if got, want := len(r.wTargets), 1; got != want {
+		t.Fatalf("one down: got %d targets want %d", got, want)
+	}
+
+	// fail open if all targets are down
+	r.Targets[1].stats.unhealthy.Store(true)
+	r.weighTargets()
+	if got, want := len(r.wTargets), 2; got != want {
+		t.Fatalf("all down: got %d targets want %d", got, want)
+	}
+}
@@ -0,0 +1,111 @@
+package route
+
+import (
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// matchCond is a condition on the request which must be met in addition
+// to the host and path for a route to match. Conditions are set with the
+// 'match' clause of the 'route add' command:
+//
+//	header:<name>=<value>  request header <name> has the value <value>
+//	header:<name>          request header <name> is present
+//	query:<name>=<value>   query parameter <name> has the value <value>
+//	query:<name>           query parameter <name> is present
+//	method:<m1>,<m2>,...   request method is one of the methods
+type matchCond struct {
+	kind   string
+	name   string
+	value  string
+	exists bool
+	values []string
+}
+
+// parseMatch parses the space separated match conditions and returns them
+// together with their canonical form which identifies the route.
+func parseMatch(s string) ([]matchCond, string, error) {
+	var conds []matchCond
+	var canon []string
+	for f := range strings.FieldsSeq(s) {
+		kind, spec, _ := strings.Cut(f, ":")
+		var c matchCond
+		switch kind {
+		case "header", "query":
+			name, value, hasValue := strings.Cut(spec, "=")
+			if name == "" {
+				return nil, "", fmt.Errorf("route: match condition %q requires a name", f)
+			}
+			if kind == "header" {
+				name = textproto.CanonicalMIMEHeaderKey(name)
+			}
+			c = matchCond{kind: kind, name: name, value: value, exists: !hasValue}
+			if hasValue {
+				canon = append(canon, kind+":"+name+"="+value)
+			} else {
+				canon = append(canon, kind+":"+name)
+			}
+		case "method":
+			if spec == "" {
+				return nil, "", fmt.Errorf("route: match condition %q requires a method", f)
+			}
+			c = matchCond{kind: kind}
+			for m := range strings.SplitSeq(spec, ",") {
+				if m = strings.ToUpper(strings.TrimSpace(m)); m != "" {
+					c.values = append(c.values, m)
+				}
+			}
+			sort.Strings(c.values)
+			c.values = slices.Compact(c.values)
+			canon = append(canon, kind+":"+strings.Join(c.values, ","))
+		default:
+			return nil, "", fmt.Errorf("route: invalid match condition %q", f)
+		}
+		conds = append(conds, c)
+	}
+	sort.Strings(canon)
+	return conds, strings.Join(canon, " "), nil
+}
+
+// match returns true if the request meets the condition.
+func (c *matchCond) match(req *http.Request) bool {
+	switch c.kind {
+	case "header":
+		v, ok := req.Header[c.name]
+		if c.exists {
+			return ok
+		}
+		return slices.Contains(v, c.value)
+	case "query":
+		v, ok := req.URL.Query()[c.name]
+		if c.exists {
+			return ok
+		}
+		return slices.Contains(v, c.value)
+	case "method":
+		return slices.Contains(c.values, req.Method)
+	}
+	return false
+}
+
+// matchRequest returns true if the request meets all match conditions
+// of the route. Routes with conditions never match without a request,
+// e.g. for TCP connections.
+func (r *Route) matchRequest(req *http.Request) bool {
+	if len(r.conds) == 0 {
+		return true
+	}
+	if req == nil {
+		return false
+	}
+	for i := range r.conds {
+		if !r.conds[i].match(req) {
+			return false
+		}
+	}
+	return true
+}
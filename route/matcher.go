@@ -13,6 +13,7 @@ var Matcher = map[string]matcher{
 	"prefix":  prefixMatcher,
 	"glob":    globMatcher,
 	"iprefix": iPrefixMatcher,
+	"regex":   regexMatcher,
 }
 
 // prefixMatcher matches path to the routes' path.
@@ -33,3 +34,10 @@ func iPrefixMatcher(uri string, r *Route) bool {
 	lowerPath := strings.ToLower(r.Path)
 	return strings.HasPrefix(lowerURI, lowerPath)
 }
+
+// regexMatcher matches path to the routes' path as a regular expression
+// which is anchored at the start of the path. Routes with a path which is
+// not a valid regular expression never match.
+func regexMatcher(uri string, r *Route) bool {
+	return r.Regexp != nil && r.Regexp.MatchString(uri)
+}
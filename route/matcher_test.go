@@ -81,3 +81,34 @@ func TestIPrefixMatcher(t *testing.T) {
 		})
 	}
 }
+
+func TestRegexMatcher(t *testing.T) {
+	tests := []struct {
+		uri     string
+		matches bool
+		route   *Route
+	}{
+		// happy flows
+		{uri: "/foo", matches: true, route: &Route{Path: "/foo"}},
+		{uri: "/fools", matches: true, route: &Route{Path: "/foo"}},
+		{uri: "/api/v1/users", matches: true, route: &Route{Path: "/api/v[12]/"}},
+		{uri: "/api/v2/users", matches: true, route: &Route{Path: "/api/v[12]/"}},
+		{uri: "/users/123", matches: true, route: &Route{Path: `/users/\d+$`}},
+
+		// error flows
+		{uri: "/fo", matches: false, route: &Route{Path: "/foo"}},
+		{uri: "/x/foo", matches: false, route: &Route{Path: "/foo"}},
+		{uri: "/api/v3/users", matches: false, route: &Route{Path: "/api/v[12]/"}},
+		{uri: "/users/123/x", matches: false, route: &Route{Path: `/users/\d+$`}},
+		{uri: "/foo(", matches: false, route: &Route{Path: "/foo("}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.uri, func(t *testing.T) {
+			_, tt.route.Regexp, _ = compilePath(tt.route.Path)
+			if got, want := regexMatcher(tt.uri, tt.route), tt.matches; got != want {
+				t.Fatalf("got %v want %v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package route
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"sync/atomic"
+)
+
+// serviceRoutes contains the keys of the routes of every service in the
+// active routing table. It is built by SetTable so that the mirror
+// targets are found without scanning the table.
+var serviceRoutes atomic.Pointer[map[string][]routeKey]
+
+// indexServices returns the keys of the routes of every service in the
+// table.
+func indexServices(t Table) map[string][]routeKey {
+	idx := map[string][]routeKey{}
+	for _, routes := range t {
+		for _, r := range routes {
+			k := r.key()
+			for _, tg := range r.Targets {
+				if keys := idx[tg.Service]; len(keys) == 0 || keys[len(keys)-1] != k {
+					idx[tg.Service] = append(keys, k)
+				}
+			}
+		}
+	}
+	return idx
+}
+
+// parseMirror returns the mirror service and the percentage of mirrored
+// requests from the route options. It is configured with the following
+// options:
+//
+//	mirror=svc     name of the service which receives a copy of the requests
+//	mirror_pct=10  percentage of mirrored requests. Default is 100.
+func parseMirror(opts map[string]string) (string, float64, error) {
+	svc := opts["mirror"]
+	if svc == "" {
+		return "", 0, nil
+	}
+
+	pct := 100.0
+	if v := opts["mirror_pct"]; v != "" {
+		var err error
+		if pct, err = strconv.ParseFloat(v, 64); err != nil || pct < 0 || pct > 100 {
+			return "", 0, fmt.Errorf("route: invalid mirror_pct %q", v)
+		}
+	}
+	return svc, pct, nil
+}
+
+// MirrorTarget returns a random healthy target of the service from the
+// active routing table or nil if the service has no route.
+func MirrorTarget(service string) *Target {
+	idx := serviceRoutes.Load()
+	if idx == nil {
+		return nil
+	}
+	tbl := GetTable()
+	var targets []*Target
+	for _, k := range (*idx)[service] {
+		r := tbl.routeByKey(k)
+		if r == nil {
+			continue
+		}
+		for _, t := range r.Targets {
+			if t.Service != service || !r.isUp(t) || slices.ContainsFunc(targets, func(tt *Target) bool { return tt.URL.String() == t.URL.String() }) {
+				continue
+			}
+			targets = append(targets, t)
+		}
+	}
+	if len(targets) == 0 {
+		return nil
+	}
+	return targets[randIntn(len(targets))]
+}
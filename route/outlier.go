@@ -0,0 +1,133 @@
+package route
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+)
+
+// outlier contains the configuration of the passive outlier detection.
+// It is disabled by default.
+var outlier config.Outlier
+
+// SetOutlierConfig configures the passive outlier detection which ejects
+// targets from the load balancing after too many failed requests.
+// It must be called before the proxies are started.
+func SetOutlierConfig(c config.Outlier) {
+	outlier = c
+}
+
+// outlierEnabled returns true if one of the outlier checks is enabled.
+func outlierEnabled() bool {
+	return outlier.ConsecutiveFailures > 0 || outlier.FailureRate > 0
+}
+
+// outlierStats tracks the failed requests of a target.
+type outlierStats struct {
+	mu sync.Mutex
+
+	// consecutive is the number of failed requests in a row.
+	consecutive int
+
+	// requests and failures count the requests
+	// in the interval which started at window.
+	requests int
+	failures int
+	window   time.Time
+}
+
+// report records the result of a request and returns
+// true if the target should be ejected.
+func (s *outlierStats) report(ok bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t := now()
+	if t.Sub(s.window) > outlier.Interval {
+		s.requests, s.failures, s.window = 0, 0, t
+	}
+
+	s.requests++
+	if ok {
+		s.consecutive = 0
+		return false
+	}
+	s.failures++
+	s.consecutive++
+
+	if outlier.ConsecutiveFailures > 0 && s.consecutive >= outlier.ConsecutiveFailures {
+		return true
+	}
+	if outlier.FailureRate > 0 && s.requests >= outlier.MinRequests && float64(s.failures)/float64(s.requests) >= outlier.FailureRate {
+		return true
+	}
+	return false
+}
+
+// reset clears the failure counters after an ejection.
+func (s *outlierStats) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.consecutive, s.requests, s.failures, s.window = 0, 0, 0, now()
+}
+
+// outlierMu serializes the ejection decisions so that
+// the maximum ejection percentage is not exceeded.
+var outlierMu sync.Mutex
+
+// ReportResult records the result of a request or connection to the target
+// for the passive outlier detection. Failed requests are upstream errors
+// and 5xx responses for HTTP and failed connections for TCP.
+func (t *Target) ReportResult(ok bool) {
+	if t.stats == nil || !outlierEnabled() {
+		return
+	}
+	if t.stats.outlier.report(ok) {
+		t.eject()
+	}
+}
+
+// eject removes the target from the load balancing for the backoff period
+// unless this would exceed the maximum number of ejected targets of the
+// route. With a maximum of zero percent no target is ejected.
+func (t *Target) eject() {
+	s := t.stats
+
+	outlierMu.Lock()
+	if s.ejected.Load() {
+		outlierMu.Unlock()
+		return
+	}
+	if r := GetTable().routeByKey(s.route); r != nil {
+		var ejected int
+		for _, tg := range r.Targets {
+			if tg.stats != nil && tg.stats.ejected.Load() {
+				ejected++
+			}
+		}
+		if (ejected+1)*100 > outlier.MaxEjectionPercent*len(r.Targets) {
+			outlierMu.Unlock()
+			log.Printf("[WARN] outlier: not ejecting %s for route %s%s. Too many ejected targets", t.URL, s.route.host, s.route.path)
+			return
+		}
+	}
+	s.ejected.Store(true)
+	s.outlier.reset()
+	outlierMu.Unlock()
+
+	log.Printf("[WARN] outlier: ejecting %s for route %s%s for %s", t.URL, s.route.host, s.route.path, outlier.Backoff)
+	labels := []string{"service", t.Service, "host", s.route.host, "path", s.route.path, "target", t.URL.String()}
+	counters.ejections.With(labels...).Add(1)
+	counters.ejected.With(labels...).Set(1)
+	reweighRoute(s.route)
+
+	time.AfterFunc(outlier.Backoff, func() {
+		s.outlier.reset()
+		s.ejected.Store(false)
+		counters.ejected.With(labels...).Set(0)
+		log.Printf("[INFO] outlier: returning %s for route %s%s", t.URL, s.route.host, s.route.path)
+		reweighRoute(s.route)
+	})
+}
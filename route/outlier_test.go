@@ -0,0 +1,163 @@
+package route
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+)
+
+func TestOutlierStatsReport(t *testing.T) {
+	prevCfg, prevNow := outlier, now
+	defer func() { outlier, now = prevCfg, prevNow }()
+
+	clock := time.Unix(0, 0)
+	now = func() time.Time { return clock }
+
+	t.Run("consecutive failures", func(t *testing.T) {
+		outlier = config.Outlier{ConsecutiveFailures: 3, Interval: time.Second}
+		var s outlierStats
+		results := []bool{false, false, true, false, false}
+		for i, ok := range results {
+			if s.report(ok) {
+				t.Fatalf("%d: ejected too early", i)
+			}
+		}
+		if !s.report(false) {
+			t.Fatal("not ejected after 3 consecutive failures")
+		}
+	})
+
+	t.Run("failure rate", func(t *testing.T) {
+		outlier = config.Outlier{FailureRate: 0.5, MinRequests: 4, Interval: time.Second}
+		var s outlierStats
+		results := []bool{true, false, true}
+		for i, ok := range results {
+			if s.report(ok) {
+				t.Fatalf("%d: ejected too early", i)
+			}
+		}
+		if !s.report(false) {
+			t.Fatal("not ejected with 50% failures")
+		}
+	})
+
+	t.Run("failure rate interval", func(t *testing.T) {
+		outlier = config.Outlier{FailureRate: 0.5, MinRequests: 4, Interval: time.Second}
+		var s outlierStats
+		for i, ok := range []bool{false, false, true} {
+			if s.report(ok) {
+				t.Fatalf("%d: ejected too early", i)
+			}
+		}
+		// failures of the previous interval do not count
+		clock = clock.Add(2 * time.Second)
+		for i, ok := range []bool{true, true, true, false} {
+			if s.report(ok) {
+				t.Fatalf("%d: ejected after interval", i)
+			}
+		}
+	})
+}
+
+func TestOutlierEject(t *testing.T) {
+	prevCfg := outlier
+	defer func() { outlier = prevCfg }()
+	outlier = config.Outlier{ConsecutiveFailures: 2, Interval: time.Second, Backoff: 100 * time.Millisecond, MaxEjectionPercent: 50}
+
+	tbl, err := NewTable(bytes.NewBufferString(`
+route add svc /outlier http://foo.com/
+route add svc /outlier http://bar.com/
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetTable(tbl)
+	defer SetTable(make(Table))
+
+	current := func() *Route { return GetTable()[""].find("/outlier", "") }
+	target := func(u string) *Target {
+		for _, t := range current().Targets {
+			if t.URL.String() == u {
+				return t
+			}
+		}
+		return nil
+	}
+
+	foo := target("http://foo.com/")
+	foo.ReportResult(false)
+	foo.ReportResult(false)
+
+	if got, want := target("http://foo.com/").Health(), "ejected"; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+	for _, tg := range current().wTargets {
+		if tg.URL.String() == "http://foo.com/" {
+			t.Fatal("ejected target receives traffic")
+		}
+	}
+
+	// the second target cannot be ejected since
+	// this would exceed the maximum ejection percentage
+	bar := target("http://bar.com/")
+	bar.ReportResult(false)
+	bar.ReportResult(false)
+	if got, want := target("http://bar.com/").Health(), ""; got != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+
+	// the ejected target returns after the backoff period
+	deadline := time.Now().Add(5 * time.Second)
+	for target("http://foo.com/").Health() == "ejected" {
+		if time.Now().After(deadline) {
+			t.Fatal("ejected target did not return")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got, want := len(current().wTargets), 2; got != want {
+		t.Fatalf("got %d targets want %d", got, want)
+	}
+}
+
+func TestOutlierEjectMaxPercent(t *testing.T) {
+	prevCfg := outlier
+	defer func() { outlier = prevCfg }()
+
+	tests := []struct {
+		desc    string
+		percent int
+		routes  string
+		ejected bool
+	}{
+		{"zero percent", 0, "route add svc /outlier http://foo.com/\nroute add svc /outlier http://bar.com/", false},
+		{"single target", 50, "route add svc /outlier http://foo.com/", false},
+		{"single target with 100 percent", 100, "route add svc /outlier http://foo.com/", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			outlier = config.Outlier{ConsecutiveFailures: 1, Interval: time.Second, Backoff: time.Minute, MaxEjectionPercent: tt.percent}
+			tbl, err := NewTable(bytes.NewBufferString(tt.routes))
+			if err != nil {
+				t.Fatal(err)
+			}
+			tbl["other.com"] = Routes{{Host: "other.com", Path: "/"}}
+			SetTable(tbl)
+			defer SetTable(make(Table))
+
+			foo := GetTable()[""].find("/outlier", "").Targets[0]
+			foo.ReportResult(false)
+			if got, want := foo.stats.ejected.Load(), tt.ejected; got != want {
+				t.Fatalf("got ejected %v want %v", got, want)
+			}
+
+			// only the route of the ejected target is replaced
+			if got, want := GetTable()["other.com"][0] == tbl["other.com"][0], true; got != want {
+				t.Fatalf("got shared route %v want %v", got, want)
+			}
+			foo.stats.ejected.Store(false)
+		})
+	}
+}
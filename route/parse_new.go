@@ -21,18 +21,53 @@ var (
 const Commands = `
 Route commands can have the following form:
 
-route add <svc> <src> <dst>[ weight <w>][ tags "<t1>,<t2>,..."][ opts "k1=v1 k2=v2 ..."]
-  - Add route for service svc from src to dst with optional weight, tags and options.
+route add <svc> <src> <dst>[ weight <w>][ match "<c1> <c2> ..."][ tags "<t1>,<t2>,..."][ opts "k1=v1 k2=v2 ..."]
+  - Add route for service svc from src to dst with optional weight, match conditions, tags and options.
+    Requests must meet all match conditions. Valid match conditions are:
+
+	  header:name=value      : request header 'name' has the value 'value'
+	  header:name            : request header 'name' is present
+	  query:name=value       : query parameter 'name' has the value 'value'
+	  query:name             : query parameter 'name' is present
+	  method:GET,HEAD        : request method is one of the methods
+
+    Routes with a more specific path are preferred. Routes with the same
+    path are checked in order of the number of their match conditions.
+
     Valid options are:
 
-	  strip=/path        : forward '/path/to/file' as '/to/file'
-	  prepend=/prefix    : forward '/path/to/file' as '/prefix/path/to/file'
-	  proto=tcp          : upstream service is TCP, dst is ':port'
-	  proto=https        : upstream service is HTTPS
-	  tlsskipverify=true : disable TLS cert validation for HTTPS upstream
-	  host=name          : set the Host header to 'name'. If 'name == "dst"' then the 'Host' header will be set to the registered upstream host name
-	  register=name      : register fabio as new service 'name'. Useful for registering hostnames for host specific routes.
-      auth=name          : name of the auth scheme to use (defined in proxy.auth)
+	  strip=/path            : forward '/path/to/file' as '/to/file'
+	  prepend=/prefix        : forward '/path/to/file' as '/prefix/path/to/file'
+	  rewrite=/path/$1       : replace the part of the path matched by the regex route path. Requires proxy.matcher = regex
+	  proto=tcp              : upstream service is TCP, dst is ':port'
+	  proto=https            : upstream service is HTTPS
+	  proto=h2c              : upstream service is HTTP/2 without TLS (prior knowledge)
+	  tls=terminate          : terminate TLS on https+tcp+sni listeners. 'passthrough' forwards TLS
+	  tlsskipverify=true     : disable TLS cert validation for HTTPS upstream
+	  upstreamcert=name      : present a client certificate from the proxy.cs cert source to the upstream
+	  upstreamca=path        : validate the upstream with the CA certificates from the PEM file
+	  pxyproto=v2            : send PROXY protocol v2 headers to the upstream. 'true' sends v1 headers
+	  host=name              : set the Host header to 'name'. If 'name == "dst"' then the 'Host' header will be set to the registered upstream host name
+	  register=name          : register fabio as new service 'name'. Useful for registering hostnames for host specific routes.
+      auth=name              : name of the auth scheme to use (defined in proxy.auth)
+	  strategy=name          : override proxy.strategy for this route: rnd, rr, leastconn or ewma
+	  hash=source            : pin requests to a target with consistent hashing on 'ip', 'header:<name>' or 'cookie:<name>'
+	  check=/path            : enable active health checks with 'GET /path'. See also check.interval, check.timeout and check.status
+	  retries=n              : retry failed idempotent requests n times with other targets. See also retry.on and retry.timeout
+	  compress=on            : compress responses with proxy.compress.encodings. Also 'off' or a list like 'br,gzip'
+	  decompress.req=true    : decompress compressed request bodies. See also proxy.decompress.maxsize
+	  compress.upstream=gzip : request compressed responses from the upstream and decompress them if necessary
+	  mirror=svc             : send a copy of the requests to service 'svc' and discard the response. See also mirror_pct
+	  hdr.req.set=N:v        : set request header 'N' to 'v'. See also hdr.req.add, hdr.req.del and hdr.resp.*
+	  ratelimit=100/s        : limit the requests or connections with a token bucket. See also burst and ratelimit.key
+	  cache=true             : cache the responses of GET requests. See also cache_ttl and proxy.cache.size
+	  ws.idletimeout=5m      : close websocket sessions without traffic after 5m. See also ws.maxlifetime
+	  ws.origins=list        : comma separated list of allowed websocket Origin headers, e.g. 'https://*.a.com'
+	  maxbody=10MB           : reject larger request bodies with 413. Overrides proxy.maxbody, 0 disables the limit
+	  maxresponsebody=100MB  : maximum size of response bodies. Overrides proxy.maxresponsebody
+	  timeout=60s            : fail upstream requests which take longer with 504
+	  dialtimeout=500ms      : upstream connect timeout. Overrides proxy.dialtimeout
+	  idletimeout=90s        : close idle upstream connections after 90s. Overrides proxy.idleconntimeout
 
 route del <svc>[ <src>[ <dst>]]
   - Remove route matching svc, src and/or dst
@@ -133,9 +168,9 @@ func ParseAliases(in string) (names []string, err error) {
 	return aliases, nil
 }
 
-// route add <svc> <src> <dst>[ weight <w>][ tags "<t1>,<t2>,..."][ opts "k=v k=v ..."]
-// 1: service 2: src 3: dst 4: weight expr 5: weight val 6: tags expr 7: tags val 8: opts expr 9: opts val
-var reAdd = mustCompileWithFlexibleSpace(`^route add (\S+) (\S+) (\S+)( weight (\S+))?( tags "([^"]*)")?( opts "([^"]*)")?$`)
+// route add <svc> <src> <dst>[ weight <w>][ match "<c1> <c2> ..."][ tags "<t1>,<t2>,..."][ opts "k=v k=v ..."]
+// 1: service 2: src 3: dst 4: weight expr 5: weight val 6: match expr 7: match val 8: tags expr 9: tags val 10: opts expr 11: opts val
+var reAdd = mustCompileWithFlexibleSpace(`^route add (\S+) (\S+) (\S+)( weight (\S+))?( match "([^"]*)")?( tags "([^"]*)")?( opts "([^"]*)")?$`)
 
 func parseRouteAdd(s string) (*RouteDef, error) {
 	if m := reAdd.FindStringSubmatch(s); m != nil {
@@ -146,8 +181,9 @@ func parseRouteAdd(s string) (*RouteDef, error) {
 			Src:     m[2],
 			Dst:     m[3],
 			Weight:  w,
-			Tags:    parseTags(m[7]),
-			Opts:    parseOpts(m[9]),
+			Match:   strings.TrimSpace(m[7]),
+			Tags:    parseTags(m[9]),
+			Opts:    parseOpts(m[11]),
 		}, err
 	}
 	return nil, errors.New("syntax error: 'route add' invalid")
@@ -52,6 +52,16 @@ func TestParse(t *testing.T) {
 			in:   `route add svc /prefix http://1.2.3.4/ weight 1.2 opts "foo=bar baz=bang blimp"`,
 			out:  []*RouteDef{{Cmd: RouteAddCmd, Service: "svc", Src: "/prefix", Dst: "http://1.2.3.4/", Weight: 1.2, Opts: map[string]string{"foo": "bar", "baz": "bang", "blimp": ""}}},
 		},
+		{
+			desc: "RouteAddServiceMatch",
+			in:   `route add svc /prefix http://1.2.3.4/ match "header:X-Canary=true method:GET"`,
+			out:  []*RouteDef{{Cmd: RouteAddCmd, Service: "svc", Src: "/prefix", Dst: "http://1.2.3.4/", Match: "header:X-Canary=true method:GET"}},
+		},
+		{
+			desc: "RouteAddServiceWeightMatchTagsOpts",
+			in:   `route add svc /prefix http://1.2.3.4/ weight 1.2 match "query:version=2" tags "a,b" opts "foo=bar"`,
+			out:  []*RouteDef{{Cmd: RouteAddCmd, Service: "svc", Src: "/prefix", Dst: "http://1.2.3.4/", Weight: 1.2, Match: "query:version=2", Tags: []string{"a", "b"}, Opts: map[string]string{"foo": "bar"}}},
+		},
 		{
 			desc: "RouteAddServiceWeightTagsOpts",
 			in:   `route add svc /prefix http://1.2.3.4/ weight 1.2 tags "a,b" opts "foo=bar baz=bang blimp"`,
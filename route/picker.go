@@ -1,6 +1,7 @@
 package route
 
 import (
+	"math"
 	"math/rand"
 	"sync/atomic"
 )
@@ -11,8 +12,10 @@ type picker func(r *Route) *Target
 // Picker contains the available picker functions.
 // Update config/load.go#load after updating.
 var Picker = map[string]picker{
-	"rnd": rndPicker,
-	"rr":  rrPicker,
+	"rnd":       rndPicker,
+	"rr":        rrPicker,
+	"leastconn": leastconnPicker,
+	"ewma":      ewmaPicker,
 }
 
 // rndPicker picks a random target from the list of targets.
@@ -27,6 +30,60 @@ func rrPicker(r *Route) *Target {
 	return u
 }
 
+// leastconnPicker picks the target with the lowest number of active
+// requests relative to its weight.
+func leastconnPicker(r *Route) *Target {
+	return pickMinCost(r, func(t *Target) float64 {
+		return float64(t.InFlight() + 1)
+	})
+}
+
+// ewmaPicker picks the target with the lowest peak EWMA latency
+// multiplied by the number of active requests relative to its weight.
+// Targets without latency observations are preferred while they are
+// idle so that they receive traffic quickly after they have been added.
+func ewmaPicker(r *Route) *Target {
+	return pickMinCost(r, func(t *Target) float64 {
+		if t.stats == nil {
+			return 0
+		}
+		n := t.InFlight()
+		lat := t.stats.latency()
+		if lat == 0 {
+			if n == 0 {
+				return 0
+			}
+			return math.MaxFloat64 / 2
+		}
+		return lat * float64(n+1)
+	})
+}
+
+// pickMinCost returns the target with the lowest cost divided by its
+// weight or nil if no target has a weight or is healthy. The search starts at a
+// random position so that ties are spread across the targets.
+func pickMinCost(r *Route, cost func(t *Target) float64) *Target {
+	n := len(r.Targets)
+	if n == 0 {
+		return nil
+	}
+
+	var best *Target
+	var bestCost float64
+	start := randIntn(n)
+	for i := range n {
+		t := r.Targets[(start+i)%n]
+		if t.Weight <= 0 || !r.isUp(t) {
+			continue
+		}
+		c := cost(t) / t.Weight
+		if best == nil || c < bestCost {
+			best, bestCost = t, c
+		}
+	}
+	return best
+}
+
 // as it turns out, math/rand's Intn is now way faster (4x) than the previous implementation using
 // time.UnixNano().  As a bonus, this actually works properly on 32 bit platforms.
 var randIntn = func(n int) int {
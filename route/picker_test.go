@@ -1,6 +1,7 @@
 package route
 
 import (
+	"bytes"
 	"net/url"
 	"reflect"
 	"testing"
@@ -83,3 +84,128 @@ func BenchmarkMathRandIntn(b *testing.B) {
 	}
 	result = r
 }
+
+func TestLeastconnPicker(t *testing.T) {
+	r := &Route{Host: "www.bar.com", Path: "/leastconn"}
+	r.addTarget("svc", fooDotCom, 0, nil, nil)
+	r.addTarget("svc", barDotCom, 0, nil, nil)
+
+	prev := randIntn
+	defer func() { randIntn = prev }()
+	randIntn = func(int) int { return 0 }
+
+	foo, bar := r.Targets[0], r.Targets[1]
+
+	if got, want := leastconnPicker(r), foo; got != want {
+		t.Fatalf("idle: got %v want %v", got.URL, want.URL)
+	}
+
+	foo.Begin()
+	if got, want := leastconnPicker(r), bar; got != want {
+		t.Fatalf("foo busy: got %v want %v", got.URL, want.URL)
+	}
+
+	bar.Begin()
+	bar.Begin()
+	if got, want := leastconnPicker(r), foo; got != want {
+		t.Fatalf("bar busy: got %v want %v", got.URL, want.URL)
+	}
+
+	bar.End(0)
+	bar.End(0)
+	foo.End(0)
+	if got, want := foo.InFlight()+bar.InFlight(), int64(0); got != want {
+		t.Fatalf("got %d requests in flight want %d", got, want)
+	}
+}
+
+func TestLeastconnPickerWeight(t *testing.T) {
+	r := &Route{Host: "www.bar.com", Path: "/leastconn-weight"}
+	r.addTarget("svc", fooDotCom, 0.75, nil, nil)
+	r.addTarget("svc", barDotCom, 0.25, nil, nil)
+
+	prev := randIntn
+	defer func() { randIntn = prev }()
+	randIntn = func(int) int { return 1 }
+
+	foo, bar := r.Targets[0], r.Targets[1]
+	defer func() {
+		for foo.InFlight() > 0 {
+			foo.End(0)
+		}
+	}()
+
+	// foo has three times the weight of bar so bar
+	// should only be picked once foo is busy
+	for i := range 2 {
+		if got, want := leastconnPicker(r), foo; got != want {
+			t.Fatalf("%d: got %v want %v", i, got.URL, want.URL)
+		}
+		foo.Begin()
+	}
+	if got, want := leastconnPicker(r), bar; got != want {
+		t.Fatalf("got %v want %v", got.URL, want.URL)
+	}
+}
+
+func TestEWMAPicker(t *testing.T) {
+	r := &Route{Host: "www.bar.com", Path: "/ewma"}
+	r.addTarget("svc", fooDotCom, 0, nil, nil)
+	r.addTarget("svc", barDotCom, 0, nil, nil)
+
+	prevRand, prevNow := randIntn, now
+	defer func() { randIntn, now = prevRand, prevNow }()
+	randIntn = func(int) int { return 0 }
+
+	clock := time.Unix(0, 0)
+	now = func() time.Time { return clock }
+
+	foo, bar := r.Targets[0], r.Targets[1]
+
+	// targets without observations are picked while idle
+	foo.Begin()
+	if got, want := ewmaPicker(r), bar; got != want {
+		t.Fatalf("unobserved: got %v want %v", got.URL, want.URL)
+	}
+	foo.End(100 * time.Millisecond)
+	bar.Begin()
+	bar.End(10 * time.Millisecond)
+
+	if got, want := ewmaPicker(r), bar; got != want {
+		t.Fatalf("bar faster: got %v want %v", got.URL, want.URL)
+	}
+
+	// a latency peak is picked up immediately
+	bar.Begin()
+	bar.End(time.Second)
+	if got, want := ewmaPicker(r), foo; got != want {
+		t.Fatalf("bar peak: got %v want %v", got.URL, want.URL)
+	}
+
+	// and decays over time
+	clock = clock.Add(time.Minute)
+	bar.Begin()
+	bar.End(10 * time.Millisecond)
+	if got, want := ewmaPicker(r), bar; got != want {
+		t.Fatalf("bar decayed: got %v want %v", got.URL, want.URL)
+	}
+}
+
+func TestRoutePickerOption(t *testing.T) {
+	tbl, err := NewTable(bytes.NewBufferString(`
+route add svc / http://foo.com/ opts "strategy=leastconn"
+route add svc / http://bar.com/
+route add svc /rr http://foo.com/
+route add svc /rr http://bar.com/ opts "strategy=bogus"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tbl[""].find("/", "").pick == nil {
+		t.Fatal("route / has no picker")
+	}
+	if tbl[""].find("/rr", "").pick != nil {
+		t.Fatal("route /rr has a picker")
+	}
+}
@@ -0,0 +1,276 @@
+package route
+
+import (
+	"container/list"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxRateLimitKeys is the maximum number of client buckets of a rate
+// limiter. The least recently used bucket is removed for a new client
+// when the limit is reached. It is a variable to allow testing.
+var maxRateLimitKeys = 100000
+
+// rateLimitSweep is the interval in which full buckets are removed.
+// A full bucket behaves like a new one so removing it does not change
+// the limit.
+const rateLimitSweep = time.Minute
+
+// rateLimiters stores the rate limiters by route and configuration so
+// that their state survives routing table updates.
+var rateLimiters sync.Map
+
+// rateLimit describes a token bucket limit. It is configured with the
+// following options:
+//
+//	ratelimit=100/s              rate in requests per second (s), minute (m) or hour (h)
+//	burst=50                     size of the bucket. Default is the rate per second but at least 1.
+//	ratelimit.key=route          the limit applies to all requests of the route (default)
+//	ratelimit.key=ip             the limit applies per client ip address
+//	ratelimit.key=header:<name>  the limit applies per value of request header <name>
+type rateLimit struct {
+	// rate is the number of tokens which are added per second.
+	rate float64
+
+	// burst is the maximum number of tokens.
+	burst float64
+
+	// src is the part of the request the limit is keyed by.
+	// It is nil if the limit applies to the whole route.
+	src *hashSource
+}
+
+// parseRateLimit returns the rate limit from the route options or nil
+// if there is none.
+func parseRateLimit(opts map[string]string) (*rateLimit, error) {
+	v := opts["ratelimit"]
+	if v == "" {
+		return nil, nil
+	}
+
+	n, unit, _ := strings.Cut(v, "/")
+	rate, err := strconv.ParseFloat(n, 64)
+	if err != nil || rate <= 0 || math.IsInf(rate, 0) {
+		return nil, fmt.Errorf("route: invalid ratelimit %q", v)
+	}
+	switch unit {
+	case "", "s":
+	case "m":
+		rate /= 60
+	case "h":
+		rate /= 3600
+	default:
+		return nil, fmt.Errorf("route: invalid ratelimit %q", v)
+	}
+
+	l := &rateLimit{rate: rate, burst: math.Max(1, math.Ceil(rate))}
+	if b := opts["burst"]; b != "" {
+		burst, err := strconv.Atoi(b)
+		if err != nil || burst < 1 {
+			return nil, fmt.Errorf("route: invalid burst %q", b)
+		}
+		l.burst = float64(burst)
+	}
+
+	switch k := opts["ratelimit.key"]; {
+	case k == "" || k == "route":
+	case strings.HasPrefix(k, "cookie:"):
+		return nil, fmt.Errorf("route: invalid ratelimit.key %q", k)
+	default:
+		if l.src, err = parseHashSource(k); err != nil {
+			return nil, fmt.Errorf("route: invalid ratelimit.key %q", k)
+		}
+	}
+	return l, nil
+}
+
+// String returns the canonical form of the rate limit.
+func (l *rateLimit) String() string {
+	s := fmt.Sprintf("%g/s burst=%g", l.rate, l.burst)
+	if l.src != nil {
+		s += " " + l.src.kind
+		if l.src.name != "" {
+			s += ":" + l.src.name
+		}
+	}
+	return s
+}
+
+// rateLimiter holds the token buckets of a rate limit.
+type rateLimiter struct {
+	*rateLimit
+
+	// key is the key of the limiter in rateLimiters.
+	key string
+
+	mu sync.Mutex
+
+	// buckets contains the elements of lru by client key.
+	buckets map[string]*list.Element
+
+	// lru contains the buckets from the most to the least recently used.
+	lru       *list.List
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	key    string
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(l *rateLimit, key string) *rateLimiter {
+	return &rateLimiter{
+		rateLimit: l,
+		key:       key,
+		buckets:   map[string]*list.Element{},
+		lru:       list.New(),
+		lastSweep: now(),
+	}
+}
+
+// loadRateLimiter returns the rate limiter for the rate limit of the
+// route. Limiters with the same route and limit share their state.
+func loadRateLimiter(r *Route, l *rateLimit) *rateLimiter {
+	key := r.Host + "\x00" + r.Path + "\x00" + r.Match + "\x00" + l.String()
+	if v, ok := rateLimiters.Load(key); ok {
+		return v.(*rateLimiter)
+	}
+	v, _ := rateLimiters.LoadOrStore(key, newRateLimiter(l, key))
+	return v.(*rateLimiter)
+}
+
+// allow takes a token from the bucket of the client key. If the bucket
+// is empty it returns false and the time until the next token is added.
+func (l *rateLimiter) allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	t := now()
+	if t.Sub(l.lastSweep) > rateLimitSweep {
+		l.sweep(t)
+	}
+
+	var b *tokenBucket
+	if e := l.buckets[key]; e != nil {
+		l.lru.MoveToFront(e)
+		b = e.Value.(*tokenBucket)
+	} else {
+		if len(l.buckets) >= maxRateLimitKeys {
+			l.remove(l.lru.Back())
+		}
+		b = &tokenBucket{key: key, tokens: l.burst, last: t}
+		l.buckets[key] = l.lru.PushFront(b)
+	}
+	b.refill(t, l.rate, l.burst)
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// sweep removes the least recently used buckets which have been refilled
+// completely. It stops at the first bucket which is not full since the
+// more recently used buckets are unlikely to be full.
+func (l *rateLimiter) sweep(t time.Time) {
+	for e := l.lru.Back(); e != nil; e = l.lru.Back() {
+		b := e.Value.(*tokenBucket)
+		if b.refill(t, l.rate, l.burst); b.tokens < l.burst {
+			break
+		}
+		l.remove(e)
+	}
+	l.lastSweep = t
+}
+
+func (l *rateLimiter) remove(e *list.Element) {
+	l.lru.Remove(e)
+	delete(l.buckets, e.Value.(*tokenBucket).key)
+}
+
+func (b *tokenBucket) refill(t time.Time, rate, burst float64) {
+	if d := t.Sub(b.last); d > 0 {
+		b.tokens = math.Min(burst, b.tokens+d.Seconds()*rate)
+		b.last = t
+	}
+}
+
+// clientIP is the key of requests which do not have the header of a
+// limit which is keyed by a request header.
+var clientIP = &hashSource{kind: "ip"}
+
+// RateLimitedHTTP returns true and the time after which the client can
+// retry if the request exceeds the rate limit of the target. Requests
+// without the header of a limit keyed by a request header are limited
+// per client ip address.
+func (t *Target) RateLimitedHTTP(r *http.Request) (bool, time.Duration) {
+	if t.limiter == nil {
+		return false, 0
+	}
+	key := ""
+	if src := t.limiter.src; src != nil {
+		var ok bool
+		if key, ok = src.key(r); !ok {
+			key, _ = clientIP.key(r)
+		}
+	}
+	return t.rateLimited(key)
+}
+
+// RateLimitedTCP returns true if the connection exceeds the rate limit
+// of the target. Limits which are keyed by a request header are applied
+// per client ip address.
+func (t *Target) RateLimitedTCP(c net.Conn) bool {
+	if t.limiter == nil {
+		return false
+	}
+	// Calling RemoteAddr on a proxy-protocol enabled connection may
+	// block so only do it if the limit is keyed by the client.
+	key := ""
+	if t.limiter.src != nil {
+		if addr, ok := c.RemoteAddr().(*net.TCPAddr); ok {
+			key = addr.IP.String()
+		}
+	}
+	limited, _ := t.rateLimited(key)
+	return limited
+}
+
+func (t *Target) rateLimited(key string) (bool, time.Duration) {
+	ok, retry := t.limiter.allow(key)
+	if ok {
+		return false, 0
+	}
+	if t.RateLimitCounter != nil {
+		t.RateLimitCounter.Add(1)
+	}
+	return true, retry
+}
+
+// cleanupRateLimiters removes the rate limiters which are no longer
+// used by the routing table.
+func cleanupRateLimiters(t Table) {
+	active := map[string]bool{}
+	for _, routes := range t {
+		for _, r := range routes {
+			for _, target := range r.Targets {
+				if target.limiter != nil {
+					active[target.limiter.key] = true
+				}
+			}
+		}
+	}
+	rateLimiters.Range(func(k, _ any) bool {
+		if !active[k.(string)] {
+			rateLimiters.Delete(k)
+		}
+		return true
+	})
+}
@@ -0,0 +1,207 @@
+package route
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pascaldekloe/goe/verify"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	tests := []struct {
+		desc  string
+		opts  map[string]string
+		limit *rateLimit
+		err   bool
+	}{
+		{"no limit", map[string]string{"burst": "5"}, nil, false},
+		{"per second", map[string]string{"ratelimit": "100/s"}, &rateLimit{rate: 100, burst: 100}, false},
+		{"no unit", map[string]string{"ratelimit": "2.5"}, &rateLimit{rate: 2.5, burst: 3}, false},
+		{"per minute", map[string]string{"ratelimit": "30/m"}, &rateLimit{rate: 0.5, burst: 1}, false},
+		{"per hour", map[string]string{"ratelimit": "7200/h", "burst": "50"}, &rateLimit{rate: 2, burst: 50}, false},
+		{"route key", map[string]string{"ratelimit": "1/s", "ratelimit.key": "route"}, &rateLimit{rate: 1, burst: 1}, false},
+		{"ip key", map[string]string{"ratelimit": "1/s", "ratelimit.key": "ip"}, &rateLimit{rate: 1, burst: 1, src: &hashSource{kind: "ip"}}, false},
+		{
+			"header key",
+			map[string]string{"ratelimit": "1/s", "ratelimit.key": "header:x-api-key"},
+			&rateLimit{rate: 1, burst: 1, src: &hashSource{kind: "header", name: "X-Api-Key"}},
+			false,
+		},
+		{"invalid rate", map[string]string{"ratelimit": "fast"}, nil, true},
+		{"zero rate", map[string]string{"ratelimit": "0/s"}, nil, true},
+		{"invalid unit", map[string]string{"ratelimit": "1/d"}, nil, true},
+		{"invalid burst", map[string]string{"ratelimit": "1/s", "burst": "0"}, nil, true},
+		{"invalid key", map[string]string{"ratelimit": "1/s", "ratelimit.key": "client"}, nil, true},
+		{"cookie key", map[string]string{"ratelimit": "1/s", "ratelimit.key": "cookie:id"}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			l, err := parseRateLimit(tt.opts)
+			if got, want := err != nil, tt.err; got != want {
+				t.Fatalf("got error %v want error %v", err, want)
+			}
+			verify.Values(t, "", l, tt.limit)
+		})
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	prevNow := now
+	defer func() { now = prevNow }()
+	clock := time.Unix(1000, 0)
+	now = func() time.Time { return clock }
+
+	l := newRateLimiter(&rateLimit{rate: 2, burst: 3}, "")
+
+	for i := range 3 {
+		if ok, _ := l.allow("a"); !ok {
+			t.Fatalf("request %d was limited", i)
+		}
+	}
+	ok, retry := l.allow("a")
+	if ok {
+		t.Fatal("request exceeding the burst was not limited")
+	}
+	if got, want := retry, 500*time.Millisecond; got != want {
+		t.Fatalf("got retry %s want %s", got, want)
+	}
+	if ok, _ := l.allow("b"); !ok {
+		t.Fatal("request with another key was limited")
+	}
+
+	clock = clock.Add(500 * time.Millisecond)
+	if ok, _ := l.allow("a"); !ok {
+		t.Fatal("request after refill was limited")
+	}
+	if ok, _ := l.allow("a"); ok {
+		t.Fatal("request exceeding the rate was not limited")
+	}
+
+	clock = clock.Add(2 * time.Minute)
+	l.allow("c")
+	if got, want := len(l.buckets), 1; got != want {
+		t.Fatalf("got %d buckets after sweep want %d", got, want)
+	}
+}
+
+func TestRateLimiterMaxKeys(t *testing.T) {
+	prevNow, prevMax := now, maxRateLimitKeys
+	defer func() { now, maxRateLimitKeys = prevNow, prevMax }()
+	clock := time.Unix(1000, 0)
+	now = func() time.Time { return clock }
+	maxRateLimitKeys = 3
+
+	l := newRateLimiter(&rateLimit{rate: 1, burst: 1}, "")
+	l.allow("a")
+	l.allow("b")
+	l.allow("c")
+	if ok, _ := l.allow("a"); ok {
+		t.Fatal("request exceeding the burst was not limited")
+	}
+
+	// new keys evict the least recently used bucket
+	for _, k := range []string{"d", "e", "f", "g"} {
+		l.allow(k)
+		if got, want := len(l.buckets), 3; got != want {
+			t.Fatalf("got %d buckets want %d", got, want)
+		}
+	}
+	if _, ok := l.buckets["a"]; ok {
+		t.Fatal("least recently used bucket was not evicted")
+	}
+}
+
+func TestTargetRateLimitedHTTP(t *testing.T) {
+	defer cleanupRateLimiters(nil)
+
+	newTarget := func(opts string) *Target {
+		tbl, err := NewTable(bytes.NewBufferString(`route add svc / http://foo/ opts "` + opts + `"`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tbl.route("", "/", "").Targets[0]
+	}
+
+	t.Run("route", func(t *testing.T) {
+		tg := newTarget("ratelimit=1/h")
+		r := httptest.NewRequest("GET", "/", nil)
+		if limited, _ := tg.RateLimitedHTTP(r); limited {
+			t.Fatal("first request was limited")
+		}
+		r.RemoteAddr = "5.6.7.8:1234"
+		limited, retry := tg.RateLimitedHTTP(r)
+		if !limited {
+			t.Fatal("second request was not limited")
+		}
+		if retry <= 59*time.Minute {
+			t.Fatalf("got retry %s want about 1h", retry)
+		}
+
+		// the limiter survives a table update
+		if limited, _ := newTarget("ratelimit=1/h").RateLimitedHTTP(r); !limited {
+			t.Fatal("limit was reset by a table update")
+		}
+		// and is reset when the limit changes
+		if limited, _ := newTarget("ratelimit=1/h burst=2").RateLimitedHTTP(r); limited {
+			t.Fatal("limit was not reset by a new configuration")
+		}
+	})
+
+	t.Run("header", func(t *testing.T) {
+		tg := newTarget("ratelimit=1/h ratelimit.key=header:X-Api-Key")
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("X-Api-Key", "a")
+		if limited, _ := tg.RateLimitedHTTP(r); limited {
+			t.Fatal("first request for key a was limited")
+		}
+		if limited, _ := tg.RateLimitedHTTP(r); !limited {
+			t.Fatal("second request for key a was not limited")
+		}
+		r.Header.Set("X-Api-Key", "b")
+		if limited, _ := tg.RateLimitedHTTP(r); limited {
+			t.Fatal("first request for key b was limited")
+		}
+
+		// requests without the header are limited by client ip
+		r.Header.Del("X-Api-Key")
+		if limited, _ := tg.RateLimitedHTTP(r); limited {
+			t.Fatal("first request without key was limited")
+		}
+		if limited, _ := tg.RateLimitedHTTP(r); !limited {
+			t.Fatal("second request without key was not limited")
+		}
+		r.RemoteAddr = "5.6.7.8:1234"
+		if limited, _ := tg.RateLimitedHTTP(r); limited {
+			t.Fatal("request without key from another ip was limited")
+		}
+	})
+}
+
+func TestCleanupRateLimiters(t *testing.T) {
+	defer cleanupRateLimiters(nil)
+
+	tbl, err := NewTable(bytes.NewBufferString(`
+		route add svc /foo http://foo/ opts "ratelimit=1/s"
+		route add svc /bar http://bar/ opts "ratelimit=1/s"
+	`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cleanupRateLimiters(tbl)
+	count := func() (n int) {
+		rateLimiters.Range(func(_, _ any) bool { n++; return true })
+		return n
+	}
+	if got, want := count(), 2; got != want {
+		t.Fatalf("got %d rate limiters want %d", got, want)
+	}
+
+	delete(tbl, "")
+	cleanupRateLimiters(tbl)
+	if got, want := count(), 0; got != want {
+		t.Fatalf("got %d rate limiters want %d", got, want)
+	}
+}
@@ -0,0 +1,141 @@
+package route
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+)
+
+// RetryPolicy describes how often and under which conditions a failed
+// request is retried with another target of the same route.
+type RetryPolicy struct {
+	// Attempts is the maximum number of retries.
+	Attempts int
+
+	// Timeout is the timeout for receiving the response headers of a
+	// single attempt. A timeout of zero disables the per-try timeout.
+	Timeout time.Duration
+
+	// Connect enables retries when the connection to the target fails.
+	Connect bool
+
+	// Status contains the status codes which are retried.
+	Status []int
+}
+
+// Retryable returns true if a response with the given
+// status code should be retried.
+func (p *RetryPolicy) Retryable(status int) bool {
+	return slices.Contains(p.Status, status)
+}
+
+// ParseRetryOn parses a comma separated list of retry conditions.
+// Valid conditions are 'connect' for failed upstream connections and
+// 5xx status codes.
+func ParseRetryOn(s string) (connect bool, status []int, err error) {
+	for v := range strings.SplitSeq(s, ",") {
+		v = strings.TrimSpace(v)
+		switch {
+		case v == "":
+			continue
+		case v == "connect":
+			connect = true
+		default:
+			code, err := strconv.Atoi(v)
+			if err != nil || code < 500 || code > 599 {
+				return false, nil, fmt.Errorf("route: invalid retry condition %q", v)
+			}
+			status = append(status, code)
+		}
+	}
+	return connect, status, nil
+}
+
+// retry contains the default retry configuration for routes
+// which set the 'retries' option.
+var retry = config.Retry{On: "connect,502,503,504"}
+
+// SetRetryConfig configures the defaults for the retry options.
+// It must be called before the routing table is built.
+func SetRetryConfig(c config.Retry) error {
+	if _, _, err := ParseRetryOn(c.On); err != nil {
+		return err
+	}
+	retry = c
+	return nil
+}
+
+// parseRetryPolicy returns the retry policy from the route options
+// or nil if the route has no retries. It is configured with the
+// following options:
+//
+//	retries=2            number of retries
+//	retry.timeout=1s     timeout of a single attempt. Default is proxy.retry.timeout
+//	retry.on=connect,503 retry conditions. Default is proxy.retry.on
+func parseRetryPolicy(opts map[string]string) (*RetryPolicy, error) {
+	if opts["retries"] == "" {
+		return nil, nil
+	}
+
+	n, err := strconv.Atoi(opts["retries"])
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("route: invalid retries %q", opts["retries"])
+	}
+	if n == 0 {
+		return nil, nil
+	}
+
+	p := &RetryPolicy{Attempts: n, Timeout: retry.Timeout}
+	if v := opts["retry.timeout"]; v != "" {
+		if p.Timeout, err = time.ParseDuration(v); err != nil || p.Timeout < 0 {
+			return nil, fmt.Errorf("route: invalid retry.timeout %q", v)
+		}
+	}
+
+	on := retry.On
+	if v := opts["retry.on"]; v != "" {
+		on = v
+	}
+	if p.Connect, p.Status, err = ParseRetryOn(on); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// NextTarget returns another healthy target of the route of the given
+// targets from the active routing table which has not been tried yet.
+// It returns nil if there is none.
+func NextTarget(tried []*Target) *Target {
+	if len(tried) == 0 || tried[0].stats == nil {
+		return nil
+	}
+	s := tried[0].stats
+	r := GetTable().routeByKey(s.route)
+	if r == nil {
+		return nil
+	}
+
+	seen := func(t *Target) bool {
+		for _, tt := range tried {
+			if tt.Service == t.Service && tt.URL.String() == t.URL.String() {
+				return true
+			}
+		}
+		return false
+	}
+
+	n := len(r.Targets)
+	start := randIntn(n)
+	for i := range n {
+		t := r.Targets[(start+i)%n]
+		if t.Weight <= 0 || !r.isUp(t) || seen(t) {
+			continue
+		}
+		return t
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package route
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/fabiolb/fabio/config"
+	"github.com/pascaldekloe/goe/verify"
+)
+
+func TestParseRetryPolicy(t *testing.T) {
+	prev := retry
+	defer func() { retry = prev }()
+	retry = config.Retry{On: "connect,503", Timeout: time.Second}
+
+	tests := []struct {
+		desc string
+		opts map[string]string
+		p    *RetryPolicy
+		err  bool
+	}{
+		{"no retries", map[string]string{}, nil, false},
+		{"zero retries", map[string]string{"retries": "0"}, nil, false},
+		{"defaults", map[string]string{"retries": "2"}, &RetryPolicy{Attempts: 2, Timeout: time.Second, Connect: true, Status: []int{503}}, false},
+		{"options", map[string]string{"retries": "1", "retry.timeout": "500ms", "retry.on": "502,504"}, &RetryPolicy{Attempts: 1, Timeout: 500 * time.Millisecond, Status: []int{502, 504}}, false},
+		{"invalid retries", map[string]string{"retries": "x"}, nil, true},
+		{"negative retries", map[string]string{"retries": "-1"}, nil, true},
+		{"invalid timeout", map[string]string{"retries": "1", "retry.timeout": "x"}, nil, true},
+		{"invalid condition", map[string]string{"retries": "1", "retry.on": "404"}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			p, err := parseRetryPolicy(tt.opts)
+			if got, want := err != nil, tt.err; got != want {
+				t.Fatalf("got error %v want error %v", err, want)
+			}
+			verify.Values(t, "", p, tt.p)
+		})
+	}
+}
+
+func TestNextTarget(t *testing.T) {
+	tbl, err := NewTable(bytes.NewBufferString(`
+route add svc /retry http://foo.com/
+route add svc /retry http://bar.com/
+route add svc a.com/retry http://baz.com/ match "header:X=1 method:GET"
+route add svc a.com/retry http://qux.com/ match "header:X=1 method:GET"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetTable(tbl)
+	defer SetTable(make(Table))
+
+	r := GetTable()[""].find("/retry", "")
+	var first *Target
+	for _, tg := range r.Targets {
+		if tg.URL.String() == "http://foo.com/" {
+			first = tg
+		}
+	}
+
+	next := NextTarget([]*Target{first})
+	if next == nil || next.URL.String() != "http://bar.com/" {
+		t.Fatalf("got %v want http://bar.com/", next)
+	}
+	if next := NextTarget([]*Target{first, next}); next != nil {
+		t.Fatalf("got %v want nil", next.URL)
+	}
+	// the route is found by host, path and match conditions
+	mr := GetTable()["a.com"][0]
+	if mr.Match == "" {
+		t.Fatal("route without match conditions")
+	}
+	next = NextTarget([]*Target{mr.Targets[0]})
+	if next == nil || next.URL.String() != mr.Targets[1].URL.String() {
+		t.Fatalf("got %v want %s", next, mr.Targets[1].URL)
+	}
+}
@@ -7,10 +7,12 @@ import (
 	"log"
 	"net/url"
 	"reflect"
+	"regexp"
 	"slices"
 	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
 
 	"github.com/gobwas/glob"
 )
@@ -24,6 +26,11 @@ type Route struct {
 
 	// Glob represents compiled pattern.
 	Glob glob.Glob
+
+	// Regexp is the path compiled as a regular expression which is
+	// anchored at the start of the path. It is nil if the path is not
+	// a valid regular expression.
+	Regexp *regexp.Regexp
 	// Host contains the host of the route.
 	// not used for routing but for config generation
 	// Table has a map with the host as key
@@ -33,6 +40,13 @@ type Route struct {
 	// Path is the path prefix from a request uri
 	Path string
 
+	// Match contains the canonical form of the match conditions
+	// on the request headers, query parameters and method.
+	Match string
+
+	// conds contains the parsed match conditions.
+	conds []matchCond
+
 	// Targets contains the list of URLs
 	Targets []*Target
 
@@ -42,6 +56,22 @@ type Route struct {
 	// total contains the total number of requests for this route.
 	// Used by the RRPicker
 	total uint64
+
+	// pick overrides the global picker for this route.
+	// It is set with the 'strategy' option.
+	pick picker
+
+	// hash selects the request attribute for consistent hashing.
+	// It is set with the 'hash' option.
+	hash *hashSource
+
+	// ring contains the targets on a consistent hash ring. It is
+	// built on first use and reset when the targets change.
+	ring atomic.Pointer[hashRing]
+
+	// allDown is set when the health checks of all targets fail.
+	// The route then uses all targets instead of none.
+	allDown bool
 }
 
 func (r *Route) addTarget(service string, targetURL *url.URL, fixedWeight float64, tags []string, opts map[string]string) {
@@ -65,6 +95,18 @@ func (r *Route) addTarget(service string, targetURL *url.URL, fixedWeight float6
 		Timer:       counters.histogram.With("service", service, "host", r.Host, "path", r.Path, "target", targetURL.String()),
 		RxCounter:   counters.rxCounter.With("service", service, "host", r.Host, "path", r.Path, "target", targetURL.String()),
 		TxCounter:   counters.txCounter.With("service", service, "host", r.Host, "path", r.Path, "target", targetURL.String()),
+		stats:       loadStats(r.statsKey(service, targetURL.String()), r.key()),
+
+		RateLimitCounter: counters.ratelimited.With("service", service, "host", r.Host, "path", r.Path, "target", targetURL.String()),
+
+		WSRxCounter: counters.wsRx.With("service", service, "host", r.Host, "path", r.Path, "target", targetURL.String()),
+		WSTxCounter: counters.wsTx.With("service", service, "host", r.Host, "path", r.Path, "target", targetURL.String()),
+		WSRxFrames:  counters.wsRxFrames.With("service", service, "host", r.Host, "path", r.Path, "target", targetURL.String()),
+		WSTxFrames:  counters.wsTxFrames.With("service", service, "host", r.Host, "path", r.Path, "target", targetURL.String()),
+		WSDuration:  counters.wsDuration.With("service", service, "host", r.Host, "path", r.Path, "target", targetURL.String()),
+
+		TimeoutCounter:     counters.timeouts.With("service", service, "host", r.Host, "path", r.Path, "target", targetURL.String()),
+		DialTimeoutCounter: counters.dialTimeout.With("service", service, "host", r.Host, "path", r.Path, "target", targetURL.String()),
 	}
 
 	var err error
@@ -72,9 +114,30 @@ func (r *Route) addTarget(service string, targetURL *url.URL, fixedWeight float6
 
 		t.StripPath = opts["strip"]
 		t.PrependPath = opts["prepend"]
+		t.Rewrite = opts["rewrite"]
 		t.TLSSkipVerify = opts["tlsskipverify"] == "true"
 		t.Host = opts["host"]
-		t.ProxyProto = opts["pxyproto"] == "true"
+
+		if t.UpstreamTLS, err = parseUpstreamTLS(opts); err != nil {
+			log.Printf("[ERROR] %s for route %s%s", err, r.Host, r.Path)
+		}
+
+		switch v := opts["tls"]; v {
+		case "", "passthrough", "terminate":
+			t.TLS = v
+		default:
+			log.Printf("[ERROR] invalid tls %q for route %s%s", v, r.Host, r.Path)
+		}
+
+		switch v := opts["pxyproto"]; v {
+		case "", "false":
+		case "true", "v1":
+			t.ProxyProto = true
+		case "v2":
+			t.ProxyProto, t.ProxyProtoV2 = true, true
+		default:
+			log.Printf("[ERROR] invalid pxyproto %q for route %s%s", v, r.Host, r.Path)
+		}
 
 		// if Host is "dst", we don't need a special transport to override the sni because
 		// this is already the default behavior.
@@ -82,6 +145,14 @@ func (r *Route) addTarget(service string, targetURL *url.URL, fixedWeight float6
 			t.Transport = transport.NewTransport(&tls.Config{ServerName: t.Host, InsecureSkipVerify: t.TLSSkipVerify})
 		}
 
+		if opts["proto"] == "h2c" {
+			if t.URL.Scheme == "http" {
+				t.Transport = h2cTransport()
+			} else {
+				log.Printf("[ERROR] proto=h2c requires an http upstream for route %s%s", r.Host, r.Path)
+			}
+		}
+
 		if opts["redirect"] != "" {
 			t.RedirectCode, err = strconv.Atoi(opts["redirect"])
 			if err != nil {
@@ -98,12 +169,119 @@ func (r *Route) addTarget(service string, targetURL *url.URL, fixedWeight float6
 		}
 
 		t.AuthScheme = opts["auth"]
+
+		if opts["strategy"] != "" {
+			if pick, ok := Picker[opts["strategy"]]; ok {
+				r.pick = pick
+			} else {
+				log.Printf("[ERROR] invalid strategy %q for route %s%s", opts["strategy"], r.Host, r.Path)
+			}
+		}
+
+		if t.check, err = parseHealthCheck(opts); err != nil {
+			log.Printf("[ERROR] %s for route %s%s", err, r.Host, r.Path)
+		}
+
+		if t.Retry, err = parseRetryPolicy(opts); err != nil {
+			log.Printf("[ERROR] %s for route %s%s", err, r.Host, r.Path)
+		}
+
+		if t.Mirror, t.MirrorPct, err = parseMirror(opts); err != nil {
+			log.Printf("[ERROR] %s for route %s%s", err, r.Host, r.Path)
+		}
+
+		if t.Compress, err = parseCompress(opts); err != nil {
+			log.Printf("[ERROR] %s for route %s%s", err, r.Host, r.Path)
+		}
+
+		t.DecompressRequest = opts["decompress.req"] == "true"
+
+		if v := opts["compress.upstream"]; v != "" {
+			if t.UpstreamEncodings, err = parseEncodings("compress.upstream", v); err != nil {
+				log.Printf("[ERROR] %s for route %s%s", err, r.Host, r.Path)
+			}
+		}
+
+		if t.Cache, t.CacheTTL, err = parseCache(opts); err != nil {
+			log.Printf("[ERROR] %s for route %s%s", err, r.Host, r.Path)
+		}
+
+		if t.WS, err = parseWSPolicy(opts); err != nil {
+			log.Printf("[ERROR] %s for route %s%s", err, r.Host, r.Path)
+		}
+
+		if t.MaxBody, t.MaxResponseBody, err = parseBodyLimits(opts); err != nil {
+			log.Printf("[ERROR] %s for route %s%s", err, r.Host, r.Path)
+		}
+
+		if t.Timeout, t.DialTimeout, t.IdleTimeout, err = parseTimeouts(opts); err != nil {
+			log.Printf("[ERROR] %s for route %s%s", err, r.Host, r.Path)
+		}
+
+		// targets with timeouts, PROXY protocol or upstream TLS settings
+		// need their own transport
+		pxyProto := t.ProxyProto && (t.URL.Scheme == "http" || t.URL.Scheme == "https")
+		upstreamTLS := t.UpstreamTLS != nil && (t.URL.Scheme == "https" || opts["proto"] == "https")
+		if t.DialTimeout > 0 || t.IdleTimeout > 0 || pxyProto || upstreamTLS {
+			k := transportKey{
+				dial:          t.DialTimeout,
+				idle:          t.IdleTimeout,
+				tlsSkipVerify: t.TLSSkipVerify,
+				h2c:           opts["proto"] == "h2c" && t.URL.Scheme == "http",
+				proxyProto:    pxyProto,
+				proxyProtoV2:  pxyProto && t.ProxyProtoV2,
+			}
+			if upstreamTLS {
+				k.upstreamTLS = t.UpstreamTLS
+			}
+			if t.Host != "" && t.Host != "dst" && (t.URL.Scheme == "https" || opts["proto"] == "https") {
+				k.serverName = t.Host
+			}
+			t.Transport = targetTransport(k)
+		}
+
+		if t.ReqHeaders, err = parseHeaderRules(opts, "req"); err != nil {
+			log.Printf("[ERROR] %s for route %s%s", err, r.Host, r.Path)
+		}
+
+		if t.RespHeaders, err = parseHeaderRules(opts, "resp"); err != nil {
+			log.Printf("[ERROR] %s for route %s%s", err, r.Host, r.Path)
+		}
+
+		if l, err := parseRateLimit(opts); err != nil {
+			log.Printf("[ERROR] %s for route %s%s", err, r.Host, r.Path)
+		} else if l != nil {
+			t.limiter = loadRateLimiter(r, l)
+		}
+
+		if opts["hash"] != "" {
+			if r.hash, err = parseHashSource(opts["hash"]); err != nil {
+				log.Printf("[ERROR] %s for route %s%s", err, r.Host, r.Path)
+			}
+		}
 	}
 
+	t.pathRe = r.Regexp
 	r.Targets = append(r.Targets, t)
 	r.weighTargets()
 }
 
+// compilePath compiles the path of the route as a glob pattern and as
+// a regular expression. Paths which are not valid regular expressions
+// are allowed since they are only used by the regex matcher.
+func compilePath(path string) (glob.Glob, *regexp.Regexp, error) {
+	g, err := glob.Compile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	re, err := regexp.Compile("^(?:" + path + ")")
+	if err != nil {
+		log.Printf("[DEBUG] route: path %q is not a valid regular expression. %s", path, err)
+		re = nil
+	}
+	return g, re, nil
+}
+
 func (r *Route) filter(skip func(t *Target) bool) {
 	var clone []*Target
 	for _, t := range r.Targets {
@@ -164,6 +342,9 @@ func (r *Route) TargetConfig(t *Target, addWeight bool) string {
 	} else if t.FixedWeight > 0 {
 		s += fmt.Sprintf(" weight %.4f", t.FixedWeight)
 	}
+	if r.Match != "" {
+		s += fmt.Sprintf(" match %q", r.Match)
+	}
 	if len(t.Tags) > 0 {
 		s += fmt.Sprintf(" tags %q", strings.Join(t.Tags, ","))
 	}
@@ -211,6 +392,18 @@ const maxSlots = 1e4 // 10000
 // Targets with a dynamic weight will receive an equal share of the remaining
 // traffic if there is any left.
 func (r *Route) weighTargets() {
+	r.ring.Store(nil)
+
+	// targets with failing health checks receive no traffic
+	// unless all of them fail.
+	r.allDown = len(r.Targets) > 0
+	for _, t := range r.Targets {
+		if t.Healthy() {
+			r.allDown = false
+			break
+		}
+	}
+
 	// how big is the fixed weighted traffic?
 	var nFixed int
 	var sumFixed float64
@@ -225,10 +418,17 @@ func (r *Route) weighTargets() {
 	// an equal amount of traffic
 	if nFixed == 0 {
 		w := 1.0 / float64(len(r.Targets))
+		var up []*Target
 		for _, t := range r.Targets {
 			t.Weight = w
+			if r.isUp(t) {
+				up = append(up, t)
+			}
 		}
-		r.wTargets = r.Targets
+		if len(up) == len(r.Targets) {
+			up = r.Targets
+		}
+		r.wTargets = up
 		return
 	}
 
@@ -288,6 +488,9 @@ func (r *Route) weighTargets() {
 		if n == 0 && t.Weight > 0 {
 			n = 1
 		}
+		if !r.isUp(t) {
+			n = 0
+		}
 		slots[i].i = i
 		slots[i].n = n
 		usedSlots += n
@@ -316,6 +519,12 @@ func (r *Route) weighTargets() {
 	r.wTargets = targets
 }
 
+// isUp returns true if the target should receive traffic
+// according to its health.
+func (r *Route) isUp(t *Target) bool {
+	return r.allDown || t.Healthy()
+}
+
 type byN []struct{ i, n int }
 
 func (r byN) Len() int           { return len(r) }
@@ -14,6 +14,7 @@ type RouteDef struct {
 	Service string            `json:"service"`
 	Src     string            `json:"src"`
 	Dst     string            `json:"dst"`
+	Match   string            `json:"match,omitempty"`
 	Tags    []string          `json:"tags,omitempty"`
 	Weight  float64           `json:"weight"`
 }
@@ -3,17 +3,28 @@ package route
 // Routes stores a list of routes usually for a single host.
 type Routes []*Route
 
-// find returns the route with the given path and returns nil if none was found.
-func (rt Routes) find(path string) *Route {
+// find returns the route with the given path and match conditions
+// and returns nil if none was found.
+func (rt Routes) find(path, match string) *Route {
 	for _, r := range rt {
-		if r.Path == path {
+		if r.Path == path && r.Match == match {
 			return r
 		}
 	}
 	return nil
 }
 
-// sort by path in reverse order (most to least specific)
-func (rt Routes) Len() int           { return len(rt) }
-func (rt Routes) Swap(i, j int)      { rt[i], rt[j] = rt[j], rt[i] }
-func (rt Routes) Less(i, j int) bool { return rt[j].Path < rt[i].Path }
+// sort by path in reverse order (most to least specific). Routes with the
+// same path are sorted by the number of match conditions so that routes
+// with more conditions are checked first.
+func (rt Routes) Len() int      { return len(rt) }
+func (rt Routes) Swap(i, j int) { rt[i], rt[j] = rt[j], rt[i] }
+func (rt Routes) Less(i, j int) bool {
+	if rt[i].Path != rt[j].Path {
+		return rt[j].Path < rt[i].Path
+	}
+	if len(rt[i].conds) != len(rt[j].conds) {
+		return len(rt[i].conds) > len(rt[j].conds)
+	}
+	return rt[i].Match < rt[j].Match
+}
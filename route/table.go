@@ -36,9 +36,19 @@ func init() {
 }
 
 type metrix struct {
-	histogram gkm.Histogram
-	rxCounter gkm.Counter
-	txCounter gkm.Counter
+	histogram   gkm.Histogram
+	rxCounter   gkm.Counter
+	txCounter   gkm.Counter
+	ejections   gkm.Counter
+	ejected     gkm.Gauge
+	ratelimited gkm.Counter
+	wsRx        gkm.Counter
+	wsTx        gkm.Counter
+	wsRxFrames  gkm.Counter
+	wsTxFrames  gkm.Counter
+	wsDuration  gkm.Histogram
+	timeouts    gkm.Counter
+	dialTimeout gkm.Counter
 }
 
 var counters metrix
@@ -97,6 +107,43 @@ func cleanupStaleMetrics(oldTable, newTable Table) {
 			if dc, ok := counters.txCounter.(metrics.DeletableCounter); ok {
 				dc.DeleteLabelValues(service, host, path, target)
 			}
+			if dc, ok := counters.ejections.(metrics.DeletableCounter); ok {
+				dc.DeleteLabelValues(service, host, path, target)
+			}
+			if dg, ok := counters.ejected.(metrics.DeletableGauge); ok {
+				dg.DeleteLabelValues(service, host, path, target)
+			}
+			if dc, ok := counters.ratelimited.(metrics.DeletableCounter); ok {
+				dc.DeleteLabelValues(service, host, path, target)
+			}
+			for _, c := range []gkm.Counter{counters.wsRx, counters.wsTx, counters.wsRxFrames, counters.wsTxFrames, counters.timeouts, counters.dialTimeout} {
+				if dc, ok := c.(metrics.DeletableCounter); ok {
+					dc.DeleteLabelValues(service, host, path, target)
+				}
+			}
+			if dh, ok := counters.wsDuration.(metrics.DeletableHistogram); ok {
+				dh.DeleteLabelValues(service, host, path, target)
+			}
+		}
+	}
+
+	// Remove the stats of removed targets
+	newStats := make(map[string]struct{})
+	for _, routes := range newTable {
+		for _, r := range routes {
+			for _, target := range r.Targets {
+				newStats[r.statsKey(target.Service, target.URL.String())] = struct{}{}
+			}
+		}
+	}
+	for _, routes := range oldTable {
+		for _, r := range routes {
+			for _, target := range r.Targets {
+				key := r.statsKey(target.Service, target.URL.String())
+				if _, exists := newStats[key]; !exists {
+					targetLoad.Delete(key)
+				}
+			}
 		}
 	}
 }
@@ -105,6 +152,16 @@ func SetMetricsProvider(p metrics.Provider) {
 	counters.histogram = p.NewHistogram("route", "service", "host", "path", "target")
 	counters.rxCounter = p.NewCounter("route.rx", "service", "host", "path", "target")
 	counters.txCounter = p.NewCounter("route.tx", "service", "host", "path", "target")
+	counters.ejections = p.NewCounter("route.ejections", "service", "host", "path", "target")
+	counters.ejected = p.NewGauge("route.ejected", "service", "host", "path", "target")
+	counters.ratelimited = p.NewCounter("route.ratelimited", "service", "host", "path", "target")
+	counters.wsRx = p.NewCounter("route.ws.rx", "service", "host", "path", "target")
+	counters.wsTx = p.NewCounter("route.ws.tx", "service", "host", "path", "target")
+	counters.wsRxFrames = p.NewCounter("route.ws.rx.frames", "service", "host", "path", "target")
+	counters.wsTxFrames = p.NewCounter("route.ws.tx.frames", "service", "host", "path", "target")
+	counters.wsDuration = p.NewHistogram("route.ws.duration", "service", "host", "path", "target")
+	counters.timeouts = p.NewCounter("route.timeout", "service", "host", "path", "target")
+	counters.dialTimeout = p.NewCounter("route.dialtimeout", "service", "host", "path", "target")
 }
 
 // GetTable returns the active routing table. The function
@@ -123,14 +180,31 @@ func SetTable(t Table) {
 		log.Print("[WARN] Ignoring nil routing table")
 		return
 	}
+	tableMu.Lock()
+	defer tableMu.Unlock()
+	// Start the health checks for the new table and distribute the
+	// targets according to their current health since it may have
+	// changed after the table was built.
+	checks.sync(t)
+	if checks.active() {
+		for _, routes := range t {
+			for _, r := range routes {
+				r.weighTargets()
+			}
+		}
+	}
 	// Get the old table to compare against
 	oldTable := GetTable()
 	// Store the new table FIRST, then cleanup stale metrics.
 	// This order is important to avoid a race condition where traffic
 	// could recreate the metrics between delete and table swap.
 	table.Store(t)
+	idx := indexServices(t)
+	serviceRoutes.Store(&idx)
 	// Clean up metrics for removed targets after storing the new table
 	cleanupStaleMetrics(oldTable, t)
+	cleanupRateLimiters(t)
+	cleanupUpstreamTLS(t)
 }
 
 // Table contains a set of routes grouped by host.
@@ -235,30 +309,35 @@ func (t Table) addRoute(d *RouteDef) error {
 		return fmt.Errorf("route: invalid target. %s", err)
 	}
 
+	conds, match, err := parseMatch(d.Match)
+	if err != nil {
+		return err
+	}
+
 	switch {
 	// add new host
 	case t[host] == nil:
-		g, err := glob.Compile(path)
+		g, re, err := compilePath(path)
 		if err != nil {
 			return err
 		}
-		r := &Route{Host: host, Path: path, Glob: g}
+		r := &Route{Host: host, Path: path, Match: match, conds: conds, Glob: g, Regexp: re}
 		r.addTarget(d.Service, targetURL, d.Weight, d.Tags, d.Opts)
 		t[host] = Routes{r}
 
 	// add new route to existing host
-	case t[host].find(path) == nil:
-		g, err := glob.Compile(path)
+	case t[host].find(path, match) == nil:
+		g, re, err := compilePath(path)
 		if err != nil {
 			return err
 		}
-		r := &Route{Host: host, Path: path, Glob: g}
+		r := &Route{Host: host, Path: path, Match: match, conds: conds, Glob: g, Regexp: re}
 		r.addTarget(d.Service, targetURL, d.Weight, d.Tags, d.Opts)
 		t[host] = append(t[host], r)
 
 	// add new target to existing route
 	default:
-		t[host].find(path).addTarget(d.Service, targetURL, d.Weight, d.Tags, d.Opts)
+		t[host].find(path, match).addTarget(d.Service, targetURL, d.Weight, d.Tags, d.Opts)
 	}
 
 	return nil
@@ -271,11 +350,15 @@ func (t Table) weighRoute(d *RouteDef) error {
 		return errInvalidPrefix
 	}
 
-	if t[host] == nil || t[host].find(path) == nil {
-		return errNoMatch
+	// the weight applies to the routes for the path
+	// independent of their match conditions.
+	var n int
+	for _, r := range t[host] {
+		if r.Path == path {
+			n += r.setWeight(d.Service, d.Weight, d.Tags)
+		}
 	}
-
-	if n := t[host].find(path).setWeight(d.Service, d.Weight, d.Tags); n == 0 {
+	if n == 0 {
 		return errNoMatch
 	}
 	return nil
@@ -309,13 +392,11 @@ func (t Table) delRoute(d *RouteDef) error {
 		}
 
 	case d.Dst == "":
-		r := t.route(hostpath(d.Src))
-		if r == nil {
-			return nil
+		for _, r := range t.routes(hostpath(d.Src)) {
+			r.filter(func(tg *Target) bool {
+				return tg.Service == d.Service
+			})
 		}
-		r.filter(func(tg *Target) bool {
-			return tg.Service == d.Service
-		})
 
 	default:
 		targetURL, err := url.Parse(d.Dst)
@@ -323,13 +404,11 @@ func (t Table) delRoute(d *RouteDef) error {
 			return fmt.Errorf("route: invalid target. %s", err)
 		}
 
-		r := t.route(hostpath(d.Src))
-		if r == nil {
-			return nil
+		for _, r := range t.routes(hostpath(d.Src)) {
+			r.filter(func(tg *Target) bool {
+				return tg.Service == d.Service && tg.URL.String() == targetURL.String()
+			})
 		}
-		r.filter(func(tg *Target) bool {
-			return tg.Service == d.Service && tg.URL.String() == targetURL.String()
-		})
 	}
 
 	// remove all routes without targets
@@ -354,13 +433,31 @@ func (t Table) delRoute(d *RouteDef) error {
 	return nil
 }
 
-// route finds the route for host/path or returns nil if none exists.
-func (t Table) route(host, path string) *Route {
+// route finds the route for host/path with the given match conditions
+// or returns nil if none exists.
+func (t Table) route(host, path, match string) *Route {
 	routes := t[host]
 	if routes == nil {
 		return nil
 	}
-	return routes.find(path)
+	return routes.find(path, match)
+}
+
+// routeByKey returns the route for the route key or nil.
+func (t Table) routeByKey(k routeKey) *Route {
+	return t.route(k.host, k.path, k.match)
+}
+
+// routes returns the routes for host/path independent
+// of their match conditions.
+func (t Table) routes(host, path string) []*Route {
+	var routes []*Route
+	for _, r := range t[host] {
+		if r.Path == path {
+			routes = append(routes, r)
+		}
+	}
+	return routes
 }
 
 // normalizeHost returns the hostname from the request
@@ -484,7 +581,7 @@ func (t Table) Lookup(req *http.Request, pick picker, match matcher, globCache *
 
 	hosts = append(hosts, "")
 	for _, h := range hosts {
-		if target = t.lookup(h, req.URL.Path, pick, match); target != nil {
+		if target = t.lookup(h, req.URL.Path, req, pick, match); target != nil {
 			if target.RedirectCode != 0 {
 				req.URL.Host = req.Host
 				target.BuildRedirectURL(req.URL) // build redirect url and cache in target
@@ -502,26 +599,67 @@ func (t Table) Lookup(req *http.Request, pick picker, match matcher, globCache *
 	return target
 }
 
+// HasHost returns true if the table has routes for the host name. Host
+// name patterns match like for the requests unless globDisabled is set
+// and the port of the routes is ignored. Routes without a host name do
+// not match any host name.
+func (t Table) HasHost(host string, globCache *GlobCache, globDisabled bool) bool {
+	if host == "" {
+		return false
+	}
+	host = strings.ToLower(host)
+	for pattern := range t {
+		if pattern == "" {
+			continue
+		}
+		if h, _, err := net.SplitHostPort(pattern); err == nil {
+			pattern = h
+		}
+		if pattern == host {
+			return true
+		}
+		if globDisabled {
+			continue
+		}
+		g, err := globCache.Get(pattern)
+		if err != nil {
+			log.Print("[Error] Compiling glob - ", err)
+			continue
+		}
+		if g.Match(host) {
+			return true
+		}
+	}
+	return false
+}
+
 func (t Table) LookupHost(host string, pick picker) *Target {
-	return t.lookup(host, "/", pick, prefixMatcher)
+	return t.lookup(host, "/", nil, pick, prefixMatcher)
 }
 
-func (t Table) lookup(host, path string, pick picker, match matcher) *Target {
+// lookup returns the target for the first route of the host which matches
+// the path and the match conditions. Routes with consistent hashing pick
+// the target based on the request and fall back to the picker if the
+// request has no hash key. req can be nil.
+func (t Table) lookup(host, path string, req *http.Request, pick picker, match matcher) *Target {
 	host = strings.ToLower(host) // routes are always added lowercase
 	for _, r := range t[host] {
-		if match(path, r) {
+		if match(path, r) && r.matchRequest(req) {
 			n := len(r.Targets)
 			if n == 0 {
 				return nil
 			}
 
-			var target *Target
 			if n == 1 {
-				target = r.Targets[0]
-			} else {
-				target = pick(r)
+				return r.Targets[0]
+			}
+			if target := r.hashPick(req); target != nil {
+				return target
+			}
+			if r.pick != nil {
+				return r.pick(r)
 			}
-			return target
+			return pick(r)
 		}
 	}
 	return nil
@@ -421,7 +421,8 @@ func TestTableParse(t *testing.T) {
 				checked[path] = true
 
 				// fetch the route
-				r := tbl.route(hostpath(path))
+				host, p := hostpath(path)
+				r := tbl.route(host, p, "")
 				if r == nil {
 					t.Fatalf("got nil want route %s", path)
 				}
@@ -503,6 +504,41 @@ func TestNormalizeHost(t *testing.T) {
 	}
 }
 
+func TestTableHasHost(t *testing.T) {
+	s := `
+	route add svc a.com/api http://1.2.3.4/
+	route add svc b.com:443/ http://1.2.3.4/
+	route add svc *.c.com/ http://1.2.3.4/
+	route add svc / http://1.2.3.4/
+	`
+	tbl, err := NewTable(bytes.NewBufferString(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		host   string
+		want   bool
+		noGlob bool
+	}{
+		{"a.com", true, true},
+		{"A.COM", true, true},
+		{"b.com", true, true},
+		{"x.c.com", true, false},
+		{"c.com", false, false},
+		{"d.com", false, false},
+		{"", false, false},
+	}
+	for _, tt := range tests {
+		if got := tbl.HasHost(tt.host, NewGlobCache(10), false); got != tt.want {
+			t.Errorf("%q: got %v want %v", tt.host, got, tt.want)
+		}
+		if got := tbl.HasHost(tt.host, NewGlobCache(10), true); got != tt.noGlob {
+			t.Errorf("%q without glob: got %v want %v", tt.host, got, tt.noGlob)
+		}
+	}
+}
+
 // see https://github.com/fabiolb/fabio/issues/448
 // for more information on the issue and purpose of this test
 func TestTableLookupIssue448(t *testing.T) {
@@ -654,6 +690,70 @@ func TestTableLookup(t *testing.T) {
 	}
 }
 
+func TestTableLookupMatch(t *testing.T) {
+	s := `
+	route add svc / http://foo.com:800
+	route add svc /foo http://foo.com:900
+	route add canary / http://foo.com:1000 match "header:X-Canary=true"
+	route add v2 / http://foo.com:2000 match "query:version=2"
+	route add v2canary / http://foo.com:3000 match "query:version=2 header:x-canary=true"
+	route add write / http://foo.com:4000 match "method:post,PUT"
+	route add debug / http://foo.com:5000 match "header:X-Debug"
+	`
+
+	tbl, err := NewTable(bytes.NewBufferString(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := func(method, uri string, header ...string) *http.Request {
+		r := &http.Request{Method: method, URL: mustParse(uri), Header: http.Header{}}
+		for i := 0; i < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		return r
+	}
+
+	var tests = []struct {
+		desc string
+		req  *http.Request
+		dst  string
+	}{
+		{"no conditions", req("GET", "/"), "http://foo.com:800"},
+		{"header", req("GET", "/", "X-Canary", "true"), "http://foo.com:1000"},
+		{"header value does not match", req("GET", "/", "X-Canary", "false"), "http://foo.com:800"},
+		{"query", req("GET", "/?version=2"), "http://foo.com:2000"},
+		{"query value does not match", req("GET", "/?version=3"), "http://foo.com:800"},
+		{"more conditions first", req("GET", "/?version=2", "X-Canary", "true"), "http://foo.com:3000"},
+		{"method", req("PUT", "/"), "http://foo.com:4000"},
+		{"header present", req("GET", "/", "X-Debug", ""), "http://foo.com:5000"},
+		{"more specific path first", req("GET", "/foo", "X-Canary", "true"), "http://foo.com:900"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			if got, want := tbl.Lookup(tt.req, rndPicker, prefixMatcher, globCache, globEnabled).URL.String(), tt.dst; got != want {
+				t.Errorf("got %v want %v", got, want)
+			}
+		})
+	}
+
+	// routes with match conditions are not used without a request
+	if got, want := tbl.LookupHost("", rndPicker).URL.String(), "http://foo.com:800"; got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+
+	// match conditions are part of the route config
+	want := `route add canary / http://foo.com:1000 match "header:X-Canary=true"`
+	if !strings.Contains(tbl.String(), want) {
+		t.Errorf("got %s want %s", tbl.String(), want)
+	}
+
+	if _, err := NewTable(bytes.NewBufferString(`route add svc / http://foo.com/ match "cookie:foo"`)); err == nil {
+		t.Error("got nil want error for invalid match condition")
+	}
+}
+
 func TestTableLookup_656(t *testing.T) {
 	// A typical HTTPS redirect
 	s := `
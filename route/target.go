@@ -1,10 +1,14 @@
 package route
 
 import (
+	"crypto/tls"
+
 	gkm "github.com/go-kit/kit/metrics"
 	"net/http"
 	"net/url"
+	"regexp"
 	"strings"
+	"time"
 )
 
 type Target struct {
@@ -16,6 +20,25 @@ type Target struct {
 	RxCounter gkm.Counter
 	TxCounter gkm.Counter
 
+	// RateLimitCounter counts the requests and connections which
+	// were rejected by the rate limit.
+	RateLimitCounter gkm.Counter
+
+	// WSRxCounter and WSTxCounter count the bytes and WSRxFrames and
+	// WSTxFrames the frames which were received from and sent to the
+	// clients of websocket sessions. WSDuration measures the duration
+	// of the sessions.
+	WSRxCounter gkm.Counter
+	WSTxCounter gkm.Counter
+	WSRxFrames  gkm.Counter
+	WSTxFrames  gkm.Counter
+	WSDuration  gkm.Histogram
+
+	// TimeoutCounter and DialTimeoutCounter count the requests which
+	// exceeded the route timeout and the upstream connect timeout.
+	TimeoutCounter     gkm.Counter
+	DialTimeoutCounter gkm.Counter
+
 	// Opts is the raw options for the target.
 	Opts map[string]string
 
@@ -42,6 +65,14 @@ type Target struct {
 	// request path (after StripPath has been removed)
 	PrependPath string
 
+	// Rewrite replaces the part of the request path which matches
+	// the route path as a regular expression. It can refer to the
+	// captured groups with $1 or ${name}.
+	Rewrite string
+
+	// pathRe is the compiled regular expression of the route path.
+	pathRe *regexp.Regexp
+
 	// Host signifies what the proxy will set the Host header to.
 	// The proxy does not modify the Host header by default.
 	// When Host is set to 'dst' the proxy will use the host name
@@ -69,8 +100,187 @@ type Target struct {
 	// TLS connections.
 	TLSSkipVerify bool
 
-	// ProxyProto enables PROXY Protocol on upstream connection
-	ProxyProto bool
+	// UpstreamTLS is the TLS config with the client certificate and
+	// the CA certificates for upstream connections. It is nil if the
+	// route has no 'upstreamcert' or 'upstreamca' option.
+	UpstreamTLS *tls.Config
+
+	// TLS is 'passthrough' or 'terminate' to forward TLS connections
+	// on https+tcp+sni listeners with or without terminating TLS. It
+	// is empty if the default for the protocol of the target applies.
+	TLS string
+
+	// ProxyProto enables PROXY Protocol on upstream connection.
+	// ProxyProtoV2 sends the binary v2 header with TLVs instead
+	// of the v1 text header.
+	ProxyProto   bool
+	ProxyProtoV2 bool
+
+	// Retry is the policy for retrying failed idempotent requests
+	// with another target. It is nil if retries are disabled.
+	Retry *RetryPolicy
+
+	// Mirror is the name of the service which receives a copy of
+	// MirrorPct percent of the requests. Its responses are discarded.
+	Mirror    string
+	MirrorPct float64
+
+	// Compress is 'on' or 'off' to enable or disable the compression of
+	// responses or the list of content encodings for the compression.
+	// It is empty if the proxy.gzip.contenttype setting applies.
+	Compress string
+
+	// DecompressRequest enables the decompression of compressed
+	// request bodies before they are forwarded.
+	DecompressRequest bool
+
+	// UpstreamEncodings is the list of content encodings which are
+	// requested from the upstream. Responses with an encoding the
+	// client does not accept are decompressed.
+	UpstreamEncodings []string
+
+	// Cache enables the caching of responses to GET requests.
+	// CacheTTL is the freshness lifetime of responses without
+	// Cache-Control or Expires header.
+	Cache    bool
+	CacheTTL time.Duration
+
+	// ReqHeaders and RespHeaders modify the request and
+	// response headers. They are nil if there are no rules.
+	ReqHeaders  *HeaderRules
+	RespHeaders *HeaderRules
+
+	// WS limits the websocket sessions. It is nil if the
+	// route has no websocket options.
+	WS *WSPolicy
+
+	// Timeout is the maximum duration of upstream requests. DialTimeout
+	// and IdleTimeout override the connect timeout and the idle timeout
+	// of the upstream connections. Zero means that the global settings
+	// apply.
+	Timeout     time.Duration
+	DialTimeout time.Duration
+	IdleTimeout time.Duration
+
+	// MaxBody and MaxResponseBody are the maximum sizes of the request
+	// and response bodies in bytes. Zero means that the global limit
+	// applies and a negative value disables the limit for the route.
+	MaxBody         int64
+	MaxResponseBody int64
+
+	// limiter is the rate limiter of the route. It is nil if the
+	// route has no rate limit.
+	limiter *rateLimiter
+
+	// stats tracks the active requests, the latency and the health
+	// of this target.
+	stats *targetStats
+
+	// check is the active health check of this target.
+	check *healthCheck
+}
+
+// Healthy returns false if the active health check of the target fails
+// or if the target has been ejected by the outlier detection.
+func (t *Target) Healthy() bool {
+	return t.stats == nil || !(t.stats.unhealthy.Load() || t.stats.ejected.Load())
+}
+
+// Health returns "ejected" if the target has been ejected by the outlier
+// detection. Otherwise, it returns the result of the active health check
+// as "healthy" or "unhealthy" or "" if the target is not checked.
+func (t *Target) Health() string {
+	switch {
+	case t.stats != nil && t.stats.ejected.Load():
+		return "ejected"
+	case t.check == nil:
+		return ""
+	case t.Healthy():
+		return "healthy"
+	default:
+		return "unhealthy"
+	}
+}
+
+// TLSPassthrough returns true if TLS connections for the target on
+// https+tcp+sni listeners are forwarded without terminating TLS.
+// This is the default for TCP targets.
+func (t *Target) TLSPassthrough() bool {
+	switch t.TLS {
+	case "passthrough":
+		return true
+	case "terminate":
+		return false
+	default:
+		return t.proto() == "tcp"
+	}
+}
+
+// TLSTerminateTCP returns true if TLS connections for the TCP target
+// on https+tcp+sni listeners are terminated and the plaintext data is
+// forwarded to the target.
+func (t *Target) TLSTerminateTCP() bool {
+	return t.TLS == "terminate" && t.proto() == "tcp"
+}
+
+// proto returns the protocol of the target. The 'proto' option
+// overrides the scheme of the target URL.
+func (t *Target) proto() string {
+	if proto, ok := t.Opts["proto"]; ok {
+		return proto
+	}
+	if t.URL != nil {
+		return t.URL.Scheme
+	}
+	return ""
+}
+
+// Begin marks the start of a request or connection to the target.
+// Every call to Begin must be followed by a call to End.
+func (t *Target) Begin() {
+	if t.stats != nil {
+		t.stats.inflight.Add(1)
+	}
+}
+
+// End marks the end of a request or connection to the target.
+// If d is greater than zero it is used to update the latency
+// estimate of the target.
+func (t *Target) End(d time.Duration) {
+	if t.stats == nil {
+		return
+	}
+	t.stats.inflight.Add(-1)
+	if d > 0 {
+		t.stats.observe(d)
+	}
+}
+
+// InFlight returns the number of active requests or connections
+// to the target.
+func (t *Target) InFlight() int64 {
+	if t.stats == nil {
+		return 0
+	}
+	return t.stats.inflight.Load()
+}
+
+// RewritePath returns the path with the part which matches the route
+// path replaced by the expanded Rewrite template. The path is returned
+// unchanged if the target has no rewrite or the path does not match.
+func (t *Target) RewritePath(path string) string {
+	if t.Rewrite == "" || t.pathRe == nil {
+		return path
+	}
+	m := t.pathRe.FindStringSubmatchIndex(path)
+	if m == nil {
+		return path
+	}
+	p := string(t.pathRe.ExpandString(nil, t.Rewrite, path, m)) + path[m[1]:]
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	return p
 }
 
 func (t *Target) BuildRedirectURL(requestURL *url.URL) {
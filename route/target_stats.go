@@ -0,0 +1,105 @@
+package route
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ewmaDecay is the time constant for the peak EWMA latency estimate.
+// Observations older than this contribute less than 1/e to the estimate.
+var ewmaDecay = 10 * time.Second
+
+// now returns the current time. It is a variable to allow testing.
+var now = time.Now
+
+// targetStats tracks the load of a target. The stats are shared between
+// targets with the same service, host, path and target URL so that they
+// survive routing table updates.
+type targetStats struct {
+	// inflight is the number of active requests or connections.
+	inflight atomic.Int64
+
+	// unhealthy is set when the active health check of the target fails.
+	unhealthy atomic.Bool
+
+	// ejected is set when the outlier detection has ejected the target.
+	ejected atomic.Bool
+
+	// outlier tracks the failed requests for the outlier detection.
+	outlier outlierStats
+
+	// route identifies the route of the target.
+	route routeKey
+
+	mu sync.Mutex
+
+	// ewma is the peak EWMA of the request latency in nanoseconds.
+	ewma float64
+
+	// stamp is the time of the last latency observation.
+	stamp time.Time
+}
+
+// observe updates the peak EWMA with the latency d. Latencies above the
+// current estimate replace it immediately while lower latencies decay
+// the estimate over time.
+func (s *targetStats) observe(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, rtt := now(), float64(d)
+	switch {
+	case s.stamp.IsZero(), rtt > s.ewma:
+		s.ewma = rtt
+	default:
+		w := math.Exp(-float64(t.Sub(s.stamp)) / float64(ewmaDecay))
+		s.ewma = s.ewma*w + rtt*(1-w)
+	}
+	s.stamp = t
+}
+
+// latency returns the current peak EWMA latency in nanoseconds
+// or zero if there are no observations.
+func (s *targetStats) latency() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ewma
+}
+
+// targetLoad stores the targetStats by stats key.
+var targetLoad sync.Map
+
+// statsKey returns the key of the stats of a target of the route. It is
+// the metric key for routes without match conditions since their metrics
+// and stats are removed together.
+func (r *Route) statsKey(service, target string) string {
+	key := makeMetricKey(service, r.Host, r.Path, target)
+	if r.Match != "" {
+		key += "\x00" + r.Match
+	}
+	return key
+}
+
+// routeKey identifies a route in the routing table. Routes are copied
+// when the table changes and the targets find their current route with
+// its key.
+type routeKey struct {
+	host, path, match string
+}
+
+// key returns the key of the route.
+func (r *Route) key() routeKey {
+	return routeKey{host: r.Host, path: r.Path, match: r.Match}
+}
+
+// loadStats returns the stats for the given stats key of a target of
+// the route rk.
+func loadStats(key string, rk routeKey) *targetStats {
+	if s, ok := targetLoad.Load(key); ok {
+		return s.(*targetStats)
+	}
+	v, _ := targetLoad.LoadOrStore(key, &targetStats{route: rk})
+	return v.(*targetStats)
+}
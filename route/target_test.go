@@ -193,3 +193,105 @@ func TestTarget_BuildRedirectURL(t *testing.T) {
 		}
 	}
 }
+
+func TestTarget_RewritePath(t *testing.T) {
+	tests := []struct {
+		route string
+		path  string
+		want  string
+	}{
+		{`route add svc /api/v1/(.*) http://bar.com/ opts "rewrite=/api/v2/$1"`, "/api/v1/users", "/api/v2/users"},
+		{`route add svc /users/(?P<id>\d+) http://bar.com/ opts "rewrite=/u/${id}/profile"`, "/users/123/x", "/u/123/profile/x"},
+		{`route add svc /(\w+)/(\w+) http://bar.com/ opts "rewrite=$2/$1"`, "/a/b", "/b/a"},
+		{`route add svc /api/v1/(.*) http://bar.com/ opts "rewrite=/api/v2/$1"`, "/other", "/other"},
+		{`route add svc /api/v1/(.*) http://bar.com/`, "/api/v1/users", "/api/v1/users"},
+		{`route add svc /api/v1/( http://bar.com/ opts "rewrite=/x"`, "/api/v1/(", "/api/v1/("},
+	}
+	for _, tt := range tests {
+		tbl, err := NewTable(bytes.NewBufferString(tt.route))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, routes := range tbl {
+			if got := routes[0].Targets[0].RewritePath(tt.path); got != tt.want {
+				t.Errorf("%s: got %s want %s", tt.route, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestTarget_Mirror(t *testing.T) {
+	tests := []struct {
+		route  string
+		mirror string
+		pct    float64
+	}{
+		{`route add svc / http://bar.com/`, "", 0},
+		{`route add svc / http://bar.com/ opts "mirror=svc-v2"`, "svc-v2", 100},
+		{`route add svc / http://bar.com/ opts "mirror=svc-v2 mirror_pct=10"`, "svc-v2", 10},
+		{`route add svc / http://bar.com/ opts "mirror=svc-v2 mirror_pct=101"`, "", 0},
+	}
+	for _, tt := range tests {
+		tbl, err := NewTable(bytes.NewBufferString(tt.route))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tg := tbl[""][0].Targets[0]
+		if tg.Mirror != tt.mirror || tg.MirrorPct != tt.pct {
+			t.Errorf("%s: got %q %v want %q %v", tt.route, tg.Mirror, tg.MirrorPct, tt.mirror, tt.pct)
+		}
+	}
+}
+
+func TestTarget_TLS(t *testing.T) {
+	tests := []struct {
+		route                  string
+		passthrough, terminate bool
+	}{
+		{`route add svc a.com/ tcp://1.2.3.4:443`, true, false},
+		{`route add svc a.com/ tcp://1.2.3.4:443 opts "tls=passthrough"`, true, false},
+		{`route add svc a.com/ tcp://1.2.3.4:80 opts "tls=terminate"`, false, true},
+		{`route add svc a.com/ http://1.2.3.4:80 opts "proto=tcp tls=terminate"`, false, true},
+		{`route add svc a.com/ http://1.2.3.4:80`, false, false},
+		{`route add svc a.com/ https://1.2.3.4:443 opts "tls=passthrough"`, true, false},
+		{`route add svc a.com/ http://1.2.3.4:80 opts "tls=terminate"`, false, false},
+		{`route add svc a.com/ tcp://1.2.3.4:443 opts "tls=foo"`, true, false},
+	}
+	for _, tt := range tests {
+		tbl, err := NewTable(bytes.NewBufferString(tt.route))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tg := tbl["a.com"][0].Targets[0]
+		if got, want := tg.TLSPassthrough(), tt.passthrough; got != want {
+			t.Errorf("%s: got passthrough %v want %v", tt.route, got, want)
+		}
+		if got, want := tg.TLSTerminateTCP(), tt.terminate; got != want {
+			t.Errorf("%s: got terminate %v want %v", tt.route, got, want)
+		}
+	}
+}
+
+func TestMirrorTarget(t *testing.T) {
+	tbl, err := NewTable(bytes.NewBufferString(`
+route add svc / http://foo.com/
+route add svc-v2 mirror.internal/ http://bar.com/
+route add svc-v2 other.internal/ http://bar.com/
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetTable(tbl)
+	defer SetTable(make(Table))
+
+	if tg := MirrorTarget("svc-v2"); tg == nil || tg.URL.String() != "http://bar.com/" {
+		t.Fatalf("got %v want http://bar.com/", tg)
+	}
+	if tg := MirrorTarget("unknown"); tg != nil {
+		t.Fatalf("got %v want nil", tg.URL)
+	}
+	// the routes of the services are indexed when the table is set
+	if got, want := len((*serviceRoutes.Load())["svc-v2"]), 2; got != want {
+		t.Fatalf("got %d indexed routes want %d", got, want)
+	}
+}
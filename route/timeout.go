@@ -0,0 +1,37 @@
+package route
+
+import (
+	"fmt"
+	"time"
+)
+
+// parseTimeouts returns the upstream timeouts of the route. It returns
+// zero if an option is not set and the global setting applies. It is
+// configured with the following options:
+//
+//	timeout=60s        maximum duration of the upstream request
+//	dialtimeout=500ms  maximum duration of connecting to the upstream
+//	idletimeout=90s    time after which idle upstream connections are closed
+func parseTimeouts(opts map[string]string) (timeout, dial, idle time.Duration, err error) {
+	parse := func(name string) (time.Duration, error) {
+		v := opts[name]
+		if v == "" {
+			return 0, nil
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return 0, fmt.Errorf("route: invalid %s %q", name, v)
+		}
+		return d, nil
+	}
+	if timeout, err = parse("timeout"); err != nil {
+		return 0, 0, 0, err
+	}
+	if dial, err = parse("dialtimeout"); err != nil {
+		return 0, 0, 0, err
+	}
+	if idle, err = parse("idletimeout"); err != nil {
+		return 0, 0, 0, err
+	}
+	return timeout, dial, idle, nil
+}
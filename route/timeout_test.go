@@ -0,0 +1,36 @@
+package route
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimeouts(t *testing.T) {
+	tests := []struct {
+		desc                string
+		opts                map[string]string
+		timeout, dial, idle time.Duration
+		err                 bool
+	}{
+		{"no options", map[string]string{}, 0, 0, 0, false},
+		{"timeout", map[string]string{"timeout": "60s"}, 60 * time.Second, 0, 0, false},
+		{"dialtimeout", map[string]string{"dialtimeout": "500ms"}, 0, 500 * time.Millisecond, 0, false},
+		{"idletimeout", map[string]string{"idletimeout": "2m"}, 0, 0, 2 * time.Minute, false},
+		{"all", map[string]string{"timeout": "1s", "dialtimeout": "2s", "idletimeout": "3s"}, time.Second, 2 * time.Second, 3 * time.Second, false},
+		{"invalid timeout", map[string]string{"timeout": "60"}, 0, 0, 0, true},
+		{"negative dialtimeout", map[string]string{"dialtimeout": "-1s"}, 0, 0, 0, true},
+		{"invalid idletimeout", map[string]string{"idletimeout": "x"}, 0, 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			timeout, dial, idle, err := parseTimeouts(tt.opts)
+			if got, want := err != nil, tt.err; got != want {
+				t.Fatalf("got error %v want error %v", err, want)
+			}
+			if timeout != tt.timeout || dial != tt.dial || idle != tt.idle {
+				t.Fatalf("got %s, %s, %s want %s, %s, %s", timeout, dial, idle, tt.timeout, tt.dial, tt.idle)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package route
+
+import (
+	"crypto/tls"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/fabiolb/fabio/transport"
+)
+
+// transports contains the transports of targets which need their own
+// settings. Targets with the same settings share a transport which keeps
+// the connections open across routing table updates.
+var transports sync.Map
+
+// transportKey contains the settings of a target transport.
+type transportKey struct {
+	// dial and idle are the dial and idle connection timeouts.
+	dial, idle time.Duration
+
+	// serverName overrides the TLS server name if it is not empty.
+	serverName    string
+	tlsSkipVerify bool
+
+	// upstreamTLS is the TLS config with the client certificate and
+	// the CA certificates. The configs are shared and can be compared
+	// by pointer.
+	upstreamTLS *tls.Config
+
+	// h2c enables HTTP/2 with prior knowledge.
+	h2c bool
+
+	// proxyProto enables PROXY protocol headers. v2 headers describe a
+	// single request and the connections are not reused.
+	proxyProto, proxyProtoV2 bool
+}
+
+// targetTransport returns the shared transport for the settings.
+func targetTransport(k transportKey) *http.Transport {
+	if tr, ok := transports.Load(k); ok {
+		return tr.(*http.Transport)
+	}
+
+	var tr *http.Transport
+	if k.h2c {
+		tr = transport.NewH2CTransport()
+	} else {
+		tlscfg := &tls.Config{}
+		if k.upstreamTLS != nil {
+			tlscfg = k.upstreamTLS.Clone()
+		}
+		tlscfg.ServerName = k.serverName
+		tlscfg.InsecureSkipVerify = k.tlsSkipVerify
+		tr = transport.NewTransport(tlscfg)
+	}
+	transport.SetTimeouts(tr, k.dial, k.idle)
+	if k.proxyProtoV2 {
+		transport.SetProxyHeader(tr)
+	}
+
+	v, _ := transports.LoadOrStore(k, tr)
+	return v.(*http.Transport)
+}
@@ -0,0 +1,63 @@
+package route
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestTargetTransport(t *testing.T) {
+	a := targetTransport(transportKey{dial: time.Second})
+	if got, want := targetTransport(transportKey{dial: time.Second}), a; got != want {
+		t.Fatal("transports with the same settings are not shared")
+	}
+	if got := targetTransport(transportKey{dial: 2 * time.Second}); got == a {
+		t.Fatal("transports with different settings are shared")
+	}
+	if got, want := targetTransport(transportKey{idle: time.Minute}).IdleConnTimeout, time.Minute; got != want {
+		t.Fatalf("got idle timeout %s want %s", got, want)
+	}
+	if got, want := targetTransport(transportKey{serverName: "example.com"}).TLSClientConfig.ServerName, "example.com"; got != want {
+		t.Fatalf("got server name %q want %q", got, want)
+	}
+	if got := targetTransport(transportKey{proxyProto: true}); got.DisableKeepAlives {
+		t.Fatal("transports with PROXY protocol v1 do not reuse connections")
+	}
+	if got := targetTransport(transportKey{proxyProto: true, proxyProtoV2: true}); !got.DisableKeepAlives {
+		t.Fatal("transports with PROXY protocol v2 reuse connections")
+	}
+}
+
+func TestProxyProtoOption(t *testing.T) {
+	tbl, err := NewTable(bytes.NewBufferString(`
+		route add a a.com/ http://1.2.3.4/ opts "pxyproto=true"
+		route add b b.com/ http://1.2.3.5/ opts "pxyproto=v2"
+		route add c :1234 tcp://1.2.3.6:5678 opts "pxyproto=v2"
+		route add d d.com/ http://1.2.3.7/ opts "pxyproto=v3"`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := func(host string) *Target { return tbl[host][0].Targets[0] }
+
+	tests := []struct {
+		host      string
+		pxy, v2   bool
+		transport bool
+	}{
+		{"a.com", true, false, true},
+		{"b.com", true, true, true},
+		{":1234", true, true, false},
+		{"d.com", false, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			tg := target(tt.host)
+			if tg.ProxyProto != tt.pxy || tg.ProxyProtoV2 != tt.v2 {
+				t.Fatalf("got pxyproto %v, v2 %v want %v, %v", tg.ProxyProto, tg.ProxyProtoV2, tt.pxy, tt.v2)
+			}
+			if got, want := tg.Transport != nil, tt.transport; got != want {
+				t.Fatalf("got transport %v want %v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package route
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/fabiolb/fabio/cert"
+	"github.com/fabiolb/fabio/config"
+)
+
+// certSources contains the certificate sources from proxy.cs by name.
+var certSources map[string]config.CertSource
+
+// SetCertSources configures the certificate sources which routes can
+// use for upstream client certificates with the 'upstreamcert' option.
+// It must be called before the routing table is built.
+func SetCertSources(cs map[string]config.CertSource) {
+	upstreamTLSMu.Lock()
+	defer upstreamTLSMu.Unlock()
+	certSources = cs
+	clientCerts = map[string]*tls.Config{}
+	upstreamTLSConfigs = map[upstreamTLSKey]*upstreamTLSConfig{}
+}
+
+// upstreamTLSKey contains the name of the certificate source and the
+// path of the CA bundle of an upstream TLS config.
+type upstreamTLSKey struct {
+	cert, ca string
+}
+
+// upstreamTLSConfig is a shared upstream TLS config with the state of
+// the CA bundle it was built from.
+type upstreamTLSConfig struct {
+	cfg *tls.Config
+
+	// caMod and caSize detect changes of the CA bundle.
+	caMod  time.Time
+	caSize int64
+}
+
+var (
+	upstreamTLSMu sync.Mutex
+
+	// upstreamTLSConfigs contains the upstream TLS configs. They are
+	// shared across routing table updates and are removed when no
+	// route uses them anymore.
+	upstreamTLSConfigs = map[upstreamTLSKey]*upstreamTLSConfig{}
+
+	// clientCerts contains the TLS configs with the client certificates
+	// by name of the certificate source. Every certificate source is
+	// only started once and rotates its certificates the same way as
+	// the certificate sources of the listeners. Since proxy.cs cannot
+	// change at runtime their number is bounded like for the listeners.
+	clientCerts = map[string]*tls.Config{}
+)
+
+// parseUpstreamTLS returns the TLS config for upstream connections with
+// the client certificate and the CA certificates of the route or nil if
+// the route has neither. The CA bundle is loaded again when it has
+// changed since the last routing table update. It is configured with
+// the following options:
+//
+//	upstreamcert=name  name of the cert source from proxy.cs for the client certificate
+//	upstreamca=path    path of the PEM encoded CA bundle for validating the upstream
+func parseUpstreamTLS(opts map[string]string) (*tls.Config, error) {
+	k := upstreamTLSKey{cert: opts["upstreamcert"], ca: opts["upstreamca"]}
+	if k == (upstreamTLSKey{}) {
+		return nil, nil
+	}
+
+	upstreamTLSMu.Lock()
+	defer upstreamTLSMu.Unlock()
+
+	var fi os.FileInfo
+	if k.ca != "" {
+		var err error
+		if fi, err = os.Stat(k.ca); err != nil {
+			return nil, fmt.Errorf("route: invalid upstreamca %q. %s", k.ca, err)
+		}
+	}
+
+	c := upstreamTLSConfigs[k]
+	if c != nil && (fi == nil || fi.ModTime().Equal(c.caMod) && fi.Size() == c.caSize) {
+		return c.cfg, nil
+	}
+
+	var rootCAs *x509.CertPool
+	if k.ca != "" {
+		var err error
+		if rootCAs, err = cert.LoadCAs(k.ca); err != nil {
+			return nil, fmt.Errorf("route: invalid upstreamca %q. %s", k.ca, err)
+		}
+	}
+
+	cfg := &tls.Config{RootCAs: rootCAs}
+	if k.cert != "" {
+		client, ok := clientCerts[k.cert]
+		if !ok {
+			cs, ok := certSources[k.cert]
+			if !ok {
+				return nil, fmt.Errorf("route: unknown upstreamcert %q", k.cert)
+			}
+			src, err := cert.NewSource(cs)
+			if err != nil {
+				return nil, fmt.Errorf("route: invalid upstreamcert %q. %s", k.cert, err)
+			}
+			client = cert.ClientTLSConfig(src, nil)
+			clientCerts[k.cert] = client
+		}
+		cfg = client.Clone()
+		cfg.RootCAs = rootCAs
+	}
+
+	c = &upstreamTLSConfig{cfg: cfg}
+	if fi != nil {
+		c.caMod, c.caSize = fi.ModTime(), fi.Size()
+	}
+	upstreamTLSConfigs[k] = c
+	return cfg, nil
+}
+
+// cleanupUpstreamTLS removes the upstream TLS configs and the transports
+// which use them if they are no longer used by the routing table.
+func cleanupUpstreamTLS(t Table) {
+	active := map[*tls.Config]bool{}
+	for _, routes := range t {
+		for _, r := range routes {
+			for _, target := range r.Targets {
+				if target.UpstreamTLS != nil {
+					active[target.UpstreamTLS] = true
+				}
+			}
+		}
+	}
+
+	upstreamTLSMu.Lock()
+	for k, c := range upstreamTLSConfigs {
+		if !active[c.cfg] {
+			delete(upstreamTLSConfigs, k)
+		}
+	}
+	upstreamTLSMu.Unlock()
+
+	transports.Range(func(k, v any) bool {
+		if cfg := k.(transportKey).upstreamTLS; cfg != nil && !active[cfg] {
+			transports.Delete(k)
+			v.(*http.Transport).CloseIdleConnections()
+		}
+		return true
+	})
+}
@@ -0,0 +1,157 @@
+package route
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fabiolb/fabio/config"
+)
+
+// caPEM is a self-signed CA certificate for example.com.
+const caPEM = `-----BEGIN CERTIFICATE-----
+MIIBgTCCASegAwIBAgIUGtNFpdTCkjbql7D5S46NGLjnJUQwCgYIKoZIzj0EAwIw
+FjEUMBIGA1UEAwwLZXhhbXBsZS5jb20wHhcNMjYxMDE4MDAzNDQ3WhcNMzYxMDE1
+MDAzNDQ3WjAWMRQwEgYDVQQDDAtleGFtcGxlLmNvbTBZMBMGByqGSM49AgEGCCqG
+SM49AwEHA0IABB/0xASLRQhRAGK5ZzQeiWASxv3hWLA1Aa6jk/fTkK0FEObL/yPs
+bP9AAgGicmRX86ZD0PZzdKx4WULYzybpRrCjUzBRMB0GA1UdDgQWBBSMG9DMaFow
+o4z6eihNv9VAHjhSGjAfBgNVHSMEGDAWgBSMG9DMaFowo4z6eihNv9VAHjhSGjAP
+BgNVHRMBAf8EBTADAQH/MAoGCCqGSM49BAMCA0gAMEUCIQDWMGPgRYdn2R+SMLYh
+i/86SViOHqVh7gDWAGaIdTwX8QIgO5i1gz/EnZRWcVmOUnNtmZMD9Z31tGUmF8d/
+mFpXYlA=
+-----END CERTIFICATE-----
+`
+
+func TestParseUpstreamTLS(t *testing.T) {
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(caFile, []byte(caPEM), 0644); err != nil {
+		t.Fatal(err)
+	}
+	SetCertSources(map[string]config.CertSource{
+		"upstream": {Name: "upstream", Type: "path", CertPath: dir},
+	})
+	defer SetCertSources(nil)
+
+	tests := []struct {
+		desc       string
+		opts       map[string]string
+		cfg        bool
+		clientCert bool
+		rootCAs    bool
+		err        bool
+	}{
+		{"no options", map[string]string{}, false, false, false, false},
+		{"ca", map[string]string{"upstreamca": caFile}, true, false, true, false},
+		{"cert", map[string]string{"upstreamcert": "upstream"}, true, true, false, false},
+		{"cert and ca", map[string]string{"upstreamcert": "upstream", "upstreamca": caFile}, true, true, true, false},
+		{"unknown cert", map[string]string{"upstreamcert": "foo"}, false, false, false, true},
+		{"missing ca", map[string]string{"upstreamca": filepath.Join(dir, "missing.pem")}, false, false, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			cfg, err := parseUpstreamTLS(tt.opts)
+			if got, want := err != nil, tt.err; got != want {
+				t.Fatalf("got error %v want %v", err, want)
+			}
+			if got, want := cfg != nil, tt.cfg; got != want {
+				t.Fatalf("got config %v want %v", got, want)
+			}
+			if cfg == nil {
+				return
+			}
+			if got, want := cfg.GetClientCertificate != nil, tt.clientCert; got != want {
+				t.Fatalf("got client certificate %v want %v", got, want)
+			}
+			if got, want := cfg.RootCAs != nil, tt.rootCAs; got != want {
+				t.Fatalf("got root CAs %v want %v", got, want)
+			}
+			if again, _ := parseUpstreamTLS(tt.opts); again != cfg {
+				t.Fatal("configs with the same options are not shared")
+			}
+		})
+	}
+}
+
+func TestUpstreamTLSOption(t *testing.T) {
+	dir := t.TempDir()
+	SetCertSources(map[string]config.CertSource{
+		"upstream": {Name: "upstream", Type: "path", CertPath: dir},
+	})
+	defer SetCertSources(nil)
+
+	tbl, err := NewTable(bytes.NewBufferString(`
+		route add a a.com/ https://1.2.3.4/ opts "upstreamcert=upstream"
+		route add b b.com/ http://1.2.3.5/ opts "upstreamcert=upstream"
+		route add c c.com/ https://1.2.3.6/ opts "upstreamcert=foo"`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := func(host string) *Target { return tbl[host][0].Targets[0] }
+
+	if tr := target("a.com").Transport; tr == nil || tr.TLSClientConfig.GetClientCertificate == nil {
+		t.Fatal("https target has no transport with client certificate")
+	}
+	if tr := target("b.com").Transport; tr != nil {
+		t.Fatal("http target has a transport with client certificate")
+	}
+	if tg := target("c.com"); tg.UpstreamTLS != nil || tg.Transport != nil {
+		t.Fatal("target with unknown cert source has upstream TLS config")
+	}
+}
+
+func TestUpstreamTLSReload(t *testing.T) {
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(caFile, []byte(caPEM), 0644); err != nil {
+		t.Fatal(err)
+	}
+	SetCertSources(nil)
+	defer SetCertSources(nil)
+
+	opts := map[string]string{"upstreamca": caFile}
+	cfg, err := parseUpstreamTLS(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a CA bundle with a different size is loaded again
+	if err := os.WriteFile(caFile, []byte(caPEM+caPEM), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg2, err := parseUpstreamTLS(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg2 == cfg {
+		t.Fatal("changed CA bundle was not loaded again")
+	}
+
+	tbl := Table{"www.bar.com": Routes{&Route{
+		Host:    "www.bar.com",
+		Path:    "/",
+		Targets: []*Target{{Service: "svc", UpstreamTLS: cfg2}},
+	}}}
+	targetTransport(transportKey{upstreamTLS: cfg})
+	targetTransport(transportKey{upstreamTLS: cfg2})
+
+	cleanupUpstreamTLS(tbl)
+	if got, want := len(upstreamTLSConfigs), 1; got != want {
+		t.Fatalf("got %d configs want %d", got, want)
+	}
+	if _, ok := transports.Load(transportKey{upstreamTLS: cfg}); ok {
+		t.Fatal("transport of the replaced config was not removed")
+	}
+	if _, ok := transports.Load(transportKey{upstreamTLS: cfg2}); !ok {
+		t.Fatal("transport of the used config was removed")
+	}
+
+	cleanupUpstreamTLS(Table{})
+	if got, want := len(upstreamTLSConfigs), 0; got != want {
+		t.Fatalf("got %d configs want %d", got, want)
+	}
+	if _, ok := transports.Load(transportKey{upstreamTLS: cfg2}); ok {
+		t.Fatal("transport of the unused config was not removed")
+	}
+}
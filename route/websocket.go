@@ -0,0 +1,76 @@
+package route
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// WSPolicy limits the websocket sessions of a route.
+type WSPolicy struct {
+	// IdleTimeout closes a session when no data was sent in either
+	// direction for this long. Zero disables the idle timeout.
+	IdleTimeout time.Duration
+
+	// MaxLifetime closes a session after this time regardless of
+	// its activity. Zero disables the limit.
+	MaxLifetime time.Duration
+
+	// Origins contains the allowed values of the Origin header in
+	// lower case. They can contain '*' wildcards, e.g.
+	// 'https://*.example.com'. All origins are allowed if it is empty.
+	Origins []string
+}
+
+// AllowOrigin returns true if a websocket handshake with the
+// given Origin header is allowed.
+func (p *WSPolicy) AllowOrigin(origin string) bool {
+	if len(p.Origins) == 0 {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	for _, o := range p.Origins {
+		if ok, _ := path.Match(o, origin); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// parseWSPolicy returns the websocket policy from the route options
+// or nil if the route has none. It is configured with the following
+// options:
+//
+//	ws.idletimeout=5m     close sessions without traffic after 5m
+//	ws.maxlifetime=1h     close sessions after 1h
+//	ws.origins=https://a.com,https://*.b.com
+//	                      allowed Origin headers of the handshake
+func parseWSPolicy(opts map[string]string) (*WSPolicy, error) {
+	var p WSPolicy
+	var err error
+	if v := opts["ws.idletimeout"]; v != "" {
+		if p.IdleTimeout, err = time.ParseDuration(v); err != nil || p.IdleTimeout < 0 {
+			return nil, fmt.Errorf("route: invalid ws.idletimeout %q", v)
+		}
+	}
+	if v := opts["ws.maxlifetime"]; v != "" {
+		if p.MaxLifetime, err = time.ParseDuration(v); err != nil || p.MaxLifetime < 0 {
+			return nil, fmt.Errorf("route: invalid ws.maxlifetime %q", v)
+		}
+	}
+	for o := range strings.SplitSeq(opts["ws.origins"], ",") {
+		o = strings.ToLower(strings.TrimSpace(o))
+		if o == "" {
+			continue
+		}
+		if _, err := path.Match(o, ""); err != nil {
+			return nil, fmt.Errorf("route: invalid ws.origins %q", o)
+		}
+		p.Origins = append(p.Origins, o)
+	}
+	if p.IdleTimeout == 0 && p.MaxLifetime == 0 && len(p.Origins) == 0 {
+		return nil, nil
+	}
+	return &p, nil
+}
@@ -0,0 +1,60 @@
+package route
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseWSPolicy(t *testing.T) {
+	tests := []struct {
+		desc string
+		opts map[string]string
+		p    *WSPolicy
+		err  bool
+	}{
+		{"no options", map[string]string{}, nil, false},
+		{"idle timeout", map[string]string{"ws.idletimeout": "5m"}, &WSPolicy{IdleTimeout: 5 * time.Minute}, false},
+		{"max lifetime", map[string]string{"ws.maxlifetime": "1h"}, &WSPolicy{MaxLifetime: time.Hour}, false},
+		{"origins", map[string]string{"ws.origins": "https://A.com, https://*.b.com"}, &WSPolicy{Origins: []string{"https://a.com", "https://*.b.com"}}, false},
+		{"invalid idle timeout", map[string]string{"ws.idletimeout": "5"}, nil, true},
+		{"negative max lifetime", map[string]string{"ws.maxlifetime": "-1s"}, nil, true},
+		{"invalid origin", map[string]string{"ws.origins": "https://[a.com"}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			p, err := parseWSPolicy(tt.opts)
+			if got, want := err != nil, tt.err; got != want {
+				t.Fatalf("got error %v want error %v", err, want)
+			}
+			if got, want := p, tt.p; !reflect.DeepEqual(got, want) {
+				t.Fatalf("got %+v want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestWSPolicyAllowOrigin(t *testing.T) {
+	p := &WSPolicy{Origins: []string{"https://a.com", "https://*.b.com"}}
+	tests := []struct {
+		origin string
+		allow  bool
+	}{
+		{"https://a.com", true},
+		{"HTTPS://A.COM", true},
+		{"https://x.b.com", true},
+		{"https://b.com", false},
+		{"http://a.com", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got, want := p.AllowOrigin(tt.origin), tt.allow; got != want {
+			t.Errorf("%q: got %v want %v", tt.origin, got, want)
+		}
+	}
+	if !(&WSPolicy{}).AllowOrigin("https://c.com") {
+		t.Error("got false want true for policy without origins")
+	}
+}
@@ -1,10 +1,13 @@
 package transport
 
 import (
+	"context"
 	"crypto/tls"
 	"github.com/fabiolb/fabio/config"
 	"net"
 	"net/http"
+	"sync"
+	"time"
 )
 
 var (
@@ -16,14 +19,153 @@ func NewTransport(tlscfg *tls.Config) *http.Transport {
 		ResponseHeaderTimeout: cfg.Proxy.ResponseHeaderTimeout,
 		IdleConnTimeout:       cfg.Proxy.IdleConnTimeout,
 		MaxIdleConnsPerHost:   cfg.Proxy.MaxConn,
-		Dial: (&net.Dialer{
+		DialContext: (&net.Dialer{
 			Timeout:   cfg.Proxy.DialTimeout,
 			KeepAlive: cfg.Proxy.KeepAliveTimeout,
-		}).Dial,
+		}).DialContext,
 		TLSClientConfig: tlscfg,
 	}
 }
 
+// NewH2CTransport returns a transport which speaks HTTP/2 with prior
+// knowledge to plaintext upstreams (h2c).
+func NewH2CTransport() *http.Transport {
+	tr := NewTransport(nil)
+	tr.Protocols = new(http.Protocols)
+	tr.Protocols.SetUnencryptedHTTP2(true)
+	return tr
+}
+
+// SetTimeouts overrides the dial timeout and the idle connection
+// timeout of the transport. Zero values keep the configured timeouts.
+func SetTimeouts(tr *http.Transport, dial, idle time.Duration) {
+	if dial > 0 {
+		tr.DialContext = (&net.Dialer{
+			Timeout:   dial,
+			KeepAlive: cfg.Proxy.KeepAliveTimeout,
+		}).DialContext
+	}
+	if idle > 0 {
+		tr.IdleConnTimeout = idle
+	}
+}
+
+type proxyHeaderKey struct{}
+
+// WithProxyHeader returns a context with the PROXY protocol header
+// which transports configured with SetProxyHeader write to new
+// upstream connections.
+func WithProxyHeader(ctx context.Context, hdr []byte) context.Context {
+	return context.WithValue(ctx, proxyHeaderKey{}, hdr)
+}
+
+// SetProxyHeader configures the transport to write the PROXY protocol
+// header of the request context to new upstream connections. The
+// connections are not reused since the header describes a single
+// request.
+func SetProxyHeader(tr *http.Transport) {
+	dial := tr.DialContext
+	tr.DisableKeepAlives = true
+	tr.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		hdr, _ := ctx.Value(proxyHeaderKey{}).([]byte)
+		return dialProxyHeader(ctx, dial, network, addr, hdr)
+	}
+}
+
+func dialProxyHeader(ctx context.Context, dial func(context.Context, string, string) (net.Conn, error), network, addr string, hdr []byte) (net.Conn, error) {
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	c, err := dial(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if hdr != nil {
+		if _, err := c.Write(hdr); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// defaultProxyHeaderIdle is the time after which unused connection pools
+// for PROXY protocol headers are removed if the transport has no idle
+// connection timeout.
+const defaultProxyHeaderIdle = 90 * time.Second
+
+type proxyHeaderPoolKey struct {
+	tr  *http.Transport
+	hdr string
+}
+
+type proxyHeaderPool struct {
+	tr   *http.Transport
+	last time.Time
+}
+
+var (
+	proxyHeaderMu    sync.Mutex
+	proxyHeaderPools = map[proxyHeaderPoolKey]*proxyHeaderPool{}
+	proxyHeaderSweep time.Time
+)
+
+// ProxyHeaderRoundTripper returns a round tripper which sends the
+// requests over connections which start with the PROXY protocol header
+// of the request context. Unlike with SetProxyHeader the connections
+// are reused for requests with the same header, i.e. for the requests
+// of the same client connection. Every header gets its own copy of tr
+// which is removed after it has not been used for the idle connection
+// timeout.
+func ProxyHeaderRoundTripper(tr *http.Transport) http.RoundTripper {
+	return roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		hdr, _ := r.Context().Value(proxyHeaderKey{}).([]byte)
+		if hdr == nil {
+			return tr.RoundTrip(r)
+		}
+		return proxyHeaderTransport(tr, hdr).RoundTrip(r)
+	})
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+// proxyHeaderTransport returns the copy of tr which writes hdr to its
+// new connections.
+func proxyHeaderTransport(tr *http.Transport, hdr []byte) *http.Transport {
+	proxyHeaderMu.Lock()
+	defer proxyHeaderMu.Unlock()
+
+	now := time.Now()
+	if now.Sub(proxyHeaderSweep) > time.Minute {
+		for k, p := range proxyHeaderPools {
+			if now.Sub(p.last) > p.tr.IdleConnTimeout {
+				p.tr.CloseIdleConnections()
+				delete(proxyHeaderPools, k)
+			}
+		}
+		proxyHeaderSweep = now
+	}
+
+	k := proxyHeaderPoolKey{tr: tr, hdr: string(hdr)}
+	if p := proxyHeaderPools[k]; p != nil {
+		p.last = now
+		return p.tr
+	}
+
+	c := tr.Clone()
+	if c.IdleConnTimeout <= 0 {
+		c.IdleConnTimeout = defaultProxyHeaderIdle
+	}
+	dial := tr.DialContext
+	c.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return dialProxyHeader(ctx, dial, network, addr, hdr)
+	}
+	proxyHeaderPools[k] = &proxyHeaderPool{tr: c, last: now}
+	return c
+}
+
 func SetConfig(ncfg *config.Config) {
 	cfg = ncfg
 }